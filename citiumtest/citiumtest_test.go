@@ -0,0 +1,132 @@
+package citiumtest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/citiumtest"
+	"github.com/meomap/citium/scheduler"
+	"github.com/meomap/citium/schema"
+)
+
+var _ scheduler.Requester = new(citiumtest.Requester)
+
+func TestDynamoDB(t *testing.T) {
+	ctx := context.Background()
+	table := "citiumtest_test"
+	db := citiumtest.NewDynamoDB()
+	at := time.Date(2018, 9, 10, 8, 0, 0, 0, time.UTC)
+	newRequest := func(id string) *schema.ScheduledRequest {
+		return &schema.ScheduledRequest{ID: id, Method: "GET", URL: "/reports", EffectiveAfter: at}
+	}
+	for _, c := range []struct {
+		caseName string
+		run      func(t *testing.T)
+	}{
+		{
+			caseName: "create_get",
+			run: func(t *testing.T) {
+				require.NoError(t, scheduler.Create(ctx, db, table, newRequest("test-1")))
+				req, err := scheduler.Get(ctx, db, table, "test-1")
+				require.NoError(t, err)
+				assert.Equal(t, "/reports", req.URL)
+				assert.Equal(t, schema.StatusPending, req.Status)
+				assert.Len(t, db.Puts, 1)
+			},
+		},
+		{
+			caseName: "batch_create_fetch",
+			run: func(t *testing.T) {
+				reqs := []*schema.ScheduledRequest{newRequest("test-2"), newRequest("test-1"), newRequest("test-3")}
+				require.NoError(t, scheduler.BatchCreate(ctx, db, table, reqs))
+				fetched, err := scheduler.FetchSchedRequests(ctx, db, table, at, 10, 2, nil)
+				require.NoError(t, err)
+				require.Len(t, fetched, 3)
+				assert.Equal(t, "test-1", fetched[0].ID)
+				assert.Len(t, db.Scans, 2)
+			},
+		},
+		{
+			caseName: "delete",
+			run: func(t *testing.T) {
+				require.NoError(t, db.Add(newRequest("test-1")))
+				require.NoError(t, scheduler.Delete(ctx, db, table, "test-1"))
+				req, err := db.Request("test-1")
+				require.NoError(t, err)
+				assert.Nil(t, req)
+			},
+		},
+		{
+			caseName: "upsert",
+			run: func(t *testing.T) {
+				created, err := scheduler.Upsert(ctx, db, table, newRequest("test-1"))
+				require.NoError(t, err)
+				assert.True(t, created)
+				stored := newRequest("test-1")
+				stored.Version = 4
+				require.NoError(t, db.Add(stored))
+				replaced := newRequest("test-1")
+				replaced.URL = "/invoices"
+				created, err = scheduler.Upsert(ctx, db, table, replaced)
+				require.NoError(t, err)
+				assert.False(t, created)
+				assert.Equal(t, int64(5), replaced.Version)
+				assert.Len(t, db.Updates, 2)
+			},
+		},
+		{
+			caseName: "put_error",
+			run: func(t *testing.T) {
+				db.PutErr = citiumtest.ConditionalCheckFailed()
+				assert.Error(t, scheduler.Create(ctx, db, table, newRequest("test-1")))
+				reqs, err := db.Requests()
+				require.NoError(t, err)
+				assert.Empty(t, reqs)
+			},
+		},
+		{
+			caseName: "canceled",
+			run: func(t *testing.T) {
+				canceled, cancel := context.WithCancel(ctx)
+				cancel()
+				_, err := scheduler.Get(canceled, db, table, "test-1")
+				require.Error(t, err)
+				assert.Empty(t, db.Gets)
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			db.Reset()
+			c.run(t)
+		})
+	}
+}
+
+func TestRequester(t *testing.T) {
+	requester := new(citiumtest.Requester)
+	resp, err := requester.DoRequest(context.Background(), "POST", "https://example.com/hooks", map[string]string{"X-Token": "abc"}, "{}")
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.Code)
+
+	requester.Response = &schema.Response{Code: 503, Body: "unavailable"}
+	resp, err = requester.DoRequest(context.Background(), "GET", "https://example.com/reports", nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, "unavailable", resp.Body)
+
+	requester.Err = errors.New("connection refused")
+	_, err = requester.DoRequest(context.Background(), "GET", "https://example.com/reports", nil, "")
+	assert.Error(t, err)
+
+	calls := requester.Calls()
+	require.Len(t, calls, 3)
+	assert.Equal(t, citiumtest.Call{Method: "POST", URL: "https://example.com/hooks", Headers: map[string]string{"X-Token": "abc"}, Body: "{}"}, calls[0])
+
+	requester.Reset()
+	assert.Empty(t, requester.Calls())
+}
@@ -0,0 +1,271 @@
+// Package citiumtest provides test doubles of the dependencies of scheduler, so that code scheduling
+// requests is unit tested without AWS:
+//
+//	db := citiumtest.NewDynamoDB()
+//	err := scheduler.Create(ctx, db, "requests", req)
+//	stored, err := db.Request(req.ID)
+package citiumtest
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// DynamoDB is an in-memory table of requests keyed by ID. Items are put, got, scanned and deleted
+// as DynamoDB does, but expressions are not evaluated: scans return every item, conditions always
+// pass and updates are only recorded. Calls of other operations panic.
+//
+// The Err fields fail every call of their operation until reset, and the inputs of calls are
+// recorded for assertions
+type DynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu    sync.Mutex
+	items map[string]map[string]*dynamodb.AttributeValue
+
+	ScanErr   error
+	GetErr    error
+	PutErr    error
+	UpdateErr error
+	DeleteErr error
+	BatchErr  error
+
+	Scans   []*dynamodb.ScanInput
+	Gets    []*dynamodb.GetItemInput
+	Puts    []*dynamodb.PutItemInput
+	Updates []*dynamodb.UpdateItemInput
+	Deletes []*dynamodb.DeleteItemInput
+	Batches []*dynamodb.BatchWriteItemInput
+}
+
+// NewDynamoDB returns empty table
+func NewDynamoDB() *DynamoDB {
+	return &DynamoDB{items: map[string]map[string]*dynamodb.AttributeValue{}}
+}
+
+// ConditionalCheckFailed returns the error of DynamoDB when the condition of a write fails, e.g.
+// to set as PutErr when the id of created request is taken
+func ConditionalCheckFailed() error {
+	return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+}
+
+// Reset empties the table, clears errors and recorded calls
+func (d *DynamoDB) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.items = map[string]map[string]*dynamodb.AttributeValue{}
+	d.ScanErr, d.GetErr, d.PutErr, d.UpdateErr, d.DeleteErr, d.BatchErr = nil, nil, nil, nil, nil, nil
+	d.Scans, d.Gets, d.Puts, d.Updates, d.Deletes, d.Batches = nil, nil, nil, nil, nil, nil
+}
+
+// Add stores reqs as they are, replacing the ones of same ID
+func (d *DynamoDB) Add(reqs ...*schema.ScheduledRequest) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, req := range reqs {
+		av, err := dynamodbattribute.MarshalMap(req)
+		if err != nil {
+			return errors.Wrapf(err, "dynamodbattribute.MarshalMap id=%s", req.ID)
+		}
+		d.put(av)
+	}
+	return nil
+}
+
+// Request returns the stored request of id, nil if there is none
+func (d *DynamoDB) Request(id string) (*schema.ScheduledRequest, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	item, ok := d.items[id]
+	if !ok {
+		return nil, nil
+	}
+	req := new(schema.ScheduledRequest)
+	if err := dynamodbattribute.UnmarshalMap(item, req); err != nil {
+		return nil, errors.Wrapf(err, "dynamodbattribute.UnmarshalMap id=%s", id)
+	}
+	return req, nil
+}
+
+// Requests returns every stored request ordered by ID
+func (d *DynamoDB) Requests() ([]*schema.ScheduledRequest, error) {
+	reqs := []*schema.ScheduledRequest{}
+	if err := dynamodbattribute.UnmarshalListOfMaps(d.sorted(), &reqs); err != nil {
+		return nil, errors.Wrap(err, "dynamodbattribute.UnmarshalListOfMaps")
+	}
+	return reqs, nil
+}
+
+// ScanWithContext returns every item ordered by ID, paged by the limit of input
+func (d *DynamoDB) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	if err := d.record(ctx, func() { d.Scans = append(d.Scans, input) }, d.ScanErr); err != nil {
+		return nil, err
+	}
+	items := d.sorted()
+	if input.ExclusiveStartKey != nil {
+		start := aws.StringValue(input.ExclusiveStartKey["ID"].S)
+		i := sort.Search(len(items), func(i int) bool {
+			return aws.StringValue(items[i]["ID"].S) > start
+		})
+		items = items[i:]
+	}
+	var lastKey map[string]*dynamodb.AttributeValue
+	if input.Limit != nil && int(*input.Limit) < len(items) {
+		items = items[:*input.Limit]
+		lastKey = map[string]*dynamodb.AttributeValue{"ID": items[len(items)-1]["ID"]}
+	}
+	return &dynamodb.ScanOutput{
+		Count:            aws.Int64(int64(len(items))),
+		ScannedCount:     aws.Int64(int64(len(items))),
+		Items:            items,
+		LastEvaluatedKey: lastKey,
+	}, nil
+}
+
+// Scan is ScanWithContext without context
+func (d *DynamoDB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	return d.ScanWithContext(context.Background(), input)
+}
+
+// GetItemWithContext returns the item of key, no item if there is none
+func (d *DynamoDB) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	if err := d.record(ctx, func() { d.Gets = append(d.Gets, input) }, d.GetErr); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return &dynamodb.GetItemOutput{Item: d.items[keyOf(input.Key)]}, nil
+}
+
+// GetItem is GetItemWithContext without context
+func (d *DynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return d.GetItemWithContext(context.Background(), input)
+}
+
+// PutItemWithContext stores the item, replacing the one of same ID
+func (d *DynamoDB) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if err := d.record(ctx, func() { d.Puts = append(d.Puts, input) }, d.PutErr); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.put(input.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// PutItem is PutItemWithContext without context
+func (d *DynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	return d.PutItemWithContext(context.Background(), input)
+}
+
+// UpdateItemWithContext records the update, leaving the item as it is. The item is returned if
+// asked for
+func (d *DynamoDB) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	if err := d.record(ctx, func() { d.Updates = append(d.Updates, input) }, d.UpdateErr); err != nil {
+		return nil, err
+	}
+	output := &dynamodb.UpdateItemOutput{}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		output.Attributes = d.items[keyOf(input.Key)]
+	}
+	return output, nil
+}
+
+// UpdateItem is UpdateItemWithContext without context
+func (d *DynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	return d.UpdateItemWithContext(context.Background(), input)
+}
+
+// DeleteItemWithContext removes the item of key, if any
+func (d *DynamoDB) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	if err := d.record(ctx, func() { d.Deletes = append(d.Deletes, input) }, d.DeleteErr); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.items, keyOf(input.Key))
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+// DeleteItem is DeleteItemWithContext without context
+func (d *DynamoDB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	return d.DeleteItemWithContext(context.Background(), input)
+}
+
+// BatchWriteItemWithContext applies every put and delete of input, leaving none unprocessed
+func (d *DynamoDB) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	if err := d.record(ctx, func() { d.Batches = append(d.Batches, input) }, d.BatchErr); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, writes := range input.RequestItems {
+		for _, write := range writes {
+			if write.PutRequest != nil {
+				d.put(write.PutRequest.Item)
+			}
+			if write.DeleteRequest != nil {
+				delete(d.items, keyOf(write.DeleteRequest.Key))
+			}
+		}
+	}
+	return &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]*dynamodb.WriteRequest{}}, nil
+}
+
+// BatchWriteItem is BatchWriteItemWithContext without context
+func (d *DynamoDB) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	return d.BatchWriteItemWithContext(context.Background(), input)
+}
+
+// record appends the call unless ctx is done, which fails the call with the error of SDK, and
+// returns the error the call fails with if any
+func (d *DynamoDB) record(ctx context.Context, appendCall func(), failure error) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	appendCall()
+	return failure
+}
+
+func (d *DynamoDB) put(item map[string]*dynamodb.AttributeValue) {
+	if d.items == nil {
+		d.items = map[string]map[string]*dynamodb.AttributeValue{}
+	}
+	d.items[keyOf(item)] = item
+}
+
+func (d *DynamoDB) sorted() []map[string]*dynamodb.AttributeValue {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	items := make([]map[string]*dynamodb.AttributeValue, 0, len(d.items))
+	for _, item := range d.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return aws.StringValue(items[i]["ID"].S) < aws.StringValue(items[j]["ID"].S)
+	})
+	return items
+}
+
+// keyOf returns the ID of key or item
+func keyOf(key map[string]*dynamodb.AttributeValue) string {
+	if id, ok := key["ID"]; ok {
+		return aws.StringValue(id.S)
+	}
+	return ""
+}
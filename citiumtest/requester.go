@@ -0,0 +1,57 @@
+package citiumtest
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/meomap/citium/schema"
+)
+
+// Call is a request sent through Requester
+type Call struct {
+	Method  string
+	URL     string
+	Headers map[string]string
+	Body    string
+}
+
+// Requester answers every request with Response, or fails it with Err, recording the calls. It
+// implements scheduler.Requester, e.g. as HTTP of scheduler.Services
+type Requester struct {
+	mu    sync.Mutex
+	calls []Call
+
+	// Response answered, 200 with empty body if nil
+	Response *schema.Response
+	Err      error
+}
+
+// DoRequest records the call and answers it
+func (r *Requester) DoRequest(ctx context.Context, method, urlStr string, headers map[string]string, body string) (*schema.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, Call{Method: method, URL: urlStr, Headers: headers, Body: body})
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	if r.Response == nil {
+		return &schema.Response{Code: http.StatusOK}, nil
+	}
+	resp := *r.Response
+	return &resp, nil
+}
+
+// Calls returns the requests sent so far in order
+func (r *Requester) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// Reset clears the recorded calls, the response and the error
+func (r *Requester) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls, r.Response, r.Err = nil, nil, nil
+}
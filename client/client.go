@@ -0,0 +1,214 @@
+// Package client schedules requests through the management API of citium, so that Go services
+// manage their requests with nothing but the API url and a token:
+//
+//	c, err := client.New("https://abc123.execute-api.eu-west-1.amazonaws.com/Prod", token)
+//	req, err := schema.NewRequestBuilder().ID("invoice-42").Post("/reminders").After(time.Hour).Build()
+//	created, err := c.Schedule(ctx, req)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// Client calls the management API with a bearer token
+type Client struct {
+	baseURL *url.URL
+	token   string
+	http    *http.Client
+}
+
+// Error is a call answered with a failure status code, along with the message of API
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("management api failed code=%d error=%s", e.StatusCode, e.Message)
+}
+
+// IsNotFound tells whether err is a call answered with 404, e.g. the request does not exist
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsConflict tells whether err is a call answered with 409, i.e. the id of a scheduled request is
+// taken or the request was updated since it was read
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+func hasStatus(err error, code int) bool {
+	apiErr, ok := errors.Cause(err).(*Error)
+	return ok && apiErr.StatusCode == code
+}
+
+// ListOptions filter and page the listed requests, zero values select every request
+type ListOptions struct {
+	Locked      *bool
+	Failed      *bool
+	Status      string
+	URLContains string
+	// Tags the requests must carry with the same values
+	Tags map[string]string
+	// Limit of requests per page, and the token of page returned by the previous call
+	Limit     int
+	NextToken string
+}
+
+// ListResult is a page of requests, NextToken is set if there are more
+type ListResult struct {
+	Requests  []*schema.ScheduledRequest `json:"requests"`
+	NextToken string                     `json:"next_token,omitempty"`
+}
+
+// New returns client of the management API deployed at baseURL, e.g. the url of its stage
+func New(baseURL, token string) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/")
+	if err != nil {
+		return nil, errors.Wrapf(err, "url.Parse base_url=%s", baseURL)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("invalid base_url=%s, expect absolute http(s) url", baseURL)
+	}
+	return &Client{
+		baseURL: u,
+		token:   token,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// SetHTTPClient replaces the default client with 30s timeout, e.g. by a traced one
+func (c *Client) SetHTTPClient(client *http.Client) {
+	c.http = client
+}
+
+// Schedule creates the request, which is returned as stored. A request of the same id is never
+// replaced, IsConflict tells the error then
+func (c *Client) Schedule(ctx context.Context, req *schema.ScheduledRequest) (*schema.ScheduledRequest, error) {
+	created := new(schema.ScheduledRequest)
+	if err := c.call(ctx, http.MethodPost, "requests", nil, req, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// Get returns the request of id, IsNotFound tells the error if there is none
+func (c *Client) Get(ctx context.Context, id string) (*schema.ScheduledRequest, error) {
+	req := new(schema.ScheduledRequest)
+	if err := c.call(ctx, http.MethodGet, "requests/"+url.PathEscape(id), nil, nil, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// Cancel keeps the request of id without ever executing it again
+func (c *Client) Cancel(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodPost, "requests/"+url.PathEscape(id)+"/cancel", nil, nil, nil)
+}
+
+// Reschedule moves the request of id to at and clears its last failure, unlocking it as well if
+// unlock is set
+func (c *Client) Reschedule(ctx context.Context, id string, at time.Time, unlock bool) error {
+	body := map[string]interface{}{"at": at.UTC(), "unlock": unlock}
+	return c.call(ctx, http.MethodPost, "requests/"+url.PathEscape(id)+"/reschedule", nil, body, nil)
+}
+
+// List returns a page of the requests matching opts
+func (c *Client) List(ctx context.Context, opts ListOptions) (*ListResult, error) {
+	query := url.Values{}
+	if opts.Locked != nil {
+		query.Set("locked", strconv.FormatBool(*opts.Locked))
+	}
+	if opts.Failed != nil {
+		query.Set("failed", strconv.FormatBool(*opts.Failed))
+	}
+	if opts.Status != "" {
+		query.Set("status", opts.Status)
+	}
+	if opts.URLContains != "" {
+		query.Set("url_contains", opts.URLContains)
+	}
+	for k, v := range opts.Tags {
+		query.Add("tag", k+"="+v)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.NextToken != "" {
+		query.Set("next_token", opts.NextToken)
+	}
+	result := new(ListResult)
+	if err := c.call(ctx, http.MethodGet, "requests", query, nil, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// call sends body encoded as JSON to path relative to the base url, decoding the response into
+// out unless it is nil. Failure status codes are returned as *Error
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	// path holds escaped ids
+	rel, err := url.Parse(path)
+	if err != nil {
+		return errors.Wrapf(err, "url.Parse path=%s", path)
+	}
+	rel.RawQuery = query.Encode()
+	u := c.baseURL.ResolveReference(rel)
+	var reader io.Reader
+	if body != nil {
+		raw, merr := json.Marshal(body)
+		if merr != nil {
+			return errors.Wrap(merr, "json.Marshal")
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequest(method, u.String(), reader)
+	if err != nil {
+		return errors.Wrapf(err, "http.NewRequest method=%s url=%s", method, u)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "c.http.Do method=%s url=%s", method, u)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "read response method=%s url=%s", method, u)
+	}
+	if resp.StatusCode/100 != 2 {
+		apiErr := &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(raw, &failure) == nil && failure.Error != "" {
+			apiErr.Message = failure.Error
+		}
+		return errors.Wrapf(apiErr, "method=%s url=%s", method, u)
+	}
+	if out == nil || len(raw) == 0 {
+		return nil
+	}
+	if err = json.Unmarshal(raw, out); err != nil {
+		return errors.Wrapf(err, "json.Unmarshal method=%s url=%s", method, u)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/schema"
+)
+
+func TestClient(t *testing.T) {
+	var (
+		lastReq  *http.Request
+		lastBody map[string]interface{}
+		status   int
+		answer   string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastReq, lastBody = r, nil
+		if r.ContentLength > 0 {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&lastBody))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, err := w.Write([]byte(answer))
+		require.NoError(t, err)
+	}))
+	defer server.Close()
+	api, err := New(server.URL+"/Prod/", "secret")
+	require.NoError(t, err)
+	at := time.Date(2018, 9, 10, 8, 0, 0, 0, time.UTC)
+	locked := true
+	for _, c := range []struct {
+		caseName   string
+		status     int
+		answer     string
+		call       func(ctx context.Context) (interface{}, error)
+		wantMethod string
+		wantPath   string
+		wantQuery  string
+		verify     func(t *testing.T, result interface{}, err error)
+	}{
+		{
+			caseName: "schedule",
+			status:   http.StatusCreated,
+			answer:   `{"ID":"test-client","Method":"GET","URL":"/reports","Status":"PENDING"}`,
+			call: func(ctx context.Context) (interface{}, error) {
+				return api.Schedule(ctx, &schema.ScheduledRequest{ID: "test-client", Method: "GET", URL: "/reports"})
+			},
+			wantMethod: http.MethodPost,
+			wantPath:   "/Prod/requests",
+			verify: func(t *testing.T, result interface{}, err error) {
+				require.NoError(t, err)
+				assert.Equal(t, "test-client", lastBody["ID"])
+				assert.Equal(t, schema.StatusPending, result.(*schema.ScheduledRequest).Status)
+			},
+		},
+		{
+			caseName: "schedule_taken",
+			status:   http.StatusConflict,
+			answer:   `{"error":"already exists"}`,
+			call: func(ctx context.Context) (interface{}, error) {
+				return api.Schedule(ctx, &schema.ScheduledRequest{ID: "test-client"})
+			},
+			wantMethod: http.MethodPost,
+			wantPath:   "/Prod/requests",
+			verify: func(t *testing.T, result interface{}, err error) {
+				require.Error(t, err)
+				assert.True(t, IsConflict(err))
+				assert.Contains(t, err.Error(), "already exists")
+			},
+		},
+		{
+			caseName: "get",
+			status:   http.StatusOK,
+			answer:   `{"ID":"test/client"}`,
+			call: func(ctx context.Context) (interface{}, error) {
+				return api.Get(ctx, "test/client")
+			},
+			wantMethod: http.MethodGet,
+			wantPath:   "/Prod/requests/test%2Fclient",
+			verify: func(t *testing.T, result interface{}, err error) {
+				require.NoError(t, err)
+				assert.Equal(t, "test/client", result.(*schema.ScheduledRequest).ID)
+			},
+		},
+		{
+			caseName: "get_not_found",
+			status:   http.StatusNotFound,
+			answer:   `{"error":"not found"}`,
+			call: func(ctx context.Context) (interface{}, error) {
+				return api.Get(ctx, "test-client")
+			},
+			wantMethod: http.MethodGet,
+			wantPath:   "/Prod/requests/test-client",
+			verify: func(t *testing.T, result interface{}, err error) {
+				assert.True(t, IsNotFound(err))
+			},
+		},
+		{
+			caseName: "cancel",
+			status:   http.StatusNoContent,
+			call: func(ctx context.Context) (interface{}, error) {
+				return nil, api.Cancel(ctx, "test-client")
+			},
+			wantMethod: http.MethodPost,
+			wantPath:   "/Prod/requests/test-client/cancel",
+			verify: func(t *testing.T, result interface{}, err error) {
+				require.NoError(t, err)
+			},
+		},
+		{
+			caseName: "reschedule",
+			status:   http.StatusNoContent,
+			call: func(ctx context.Context) (interface{}, error) {
+				return nil, api.Reschedule(ctx, "test-client", at, true)
+			},
+			wantMethod: http.MethodPost,
+			wantPath:   "/Prod/requests/test-client/reschedule",
+			verify: func(t *testing.T, result interface{}, err error) {
+				require.NoError(t, err)
+				assert.Equal(t, "2018-09-10T08:00:00Z", lastBody["at"])
+				assert.Equal(t, true, lastBody["unlock"])
+			},
+		},
+		{
+			caseName: "list",
+			status:   http.StatusOK,
+			answer:   `{"requests":[{"ID":"test-client-1"}],"next_token":"abc"}`,
+			call: func(ctx context.Context) (interface{}, error) {
+				return api.List(ctx, ListOptions{Locked: &locked, Status: schema.StatusFailed, Tags: map[string]string{"team": "billing"}, Limit: 1})
+			},
+			wantMethod: http.MethodGet,
+			wantPath:   "/Prod/requests",
+			wantQuery:  "limit=1&locked=true&status=FAILED&tag=team%3Dbilling",
+			verify: func(t *testing.T, result interface{}, err error) {
+				require.NoError(t, err)
+				page := result.(*ListResult)
+				require.Len(t, page.Requests, 1)
+				assert.Equal(t, "test-client-1", page.Requests[0].ID)
+				assert.Equal(t, "abc", page.NextToken)
+			},
+		},
+		{
+			caseName: "internal_error",
+			status:   http.StatusInternalServerError,
+			answer:   `{"error":"Internal Server Error"}`,
+			call: func(ctx context.Context) (interface{}, error) {
+				return api.List(ctx, ListOptions{})
+			},
+			wantMethod: http.MethodGet,
+			wantPath:   "/Prod/requests",
+			verify: func(t *testing.T, result interface{}, err error) {
+				require.Error(t, err)
+				assert.False(t, IsNotFound(err))
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			status, answer = c.status, c.answer
+			result, err := c.call(context.Background())
+			require.NotNil(t, lastReq)
+			assert.Equal(t, c.wantMethod, lastReq.Method)
+			assert.Equal(t, c.wantPath, lastReq.URL.EscapedPath())
+			assert.Equal(t, c.wantQuery, lastReq.URL.RawQuery)
+			assert.Equal(t, "Bearer secret", lastReq.Header.Get("Authorization"))
+			c.verify(t, result, err)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, c := range []struct {
+		caseName string
+		baseURL  string
+		err      bool
+	}{
+		{caseName: "ok", baseURL: "https://abc123.execute-api.eu-west-1.amazonaws.com/Prod"},
+		{caseName: "relative", baseURL: "/Prod", err: true},
+		{caseName: "invalid", baseURL: "https://[::1", err: true},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			_, err := New(c.baseURL, "secret")
+			if c.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
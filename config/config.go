@@ -2,30 +2,409 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
 )
 
 // Configuration defines runtime variables
 type Configuration struct {
+	// Profile selected by CITIUM_PROFILE among the ones of config file
+	Profile   string `json:"-"`
 	TableName string `json:"table_name"`
-	BaseURL   string `json:"base_url"`
-	Token     string `json:"api_token"`
-	UserAgent string `json:"user_agent"`
+	// Optional DynamoDB endpoint instead of the regional one, e.g. DynamoDB Local or LocalStack
+	DynamoDBEndpoint string `json:"dynamodb_endpoint"`
+	BaseURL          string `json:"base_url"`
+	Token            string `json:"api_token"`
+	// Tokens by target host, e.g. api.foo.com or api.foo.com:8443, used instead of the token above
+	HostTokens map[string]string `json:"host_tokens"`
+	// Optional Secrets Manager secret holding the token instead, refetched after refresh interval
+	// or once rejected by target
+	TokenSecretARN       string        `json:"api_token_secret_arn"`
+	TokenRefreshInterval time.Duration `json:"api_token_refresh_interval"`
+	UserAgent            string        `json:"user_agent"`
+	// Headers sent with every http call, e.g. a tenant or API version header, unless the request
+	// gives them itself
+	DefaultHeaders map[string]string `json:"default_headers"`
+	// Maximum number of response body bytes kept after an execution, the rest is truncated
+	MaxBodySize int64 `json:"max_body_size"`
+	// Limits of the payload size, number of headers and header size of stored requests
+	MaxPayloadSize int `json:"max_payload_size"`
+	MaxHeaders     int `json:"max_headers"`
+	MaxHeaderSize  int `json:"max_header_size"`
+	// Outgoing payloads of at least this many bytes are gzip compressed, zero disables compression
+	GzipMinSize int64 `json:"gzip_min_size"`
+	// HTTP transport connection pooling
+	MaxIdleConns        int           `json:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `json:"max_idle_conns_per_host"`
+	IdleConnTimeout     time.Duration `json:"idle_conn_timeout"`
+	TLSHandshakeTimeout time.Duration `json:"tls_handshake_timeout"`
+	// HTTP protocol negotiation, one of HTTP2Auto, HTTP2Off or HTTP2PriorKnowledge
+	HTTP2Mode string `json:"http2_mode"`
+	// S3 destination of response bodies of requests flagged StreamResultToS3
+	ResultBucket string `json:"result_bucket"`
+	ResultPrefix string `json:"result_prefix"`
+	// Response status codes or classes retried within the same execution, e.g. `429,503`
+	RetryOnStatus string `json:"retry_on_status"`
+	// Number of retries after the first attempt, zero disables retrying
+	MaxRetries int `json:"max_retries"`
+	// Wait before the first retry, doubled on each next one
+	RetryBackoff time.Duration `json:"retry_backoff"`
+	// Longest Retry-After waited for within the execution, longer ones reschedule the request
+	RetryAfterMaxWait time.Duration `json:"retry_after_max_wait"`
+	// Consecutive failures of a target host opening its circuit, zero disables circuit breaking
+	BreakerThreshold int `json:"breaker_threshold"`
+	// Period executions to a host with open circuit are deferred for
+	BreakerCooldown time.Duration `json:"breaker_cooldown"`
+	// Dial address overrides by target host or host:port, e.g. `api.internal -> 10.0.3.12:8443`
+	HostOverrides map[string]string `json:"host_overrides"`
+	// Optional DNS server address (ip:port) resolving target hosts instead of system resolver
+	DNSServer string `json:"dns_server"`
+	// SASL PLAIN credentials of kafka targets
+	KafkaUsername string `json:"kafka_username"`
+	KafkaPassword string `json:"kafka_password"`
+	// Account specific AWS IoT Core data endpoint of mqtt targets without broker
+	IoTEndpoint string `json:"iot_endpoint"`
+	// Credentials of mqtt targets with generic broker
+	MQTTUsername string `json:"mqtt_username"`
+	MQTTPassword string `json:"mqtt_password"`
+	// Polling of ssm target command status, zero timeout stores the status right after sending
+	SSMPollInterval time.Duration `json:"ssm_poll_interval"`
+	SSMPollTimeout  time.Duration `json:"ssm_poll_timeout"`
+	// CloudWatch namespace of per-run metrics emitted in Embedded Metric Format, empty disables them
+	MetricsNamespace string `json:"metrics_namespace"`
+	// Due requests processed per run at most and items evaluated per table scan call, zero values
+	// process every due request and scan in pages of 1MB
+	FetchLimit   int `json:"fetch_limit"`
+	ScanPageSize int `json:"scan_page_size"`
+	// Tags the due requests must carry with the same values to be run, e.g. to split a table
+	// between deployments of distinct teams. Empty runs every due request
+	RunTags map[string]string `json:"run_tags"`
+	// Optional table keeping the cursor where a run limited by FetchLimit stopped, the next run
+	// resumes from there instead of scanning the same items again
+	CheckpointTable string `json:"checkpoint_table"`
+	// Whether listings by status query the Status index of the table instead of scanning it, and
+	// the tag keys whose listings query their tag index
+	StatusIndex bool     `json:"status_index"`
+	IndexedTags []string `json:"indexed_tags"`
+	// Optional table of schedule leases for active/passive deployments in several regions, only
+	// the run of LeaseHolder (the region by default) holding the lease executes while others
+	// stand by, taking it over once not renewed for LeaseDuration
+	LeaseTable    string        `json:"lease_table"`
+	LeaseHolder   string        `json:"lease_holder"`
+	LeaseDuration time.Duration `json:"lease_duration"`
+	// Due requests executed concurrently at most, overall and per http target host, zero values
+	// execute every due request at once
+	MaxConcurrency        int `json:"max_concurrency"`
+	MaxConcurrencyPerHost int `json:"max_concurrency_per_host"`
+	// Either ExecutionInline or ExecutionStepFunctions, and the state machine of the latter
+	ExecutionMode            string `json:"execution_mode"`
+	ExecutionStateMachineARN string `json:"execution_state_machine_arn"`
+	// Time left before the Lambda deadline under which a run stops picking up due requests, they
+	// are left unlocked for the next run instead of being killed mid-execution
+	DeadlineMargin time.Duration `json:"deadline_margin"`
+	// Longest delay past its effective date a request is still executed at, later it is kept as
+	// EXPIRED instead, zero executes it however late
+	MaxLateness time.Duration `json:"max_lateness"`
+	// Either RunModeLambda or RunModeDaemon
+	RunMode string `json:"run_mode"`
+	// Interval between runs in daemon mode
+	PollInterval time.Duration `json:"poll_interval"`
+	// Interval between reloads of config file and parameters in daemon mode, zero disables them
+	ConfigReloadInterval time.Duration `json:"config_reload_interval"`
+	// Longest wait for the executions in flight when the daemon is stopped, the ones not started
+	// yet are left to the next run
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"`
+	// Listen address of Prometheus /metrics endpoint in daemon mode
+	MetricsAddr string `json:"metrics_addr"`
+	// Optional Prometheus pushgateway receiving metrics after each run, and the job name grouping them
+	PushgatewayURL string `json:"pushgateway_url"`
+	PushJob        string `json:"push_job"`
+	// If true then executions and their storage and target calls are traced with AWS X-Ray
+	TracingEnabled bool `json:"tracing_enabled"`
+	// Optional SNS topic receiving execution failure notifications
+	FailureTopicARN string `json:"failure_topic_arn"`
+	// Optional Slack incoming webhook receiving notifications at least as severe as SlackSeverity
+	SlackWebhookURL string `json:"slack_webhook_url"`
+	SlackSeverity   string `json:"slack_severity"`
+	// Failed executions of a request before it is given up, it is retried by next runs after
+	// AttemptBackoff doubled on each attempt until then
+	MaxAttempts    int           `json:"max_attempts"`
+	AttemptBackoff time.Duration `json:"attempt_backoff"`
+	// Optional dead-letter queue receiving given up requests, either an SQS queue or a table
+	DLQQueueURL string `json:"dlq_queue_url"`
+	DLQTable    string `json:"dlq_table"`
+	// Optional table recording state transitions of requests, made by AuditActor
+	AuditTable string `json:"audit_table"`
+	AuditActor string `json:"audit_actor"`
+	// Optional escalation services opening incidents once a request failed EscalationThreshold
+	// times or every execution of a run failed, their API urls are set for other regions
+	PagerDutyRoutingKey string `json:"pagerduty_routing_key"`
+	PagerDutyEventsURL  string `json:"pagerduty_events_url"`
+	OpsgenieAPIKey      string `json:"opsgenie_api_key"`
+	OpsgenieAlertsURL   string `json:"opsgenie_alerts_url"`
+	EscalationThreshold int    `json:"escalation_threshold"`
+	// One of HandlerTrigger, HandlerHealthCheck, HandlerIngestSQS, HandlerIngestEvent,
+	// HandlerStream or HandlerAPI, selecting what a Lambda invocation does
+	HandlerMode string `json:"handler_mode"`
+	// Url receiving the changes of stored requests read from the table stream by HandlerStream
+	StreamCallbackURL string `json:"stream_callback_url"`
+	// Bearer token required from the callers of management API served by HandlerAPI
+	ManagementToken string `json:"management_api_token"`
+	// Bearer tokens of management API by the name of their owner, recorded as the creator of the
+	// requests they create. Callers of ManagementToken are recorded as `api`
+	ManagementTokens map[string]string `json:"management_api_tokens"`
 }
 
-// NewConfiguration returns config initialized from environment variables
+// Notification severities, failure alerts are errors while run summaries are warnings if
+// any execution failed or infos otherwise
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// Available run modes
+const (
+	// RunModeLambda handles scheduled Lambda invocations
+	RunModeLambda = "lambda"
+	// RunModeDaemon runs as a long lived process polling the schedule by itself
+	RunModeDaemon = "daemon"
+)
+
+// Available handler modes
+const (
+	// HandlerTrigger executes the due requests
+	HandlerTrigger = "trigger"
+	// HandlerHealthCheck reports whether the table, its permissions and base url are usable
+	HandlerHealthCheck = "healthcheck"
+	// HandlerIngestSQS creates the requests sent as SQS messages by upstream services
+	HandlerIngestSQS = "ingest-sqs"
+	// HandlerIngestEvent creates the requests sent as custom EventBridge events
+	HandlerIngestEvent = "ingest-event"
+	// HandlerStream posts the changes of stored requests read from the table stream
+	HandlerStream = "stream"
+	// HandlerAPI serves the management API of stored requests behind API Gateway
+	HandlerAPI = "api"
+)
+
+// Available execution modes
+const (
+	// ExecutionInline executes the due requests within the run
+	ExecutionInline = "inline"
+	// ExecutionStepFunctions dispatches each due request as a state machine execution, which
+	// locks, calls, records and unlocks it with retries and execution history
+	ExecutionStepFunctions = "stepfunctions"
+)
+
+// Available HTTP/2 modes
+const (
+	// HTTP2Auto negotiates HTTP/2 over TLS and falls back to HTTP/1.1
+	HTTP2Auto = "auto"
+	// HTTP2Off always speaks HTTP/1.1
+	HTTP2Off = "off"
+	// HTTP2PriorKnowledge speaks HTTP/2 only, including cleartext h2c for http:// targets
+	HTTP2PriorKnowledge = "h2c"
+)
+
+// Default values of optional settings
+const (
+	// DefaultMaxBodySize keeps stored execution results well below dynamodb item size limit
+	DefaultMaxBodySize = 256 * 1024
+	// DefaultMaxIdleConns bounds idle connections kept across all hosts
+	DefaultMaxIdleConns = 100
+	// DefaultMaxIdleConnsPerHost allows bursts of concurrent executions to the same host
+	// reusing connections instead of the net/http default of 2
+	DefaultMaxIdleConnsPerHost = 100
+	// DefaultIdleConnTimeout is the same as net/http default transport
+	DefaultIdleConnTimeout = 90 * time.Second
+	// DefaultTLSHandshakeTimeout is the same as net/http default transport
+	DefaultTLSHandshakeTimeout = 10 * time.Second
+	// DefaultRetryOnStatus retries on throttling & temporary unavailability
+	DefaultRetryOnStatus = "429,503"
+	// DefaultRetryBackoff is the wait before the first retry
+	DefaultRetryBackoff = 200 * time.Millisecond
+	// DefaultRetryAfterMaxWait fits within the default function timeout
+	DefaultRetryAfterMaxWait = time.Second
+	// DefaultBreakerThreshold is the consecutive failures of a host opening its circuit
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is the same as the default polling interval
+	DefaultBreakerCooldown = 5 * time.Minute
+	// DefaultSSMPollInterval is the wait between ssm command status checks
+	DefaultSSMPollInterval = 2 * time.Second
+	// DefaultSSMPollTimeout fits short ops tasks within the default function timeout
+	DefaultSSMPollTimeout = 30 * time.Second
+	// DefaultMetricsNamespace groups per-run metrics in CloudWatch
+	DefaultMetricsNamespace = "Citium"
+	// DefaultDeadlineMargin leaves time to a picked up request for a typical http call
+	DefaultDeadlineMargin = 10 * time.Second
+	// DefaultLeaseDuration outlasts two missed scheduled events of the function
+	DefaultLeaseDuration = 15 * time.Minute
+	// DefaultPollInterval is the same as the scheduled event rate of the function
+	DefaultPollInterval = 5 * time.Minute
+	// DefaultShutdownTimeout stays below the grace period of container orchestrators
+	DefaultShutdownTimeout = 25 * time.Second
+	// DefaultMetricsAddr is the listen address of metrics endpoint
+	DefaultMetricsAddr = ":9090"
+	// DefaultPushJob is the job name of pushed metrics
+	DefaultPushJob = "citium"
+	// DefaultAuditActor names the scheduler in audit trail outside of Lambda
+	DefaultAuditActor = "citium"
+	// DefaultMaxAttempts gives up a request at its first failure
+	DefaultMaxAttempts = 1
+	// DefaultAttemptBackoff is the same as the scheduled event rate of the function
+	DefaultAttemptBackoff = 5 * time.Minute
+	// DefaultTokenRefreshInterval picks up rotated secret token within minutes
+	DefaultTokenRefreshInterval = 5 * time.Minute
+	// DefaultConfigReloadInterval applies configuration changes to daemon within a minute
+	DefaultConfigReloadInterval = time.Minute
+	// DefaultEscalationThreshold escalates a request at its third consecutive failure
+	DefaultEscalationThreshold = 3
+)
+
+// NewConfiguration returns config initialized from environment variables, which override the
+// values of optional config file at CONFIG_FILE and its profile CITIUM_PROFILE
 func NewConfiguration() (*Configuration, error) {
-	table := os.Getenv("TABLE_NAME")
-	if table == "" {
-		return nil, errors.New("Require environment variable TABLE_NAME")
-	}
-	return &Configuration{
-		TableName: table,
-		BaseURL:   os.Getenv("BASE_URL"),
-		Token:     os.Getenv("API_TOKEN"),
-		UserAgent: os.Getenv("USER_AGENT"),
-	}, nil
+	profile := os.Getenv("CITIUM_PROFILE")
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := LoadFile(path, profile); err != nil {
+			return nil, err
+		}
+	}
+	env := new(envReader)
+	limits := env.sizeLimits()
+	retryOnStatus := os.Getenv("RETRY_ON_STATUS")
+	if retryOnStatus == "" {
+		retryOnStatus = DefaultRetryOnStatus
+	}
+	if _, err := schema.MatchStatus(retryOnStatus, 0); err != nil {
+		env.fail(errors.Wrapf(err, "Invalid environment variable RETRY_ON_STATUS=%s", retryOnStatus))
+	}
+	metricsNamespace, found := os.LookupEnv("METRICS_NAMESPACE")
+	if !found {
+		metricsNamespace = DefaultMetricsNamespace
+	}
+	dlqQueueURL, dlqTable := env.url("DLQ_QUEUE_URL"), os.Getenv("DLQ_TABLE")
+	if dlqQueueURL != "" && dlqTable != "" {
+		env.fail(errors.New("Only one of environment variables DLQ_QUEUE_URL and DLQ_TABLE could be set"))
+	}
+	auditActor := os.Getenv("AUDIT_ACTOR")
+	if auditActor == "" {
+		auditActor = os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
+	}
+	if auditActor == "" {
+		auditActor = DefaultAuditActor
+	}
+	pushJob := os.Getenv("PUSH_JOB")
+	if pushJob == "" {
+		pushJob = DefaultPushJob
+	}
+	leaseHolder := os.Getenv("LEASE_HOLDER")
+	if leaseHolder == "" {
+		leaseHolder = os.Getenv("AWS_REGION")
+	}
+	conf := &Configuration{
+		Profile:                  profile,
+		TableName:                env.required("TABLE_NAME"),
+		DynamoDBEndpoint:         env.url("DYNAMODB_ENDPOINT"),
+		BaseURL:                  env.url("BASE_URL"),
+		Token:                    os.Getenv("API_TOKEN"),
+		HostTokens:               env.stringMap("HOST_TOKENS"),
+		TokenSecretARN:           os.Getenv("API_TOKEN_SECRET_ARN"),
+		TokenRefreshInterval:     env.duration("API_TOKEN_REFRESH_INTERVAL", DefaultTokenRefreshInterval, 0),
+		UserAgent:                os.Getenv("USER_AGENT"),
+		DefaultHeaders:           env.stringMap("DEFAULT_HEADERS"),
+		MaxBodySize:              env.int64("MAX_BODY_SIZE", DefaultMaxBodySize, 0),
+		MaxPayloadSize:           limits.Payload,
+		MaxHeaders:               limits.Headers,
+		MaxHeaderSize:            limits.HeaderSize,
+		GzipMinSize:              env.int64("GZIP_MIN_SIZE", 0, 0),
+		MaxIdleConns:             env.int("MAX_IDLE_CONNS", DefaultMaxIdleConns, 0),
+		MaxIdleConnsPerHost:      env.int("MAX_IDLE_CONNS_PER_HOST", DefaultMaxIdleConnsPerHost, 0),
+		IdleConnTimeout:          env.duration("IDLE_CONN_TIMEOUT", DefaultIdleConnTimeout, 0),
+		TLSHandshakeTimeout:      env.duration("TLS_HANDSHAKE_TIMEOUT", DefaultTLSHandshakeTimeout, 0),
+		HTTP2Mode:                env.oneOf("HTTP2_MODE", HTTP2Auto, HTTP2Auto, HTTP2Off, HTTP2PriorKnowledge),
+		ResultBucket:             os.Getenv("RESULT_BUCKET"),
+		ResultPrefix:             os.Getenv("RESULT_PREFIX"),
+		RetryOnStatus:            retryOnStatus,
+		MaxRetries:               env.int("MAX_RETRIES", 0, 0),
+		RetryBackoff:             env.duration("RETRY_BACKOFF", DefaultRetryBackoff, 0),
+		RetryAfterMaxWait:        env.duration("RETRY_AFTER_MAX_WAIT", DefaultRetryAfterMaxWait, 0),
+		BreakerThreshold:         env.int("BREAKER_THRESHOLD", DefaultBreakerThreshold, 0),
+		BreakerCooldown:          env.duration("BREAKER_COOLDOWN", DefaultBreakerCooldown, 0),
+		HostOverrides:            env.stringMap("HOST_OVERRIDES"),
+		DNSServer:                env.hostPort("DNS_SERVER", ""),
+		KafkaUsername:            os.Getenv("KAFKA_USERNAME"),
+		KafkaPassword:            os.Getenv("KAFKA_PASSWORD"),
+		IoTEndpoint:              os.Getenv("IOT_ENDPOINT"),
+		MQTTUsername:             os.Getenv("MQTT_USERNAME"),
+		MQTTPassword:             os.Getenv("MQTT_PASSWORD"),
+		SSMPollInterval:          env.duration("SSM_POLL_INTERVAL", DefaultSSMPollInterval, time.Millisecond),
+		SSMPollTimeout:           env.duration("SSM_POLL_TIMEOUT", DefaultSSMPollTimeout, 0),
+		MetricsNamespace:         metricsNamespace,
+		FetchLimit:               env.int("FETCH_LIMIT", 0, 0),
+		ScanPageSize:             env.int("SCAN_PAGE_SIZE", 0, 0),
+		RunTags:                  env.stringMap("RUN_TAGS"),
+		CheckpointTable:          os.Getenv("CHECKPOINT_TABLE"),
+		StatusIndex:              env.bool("STATUS_INDEX", false),
+		IndexedTags:              env.indexedTags(),
+		LeaseTable:               os.Getenv("LEASE_TABLE"),
+		LeaseHolder:              leaseHolder,
+		LeaseDuration:            env.duration("LEASE_DURATION", DefaultLeaseDuration, time.Second),
+		MaxConcurrency:           env.int("MAX_CONCURRENCY", 0, 0),
+		MaxConcurrencyPerHost:    env.int("MAX_CONCURRENCY_PER_HOST", 0, 0),
+		ExecutionMode:            env.oneOf("EXECUTION_MODE", ExecutionInline, ExecutionInline, ExecutionStepFunctions),
+		ExecutionStateMachineARN: os.Getenv("EXECUTION_STATE_MACHINE_ARN"),
+		DeadlineMargin:           env.duration("DEADLINE_MARGIN", DefaultDeadlineMargin, 0),
+		MaxLateness:              env.duration("MAX_LATENESS", 0, 0),
+		RunMode:                  env.oneOf("RUN_MODE", RunModeLambda, RunModeLambda, RunModeDaemon),
+		PollInterval:             env.duration("POLL_INTERVAL", DefaultPollInterval, time.Second),
+		ConfigReloadInterval:     env.duration("CONFIG_RELOAD_INTERVAL", DefaultConfigReloadInterval, 0),
+		ShutdownTimeout:          env.duration("SHUTDOWN_TIMEOUT", DefaultShutdownTimeout, 0),
+		MetricsAddr:              env.hostPort("METRICS_ADDR", DefaultMetricsAddr),
+		PushgatewayURL:           env.url("PUSHGATEWAY_URL"),
+		PushJob:                  pushJob,
+		TracingEnabled:           env.bool("TRACING_ENABLED", false),
+		FailureTopicARN:          os.Getenv("FAILURE_TOPIC_ARN"),
+		SlackWebhookURL:          env.url("SLACK_WEBHOOK_URL"),
+		SlackSeverity:            env.oneOf("SLACK_SEVERITY", SeverityWarning, SeverityInfo, SeverityWarning, SeverityError),
+		MaxAttempts:              env.int("MAX_ATTEMPTS", DefaultMaxAttempts, 1),
+		AttemptBackoff:           env.duration("ATTEMPT_BACKOFF", DefaultAttemptBackoff, 0),
+		DLQQueueURL:              dlqQueueURL,
+		DLQTable:                 dlqTable,
+		AuditTable:               os.Getenv("AUDIT_TABLE"),
+		AuditActor:               auditActor,
+		PagerDutyRoutingKey:      os.Getenv("PAGERDUTY_ROUTING_KEY"),
+		PagerDutyEventsURL:       env.url("PAGERDUTY_EVENTS_URL"),
+		OpsgenieAPIKey:           os.Getenv("OPSGENIE_API_KEY"),
+		OpsgenieAlertsURL:        env.url("OPSGENIE_ALERTS_URL"),
+		EscalationThreshold:      env.int("ESCALATION_THRESHOLD", DefaultEscalationThreshold, 1),
+		HandlerMode:              env.oneOf("HANDLER_MODE", HandlerTrigger, HandlerTrigger, HandlerHealthCheck, HandlerIngestSQS, HandlerIngestEvent, HandlerStream, HandlerAPI),
+		StreamCallbackURL:        env.url("STREAM_CALLBACK_URL"),
+		ManagementToken:          os.Getenv("MANAGEMENT_API_TOKEN"),
+		ManagementTokens:         env.stringMap("MANAGEMENT_API_TOKENS"),
+	}
+	if conf.ExecutionMode == ExecutionStepFunctions && conf.ExecutionStateMachineARN == "" {
+		env.fail(errors.New("Environment variable EXECUTION_STATE_MACHINE_ARN is required by EXECUTION_MODE=stepfunctions"))
+	}
+	if conf.LeaseTable != "" && conf.LeaseHolder == "" {
+		env.fail(errors.New("Environment variable LEASE_HOLDER is required by LEASE_TABLE outside of Lambda"))
+	}
+	if conf.HandlerMode == HandlerAPI && conf.ManagementToken == "" && len(conf.ManagementTokens) == 0 {
+		env.fail(errors.New("Environment variable MANAGEMENT_API_TOKEN or MANAGEMENT_API_TOKENS is required by HANDLER_MODE=api"))
+	}
+	if conf.HandlerMode == HandlerStream && conf.StreamCallbackURL == "" {
+		env.fail(errors.New("Environment variable STREAM_CALLBACK_URL is required by HANDLER_MODE=stream"))
+	}
+	if conf.Token != "" && conf.TokenSecretARN != "" {
+		env.fail(errors.New("Only one of environment variables API_TOKEN and API_TOKEN_SECRET_ARN could be set"))
+	}
+	if env.err != nil {
+		return nil, env.err
+	}
+	return conf, nil
 }
 
 // Must ensures configuration is properly initialized
@@ -35,3 +414,32 @@ func Must(conf *Configuration, err error) *Configuration {
 	}
 	return conf
 }
+
+// SizeLimits returns the limits of stored requests
+func (c *Configuration) SizeLimits() schema.SizeLimits {
+	return schema.SizeLimits{
+		Payload:    c.MaxPayloadSize,
+		Headers:    c.MaxHeaders,
+		HeaderSize: c.MaxHeaderSize,
+	}
+}
+
+// LoadIndexes returns the configuration of the indexes of table queried by listings set by
+// environment, without the rest of the configuration which the tools listing requests do not need
+func LoadIndexes(tableName string) (*Configuration, error) {
+	env := new(envReader)
+	conf := &Configuration{
+		TableName:   tableName,
+		StatusIndex: env.bool("STATUS_INDEX", false),
+		IndexedTags: env.indexedTags(),
+	}
+	return conf, env.err
+}
+
+// LoadSizeLimits returns the limits of stored requests set by environment, without the rest of
+// the configuration which the tools writing requests do not need
+func LoadSizeLimits() (schema.SizeLimits, error) {
+	env := new(envReader)
+	limits := env.sizeLimits()
+	return limits, env.err
+}
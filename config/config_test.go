@@ -0,0 +1,184 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/multierr"
+)
+
+func TestNewConfiguration(t *testing.T) {
+	for _, c := range []struct {
+		caseName string
+		env      map[string]string
+		errs     []string
+		verify   func(t *testing.T, conf *Configuration)
+	}{
+		{
+			caseName: "defaults",
+			env:      map[string]string{"TABLE_NAME": "citium_schedule"},
+			verify: func(t *testing.T, conf *Configuration) {
+				assert.Equal(t, "citium_schedule", conf.TableName)
+				assert.Equal(t, DefaultRetryOnStatus, conf.RetryOnStatus)
+				assert.Equal(t, DefaultRetryBackoff, conf.RetryBackoff)
+				assert.Equal(t, HandlerTrigger, conf.HandlerMode)
+				assert.Equal(t, DefaultMetricsNamespace, conf.MetricsNamespace)
+				assert.Equal(t, DefaultAuditActor, conf.AuditActor)
+			},
+		},
+		{
+			caseName: "empty_metrics_namespace",
+			env:      map[string]string{"TABLE_NAME": "citium_schedule", "METRICS_NAMESPACE": ""},
+			verify: func(t *testing.T, conf *Configuration) {
+				// set empty disables metrics rather than falling back to the default
+				assert.Empty(t, conf.MetricsNamespace)
+			},
+		},
+		{
+			caseName: "every_error_at_once",
+			env: map[string]string{
+				"MAX_RETRIES":     "many",
+				"RETRY_BACKOFF":   "soon",
+				"HTTP2_MODE":      "h3",
+				"RETRY_ON_STATUS": "6xx",
+				"BASE_URL":        "api.example.com",
+			},
+			errs: []string{
+				"Invalid environment variable RETRY_ON_STATUS=6xx",
+				"Require environment variable TABLE_NAME",
+				"Invalid environment variable BASE_URL",
+				"strconv.ParseInt MAX_RETRIES=many",
+				"time.ParseDuration RETRY_BACKOFF=soon",
+				"Invalid environment variable HTTP2_MODE=h3",
+			},
+		},
+		{
+			caseName: "cross_field",
+			env: map[string]string{
+				"TABLE_NAME":           "citium_schedule",
+				"EXECUTION_MODE":       ExecutionStepFunctions,
+				"LEASE_TABLE":          "citium_lease",
+				"HANDLER_MODE":         HandlerAPI,
+				"API_TOKEN":            "token",
+				"API_TOKEN_SECRET_ARN": "arn:aws:secretsmanager:us-east-1:123456789012:secret:token",
+				"DLQ_QUEUE_URL":        "https://sqs.us-east-1.amazonaws.com/123456789012/dlq",
+				"DLQ_TABLE":            "citium_dlq",
+			},
+			errs: []string{
+				"Only one of environment variables DLQ_QUEUE_URL and DLQ_TABLE could be set",
+				"EXECUTION_STATE_MACHINE_ARN is required by EXECUTION_MODE=stepfunctions",
+				"LEASE_HOLDER is required by LEASE_TABLE outside of Lambda",
+				"MANAGEMENT_API_TOKEN or MANAGEMENT_API_TOKENS is required by HANDLER_MODE=api",
+				"Only one of environment variables API_TOKEN and API_TOKEN_SECRET_ARN could be set",
+			},
+		},
+		{
+			caseName: "stream_without_callback",
+			env:      map[string]string{"TABLE_NAME": "citium_schedule", "HANDLER_MODE": HandlerStream},
+			errs:     []string{"STREAM_CALLBACK_URL is required by HANDLER_MODE=stream"},
+		},
+		{
+			caseName: "lease_holder_of_region",
+			env:      map[string]string{"TABLE_NAME": "citium_schedule", "LEASE_TABLE": "citium_lease", "AWS_REGION": "eu-west-1"},
+			verify: func(t *testing.T, conf *Configuration) {
+				assert.Equal(t, "eu-west-1", conf.LeaseHolder)
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			isolate(t, "CONFIG_FILE", "CITIUM_PROFILE", "TABLE_NAME", "AWS_REGION", "LEASE_HOLDER", "AWS_LAMBDA_FUNCTION_NAME")
+			for key, value := range c.env {
+				t.Setenv(key, value)
+			}
+			conf, err := NewConfiguration()
+			if len(c.errs) > 0 {
+				require.Error(t, err)
+				assert.Nil(t, conf)
+				errs := multierr.Errors(err)
+				require.Len(t, errs, len(c.errs), err.Error())
+				for _, expect := range c.errs {
+					assert.Contains(t, err.Error(), expect)
+				}
+				return
+			}
+			require.NoError(t, err)
+			c.verify(t, conf)
+		})
+	}
+}
+
+func TestNewConfigurationPrecedence(t *testing.T) {
+	isolate(t, "CONFIG_FILE", "CITIUM_PROFILE", "TABLE_NAME", "BASE_URL", "API_TOKEN", "USER_AGENT", "MAX_RETRIES", "RETRY_BACKOFF")
+	t.Setenv("BASE_URL", "https://env.example.com")
+	t.Setenv("CITIUM_PROFILE", "prod")
+	t.Setenv("CONFIG_FILE", writeFile(t, "citium.yaml", `
+table_name: citium_file
+base_url: https://file.example.com
+api_token: file-token
+user_agent: file-agent
+max_retries: 1
+profiles:
+  prod:
+    max_retries: 5
+  staging:
+    retry_backoff: 1s
+`))
+	// parameters are loaded at cold start before the configuration reads the file
+	conn := &mockSSM{params: map[string]map[string]string{
+		"/citium":      {"base_url": "https://ssm.example.com", "api_token": "ssm-token", "user_agent": "ssm-agent"},
+		"/citium/prod": {"user_agent": "ssm-prod-agent"},
+	}}
+	require.NoError(t, LoadParameters(conn, "/citium", "prod"))
+	conf, err := NewConfiguration()
+	require.NoError(t, err)
+	assert.Equal(t, "prod", conf.Profile)
+	// environment over parameters over file, profiles over their top level
+	assert.Equal(t, "https://env.example.com", conf.BaseURL)
+	assert.Equal(t, "ssm-token", conf.Token)
+	assert.Equal(t, "ssm-prod-agent", conf.UserAgent)
+	assert.Equal(t, 5, conf.MaxRetries)
+	assert.Equal(t, "citium_file", conf.TableName)
+	// the other profiles are left out
+	assert.Equal(t, DefaultRetryBackoff, conf.RetryBackoff)
+	assert.NotEqual(t, time.Second, conf.RetryBackoff)
+}
+
+func TestNewConfigurationHostTokens(t *testing.T) {
+	for _, c := range []struct {
+		caseName string
+		value    string
+		expect   map[string]string
+		err      bool
+	}{
+		{
+			caseName: "unset",
+		},
+		{
+			caseName: "host_and_port",
+			value:    "api.foo.com=token1, api.bar.com:8443=token2",
+			expect:   map[string]string{"api.foo.com": "token1", "api.bar.com:8443": "token2"},
+		},
+		{
+			caseName: "invalid_pair",
+			value:    "api.foo.com",
+			err:      true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			isolate(t, "CONFIG_FILE", "CITIUM_PROFILE")
+			t.Setenv("TABLE_NAME", "citium_schedule")
+			t.Setenv("HOST_TOKENS", c.value)
+			conf, err := NewConfiguration()
+			if c.err {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "HOST_TOKENS")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.expect, conf.HostTokens)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LoadDir reads the files of a directory, e.g. a mounted Kubernetes secret, into environment
+// variables which are unset or empty. Files are named after config keys in either case, e.g.
+// api_token or API_TOKEN, and hold their value. Files of the sub directory of given namespace take
+// precedence so that one secret serves several namespaces. Hidden entries such as the ..data link
+// of secret volumes are skipped.
+func LoadDir(dir, namespace string) error {
+	if namespace != "" {
+		nsDir := filepath.Join(dir, namespace)
+		if _, err := os.Stat(nsDir); err == nil {
+			if err = loadDir(nsDir); err != nil {
+				return errors.Wrapf(err, "namespace=%s", namespace)
+			}
+		}
+	}
+	return loadDir(dir)
+}
+
+func loadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrapf(err, "os.ReadDir dir=%s", dir)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		// secret volumes link their files, directories are other namespaces
+		info, err := os.Stat(path)
+		if err != nil {
+			return errors.Wrapf(err, "os.Stat path=%s", path)
+		}
+		if info.IsDir() {
+			continue
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "os.ReadFile path=%s", path)
+		}
+		if err = setDefault(strings.ToLower(entry.Name()), strings.TrimRight(string(raw), "\r\n")); err != nil {
+			return errors.Wrapf(err, "config dir=%s", dir)
+		}
+	}
+	return nil
+}
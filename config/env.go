@@ -0,0 +1,185 @@
+package config
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+
+	"github.com/meomap/citium/schema"
+)
+
+// indexedTagPattern matches the tag keys allowed in the names of their indexes
+var indexedTagPattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// envReader parses environment variables, collecting every problem rather than stopping at the
+// first one so that a misconfigured deployment is fixed in a single round
+type envReader struct {
+	err error
+}
+
+func (r *envReader) fail(err error) {
+	r.err = multierr.Append(r.err, err)
+}
+
+// required returns the value of key, which must not be empty
+func (r *envReader) required(key string) string {
+	v := os.Getenv(key)
+	if v == "" {
+		r.fail(errors.Errorf("Require environment variable %s", key))
+	}
+	return v
+}
+
+func (r *envReader) int(key string, fallback, min int) int {
+	return int(r.int64(key, int64(fallback), int64(min)))
+}
+
+func (r *envReader) int64(key string, fallback, min int64) int64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		r.fail(errors.Wrapf(err, "strconv.ParseInt %s=%s", key, raw))
+		return fallback
+	}
+	if v < min {
+		r.fail(errors.Errorf("Invalid environment variable %s=%d, expect at least %d", key, v, min))
+	}
+	return v
+}
+
+func (r *envReader) duration(key string, fallback, min time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil {
+		r.fail(errors.Wrapf(err, "time.ParseDuration %s=%s", key, raw))
+		return fallback
+	}
+	if v < min {
+		r.fail(errors.Errorf("Invalid environment variable %s=%s, expect at least %s", key, v, min))
+	}
+	return v
+}
+
+func (r *envReader) bool(key string, fallback bool) bool {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		r.fail(errors.Wrapf(err, "strconv.ParseBool %s=%s", key, raw))
+		return fallback
+	}
+	return v
+}
+
+// oneOf returns the value of key among allowed ones, fallback if empty
+func (r *envReader) oneOf(key, fallback string, allowed ...string) string {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	for _, a := range allowed {
+		if v == a {
+			return v
+		}
+	}
+	r.fail(errors.Errorf("Invalid environment variable %s=%s, expect one of %s", key, v, strings.Join(allowed, ",")))
+	return v
+}
+
+// url returns the value of key which must be an absolute http(s) url if set
+func (r *envReader) url(key string) string {
+	v := os.Getenv(key)
+	if v == "" {
+		return v
+	}
+	u, err := url.Parse(v)
+	if err != nil {
+		// url.Error repeats the url which could hold a secret e.g. slack webhook
+		r.fail(errors.Errorf("Invalid environment variable %s, expect absolute url", key))
+		return v
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		r.fail(errors.Errorf("Invalid environment variable %s, expect absolute http(s) url", key))
+	}
+	return v
+}
+
+// hostPort returns the value of key which must be in format host:port if set
+func (r *envReader) hostPort(key, fallback string) string {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	if _, _, err := net.SplitHostPort(v); err != nil {
+		r.fail(errors.Wrapf(err, "Invalid environment variable %s=%s", key, v))
+	}
+	return v
+}
+
+// stringMap parses comma separated list of key=value pairs
+func (r *envReader) stringMap(key string) map[string]string {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return nil
+	}
+	m := map[string]string{}
+	for _, pair := range strings.Split(raw, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			r.fail(errors.Errorf("Invalid environment variable %s pair=%s, expect format key=value", key, pair))
+			continue
+		}
+		m[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return m
+}
+
+// stringList parses comma separated list of values
+func (r *envReader) stringList(key string) []string {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return nil
+	}
+	var values []string
+	for _, value := range strings.Split(raw, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// indexedTags parses the tag keys of INDEXED_TAGS
+func (r *envReader) indexedTags() []string {
+	keys := r.stringList("INDEXED_TAGS")
+	for _, key := range keys {
+		if !indexedTagPattern.MatchString(key) {
+			r.fail(errors.Errorf("Invalid environment variable INDEXED_TAGS key=%s, expect letters, digits, '_', '-' or '.'", key))
+		}
+	}
+	return keys
+}
+
+// sizeLimits parses the limits of stored requests
+func (r *envReader) sizeLimits() schema.SizeLimits {
+	return schema.SizeLimits{
+		Payload:    r.int("MAX_PAYLOAD_SIZE", schema.DefaultMaxPayloadSize, 0),
+		Headers:    r.int("MAX_HEADERS", schema.DefaultMaxHeaders, 0),
+		HeaderSize: r.int("MAX_HEADER_SIZE", schema.DefaultMaxHeaderSize, 0),
+	}
+}
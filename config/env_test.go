@@ -0,0 +1,187 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/multierr"
+)
+
+func TestEnvReader(t *testing.T) {
+	for _, c := range []struct {
+		caseName string
+		value    string
+		read     func(r *envReader) interface{}
+		expect   interface{}
+		err      string
+	}{
+		{
+			caseName: "int_unset",
+			read:     func(r *envReader) interface{} { return r.int("TEST_KEY", 7, 0) },
+			expect:   7,
+		},
+		{
+			caseName: "int",
+			value:    "42",
+			read:     func(r *envReader) interface{} { return r.int("TEST_KEY", 7, 0) },
+			expect:   42,
+		},
+		{
+			caseName: "int_invalid",
+			value:    "many",
+			read:     func(r *envReader) interface{} { return r.int("TEST_KEY", 7, 0) },
+			expect:   7,
+			err:      "strconv.ParseInt TEST_KEY=many",
+		},
+		{
+			caseName: "int_below_min",
+			value:    "0",
+			read:     func(r *envReader) interface{} { return r.int("TEST_KEY", 7, 1) },
+			expect:   0,
+			err:      "Invalid environment variable TEST_KEY=0, expect at least 1",
+		},
+		{
+			caseName: "duration",
+			value:    "90s",
+			read:     func(r *envReader) interface{} { return r.duration("TEST_KEY", time.Minute, 0) },
+			expect:   90 * time.Second,
+		},
+		{
+			caseName: "duration_invalid",
+			value:    "90",
+			read:     func(r *envReader) interface{} { return r.duration("TEST_KEY", time.Minute, 0) },
+			expect:   time.Minute,
+			err:      "time.ParseDuration TEST_KEY=90",
+		},
+		{
+			caseName: "duration_below_min",
+			value:    "1ms",
+			read:     func(r *envReader) interface{} { return r.duration("TEST_KEY", time.Minute, time.Second) },
+			expect:   time.Millisecond,
+			err:      "expect at least 1s",
+		},
+		{
+			caseName: "bool_invalid",
+			value:    "yes",
+			read:     func(r *envReader) interface{} { return r.bool("TEST_KEY", true) },
+			expect:   true,
+			err:      "strconv.ParseBool TEST_KEY=yes",
+		},
+		{
+			caseName: "one_of",
+			value:    "off",
+			read:     func(r *envReader) interface{} { return r.oneOf("TEST_KEY", HTTP2Auto, HTTP2Auto, HTTP2Off) },
+			expect:   HTTP2Off,
+		},
+		{
+			caseName: "one_of_unset",
+			read:     func(r *envReader) interface{} { return r.oneOf("TEST_KEY", HTTP2Auto, HTTP2Auto, HTTP2Off) },
+			expect:   HTTP2Auto,
+		},
+		{
+			caseName: "one_of_invalid",
+			value:    "h3",
+			read:     func(r *envReader) interface{} { return r.oneOf("TEST_KEY", HTTP2Auto, HTTP2Auto, HTTP2Off) },
+			expect:   "h3",
+			err:      "Invalid environment variable TEST_KEY=h3, expect one of auto,off",
+		},
+		{
+			caseName: "url",
+			value:    "https://api.example.com/v1",
+			read:     func(r *envReader) interface{} { return r.url("TEST_KEY") },
+			expect:   "https://api.example.com/v1",
+		},
+		{
+			caseName: "url_relative",
+			value:    "/v1",
+			read:     func(r *envReader) interface{} { return r.url("TEST_KEY") },
+			expect:   "/v1",
+			err:      "Invalid environment variable TEST_KEY, expect absolute http(s) url",
+		},
+		{
+			caseName: "url_unparsed_hides_value",
+			value:    "https://hooks.example.com/secret\x7f",
+			read:     func(r *envReader) interface{} { return r.url("TEST_KEY") },
+			expect:   "https://hooks.example.com/secret\x7f",
+			err:      "Invalid environment variable TEST_KEY, expect absolute url",
+		},
+		{
+			caseName: "host_port",
+			value:    "10.0.0.2:53",
+			read:     func(r *envReader) interface{} { return r.hostPort("TEST_KEY", ":9090") },
+			expect:   "10.0.0.2:53",
+		},
+		{
+			caseName: "host_port_unset",
+			read:     func(r *envReader) interface{} { return r.hostPort("TEST_KEY", ":9090") },
+			expect:   ":9090",
+		},
+		{
+			caseName: "host_port_invalid",
+			value:    "10.0.0.2",
+			read:     func(r *envReader) interface{} { return r.hostPort("TEST_KEY", ":9090") },
+			expect:   "10.0.0.2",
+			err:      "Invalid environment variable TEST_KEY=10.0.0.2",
+		},
+		{
+			caseName: "string_map",
+			value:    "a=1, b = x=y ",
+			read:     func(r *envReader) interface{} { return r.stringMap("TEST_KEY") },
+			expect:   map[string]string{"a": "1", "b": "x=y"},
+		},
+		{
+			caseName: "string_map_invalid_pair",
+			value:    "a=1,b,=2",
+			read:     func(r *envReader) interface{} { return r.stringMap("TEST_KEY") },
+			expect:   map[string]string{"a": "1"},
+			err:      "Invalid environment variable TEST_KEY pair=b, expect format key=value; Invalid environment variable TEST_KEY pair==2, expect format key=value",
+		},
+		{
+			caseName: "string_list",
+			value:    "a, ,b",
+			read:     func(r *envReader) interface{} { return r.stringList("TEST_KEY") },
+			expect:   []string{"a", "b"},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			t.Setenv("TEST_KEY", c.value)
+			r := new(envReader)
+			assert.Equal(t, c.expect, c.read(r))
+			if c.err == "" {
+				assert.NoError(t, r.err)
+				return
+			}
+			if assert.Error(t, r.err) {
+				assert.Contains(t, r.err.Error(), c.err)
+			}
+		})
+	}
+}
+
+func TestEnvReaderRequired(t *testing.T) {
+	t.Setenv("TEST_KEY", "")
+	r := new(envReader)
+	assert.Empty(t, r.required("TEST_KEY"))
+	assert.EqualError(t, r.err, "Require environment variable TEST_KEY")
+}
+
+func TestEnvReaderIndexedTags(t *testing.T) {
+	t.Setenv("INDEXED_TAGS", "team, env,bad key")
+	r := new(envReader)
+	assert.Equal(t, []string{"team", "env", "bad key"}, r.indexedTags())
+	assert.EqualError(t, r.err, "Invalid environment variable INDEXED_TAGS key=bad key, expect letters, digits, '_', '-' or '.'")
+}
+
+func TestEnvReaderAggregates(t *testing.T) {
+	t.Setenv("TEST_INT", "many")
+	t.Setenv("TEST_DURATION", "soon")
+	t.Setenv("TEST_URL", "ftp://example.com")
+	r := new(envReader)
+	r.int("TEST_INT", 0, 0)
+	r.duration("TEST_DURATION", 0, 0)
+	r.url("TEST_URL")
+	// every problem is reported rather than the first one only
+	assert.Len(t, multierr.Errors(r.err), 3)
+}
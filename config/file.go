@@ -0,0 +1,138 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// configKeys are the keys accepted in config file and parameter store, the json names of
+// configuration fields which are the lower cased names of their environment variables
+var configKeys = func() map[string]bool {
+	keys := map[string]bool{}
+	t := reflect.TypeOf(Configuration{})
+	for i := 0; i < t.NumField(); i++ {
+		if name := t.Field(i).Tag.Get("json"); name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+	return keys
+}()
+
+// loadedKeys are the environment variables set from config file or parameters rather than by the
+// environment itself
+var loadedKeys = map[string]bool{}
+
+// ClearLoaded unsets the environment variables set from config file or parameters, so that their
+// sources are read again by a reload
+func ClearLoaded() error {
+	for envKey := range loadedKeys {
+		if err := os.Unsetenv(envKey); err != nil {
+			return errors.Wrapf(err, "os.Unsetenv key=%s", envKey)
+		}
+		delete(loadedKeys, envKey)
+	}
+	return nil
+}
+
+// profilesKey holds the named profiles of config file, each of them a map of config keys
+const profilesKey = "profiles"
+
+// LoadFile reads the YAML (or JSON) config file at path into environment variables which are unset
+// or empty, so that environment overrides the file and both are validated the same way. Empty ones
+// are filled too as the template declares every variable. Keys of given profile take precedence
+// over the top level ones.
+func LoadFile(path, profile string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "os.ReadFile path=%s", path)
+	}
+	values := map[string]interface{}{}
+	if err = yaml.Unmarshal(raw, &values); err != nil {
+		return errors.Wrapf(err, "yaml.Unmarshal path=%s", path)
+	}
+	profiles, _ := values[profilesKey].(map[string]interface{})
+	delete(values, profilesKey)
+	if profile != "" {
+		profileValues, ok := profiles[profile].(map[string]interface{})
+		if !ok {
+			return errors.Errorf("Unknown profile of config file path=%s profile=%s", path, profile)
+		}
+		for key, value := range profileValues {
+			if err = setDefault(key, value); err != nil {
+				return errors.Wrapf(err, "config file path=%s profile=%s", path, profile)
+			}
+		}
+	}
+	for key, value := range values {
+		if err = setDefault(key, value); err != nil {
+			return errors.Wrapf(err, "config file path=%s", path)
+		}
+	}
+	return nil
+}
+
+// setDefault sets the environment variable of config key to value unless it is set already
+func setDefault(key string, value interface{}) error {
+	if !configKeys[key] {
+		return errors.Errorf("Unknown config key=%s", key)
+	}
+	envKey := strings.ToUpper(key)
+	if os.Getenv(envKey) != "" {
+		return nil
+	}
+	flat, err := flatten(value)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid value of config key=%s", key)
+	}
+	if err = os.Setenv(envKey, flat); err != nil {
+		return errors.Wrapf(err, "os.Setenv key=%s", envKey)
+	}
+	loadedKeys[envKey] = true
+	return nil
+}
+
+// flatten formats value the way its environment variable is written: lists are comma separated
+// and maps are comma separated key=value pairs
+func flatten(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := scalar(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(v))
+		for k, item := range v {
+			s, err := scalar(item)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, k+"="+s)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	default:
+		return scalar(v)
+	}
+}
+
+func scalar(value interface{}) (string, error) {
+	switch value.(type) {
+	case []interface{}, map[string]interface{}:
+		return "", errors.Errorf("nested value %v", value)
+	}
+	return fmt.Sprint(value), nil
+}
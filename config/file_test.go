@@ -0,0 +1,151 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFile returns the path of a temporary config file of content
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadFile(t *testing.T) {
+	const content = `
+table_name: citium_schedule
+base_url: https://api.example.com
+max_retries: 3
+retry_on_status: [429, 502, 503]
+default_headers:
+  X-Tenant: acme
+  Accept: application/json
+profiles:
+  prod:
+    table_name: citium_prod
+  staging:
+    base_url: https://staging.example.com
+`
+	for _, c := range []struct {
+		caseName string
+		content  string
+		name     string
+		profile  string
+		env      map[string]string
+		err      string
+		expect   map[string]string
+	}{
+		{
+			caseName: "top_level",
+			content:  content,
+			expect: map[string]string{
+				"TABLE_NAME":      "citium_schedule",
+				"BASE_URL":        "https://api.example.com",
+				"MAX_RETRIES":     "3",
+				"RETRY_ON_STATUS": "429,502,503",
+				"DEFAULT_HEADERS": "Accept=application/json,X-Tenant=acme",
+			},
+		},
+		{
+			caseName: "profile",
+			content:  content,
+			profile:  "prod",
+			expect: map[string]string{
+				"TABLE_NAME": "citium_prod",
+				"BASE_URL":   "https://api.example.com",
+			},
+		},
+		{
+			caseName: "environment_overrides",
+			content:  content,
+			profile:  "staging",
+			env:      map[string]string{"BASE_URL": "https://local.example.com"},
+			expect: map[string]string{
+				"TABLE_NAME": "citium_schedule",
+				"BASE_URL":   "https://local.example.com",
+			},
+		},
+		{
+			caseName: "json",
+			name:     "citium.json",
+			content:  `{"table_name": "citium_json", "indexed_tags": ["team", "env"]}`,
+			expect: map[string]string{
+				"TABLE_NAME":   "citium_json",
+				"INDEXED_TAGS": "team,env",
+			},
+		},
+		{
+			caseName: "unknown_profile",
+			content:  content,
+			profile:  "dev",
+			err:      "Unknown profile of config file",
+		},
+		{
+			caseName: "unknown_key",
+			content:  "table_nam: citium_schedule\n",
+			err:      "Unknown config key=table_nam",
+		},
+		{
+			caseName: "nested_value",
+			content:  "default_headers:\n  X-Tenant: [acme, globex]\n",
+			err:      "Invalid value of config key=default_headers: nested value",
+		},
+		{
+			caseName: "invalid_yaml",
+			content:  "table_name: [citium\n",
+			err:      "yaml.Unmarshal",
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			isolate(t, "TABLE_NAME", "BASE_URL", "MAX_RETRIES", "RETRY_ON_STATUS", "DEFAULT_HEADERS", "INDEXED_TAGS")
+			for key, value := range c.env {
+				t.Setenv(key, value)
+			}
+			name := c.name
+			if name == "" {
+				name = "citium.yaml"
+			}
+			err := LoadFile(writeFile(t, name, c.content), c.profile)
+			if c.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), c.err)
+				return
+			}
+			require.NoError(t, err)
+			for key, value := range c.expect {
+				assert.Equal(t, value, os.Getenv(key), key)
+			}
+		})
+	}
+}
+
+func TestLoadFileMissing(t *testing.T) {
+	err := LoadFile(filepath.Join(t.TempDir(), "missing.yaml"), "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "os.ReadFile")
+}
+
+func TestClearLoaded(t *testing.T) {
+	isolate(t, "TABLE_NAME", "MAX_RETRIES")
+	t.Setenv("BASE_URL", "https://env.example.com")
+	path := writeFile(t, "citium.yaml", "table_name: citium_schedule\nmax_retries: 3\nbase_url: https://file.example.com\n")
+	require.NoError(t, LoadFile(path, ""))
+	assert.Equal(t, "3", os.Getenv("MAX_RETRIES"))
+
+	// a reload reads the changed file again, the environment still overriding it
+	require.NoError(t, os.WriteFile(path, []byte("table_name: citium_schedule\nbase_url: https://file.example.com\n"), 0o600))
+	require.NoError(t, ClearLoaded())
+	_, found := os.LookupEnv("MAX_RETRIES")
+	assert.False(t, found)
+	assert.Equal(t, "https://env.example.com", os.Getenv("BASE_URL"))
+	require.NoError(t, LoadFile(path, ""))
+	assert.Equal(t, "citium_schedule", os.Getenv("TABLE_NAME"))
+	assert.Empty(t, os.Getenv("MAX_RETRIES"))
+	assert.Equal(t, "https://env.example.com", os.Getenv("BASE_URL"))
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/pkg/errors"
+)
+
+// LoadParameters reads the SSM parameters directly under path prefix into environment variables
+// which are unset or empty, to be called before NewConfiguration at cold start. Parameters are
+// named after config keys e.g. /citium/api_token, secure strings are decrypted. Parameters of
+// given profile under its sub path e.g. /citium/prod/api_token take precedence.
+func LoadParameters(conn ssmiface.SSMAPI, prefix, profile string) error {
+	prefix = "/" + strings.Trim(prefix, "/")
+	if profile != "" {
+		if err := loadParameters(conn, path.Join(prefix, profile)); err != nil {
+			return err
+		}
+	}
+	return loadParameters(conn, prefix)
+}
+
+func loadParameters(conn ssmiface.SSMAPI, prefix string) error {
+	values := map[string]string{}
+	err := conn.GetParametersByPathPages(&ssm.GetParametersByPathInput{
+		Path:           aws.String(prefix),
+		WithDecryption: aws.Bool(true),
+	}, func(page *ssm.GetParametersByPathOutput, lastPage bool) bool {
+		for _, param := range page.Parameters {
+			values[path.Base(aws.StringValue(param.Name))] = aws.StringValue(param.Value)
+		}
+		return true
+	})
+	if err != nil {
+		return errors.Wrapf(err, "conn.GetParametersByPathPages path=%s", prefix)
+	}
+	for key, value := range values {
+		if err = setDefault(key, value); err != nil {
+			return errors.Wrapf(err, "parameter path=%s", prefix)
+		}
+	}
+	return nil
+}
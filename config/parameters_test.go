@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockSSM returns the parameters by path they are directly under, in pages of one parameter
+type mockSSM struct {
+	ssmiface.SSMAPI
+	params map[string]map[string]string
+	err    error
+	paths  []string
+}
+
+func (m *mockSSM) GetParametersByPathPages(input *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool) error {
+	path := aws.StringValue(input.Path)
+	m.paths = append(m.paths, path)
+	if m.err != nil {
+		return m.err
+	}
+	for name, value := range m.params[path] {
+		page := &ssm.GetParametersByPathOutput{Parameters: []*ssm.Parameter{{Name: aws.String(path + "/" + name), Value: aws.String(value)}}}
+		if !fn(page, false) {
+			break
+		}
+	}
+	return nil
+}
+
+// isolate empties the environment variables of keys for the duration of test, and unsets the
+// ones loaded from config sources once it is done
+func isolate(t *testing.T, keys ...string) {
+	for _, key := range keys {
+		t.Setenv(key, "")
+	}
+	t.Cleanup(func() {
+		require.NoError(t, ClearLoaded())
+	})
+}
+
+func TestLoadParameters(t *testing.T) {
+	for _, c := range []struct {
+		caseName  string
+		prefix    string
+		profile   string
+		params    map[string]map[string]string
+		getErr    error
+		err       string
+		wantPaths []string
+		expect    map[string]string
+	}{
+		{
+			caseName:  "prefix",
+			prefix:    "citium/",
+			params:    map[string]map[string]string{"/citium": {"api_token": "secret", "max_retries": "3"}},
+			wantPaths: []string{"/citium"},
+			expect:    map[string]string{"API_TOKEN": "secret", "MAX_RETRIES": "3"},
+		},
+		{
+			caseName: "profile_first",
+			prefix:   "/citium",
+			profile:  "prod",
+			params: map[string]map[string]string{
+				"/citium":      {"api_token": "secret", "max_retries": "3"},
+				"/citium/prod": {"max_retries": "5"},
+			},
+			wantPaths: []string{"/citium/prod", "/citium"},
+			expect:    map[string]string{"API_TOKEN": "secret", "MAX_RETRIES": "5"},
+		},
+		{
+			caseName: "unknown_key",
+			prefix:   "/citium",
+			params:   map[string]map[string]string{"/citium": {"api_tokn": "secret"}},
+			err:      "parameter path=/citium: Unknown config key=api_tokn",
+		},
+		{
+			caseName: "get_error",
+			prefix:   "/citium",
+			getErr:   errors.New("AccessDeniedException"),
+			err:      "conn.GetParametersByPathPages path=/citium: AccessDeniedException",
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			isolate(t, "API_TOKEN", "MAX_RETRIES")
+			conn := &mockSSM{params: c.params, err: c.getErr}
+			err := LoadParameters(conn, c.prefix, c.profile)
+			if c.err != "" {
+				assert.EqualError(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.wantPaths, conn.paths)
+			for key, value := range c.expect {
+				assert.Equal(t, value, os.Getenv(key), key)
+			}
+		})
+	}
+}
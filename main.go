@@ -2,26 +2,263 @@ package main
 
 import (
 	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
 
+	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-xray-sdk-go/xray"
 	"github.com/pkg/errors"
 
 	"github.com/meomap/citium/config"
 	"github.com/meomap/citium/scheduler"
 )
 
-func handler(conf *config.Configuration, conn dynamodbiface.DynamoDBAPI, client scheduler.Requester) func(ctx context.Context) error {
-	return func(ctx context.Context) error {
-		return errors.Wrap(scheduler.TriggerAPI(ctx, conf, conn, client), "scheduler.TriggerAPI")
+// runFunc triggers the due requests, the summary is returned as Lambda response
+type runFunc func(ctx context.Context) (*scheduler.RunSummary, error)
+
+func handler(conf *config.Configuration, conn dynamodbiface.DynamoDBAPI, svc *scheduler.Services) runFunc {
+	return func(ctx context.Context) (*scheduler.RunSummary, error) {
+		summary, err := scheduler.TriggerAPI(ctx, conf, conn, svc)
+		return summary, errors.Wrap(err, "scheduler.TriggerAPI")
+	}
+}
+
+// shardFunc triggers the due requests of a shard
+type shardFunc func(ctx context.Context, shard int) (*scheduler.RunSummary, error)
+
+func shardHandler(conf *config.Configuration, conn dynamodbiface.DynamoDBAPI, svc *scheduler.Services) shardFunc {
+	return func(ctx context.Context, shard int) (*scheduler.RunSummary, error) {
+		summary, err := scheduler.TriggerShard(ctx, conf, conn, svc, shard)
+		return summary, errors.Wrap(err, "scheduler.TriggerShard")
+	}
+}
+
+// invoke routes a direct invocation on its action, the scheduled event carrying none runs the due
+// requests, only the ones of its shard if given
+func invoke(run runFunc, runShard shardFunc, router *scheduler.Router) func(ctx context.Context, inv *scheduler.Invocation) (interface{}, error) {
+	return func(ctx context.Context, inv *scheduler.Invocation) (interface{}, error) {
+		if inv.Action == "" || (inv.Action == scheduler.InvokeTrigger && inv.ID == "") {
+			if inv.Shard != nil {
+				return runShard(ctx, *inv.Shard)
+			}
+			return run(ctx)
+		}
+		return router.Invoke(ctx, inv)
+	}
+}
+
+// healthcheck reports whether the deployment is able to run the schedule, as post-deploy smoke test
+func healthcheck(conf *config.Configuration, conn dynamodbiface.DynamoDBAPI, client *scheduler.HTTPClient) func(ctx context.Context) (*scheduler.HealthReport, error) {
+	return func(ctx context.Context) (*scheduler.HealthReport, error) {
+		report := scheduler.CheckHealth(ctx, conn, conf.TableName, client.Client, conf.BaseURL)
+		log.Printf("health report %s \n", report.ToString())
+		return report, nil
 	}
 }
 
+// ingestSQS creates the requests sent as SQS messages, reporting the failed ones
+func ingestSQS(conf *config.Configuration, conn dynamodbiface.DynamoDBAPI, audit *scheduler.AuditLog) func(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	return func(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+		resp := scheduler.IngestSQS(ctx, conn, conf.TableName, audit, event)
+		log.Printf("ingested messages count=%d failed=%d \n", len(event.Records), len(resp.BatchItemFailures))
+		return resp, nil
+	}
+}
+
+// ingestEvent creates the request sent as custom EventBridge event, a failure is returned for the
+// asynchronous invocation to be retried and then dead-lettered
+func ingestEvent(conf *config.Configuration, conn dynamodbiface.DynamoDBAPI, audit *scheduler.AuditLog) func(ctx context.Context, event events.CloudWatchEvent) error {
+	return func(ctx context.Context, event events.CloudWatchEvent) error {
+		_, err := scheduler.IngestEvent(ctx, conn, conf.TableName, audit, event)
+		return err
+	}
+}
+
+// stream posts the changes of stored requests read from the table stream, reporting the failed
+// records
+func stream(callback *scheduler.StreamCallback) func(ctx context.Context, event events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+	return func(ctx context.Context, event events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+		resp := callback.Handle(ctx, event)
+		log.Printf("handled stream records count=%d failed=%d \n", len(event.Records), len(resp.BatchItemFailures))
+		return resp, nil
+	}
+}
+
+// api serves the management API of stored requests behind API Gateway
+func api(management *scheduler.ManagementAPI) func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return management.Handle(ctx, req), nil
+	}
+}
+
+// traced records each run as X-Ray segment, which Lambda otherwise creates for the invocation
+func traced(run runFunc) runFunc {
+	return func(ctx context.Context) (*scheduler.RunSummary, error) {
+		ctx, seg := xray.BeginSegment(ctx, "citium")
+		summary, err := run(ctx)
+		seg.Close(err)
+		return summary, err
+	}
+}
+
+// runBuilder returns the run function of given configuration
+type runBuilder func(conf *config.Configuration) (runFunc, error)
+
+// daemon runs the schedule every poll interval until interrupted, exposing metrics over http.
+// Configuration is reloaded every reload interval, a changed one replaces the run function.
+// Once interrupted, the run in progress starts no more executions and the ones in flight are
+// given the shutdown timeout to complete.
+func daemon(conf *config.Configuration, metrics http.Handler, reload func() (*config.Configuration, error), runOf runBuilder) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// executions outlive the signal until the shutdown timeout
+	runCtx, cancelRun := context.WithCancel(context.Background())
+	defer cancelRun()
+	shutdownTimeout := conf.ShutdownTimeout
+	go func() {
+		<-ctx.Done()
+		log.Printf("shutdown started timeout=%s \n", shutdownTimeout)
+		timer := time.NewTimer(shutdownTimeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			log.Printf("shutdown timeout reached, cancel executions in flight \n")
+			cancelRun()
+		case <-runCtx.Done():
+		}
+	}()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	srv := &http.Server{Addr: conf.MetricsAddr, Handler: mux}
+	go func() {
+		log.Printf("serve metrics addr=%s \n", conf.MetricsAddr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("metrics server failed error=%s \n", err)
+		}
+	}()
+	run, err := runOf(conf)
+	if err != nil {
+		log.Fatalf("build run failed error=%s \n", err)
+	}
+	ticker := time.NewTicker(conf.PollInterval)
+	defer ticker.Stop()
+	var reloads <-chan time.Time
+	if conf.ConfigReloadInterval > 0 {
+		reloadTicker := time.NewTicker(conf.ConfigReloadInterval)
+		defer reloadTicker.Stop()
+		reloads = reloadTicker.C
+	}
+	runOnce := func() {
+		if _, err := run(scheduler.WithDrain(runCtx, ctx.Done())); err != nil {
+			log.Printf("run failed error=%s \n", err)
+		}
+	}
+	runOnce()
+	for {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				log.Printf("metrics server shutdown failed error=%s \n", err)
+			}
+			return
+		case <-ticker.C:
+			runOnce()
+		case <-reloads:
+			newConf, err := reload()
+			if err != nil {
+				// keep running with the last valid configuration
+				log.Printf("reload configuration failed error=%s \n", err)
+				continue
+			}
+			if reflect.DeepEqual(newConf, conf) {
+				continue
+			}
+			newRun, err := runOf(newConf)
+			if err != nil {
+				log.Printf("build reloaded configuration failed error=%s \n", err)
+				continue
+			}
+			log.Printf("apply reloaded configuration \n")
+			if newConf.PollInterval != conf.PollInterval {
+				ticker.Reset(newConf.PollInterval)
+			}
+			if newConf.MetricsAddr != conf.MetricsAddr || newConf.ConfigReloadInterval != conf.ConfigReloadInterval || newConf.ShutdownTimeout != conf.ShutdownTimeout {
+				log.Printf("changes of metrics address, reload interval and shutdown timeout require restart \n")
+			}
+			conf, run = newConf, newRun
+		}
+	}
+}
+
+// loadConfiguration reads configuration from parameters, config file and environment, from
+// scratch on every call so that it could be reloaded
+func loadConfiguration(sess *session.Session) (*config.Configuration, error) {
+	if err := config.ClearLoaded(); err != nil {
+		return nil, errors.Wrap(err, "config.ClearLoaded")
+	}
+	if prefix := os.Getenv("SSM_CONFIG_PATH"); prefix != "" {
+		if err := config.LoadParameters(ssm.New(sess), prefix, os.Getenv("CITIUM_PROFILE")); err != nil {
+			return nil, errors.Wrap(err, "config.LoadParameters")
+		}
+	}
+	conf, err := config.NewConfiguration()
+	return conf, errors.Wrap(err, "config.NewConfiguration")
+}
+
 func main() {
-	conf := config.Must(config.NewConfiguration())
-	dbconn := dynamodb.New(session.Must(session.NewSession(nil)))
-	client := scheduler.Must(scheduler.NewClient(conf))
-	lambda.Start(handler(conf, dbconn, client))
+	sess := session.Must(session.NewSession(nil))
+	conf := config.Must(loadConfiguration(sess))
+	var prom *scheduler.Prometheus
+	if conf.RunMode == config.RunModeDaemon || conf.PushgatewayURL != "" {
+		prom = scheduler.NewPrometheus()
+	}
+	if conf.RunMode == config.RunModeDaemon {
+		daemon(conf, prom, func() (*config.Configuration, error) {
+			return loadConfiguration(sess)
+		}, func(conf *config.Configuration) (runFunc, error) {
+			svc, _, err := scheduler.NewServices(conf, sess, prom)
+			if err != nil {
+				return nil, err
+			}
+			run := handler(conf, svc.DynamoDB, svc)
+			if conf.TracingEnabled {
+				run = traced(run)
+			}
+			return run, nil
+		})
+		return
+	}
+	svc, client, err := scheduler.NewServices(conf, sess, prom)
+	if err != nil {
+		panic(err)
+	}
+	switch conf.HandlerMode {
+	case config.HandlerHealthCheck:
+		lambda.Start(healthcheck(conf, svc.DynamoDB, client))
+		return
+	case config.HandlerIngestSQS:
+		lambda.Start(ingestSQS(conf, svc.DynamoDB, svc.Audit))
+		return
+	case config.HandlerIngestEvent:
+		lambda.Start(ingestEvent(conf, svc.DynamoDB, svc.Audit))
+		return
+	case config.HandlerStream:
+		lambda.Start(stream(scheduler.NewStreamCallback(conf.StreamCallbackURL)))
+		return
+	case config.HandlerAPI:
+		lambda.Start(api(scheduler.NewManagementAPI(conf, svc.DynamoDB, svc)))
+		return
+	}
+	lambda.Start(invoke(handler(conf, svc.DynamoDB, svc), shardHandler(conf, svc.DynamoDB, svc), scheduler.NewRouter(conf, svc.DynamoDB, svc)))
 }
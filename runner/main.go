@@ -0,0 +1,82 @@
+// Command citium-runner runs the schedule once and exits, as the container of a Kubernetes CronJob.
+// The run summary is written to stdout as JSON while logs go to stderr. The exit code is 0 when
+// every due request succeeded, 1 when some of them failed and 2 when the run could not complete.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/scheduler"
+)
+
+// Exit codes of a run
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitError  = 2
+)
+
+// loadConfiguration reads configuration from the optional mounted secret at CONFIG_DIR, scoped by
+// the POD_NAMESPACE of the downward API, then from config file and environment
+func loadConfiguration() (*config.Configuration, error) {
+	if dir := os.Getenv("CONFIG_DIR"); dir != "" {
+		if err := config.LoadDir(dir, os.Getenv("POD_NAMESPACE")); err != nil {
+			return nil, errors.Wrap(err, "config.LoadDir")
+		}
+	}
+	conf, err := config.NewConfiguration()
+	return conf, errors.Wrap(err, "config.NewConfiguration")
+}
+
+// run triggers the due requests once, returning the exit code
+func run(ctx context.Context) int {
+	conf, err := loadConfiguration()
+	if err != nil {
+		log.Printf("load configuration failed error=%s \n", err)
+		return exitError
+	}
+	sess, err := session.NewSession(nil)
+	if err != nil {
+		log.Printf("new session failed error=%s \n", err)
+		return exitError
+	}
+	var prom *scheduler.Prometheus
+	if conf.PushgatewayURL != "" {
+		prom = scheduler.NewPrometheus()
+	}
+	svc, _, err := scheduler.NewServices(conf, sess, prom)
+	if err != nil {
+		log.Printf("new services failed error=%s \n", err)
+		return exitError
+	}
+	summary, err := scheduler.TriggerAPI(ctx, conf, svc.DynamoDB, svc)
+	if summary == nil {
+		log.Printf("run failed error=%s \n", err)
+		return exitError
+	}
+	if eErr := json.NewEncoder(os.Stdout).Encode(summary); eErr != nil {
+		log.Printf("write summary failed error=%s \n", eErr)
+	}
+	if err != nil {
+		log.Printf("run completed with failures error=%s \n", err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+func main() {
+	// the pod is sent SIGTERM once the job exceeds its deadline, requests in flight complete
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx)
+	stop()
+	os.Exit(code)
+}
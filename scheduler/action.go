@@ -2,10 +2,15 @@ package scheduler
 
 import (
 	"context"
+	"fmt"
+	"log"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/aws/aws-sdk-go/service/sfn/sfniface"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 	"github.com/pkg/errors"
 	"go.uber.org/multierr"
 
@@ -13,13 +18,94 @@ import (
 	"github.com/meomap/citium/schema"
 )
 
-// TriggerAPI executes the pre-scheduled rest API calls
-func TriggerAPI(ctx context.Context, conf *config.Configuration, dbconn dynamodbiface.DynamoDBAPI, client Requester) error {
-	requests, err := FetchSchedRequests(ctx, dbconn, conf.TableName, time.Now().UTC())
+// Services groups the clients performing scheduled actions, only the ones matching the
+// target types of scheduled requests are required
+type Services struct {
+	HTTP          Requester
+	SQS           sqsiface.SQSAPI
+	Kinesis       kinesisiface.KinesisAPI
+	StepFunctions sfniface.SFNAPI
+	Kafka         Producer
+	MQTT          Publisher
+	SSM           *SSMClient
+	DynamoDB      dynamodbiface.DynamoDBAPI
+	// Optional collector of execution metrics across runs
+	Prometheus *Prometheus
+	// If true then each execution is recorded as X-Ray subsegment
+	Tracing bool
+	// Optional receivers of failure alerts and run summaries
+	Notifiers []Notifier
+	// Retrying and dead-lettering of failed requests, zero value leaves them locked
+	Failure FailurePolicy
+	// Optional audit trail of request state transitions
+	Audit *AuditLog
+	// Optional cursor of limited fetches, resuming a backlog where the last run stopped
+	Checkpoint *Checkpoint
+	// Optional ownership of the schedule, runs not holding it stand by
+	Lease *Lease
+	// Poster of execution outcomes to the CallbackURL of requests, nil skips them
+	Callback *CompletionCallback
+	// Executors of target types set by RegisterExecutor, taking precedence over the built-in ones
+	Executors map[string]Executor
+}
+
+// TriggerAPI executes the pre-scheduled rest API calls, returns the summary of outcomes along
+// with the combined error of failed requests
+func TriggerAPI(ctx context.Context, conf *config.Configuration, dbconn dynamodbiface.DynamoDBAPI, svc *Services) (*RunSummary, error) {
+	return triggerDue(ctx, conf, dbconn, svc, nil)
+}
+
+// TriggerShard executes the due requests of a single shard like TriggerAPI does, so that
+// concurrent runs of distinct shards never fetch the same requests
+func TriggerShard(ctx context.Context, conf *config.Configuration, dbconn dynamodbiface.DynamoDBAPI, svc *Services, shard int) (*RunSummary, error) {
+	if shard < 0 {
+		return nil, errors.Wrapf(ErrValidation, "invalid shard=%d", shard)
+	}
+	return triggerDue(ctx, conf, dbconn, svc, &shard)
+}
+
+// triggerDue runs the due requests of shard, or of the whole table if it is nil
+func triggerDue(ctx context.Context, conf *config.Configuration, dbconn dynamodbiface.DynamoDBAPI, svc *Services, shard *int) (*RunSummary, error) {
+	started := time.Now().UTC()
+	// every shard has its own lease and checkpoint
+	name := conf.TableName
+	if shard != nil {
+		name = fmt.Sprintf("%s#%d", conf.TableName, *shard)
+	}
+	if svc.Lease != nil {
+		held, err := svc.Lease.Acquire(ctx, name, started)
+		if err != nil {
+			return nil, errors.Wrap(err, "lease.Acquire")
+		}
+		if !held {
+			log.Printf("stand by as the schedule is run elsewhere table_name=%s name=%s \n", conf.TableName, name)
+			return &RunSummary{TableName: conf.TableName, Shard: shard, Standby: true, StartedAt: started}, nil
+		}
+	}
+	cursor := ""
+	if svc.Checkpoint != nil {
+		var cErr error
+		if cursor, cErr = svc.Checkpoint.Load(ctx, name); cErr != nil {
+			// scanning from the beginning again is only slower
+			log.Printf("load checkpoint failed error=%s \n", cErr)
+		}
+	}
+	requests, next, err := fetchFrom(ctx, dbconn, conf.TableName, started, conf.FetchLimit, conf.ScanPageSize, cursor, shard, conf.RunTags)
 	if err != nil {
-		return errors.Wrap(err, "fetchSchedRequests")
+		return nil, errors.Wrap(err, "fetchFrom")
+	}
+	if svc.Checkpoint != nil {
+		// saved before executing so that an overlapping run does not race on the same requests
+		if cErr := svc.Checkpoint.Save(ctx, name, next, cursor, started); cErr != nil {
+			log.Printf("save checkpoint failed error=%s \n", cErr)
+		}
 	}
 	lenReqs := len(requests)
+	metrics := &runMetrics{due: lenReqs}
+	limits := newLimiter(conf.MaxConcurrency, conf.MaxConcurrencyPerHost)
+	// waiting for an execution slot stops on shutdown too
+	startCtx, cancelStart := drainContext(ctx)
+	defer cancelStart()
 
 	var wg sync.WaitGroup
 
@@ -31,7 +117,40 @@ func TriggerAPI(ctx context.Context, conf *config.Configuration, dbconn dynamodb
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				if gErr := execute(ctx, dbconn, client, req, conf.TableName); gErr != nil {
+				if conf.MaxLateness > 0 && started.Sub(req.EffectiveAfter) > conf.MaxLateness {
+					if gErr := expire(ctx, dbconn, svc, req, conf.TableName, metrics); gErr != nil {
+						metrics.recordError(req.ID, gErr)
+						errc <- errors.Wrapf(gErr, "expire %s table_name=%s", req.ToString(), conf.TableName)
+					}
+					return
+				}
+				release, gErr := limits.acquire(startCtx, targetHost(req, conf.BaseURL))
+				if gErr != nil && draining(ctx) {
+					log.Printf("defer request on shutdown %s \n", req.ToString())
+					metrics.recordOutcome(req.ID, outcomeDeferred, 0, 0)
+					return
+				}
+				if gErr == nil {
+					if nearDeadline(ctx, conf.DeadlineMargin, time.Now()) || draining(ctx) {
+						// left unlocked for the next run rather than killed mid-execution
+						log.Printf("defer request near deadline or shutdown %s \n", req.ToString())
+						metrics.recordOutcome(req.ID, outcomeDeferred, 0, 0)
+					} else if conf.ExecutionMode == config.ExecutionStepFunctions {
+						gErr = dispatch(ctx, svc.StepFunctions, conf.ExecutionStateMachineARN, req)
+						if gErr == nil {
+							metrics.recordOutcome(req.ID, outcomeDispatched, 0, 0)
+						} else {
+							metrics.recordOutcome(req.ID, outcomeFailed, 0, 0)
+						}
+					} else {
+						gErr = traceExecution(ctx, svc.Tracing, req, func(ctx context.Context) error {
+							return execute(ctx, dbconn, svc, req, conf.TableName, metrics)
+						})
+					}
+					release()
+				}
+				if gErr != nil {
+					metrics.recordError(req.ID, gErr)
 					errc <- errors.Wrapf(gErr, "execute %s table_name=%s", req.ToString(), conf.TableName)
 				}
 			}()
@@ -43,35 +162,236 @@ func TriggerAPI(ctx context.Context, conf *config.Configuration, dbconn dynamodb
 			err = multierr.Combine(err, gErr)
 		}
 	}
+	if conf.MetricsNamespace != "" {
+		if mErr := metrics.emit(metricsOutput, conf.MetricsNamespace, conf.TableName, time.Now()); mErr != nil {
+			log.Printf("emit metrics failed error=%s \n", mErr)
+		}
+	}
+	if svc.Prometheus != nil {
+		svc.Prometheus.observe(metrics)
+		if conf.PushgatewayURL != "" {
+			if mErr := svc.Prometheus.Push(ctx, conf.PushgatewayURL, conf.PushJob); mErr != nil {
+				log.Printf("push metrics failed error=%s \n", mErr)
+			}
+		}
+	}
+	summary := metrics.summary(conf.TableName, started, time.Now().UTC())
+	summary.Shard = shard
+	log.Printf("run summary %s \n", summary.ToString())
+	if svc.Checkpoint != nil && summary.Deferred > 0 && next != cursor {
+		// the deferred requests are fetched again by the next run unless another one moved on
+		if cErr := svc.Checkpoint.Save(ctx, name, cursor, next, time.Now().UTC()); cErr != nil {
+			log.Printf("rewind checkpoint failed error=%s \n", cErr)
+		}
+	}
+	if lenReqs > 0 {
+		if nErr := notifySummary(ctx, svc.Notifiers, summary); nErr != nil {
+			log.Printf("notify summary failed error=%s \n", nErr)
+		}
+	}
 	// by default a scheduled function is invoke asynchronous thus it will be retried twice
 	// when failure happened
 	// https://docs.aws.amazon.com/lambda/latest/dg/invoking-lambda-function.html#supported-event-source-scheduled-events
-	return err
+	return summary, err
+}
+
+// drainKey is the context key of the channel closed once a run has to stop starting executions
+type drainKey struct{}
+
+// WithDrain returns context of a run which stops starting executions once drain is closed, e.g.
+// on shutdown. The executions in flight complete unless the context itself is cancelled, the
+// others are deferred to the next run
+func WithDrain(ctx context.Context, drain <-chan struct{}) context.Context {
+	return context.WithValue(ctx, drainKey{}, drain)
+}
+
+// draining tells whether the run of ctx has to stop starting executions
+func draining(ctx context.Context) bool {
+	drain, _ := ctx.Value(drainKey{}).(<-chan struct{})
+	if drain == nil {
+		return false
+	}
+	select {
+	case <-drain:
+		return true
+	default:
+		return false
+	}
+}
+
+// drainContext returns a copy of ctx which is cancelled once the run of ctx is draining as well
+func drainContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	dctx, cancel := context.WithCancel(ctx)
+	if drain, _ := ctx.Value(drainKey{}).(<-chan struct{}); drain != nil {
+		go func() {
+			select {
+			case <-drain:
+				cancel()
+			case <-dctx.Done():
+			}
+		}()
+	}
+	return dctx, cancel
 }
 
-func execute(ctx context.Context, dbconn dynamodbiface.DynamoDBAPI, client Requester, req *schema.ScheduledRequest, table string) error {
+// nearDeadline tells whether less than margin is left before the deadline of ctx, which is the
+// timeout of a Lambda invocation. A context without deadline is never near it
+func nearDeadline(ctx context.Context, margin time.Duration, now time.Time) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && deadline.Sub(now) < margin
+}
+
+// TriggerRequest executes the request of given id at once regardless of its effective date,
+// the same way as a run does. A locked request is not executed
+func TriggerRequest(ctx context.Context, conf *config.Configuration, dbconn dynamodbiface.DynamoDBAPI, svc *Services, reqID string) (*RunSummary, error) {
+	started := time.Now().UTC()
+	req, err := Get(ctx, dbconn, conf.TableName, reqID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get")
+	}
+	metrics := &runMetrics{due: 1}
+	err = traceExecution(ctx, svc.Tracing, req, func(ctx context.Context) error {
+		return execute(ctx, dbconn, svc, req, conf.TableName, metrics)
+	})
+	if err != nil {
+		metrics.recordError(req.ID, err)
+		err = errors.Wrapf(err, "execute %s table_name=%s", req.ToString(), conf.TableName)
+	}
+	summary := metrics.summary(conf.TableName, started, time.Now().UTC())
+	if summary.LockConflicts > 0 {
+		return summary, errors.Wrapf(ErrAlreadyLocked, "id=%s table_name=%s", reqID, conf.TableName)
+	}
+	return summary, err
+}
+
+func execute(ctx context.Context, dbconn dynamodbiface.DynamoDBAPI, svc *Services, req *schema.ScheduledRequest, table string, metrics *runMetrics) error {
 	// Always lock the request to be executing.
 	// If execution succeeded and PersistentStore=true, it will not be scheduled at the next run.
 	// In case execution failure, manual intervention is needed thus it should not be rolling out
 	// next time also.
-	err := Lock(ctx, dbconn, table, req.ID)
+	err := acquireLock(ctx, dbconn, table, req.ID, req.Version)
+	if err == ErrAlreadyLocked {
+		// a concurrent run is executing the request already, or it was edited since fetched
+		log.Printf("skip locked request %s \n", req.ToString())
+		metrics.recordOutcome(req.ID, outcomeLockConflict, 0, 0)
+		return nil
+	}
 	if err != nil {
+		metrics.recordOutcome(req.ID, outcomeFailed, 0, 0)
 		return errors.Wrapf(err, "lock id=%s table_name=%s", req.ID, table)
 	}
+	// locking incremented the stored version
+	req.Version++
+	recordAudit(ctx, svc.Audit, req.ID, AuditLocked, "")
+	if draining(ctx) {
+		// shutdown started meanwhile, the request is left to the next run
+		log.Printf("unlock request on shutdown %s \n", req.ToString())
+		metrics.recordOutcome(req.ID, outcomeDeferred, 0, 0)
+		recordAudit(ctx, svc.Audit, req.ID, AuditUnlocked, "shutdown")
+		return errors.Wrapf(Unlock(ctx, dbconn, table, req.ID), "unlock id=%s", req.ID)
+	}
 
-	resp, err := execRequest(ctx, client, req)
+	start := time.Now()
+	resp, err := perform(ctx, svc, req)
+	latency := time.Since(start)
+	switch cause := errors.Cause(err).(type) {
+	case *retryAfterError:
+		// target asked to be called later, which is not a failure
+		metrics.recordOutcome(req.ID, outcomeSkipped, cause.code, latency)
+		recordAudit(ctx, svc.Audit, req.ID, AuditUnlocked, cause.Error())
+		return errors.Wrapf(ignoreEdited(reschedule(ctx, dbconn, table, req.ID, req.Version, cause.at), req), "reschedule id=%s %s", req.ID, cause.Error())
+	case *circuitOpenError:
+		// defer execution until target host is given another chance
+		metrics.recordOutcome(req.ID, outcomeSkipped, 0, 0)
+		recordAudit(ctx, svc.Audit, req.ID, AuditUnlocked, cause.Error())
+		return errors.Wrapf(ignoreEdited(reschedule(ctx, dbconn, table, req.ID, req.Version, cause.until), req), "reschedule id=%s %s", req.ID, cause.Error())
+	}
 	if err != nil {
-		err = errors.Wrapf(err, "execRequest %s", req.ToString())
-		return multierr.Append(err, logFailure(ctx, dbconn, table, req.ID, err))
+		var code int
+		if cause, ok := errors.Cause(err).(*unexpectedStatusError); ok {
+			code = cause.code
+		}
+		metrics.recordOutcome(req.ID, outcomeFailed, code, latency)
+		perr := errors.Wrapf(err, "perform %s", req.ToString())
+		return multierr.Append(perr, recordFailure(ctx, dbconn, svc, req, table, perr))
 	}
+	metrics.recordOutcome(req.ID, outcomeExecuted, resp.Code, latency)
+	resp.Duration = float64(latency) / float64(time.Millisecond)
+	return recordSuccess(ctx, dbconn, svc, req, table, resp)
+}
+
+// expire locks the request too late to be executed for good, as EXPIRED
+func expire(ctx context.Context, dbconn dynamodbiface.DynamoDBAPI, svc *Services, req *schema.ScheduledRequest, table string, metrics *runMetrics) error {
+	log.Printf("expire late request %s \n", req.ToString())
+	err := lockAs(ctx, dbconn, table, req.ID, req.Version, schema.StatusExpired)
+	if err == ErrAlreadyLocked {
+		log.Printf("skip locked request %s \n", req.ToString())
+		metrics.recordOutcome(req.ID, outcomeLockConflict, 0, 0)
+		return nil
+	}
+	if err != nil {
+		metrics.recordOutcome(req.ID, outcomeFailed, 0, 0)
+		return errors.Wrapf(err, "lock id=%s table_name=%s", req.ID, table)
+	}
+	metrics.recordOutcome(req.ID, outcomeExpired, 0, 0)
+	recordAudit(ctx, svc.Audit, req.ID, AuditExpired, req.EffectiveAfter.Format(time.RFC3339))
+	return nil
+}
+
+// recordSuccess stores the result of executed request if PersistentStore is set, or else
+// removes it
+func recordSuccess(ctx context.Context, dbconn dynamodbiface.DynamoDBAPI, svc *Services, req *schema.ScheduledRequest, table string, resp *schema.Response) error {
+	recordAudit(ctx, svc.Audit, req.ID, AuditExecuted, "")
+	notifyCompletion(ctx, svc.Callback, req, resp, nil)
 	if req.PersistentStore {
-		if err = updateResult(ctx, dbconn, table, req.ID, resp, time.Now().UTC()); err != nil {
+		if err := ignoreEdited(updateResult(ctx, dbconn, table, req.ID, req.Version, resp, time.Now().UTC()), req); err != nil {
 			return errors.Wrapf(err, "storeResult req[%s] resp[%s]", req.ToString(), resp.ToString())
 		}
-	} else {
-		if err = removeRequest(ctx, dbconn, table, req.ID); err != nil {
-			return errors.Wrapf(err, "removeRequest %s", req.ToString())
-		}
+		return nil
+	}
+	err := removeRequest(ctx, dbconn, table, req.ID, req.Version)
+	if errors.Cause(err) == errVersionConflict {
+		// edited during execution e.g. rescheduled for another occurrence, which is kept
+		log.Printf("keep request updated during execution %s \n", req.ToString())
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "removeRequest %s", req.ToString())
+	}
+	recordAudit(ctx, svc.Audit, req.ID, AuditDeleted, "")
+	return nil
+}
+
+// recordFailure logs the failed execution of request, notifies it and applies the failure
+// policy
+func recordFailure(ctx context.Context, dbconn dynamodbiface.DynamoDBAPI, svc *Services, req *schema.ScheduledRequest, table string, perr error) error {
+	err := logFailure(ctx, dbconn, table, req.ID, req.Version, perr, time.Now().UTC())
+	edited := errors.Cause(err) == errVersionConflict
+	if edited {
+		err = ignoreEdited(err, req)
+	} else if err == nil {
+		// logging the failure incremented the stored version
+		req.Version++
+	}
+	recordAudit(ctx, svc.Audit, req.ID, AuditFailed, perr.Error())
+	notifyCompletion(ctx, svc.Callback, req, nil, perr)
+	if nErr := notifyFailure(ctx, svc.Notifiers, req, perr); nErr != nil {
+		// alerting is best effort, the failure is recorded regardless
+		log.Printf("notify failure failed id=%s error=%s \n", req.ID, nErr)
+	}
+	if edited {
+		// failure policy applies to the request as executed only
+		return err
+	}
+	return multierr.Append(err, handleFailure(ctx, dbconn, table, svc.Failure, svc.Audit, req, time.Now().UTC()))
+}
+
+// ignoreEdited drops the version conflict of a write following execution, the request having been
+// edited meanwhile e.g. rescheduled by an operator, in which case it is kept as edited
+func ignoreEdited(err error, req *schema.ScheduledRequest) error {
+	if errors.Cause(err) != errVersionConflict {
+		return err
 	}
+	log.Printf("keep request updated during execution %s \n", req.ToString())
 	return nil
 }
@@ -2,13 +2,17 @@ package scheduler
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -21,6 +25,7 @@ type mockHTTPClient struct {
 	counter    uint32
 	once       *sync.Once
 	requestErr error
+	response   *schema.Response
 }
 
 func (mc *mockHTTPClient) DoRequest(ctx context.Context, method, urlStr string, headers map[string]string, body string) (*schema.Response, error) {
@@ -29,12 +34,17 @@ func (mc *mockHTTPClient) DoRequest(ctx context.Context, method, urlStr string,
 	mc.once.Do(func() {
 		err = mc.requestErr
 	})
+	if mc.response != nil {
+		return mc.response, err
+	}
 	return &schema.Response{}, err
 }
 
 func (mc *mockHTTPClient) clear() {
 	mc.counter = 0
 	mc.once = new(sync.Once)
+	mc.requestErr = nil
+	mc.response = nil
 }
 
 func (mc *mockHTTPClient) assertCalled(t *testing.T, expect uint32) {
@@ -44,6 +54,9 @@ func (mc *mockHTTPClient) assertCalled(t *testing.T, expect uint32) {
 func TestTriggerAPI(t *testing.T) {
 	mockConn := new(mockDynamoDB)
 	mockClient := new(mockHTTPClient)
+	mockQueue := new(mockSQS)
+	mockTopic := new(mockSNS)
+	mockAudit := new(mockAuditDB)
 	table := "TriggerAPI_test"
 	conf := &config.Configuration{
 		TableName: table,
@@ -54,6 +67,9 @@ func TestTriggerAPI(t *testing.T) {
 		setup           func()
 		expectExecTimes uint32
 		err             bool
+		verify          func(t *testing.T)
+		// counts & error ids of returned summary, if set
+		summary *RunSummary
 	}{
 		{
 			caseName:    "empty",
@@ -80,6 +96,31 @@ func TestTriggerAPI(t *testing.T) {
 				}
 			},
 			expectExecTimes: 3,
+			summary:         &RunSummary{Fetched: 3, Executed: 3, Succeeded: 3},
+		},
+		{
+			caseName:    "sqs target",
+			description: "should pass with message sent instead of http request",
+			setup: func() {
+				mockConn.items = []map[string]*dynamodb.AttributeValue{
+					{
+						"ID":             {S: aws.String("test-sqs-target")},
+						"EffectiveAfter": {S: aws.String("2018-09-02T00:02:03Z")},
+						"TargetType":     {S: aws.String("sqs")},
+						"Payload":        {S: aws.String("test-sqs-body")},
+						"SQS": {M: map[string]*dynamodb.AttributeValue{
+							"QueueURL": {S: aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/test")},
+						}},
+					},
+				}
+			},
+			verify: func(t *testing.T) {
+				assert.Equal(t, []string{AuditLocked, AuditExecuted, AuditDeleted}, mockAudit.actions(t, "test-sqs-target"))
+				require.NotNil(t, mockQueue.lastSendInput)
+				assert.Equal(t, "test-sqs-body", *mockQueue.lastSendInput.MessageBody)
+				require.NotNil(t, mockConn.lastDeleteItem)
+				assert.Equal(t, "test-sqs-target", *mockConn.lastDeleteItem.Key["ID"].S)
+			},
 		},
 		{
 			caseName:    "errors raised in middle of executing multiple requests",
@@ -105,6 +146,25 @@ func TestTriggerAPI(t *testing.T) {
 			expectExecTimes: 2,
 			err:             true,
 		},
+		{
+			caseName:    "request locked by concurrent run",
+			description: "should pass with request skipped",
+			setup: func() {
+				mockConn.items = []map[string]*dynamodb.AttributeValue{
+					{
+						"ID":             {S: aws.String("test-lock-conflict")},
+						"EffectiveAfter": {S: aws.String("2018-09-02T00:02:03Z")},
+					},
+				}
+				mockConn.updateErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			verify: func(t *testing.T) {
+				require.NotNil(t, mockConn.lastUpdateItem)
+				assert.Equal(t, "Locking = :f and (attribute_not_exists(Version) or Version = :v)", *mockConn.lastUpdateItem.ConditionExpression)
+				assert.Nil(t, mockConn.lastDeleteItem)
+			},
+			summary: &RunSummary{Fetched: 1, Skipped: 1, LockConflicts: 1},
+		},
 		{
 			caseName:    "errors due to request execution",
 			description: "should failed with error",
@@ -119,6 +179,85 @@ func TestTriggerAPI(t *testing.T) {
 			},
 			expectExecTimes: 1,
 			err:             true,
+			summary: &RunSummary{
+				Fetched:  1,
+				Executed: 1,
+				Failed:   1,
+				Errors:   map[string]string{"test-multiple-records-4": "Request error"},
+			},
+		},
+		{
+			caseName:    "failure notified",
+			description: "should failed with failure published to topic",
+			setup: func() {
+				mockConn.items = []map[string]*dynamodb.AttributeValue{
+					{
+						"ID":             {S: aws.String("test-failure-notified")},
+						"EffectiveAfter": {S: aws.String("2018-09-02T00:02:03Z")},
+						"Attempts":       {N: aws.String("1")},
+					},
+				}
+				mockClient.requestErr = errors.New("Request error")
+			},
+			expectExecTimes: 1,
+			err:             true,
+			verify: func(t *testing.T) {
+				require.NotNil(t, mockTopic.lastPublishInput)
+				var notification FailureNotification
+				require.NoError(t, json.Unmarshal([]byte(*mockTopic.lastPublishInput.Message), &notification))
+				assert.Equal(t, "test-failure-notified", notification.RequestID)
+				assert.Equal(t, []string{AuditLocked, AuditFailed}, mockAudit.actions(t, "test-failure-notified"))
+				assert.Equal(t, 2, notification.Attempts)
+				assert.Contains(t, notification.Error, "Request error")
+			},
+		},
+		{
+			caseName:    "target asked to retry after",
+			description: "should pass with request rescheduled",
+			setup: func() {
+				mockConn.items = []map[string]*dynamodb.AttributeValue{
+					{
+						"ID":             {S: aws.String("test-retry-after")},
+						"EffectiveAfter": {S: aws.String("2018-09-02T00:02:03Z")},
+					},
+				}
+				mockClient.response = &schema.Response{
+					Code:       http.StatusTooManyRequests,
+					RetryAfter: "120",
+				}
+			},
+			expectExecTimes: 1,
+			verify: func(t *testing.T) {
+				require.NotNil(t, mockConn.lastUpdateItem)
+				assert.Equal(t, "SET #st = :st, EffectiveAfter = :e, Locking = :l ADD Version :vone", *mockConn.lastUpdateItem.UpdateExpression)
+				at, err := time.Parse(unixFormat, *mockConn.lastUpdateItem.ExpressionAttributeValues[":e"].S)
+				require.NoError(t, err)
+				assert.WithinDuration(t, time.Now().Add(2*time.Minute), at, 5*time.Second)
+				assert.Nil(t, mockConn.lastDeleteItem)
+			},
+			summary: &RunSummary{Fetched: 1, Skipped: 1},
+		},
+		{
+			caseName:    "target host circuit open",
+			description: "should pass with request deferred until circuit cooldown",
+			setup: func() {
+				mockConn.items = []map[string]*dynamodb.AttributeValue{
+					{
+						"ID":             {S: aws.String("test-circuit-open")},
+						"EffectiveAfter": {S: aws.String("2018-09-02T00:02:03Z")},
+					},
+				}
+				mockClient.requestErr = &circuitOpenError{
+					host:  "api.example.com",
+					until: time.Date(2018, time.September, 02, 0, 7, 3, 0, time.UTC),
+				}
+			},
+			expectExecTimes: 1,
+			verify: func(t *testing.T) {
+				require.NotNil(t, mockConn.lastUpdateItem)
+				assert.Equal(t, "2018-09-02T00:07:03Z", *mockConn.lastUpdateItem.ExpressionAttributeValues[":e"].S)
+				assert.Nil(t, mockConn.lastDeleteItem)
+			},
 		},
 		{
 			caseName:    "errors due to remove request execution",
@@ -140,13 +279,343 @@ func TestTriggerAPI(t *testing.T) {
 			mockConn.clear()
 			mockClient.clear()
 			c.setup()
-			err := TriggerAPI(context.Background(), conf, mockConn, mockClient)
+			summary, err := TriggerAPI(context.Background(), conf, mockConn, &Services{
+				HTTP:  mockClient,
+				SQS:   mockQueue,
+				Audit: NewAuditLog(mockAudit, "audit_test", "test"),
+				Notifiers: []Notifier{
+					WithSeverity(NewSNSNotifier(mockTopic, "arn:aws:sns:us-east-1:123456789012:test"), config.SeverityError),
+				},
+			})
 			if c.err == true {
 				assert.Error(t, err)
 			} else {
 				require.NoError(t, err)
 			}
 			mockClient.assertCalled(t, c.expectExecTimes)
+			if c.summary != nil {
+				require.NotNil(t, summary)
+				assert.Equal(t, conf.TableName, summary.TableName)
+				assert.Equal(t, c.summary.Fetched, summary.Fetched)
+				assert.Equal(t, c.summary.Executed, summary.Executed)
+				assert.Equal(t, c.summary.Succeeded, summary.Succeeded)
+				assert.Equal(t, c.summary.Failed, summary.Failed)
+				assert.Equal(t, c.summary.Skipped, summary.Skipped)
+				assert.Equal(t, c.summary.LockConflicts, summary.LockConflicts)
+				assert.Equal(t, len(c.summary.Errors), len(summary.Errors))
+				for id, msg := range c.summary.Errors {
+					assert.Contains(t, summary.Errors[id], msg)
+				}
+			}
+			if c.verify != nil {
+				c.verify(t)
+			}
+		})
+	}
+}
+
+func TestTriggerAPIDeadline(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	mockClient := new(mockHTTPClient)
+	conf := &config.Configuration{
+		TableName:      "TriggerAPIDeadline_test",
+		DeadlineMargin: time.Minute,
+	}
+	for _, c := range []struct {
+		caseName        string
+		timeout         time.Duration
+		expectExecTimes uint32
+		summary         *RunSummary
+	}{
+		{
+			caseName:        "far",
+			timeout:         time.Hour,
+			expectExecTimes: 2,
+			summary:         &RunSummary{Fetched: 2, Executed: 2, Succeeded: 2},
+		},
+		{
+			caseName: "near",
+			timeout:  time.Second,
+			summary:  &RunSummary{Fetched: 2, Skipped: 2, Deferred: 2},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			mockClient.clear()
+			mockConn.items = []map[string]*dynamodb.AttributeValue{
+				{"ID": {S: aws.String("test-deadline-1")}},
+				{"ID": {S: aws.String("test-deadline-2")}},
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+			defer cancel()
+			summary, err := TriggerAPI(ctx, conf, mockConn, &Services{HTTP: mockClient})
+			require.NoError(t, err)
+			mockClient.assertCalled(t, c.expectExecTimes)
+			assert.Equal(t, c.summary.Executed, summary.Executed)
+			assert.Equal(t, c.summary.Skipped, summary.Skipped)
+			assert.Equal(t, c.summary.Deferred, summary.Deferred)
+			if c.expectExecTimes == 0 {
+				// deferred requests are left unlocked
+				assert.Nil(t, mockConn.lastUpdateItem)
+			}
+		})
+	}
+}
+
+func TestTriggerAPIExpired(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	mockConn.clear()
+	mockConn.items = []map[string]*dynamodb.AttributeValue{
+		{"ID": {S: aws.String("test-expired")}, "EffectiveAfter": {S: aws.String(time.Now().Add(-2 * time.Hour).UTC().Format(unixFormat))}},
+	}
+	mockClient := new(mockHTTPClient)
+	mockClient.clear()
+	conf := &config.Configuration{
+		TableName:   "TriggerAPIExpired_test",
+		MaxLateness: time.Hour,
+	}
+	summary, err := TriggerAPI(context.Background(), conf, mockConn, &Services{HTTP: mockClient})
+	require.NoError(t, err)
+	mockClient.assertCalled(t, 0)
+	assert.Equal(t, 1, summary.Expired)
+	assert.Equal(t, 1, summary.Skipped)
+	// locked for good rather than executed
+	require.NotNil(t, mockConn.lastUpdateItem)
+	assert.True(t, aws.BoolValue(mockConn.lastUpdateItem.ExpressionAttributeValues[":t"].BOOL))
+	assert.Equal(t, schema.StatusExpired, aws.StringValue(mockConn.lastUpdateItem.ExpressionAttributeValues[":st"].S))
+}
+
+func TestTriggerAPIDrain(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	mockClient := new(mockHTTPClient)
+	conf := &config.Configuration{
+		TableName:      "TriggerAPIDrain_test",
+		MaxConcurrency: 1,
+	}
+	mockConn.clear()
+	mockClient.clear()
+	mockConn.items = []map[string]*dynamodb.AttributeValue{
+		{"ID": {S: aws.String("test-drain-1")}},
+		{"ID": {S: aws.String("test-drain-2")}},
+	}
+	drain := make(chan struct{})
+	close(drain)
+	summary, err := TriggerAPI(WithDrain(context.Background(), drain), conf, mockConn, &Services{HTTP: mockClient})
+	require.NoError(t, err)
+	mockClient.assertCalled(t, 0)
+	assert.Equal(t, 2, summary.Deferred)
+	assert.Equal(t, 0, summary.Executed)
+	// requests not started are left unlocked
+	assert.Nil(t, mockConn.lastUpdateItem)
+}
+
+func TestExecuteDrain(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	mockConn.clear()
+	mockClient := new(mockHTTPClient)
+	mockClient.clear()
+	drain := make(chan struct{})
+	close(drain)
+	metrics := &runMetrics{}
+	err := execute(WithDrain(context.Background(), drain), mockConn, &Services{HTTP: mockClient}, &schema.ScheduledRequest{ID: "test-drain"}, "ExecuteDrain_test", metrics)
+	require.NoError(t, err)
+	mockClient.assertCalled(t, 0)
+	assert.Equal(t, 1, metrics.deferred)
+	// locked request is unlocked again
+	require.NotNil(t, mockConn.lastUpdateItem)
+	assert.False(t, aws.BoolValue(mockConn.lastUpdateItem.ExpressionAttributeValues[":l"].BOOL))
+}
+
+func TestExecuteVersion(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	mockConn.clear()
+	mockClient := new(mockHTTPClient)
+	mockClient.clear()
+	// rescheduled by the cli while executing
+	mockConn.delErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	req := &schema.ScheduledRequest{ID: "test-version", Method: "GET", URL: "/reports", Version: 2}
+	err := execute(context.Background(), mockConn, &Services{HTTP: mockClient}, req, "ExecuteVersion_test", &runMetrics{})
+	require.NoError(t, err)
+	mockClient.assertCalled(t, 1)
+	// locked at the fetched version, removed at the locked one
+	require.NotNil(t, mockConn.lastUpdateItem)
+	assert.Equal(t, "2", aws.StringValue(mockConn.lastUpdateItem.ExpressionAttributeValues[":v"].N))
+	require.NotNil(t, mockConn.lastDeleteItem)
+	assert.Equal(t, "Version = :v", aws.StringValue(mockConn.lastDeleteItem.ConditionExpression))
+	assert.Equal(t, "3", aws.StringValue(mockConn.lastDeleteItem.ExpressionAttributeValues[":v"].N))
+}
+
+func TestRecordEdited(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "RecordEdited_test"
+	svc := &Services{Failure: FailurePolicy{MaxAttempts: 3, Backoff: time.Minute}}
+	ctx := context.Background()
+	for _, c := range []struct {
+		caseName    string
+		edited      bool
+		record      func(req *schema.ScheduledRequest) error
+		wantStatus  string
+		wantVersion string
+	}{
+		{
+			caseName: "success_edited",
+			edited:   true,
+			record: func(req *schema.ScheduledRequest) error {
+				return recordSuccess(ctx, mockConn, svc, req, table, &schema.Response{Code: http.StatusOK})
+			},
+			wantStatus:  schema.StatusSucceeded,
+			wantVersion: "3",
+		},
+		{
+			caseName: "failure_edited",
+			edited:   true,
+			record: func(req *schema.ScheduledRequest) error {
+				return recordFailure(ctx, mockConn, svc, req, table, errors.New("connection refused"))
+			},
+			// failure policy does not reschedule the edited request
+			wantStatus:  schema.StatusFailed,
+			wantVersion: "3",
+		},
+		{
+			caseName: "failure_rescheduled",
+			record: func(req *schema.ScheduledRequest) error {
+				return recordFailure(ctx, mockConn, svc, req, table, errors.New("connection refused"))
+			},
+			// at the version incremented by logging the failure
+			wantStatus:  schema.StatusPending,
+			wantVersion: "4",
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			if c.edited {
+				// rescheduled by the cli while executing
+				mockConn.updateErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			}
+			req := &schema.ScheduledRequest{ID: "test-record-edited", PersistentStore: true, Version: 3}
+			require.NoError(t, c.record(req))
+			update := mockConn.lastUpdateItem
+			require.NotNil(t, update)
+			assert.Equal(t, "Version = :v", aws.StringValue(update.ConditionExpression))
+			assert.Equal(t, c.wantVersion, aws.StringValue(update.ExpressionAttributeValues[":v"].N))
+			assert.Equal(t, c.wantStatus, aws.StringValue(update.ExpressionAttributeValues[":st"].S))
+		})
+	}
+}
+
+func TestRecordFailureNotifierError(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	mockConn.clear()
+	mockTopic := &mockSNS{publishErr: errors.New("internal error")}
+	svc := &Services{Notifiers: []Notifier{NewSNSNotifier(mockTopic, "arn:aws:sns:us-east-1:123456789012:test")}}
+	req := &schema.ScheduledRequest{ID: "test-notifier-error", PersistentStore: true}
+	// failing notifier is logged rather than failing the record of the execution
+	require.NoError(t, recordFailure(context.Background(), mockConn, svc, req, "RecordFailureNotifierError_test", errors.New("connection refused")))
+	require.NotNil(t, mockTopic.lastPublishInput)
+	require.NotNil(t, mockConn.lastUpdateItem)
+	assert.Equal(t, schema.StatusFailed, aws.StringValue(mockConn.lastUpdateItem.ExpressionAttributeValues[":st"].S))
+}
+
+func TestTriggerRequest(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	mockClient := new(mockHTTPClient)
+	conf := &config.Configuration{
+		TableName: "TriggerRequest_test",
+	}
+	for _, c := range []struct {
+		caseName        string
+		setup           func()
+		expectExecTimes uint32
+		err             bool
+		summary         *RunSummary
+		outcome         *ExecutionOutcome
+	}{
+		{
+			caseName: "not_due",
+			setup: func() {
+				mockConn.item = map[string]*dynamodb.AttributeValue{
+					"ID":             {S: aws.String("test-trigger-request")},
+					"EffectiveAfter": {S: aws.String(time.Now().Add(24 * time.Hour).UTC().Format(unixFormat))},
+				}
+				mockClient.response = &schema.Response{Code: 200}
+			},
+			expectExecTimes: 1,
+			summary:         &RunSummary{Fetched: 1, Executed: 1, Succeeded: 1},
+			outcome:         &ExecutionOutcome{ID: "test-trigger-request", Status: "executed", Code: 200},
+		},
+		{
+			caseName: "not_found",
+			setup:    func() {},
+			err:      true,
+		},
+		{
+			caseName: "locked",
+			setup: func() {
+				mockConn.item = map[string]*dynamodb.AttributeValue{
+					"ID":      {S: aws.String("test-trigger-request")},
+					"Locking": {BOOL: aws.Bool(true)},
+				}
+				mockConn.updateErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			err:     true,
+			summary: &RunSummary{Fetched: 1, LockConflicts: 1},
+			outcome: &ExecutionOutcome{ID: "test-trigger-request", Status: "lock_conflict"},
+		},
+		{
+			caseName: "unexpected_status",
+			setup: func() {
+				mockConn.item = map[string]*dynamodb.AttributeValue{
+					"ID":           {S: aws.String("test-trigger-request")},
+					"ExpectStatus": {S: aws.String("2xx")},
+				}
+				mockClient.response = &schema.Response{Code: http.StatusServiceUnavailable}
+			},
+			expectExecTimes: 1,
+			err:             true,
+			summary:         &RunSummary{Fetched: 1, Executed: 1, Failed: 1},
+			outcome:         &ExecutionOutcome{ID: "test-trigger-request", Status: "failed", Code: http.StatusServiceUnavailable, Error: "unexpected response status code=503"},
+		},
+		{
+			caseName: "request_failed",
+			setup: func() {
+				mockConn.item = map[string]*dynamodb.AttributeValue{
+					"ID": {S: aws.String("test-trigger-request")},
+				}
+				mockClient.requestErr = errors.New("connection refused")
+			},
+			expectExecTimes: 1,
+			err:             true,
+			summary:         &RunSummary{Fetched: 1, Executed: 1, Failed: 1},
+			outcome:         &ExecutionOutcome{ID: "test-trigger-request", Status: "failed", Error: "connection refused"},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			mockClient.clear()
+			c.setup()
+			summary, err := TriggerRequest(context.Background(), conf, mockConn, &Services{HTTP: mockClient}, "test-trigger-request")
+			if c.err {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			mockClient.assertCalled(t, c.expectExecTimes)
+			if c.summary != nil {
+				require.NotNil(t, summary)
+				assert.Equal(t, c.summary.Fetched, summary.Fetched)
+				assert.Equal(t, c.summary.Executed, summary.Executed)
+				assert.Equal(t, c.summary.Succeeded, summary.Succeeded)
+				assert.Equal(t, c.summary.Failed, summary.Failed)
+				assert.Equal(t, c.summary.LockConflicts, summary.LockConflicts)
+			}
+			if c.outcome != nil {
+				require.Len(t, summary.Outcomes, 1)
+				outcome := summary.Outcomes[0]
+				assert.Equal(t, c.outcome.ID, outcome.ID)
+				assert.Equal(t, c.outcome.Status, outcome.Status)
+				assert.Equal(t, c.outcome.Code, outcome.Code)
+				assert.Contains(t, outcome.Error, c.outcome.Error)
+			}
 		})
 	}
 }
@@ -0,0 +1,296 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/schema"
+)
+
+// errForbidden is returned when a caller of the management API presents no valid token
+var errForbidden = errors.New("forbidden")
+
+// ManagementAPI serves the stored requests to non-Go services and UIs through API Gateway proxy
+// integration, every call must present a configured token as `Authorization: Bearer <token>`
+// whose owner is recorded as the creator of created and replaced requests:
+//
+//	GET    /requests              list, filtered by query parameters
+//	POST   /requests              create, unless the id is taken
+//	GET    /requests/{id}         get
+//	PUT    /requests/{id}         create or replace
+//	DELETE /requests/{id}         delete
+//	POST   /requests/{id}/trigger execute at once
+//	POST   /requests/{id}/cancel  cancel for good
+//	POST   /requests/{id}/reschedule move to the time of APIReschedule body
+type ManagementAPI struct {
+	conf *config.Configuration
+	conn dynamodbiface.DynamoDBAPI
+	svc  *Services
+}
+
+// APIListResult is the page of listed requests answered by the API and direct invocations,
+// NextToken is set if there are more
+type APIListResult struct {
+	Requests  []*schema.ScheduledRequest `json:"requests"`
+	NextToken string                     `json:"next_token,omitempty"`
+}
+
+// APIReschedule is the request body of reschedule calls, the request is unlocked as well if Unlock
+// is set
+type APIReschedule struct {
+	At     time.Time `json:"at"`
+	Unlock bool      `json:"unlock"`
+}
+
+// APIError is the response body of a failed call
+type APIError struct {
+	Error string `json:"error"`
+}
+
+// NewManagementAPI returns api managing the requests of configured table
+func NewManagementAPI(conf *config.Configuration, conn dynamodbiface.DynamoDBAPI, svc *Services) *ManagementAPI {
+	return &ManagementAPI{conf: conf, conn: conn, svc: svc}
+}
+
+// Handle serves the call of API Gateway proxy integration, failures are answered with their
+// status code and an APIError body
+func (a *ManagementAPI) Handle(ctx context.Context, req events.APIGatewayProxyRequest) events.APIGatewayProxyResponse {
+	log.Printf("handle api call method=%s path=%s \n", req.HTTPMethod, req.Path)
+	code, v, err := a.route(ctx, req)
+	if err != nil {
+		code = errorStatus(err)
+		log.Printf("api call failed method=%s path=%s code=%d error=%s \n", req.HTTPMethod, req.Path, code, err)
+		// internal failures are logged only
+		msg := http.StatusText(code)
+		if code != http.StatusInternalServerError {
+			msg = err.Error()
+		}
+		v = &APIError{Error: msg}
+	}
+	resp := events.APIGatewayProxyResponse{
+		StatusCode: code,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+	}
+	if v != nil {
+		body, err := json.Marshal(v)
+		if err != nil {
+			log.Printf("api response failed method=%s path=%s error=%s \n", req.HTTPMethod, req.Path, err)
+			resp.StatusCode, body = http.StatusInternalServerError, []byte(`{"error":"Internal Server Error"}`)
+		}
+		resp.Body = string(body)
+	}
+	return resp
+}
+
+func (a *ManagementAPI) route(ctx context.Context, req events.APIGatewayProxyRequest) (int, interface{}, error) {
+	caller, ok := a.caller(req.Headers)
+	if !ok {
+		return 0, nil, errForbidden
+	}
+	parts := strings.Split(strings.Trim(req.Path, "/"), "/")
+	if parts[0] != "requests" || len(parts) > 3 || (len(parts) == 3 && !requestActions[parts[2]]) {
+		return 0, nil, errors.Wrapf(ErrNotFound, "path=%s", req.Path)
+	}
+	switch {
+	case len(parts) == 1 && req.HTTPMethod == http.MethodGet:
+		return a.list(ctx, req)
+	case len(parts) == 1 && req.HTTPMethod == http.MethodPost:
+		return a.create(ctx, req.Body, caller)
+	case len(parts) == 2 && req.HTTPMethod == http.MethodGet:
+		return a.get(ctx, parts[1])
+	case len(parts) == 2 && req.HTTPMethod == http.MethodPut:
+		return a.replace(ctx, parts[1], req.Body, caller)
+	case len(parts) == 2 && req.HTTPMethod == http.MethodDelete:
+		return a.delete(ctx, parts[1])
+	case len(parts) == 3 && req.HTTPMethod == http.MethodPost && parts[2] == "trigger":
+		return a.trigger(ctx, parts[1])
+	case len(parts) == 3 && req.HTTPMethod == http.MethodPost && parts[2] == "cancel":
+		return a.cancel(ctx, parts[1])
+	case len(parts) == 3 && req.HTTPMethod == http.MethodPost && parts[2] == "reschedule":
+		return a.reschedule(ctx, parts[1], req.Body)
+	}
+	return 0, nil, errors.Wrapf(errMethodNotAllowed, "method=%s path=%s", req.HTTPMethod, req.Path)
+}
+
+// requestActions are the actions on a request served under its path
+var requestActions = map[string]bool{"trigger": true, "cancel": true, "reschedule": true}
+
+// errMethodNotAllowed is returned when the path of a call does not serve its method
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// sharedTokenOwner is the caller recorded for the shared ManagementToken
+const sharedTokenOwner = "api"
+
+// caller returns the owner of the bearer token of headers, false if it matches no configured
+// token. Header names are matched regardless of case as API Gateway passes them as sent
+func (a *ManagementAPI) caller(headers map[string]string) (string, bool) {
+	for name, value := range headers {
+		if !strings.EqualFold(name, "Authorization") || !strings.HasPrefix(value, "Bearer ") {
+			continue
+		}
+		token := []byte(strings.TrimPrefix(value, "Bearer "))
+		if a.conf.ManagementToken != "" && subtle.ConstantTimeCompare(token, []byte(a.conf.ManagementToken)) == 1 {
+			return sharedTokenOwner, true
+		}
+		for owner, ownerToken := range a.conf.ManagementTokens {
+			if ownerToken != "" && subtle.ConstantTimeCompare(token, []byte(ownerToken)) == 1 {
+				return owner, true
+			}
+		}
+		return "", false
+	}
+	return "", false
+}
+
+func (a *ManagementAPI) list(ctx context.Context, req events.APIGatewayProxyRequest) (int, interface{}, error) {
+	query := req.QueryStringParameters
+	var filter ListFilter
+	var err error
+	if filter.Locked, err = queryBool(query, "locked"); err != nil {
+		return 0, nil, err
+	}
+	if filter.Failed, err = queryBool(query, "failed"); err != nil {
+		return 0, nil, err
+	}
+	filter.URLContains = query["url_contains"]
+	filter.Status = query["status"]
+	for _, tag := range req.MultiValueQueryStringParameters["tag"] {
+		if filter.Tags == nil {
+			filter.Tags = map[string]string{}
+		}
+		pair := strings.SplitN(tag, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return 0, nil, errors.Wrapf(ErrValidation, "tag=%s", tag)
+		}
+		filter.Tags[pair[0]] = pair[1]
+	}
+	limit := 0
+	if value := query["limit"]; value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			return 0, nil, errors.Wrapf(ErrValidation, "limit=%s", value)
+		}
+	}
+	reqs, next, err := ListIndexed(ctx, a.conn, a.conf, ListOptions{Limit: limit, StartKey: query["next_token"], Filter: filter})
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "ListIndexed")
+	}
+	return http.StatusOK, &APIListResult{Requests: reqs, NextToken: next}, nil
+}
+
+// queryBool returns the boolean query parameter of given name, nil if absent
+func queryBool(query map[string]string, name string) (*bool, error) {
+	value, ok := query[name]
+	if !ok {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, errors.Wrapf(ErrValidation, "%s=%s", name, value)
+	}
+	return &b, nil
+}
+
+func (a *ManagementAPI) create(ctx context.Context, body, caller string) (int, interface{}, error) {
+	req, err := decodeRequest(body, time.Now().UTC())
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "decodeRequest")
+	}
+	req.CreatedBy = caller
+	if err = createNew(ctx, a.conn, a.conf.TableName, req); err != nil {
+		return 0, nil, errors.Wrap(err, "createNew")
+	}
+	recordAudit(ctx, a.svc.Audit, req.ID, AuditCreated, "api")
+	return http.StatusCreated, req, nil
+}
+
+func (a *ManagementAPI) get(ctx context.Context, reqID string) (int, interface{}, error) {
+	req, err := Get(ctx, a.conn, a.conf.TableName, reqID)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "Get")
+	}
+	return http.StatusOK, req, nil
+}
+
+func (a *ManagementAPI) replace(ctx context.Context, reqID, body, caller string) (int, interface{}, error) {
+	req, err := decodeRequest(body, time.Now().UTC())
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "decodeRequest")
+	}
+	req.CreatedBy = caller
+	if req.ID != reqID {
+		return 0, nil, errors.Wrapf(ErrValidation, "id=%s differs from path id=%s", req.ID, reqID)
+	}
+	if err = Replace(ctx, a.conn, a.conf.TableName, req); err != nil {
+		return 0, nil, errors.Wrap(err, "Replace")
+	}
+	recordAudit(ctx, a.svc.Audit, req.ID, AuditCreated, "api replace")
+	return http.StatusOK, req, nil
+}
+
+func (a *ManagementAPI) delete(ctx context.Context, reqID string) (int, interface{}, error) {
+	if err := Delete(ctx, a.conn, a.conf.TableName, reqID); err != nil {
+		return 0, nil, errors.Wrap(err, "Delete")
+	}
+	recordAudit(ctx, a.svc.Audit, reqID, AuditDeleted, "api")
+	return http.StatusNoContent, nil, nil
+}
+
+func (a *ManagementAPI) trigger(ctx context.Context, reqID string) (int, interface{}, error) {
+	summary, err := TriggerRequest(ctx, a.conf, a.conn, a.svc, reqID)
+	if err != nil && summary == nil {
+		return 0, nil, errors.Wrap(err, "TriggerRequest")
+	}
+	// a failed execution is reported by the summary rather than the status code
+	return http.StatusOK, summary, nil
+}
+
+func (a *ManagementAPI) cancel(ctx context.Context, reqID string) (int, interface{}, error) {
+	if err := Cancel(ctx, a.conn, a.conf.TableName, reqID); err != nil {
+		return 0, nil, errors.Wrap(err, "Cancel")
+	}
+	recordAudit(ctx, a.svc.Audit, reqID, AuditCancelled, "api")
+	return http.StatusNoContent, nil, nil
+}
+
+func (a *ManagementAPI) reschedule(ctx context.Context, reqID, body string) (int, interface{}, error) {
+	var input APIReschedule
+	if err := json.Unmarshal([]byte(body), &input); err != nil {
+		return 0, nil, errors.Wrapf(ErrValidation, "json.Unmarshal error=%s", err)
+	}
+	if input.At.IsZero() {
+		return 0, nil, errors.Wrap(ErrValidation, "missing at")
+	}
+	if err := Reschedule(ctx, a.conn, a.conf.TableName, reqID, input.At, input.Unlock); err != nil {
+		return 0, nil, errors.Wrap(err, "Reschedule")
+	}
+	recordAudit(ctx, a.svc.Audit, reqID, AuditRescheduled, input.At.UTC().Format(time.RFC3339))
+	return http.StatusNoContent, nil, nil
+}
+
+// errorStatus returns the status code answering a failed call
+func errorStatus(err error) int {
+	switch errors.Cause(err) {
+	case errForbidden:
+		return http.StatusUnauthorized
+	case ErrValidation:
+		return http.StatusBadRequest
+	case ErrNotFound:
+		return http.StatusNotFound
+	case errAlreadyExists, errVersionConflict, ErrAlreadyLocked:
+		return http.StatusConflict
+	case errMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,374 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/schema"
+)
+
+func TestManagementAPI(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	mockClient := new(mockHTTPClient)
+	conf := &config.Configuration{
+		TableName:        "ManagementAPI_test",
+		ManagementToken:  "secret",
+		ManagementTokens: map[string]string{"billing-ui": "ui-secret"},
+	}
+	api := NewManagementAPI(conf, mockConn, &Services{HTTP: mockClient})
+	auth := map[string]string{"authorization": "Bearer secret"}
+	storedItem := func() {
+		mockConn.item = map[string]*dynamodb.AttributeValue{
+			"ID":     {S: aws.String("test-api")},
+			"Method": {S: aws.String("GET")},
+			"URL":    {S: aws.String("/reports")},
+		}
+	}
+	for _, c := range []struct {
+		caseName string
+		req      events.APIGatewayProxyRequest
+		setup    func()
+		wantCode int
+		verify   func(t *testing.T, body string)
+	}{
+		{
+			caseName: "missing_token",
+			req:      events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/requests"},
+			setup:    func() {},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			caseName: "wrong_token",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod: http.MethodGet,
+				Path:       "/requests",
+				Headers:    map[string]string{"Authorization": "Bearer guess"},
+			},
+			setup:    func() {},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			caseName: "list",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod:                      http.MethodGet,
+				Path:                            "/requests",
+				Headers:                         auth,
+				QueryStringParameters:           map[string]string{"failed": "true", "limit": "1", "tag": "team=billing"},
+				MultiValueQueryStringParameters: map[string][]string{"tag": {"team=billing"}},
+			},
+			setup: func() {
+				mockConn.items = []map[string]*dynamodb.AttributeValue{
+					{"ID": {S: aws.String("test-api-1")}},
+					{"ID": {S: aws.String("test-api-2")}},
+				}
+			},
+			wantCode: http.StatusOK,
+			verify: func(t *testing.T, body string) {
+				var result APIListResult
+				require.NoError(t, json.Unmarshal([]byte(body), &result))
+				require.Len(t, result.Requests, 1)
+				assert.Equal(t, "test-api-1", result.Requests[0].ID)
+				assert.NotEmpty(t, result.NextToken)
+				assert.Contains(t, mockConn.lastScanQ, "FailureReason")
+				assert.Contains(t, mockConn.lastScanQ, "billing")
+			},
+		},
+		{
+			caseName: "list_invalid_filter",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod:            http.MethodGet,
+				Path:                  "/requests",
+				Headers:               auth,
+				QueryStringParameters: map[string]string{"locked": "maybe"},
+			},
+			setup:    func() {},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			caseName: "create",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod: http.MethodPost,
+				Path:       "/requests",
+				Headers:    auth,
+				Body:       `{"ID":"test-api","Method":"GET","URL":"/reports"}`,
+			},
+			setup:    func() {},
+			wantCode: http.StatusCreated,
+			verify: func(t *testing.T, body string) {
+				require.NotNil(t, mockConn.lastPutItem)
+				assert.Equal(t, "attribute_not_exists(ID)", aws.StringValue(mockConn.lastPutItem.ConditionExpression))
+				assert.Contains(t, body, `"ID":"test-api"`)
+				assert.Equal(t, "api", aws.StringValue(mockConn.lastPutItem.Item["CreatedBy"].S))
+			},
+		},
+		{
+			caseName: "create_by_owner",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod: http.MethodPost,
+				Path:       "/requests",
+				Headers:    map[string]string{"Authorization": "Bearer ui-secret"},
+				Body:       `{"ID":"test-api","Method":"GET","URL":"/reports","CreatedBy":"someone-else"}`,
+			},
+			setup:    func() {},
+			wantCode: http.StatusCreated,
+			verify: func(t *testing.T, body string) {
+				require.NotNil(t, mockConn.lastPutItem)
+				assert.Equal(t, "billing-ui", aws.StringValue(mockConn.lastPutItem.Item["CreatedBy"].S))
+				assert.Contains(t, body, `"CreatedBy":"billing-ui"`)
+			},
+		},
+		{
+			caseName: "create_json_payload",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod: http.MethodPost,
+				Path:       "/requests",
+				Headers:    auth,
+				Body:       `{"ID":"test-api","Method":"POST","URL":"/reports","Payload":{"period": "monthly", "ids": [1, 2]}}`,
+			},
+			setup:    func() {},
+			wantCode: http.StatusCreated,
+			verify: func(t *testing.T, body string) {
+				require.NotNil(t, mockConn.lastPutItem)
+				// stored compacted as string
+				assert.Equal(t, `{"period":"monthly","ids":[1,2]}`, aws.StringValue(mockConn.lastPutItem.Item["Payload"].S))
+				assert.Equal(t, schema.PayloadJSON, aws.StringValue(mockConn.lastPutItem.Item["PayloadEncoding"].S))
+				// returned as document rather than string
+				assert.Contains(t, body, `"Payload":{"period":"monthly","ids":[1,2]}`)
+			},
+		},
+		{
+			caseName: "create_json_payload_template",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod: http.MethodPost,
+				Path:       "/requests",
+				Headers:    auth,
+				Body:       `{"ID":"test-api","Method":"POST","URL":"/reports","Payload":"{\"at\":{{ now | unix }}}","PayloadEncoding":"json"}`,
+			},
+			setup:    func() {},
+			wantCode: http.StatusCreated,
+			verify: func(t *testing.T, body string) {
+				// not a document until rendered
+				assert.Contains(t, body, `"Payload":"{\"at\":{{ now | unix }}}"`)
+			},
+		},
+		{
+			caseName: "create_invalid_json_payload",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod: http.MethodPost,
+				Path:       "/requests",
+				Headers:    auth,
+				Body:       `{"ID":"test-api","Method":"POST","URL":"/reports","Payload":"{not json","PayloadEncoding":"json"}`,
+			},
+			setup:    func() {},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			caseName: "create_taken",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod: http.MethodPost,
+				Path:       "/requests",
+				Headers:    auth,
+				Body:       `{"ID":"test-api","Method":"GET","URL":"/reports"}`,
+			},
+			setup: func() {
+				mockConn.putErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			wantCode: http.StatusConflict,
+		},
+		{
+			caseName: "create_invalid",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod: http.MethodPost,
+				Path:       "/requests",
+				Headers:    auth,
+				Body:       `{"ID":"test-api","Method":"FETCH","URL":"/reports"}`,
+			},
+			setup:    func() {},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			caseName: "get",
+			req:      events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/requests/test-api", Headers: auth},
+			setup:    storedItem,
+			wantCode: http.StatusOK,
+			verify: func(t *testing.T, body string) {
+				assert.Contains(t, body, `"URL":"/reports"`)
+			},
+		},
+		{
+			caseName: "get_not_found",
+			req:      events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/requests/test-api", Headers: auth},
+			setup:    func() {},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			caseName: "replace",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod: http.MethodPut,
+				Path:       "/requests/test-api",
+				Headers:    auth,
+				Body:       `{"ID":"test-api","Method":"POST","URL":"/reports","Version":2}`,
+			},
+			setup:    func() {},
+			wantCode: http.StatusOK,
+			verify: func(t *testing.T, body string) {
+				require.NotNil(t, mockConn.lastPutItem)
+				assert.Equal(t, "attribute_not_exists(ID) or Version = :v", aws.StringValue(mockConn.lastPutItem.ConditionExpression))
+				assert.Equal(t, "2", aws.StringValue(mockConn.lastPutItem.ExpressionAttributeValues[":v"].N))
+				assert.Equal(t, "3", aws.StringValue(mockConn.lastPutItem.Item["Version"].N))
+				assert.Contains(t, body, `"Version":3`)
+			},
+		},
+		{
+			caseName: "replace_stale",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod: http.MethodPut,
+				Path:       "/requests/test-api",
+				Headers:    auth,
+				Body:       `{"ID":"test-api","Method":"POST","URL":"/reports","Version":1}`,
+			},
+			setup: func() {
+				mockConn.putErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			wantCode: http.StatusConflict,
+		},
+		{
+			caseName: "replace_other_id",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod: http.MethodPut,
+				Path:       "/requests/test-api",
+				Headers:    auth,
+				Body:       `{"ID":"test-other","Method":"POST","URL":"/reports"}`,
+			},
+			setup:    func() {},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			caseName: "delete",
+			req:      events.APIGatewayProxyRequest{HTTPMethod: http.MethodDelete, Path: "/requests/test-api", Headers: auth},
+			setup:    func() {},
+			wantCode: http.StatusNoContent,
+			verify: func(t *testing.T, body string) {
+				assert.Empty(t, body)
+				require.NotNil(t, mockConn.lastDeleteItem)
+				assert.Equal(t, "attribute_exists(ID)", aws.StringValue(mockConn.lastDeleteItem.ConditionExpression))
+			},
+		},
+		{
+			caseName: "delete_not_found",
+			req:      events.APIGatewayProxyRequest{HTTPMethod: http.MethodDelete, Path: "/requests/test-api", Headers: auth},
+			setup: func() {
+				mockConn.delErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			caseName: "delete_error",
+			req:      events.APIGatewayProxyRequest{HTTPMethod: http.MethodDelete, Path: "/requests/test-api", Headers: auth},
+			setup: func() {
+				mockConn.delErr = errors.New("internal error")
+			},
+			wantCode: http.StatusInternalServerError,
+			verify: func(t *testing.T, body string) {
+				assert.NotContains(t, body, "internal error")
+			},
+		},
+		{
+			caseName: "trigger",
+			req:      events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: "/requests/test-api/trigger", Headers: auth},
+			setup:    storedItem,
+			wantCode: http.StatusOK,
+			verify: func(t *testing.T, body string) {
+				var summary RunSummary
+				require.NoError(t, json.Unmarshal([]byte(body), &summary))
+				assert.Equal(t, 1, summary.Succeeded)
+			},
+		},
+		{
+			caseName: "trigger_not_found",
+			req:      events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: "/requests/test-api/trigger", Headers: auth},
+			setup:    func() {},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			caseName: "cancel",
+			req:      events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: "/requests/test-api/cancel", Headers: auth},
+			setup:    func() {},
+			wantCode: http.StatusNoContent,
+			verify: func(t *testing.T, body string) {
+				require.NotNil(t, mockConn.lastUpdateItem)
+				assert.Equal(t, schema.StatusCancelled, aws.StringValue(mockConn.lastUpdateItem.ExpressionAttributeValues[":st"].S))
+			},
+		},
+		{
+			caseName: "cancel_not_found",
+			req:      events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Path: "/requests/test-api/cancel", Headers: auth},
+			setup: func() {
+				mockConn.updateErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			caseName: "reschedule",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod: http.MethodPost,
+				Path:       "/requests/test-api/reschedule",
+				Headers:    auth,
+				Body:       `{"at":"2018-09-10T08:00:00Z","unlock":true}`,
+			},
+			setup:    func() {},
+			wantCode: http.StatusNoContent,
+			verify: func(t *testing.T, body string) {
+				require.NotNil(t, mockConn.lastUpdateItem)
+				assert.Equal(t, "2018-09-10T08:00:00Z", aws.StringValue(mockConn.lastUpdateItem.ExpressionAttributeValues[":e"].S))
+				assert.Contains(t, aws.StringValue(mockConn.lastUpdateItem.UpdateExpression), "Locking = :l")
+			},
+		},
+		{
+			caseName: "reschedule_missing_at",
+			req: events.APIGatewayProxyRequest{
+				HTTPMethod: http.MethodPost,
+				Path:       "/requests/test-api/reschedule",
+				Headers:    auth,
+				Body:       `{"unlock":true}`,
+			},
+			setup:    func() {},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			caseName: "unknown_path",
+			req:      events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/schedules", Headers: auth},
+			setup:    func() {},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			caseName: "method_not_allowed",
+			req:      events.APIGatewayProxyRequest{HTTPMethod: http.MethodPatch, Path: "/requests/test-api", Headers: auth},
+			setup:    func() {},
+			wantCode: http.StatusMethodNotAllowed,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			mockClient.clear()
+			c.setup()
+			resp := api.Handle(context.Background(), c.req)
+			assert.Equal(t, c.wantCode, resp.StatusCode, resp.Body)
+			if c.verify != nil {
+				c.verify(t, resp.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// checkAssertions verifies response body against the declared assertions
+func checkAssertions(assertions []schema.Assertion, body string) error {
+	if len(assertions) == 0 {
+		return nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		return errors.Wrap(err, "json.Unmarshal response body")
+	}
+	for _, a := range assertions {
+		v, err := lookupPath(doc, a.Path)
+		if err != nil {
+			return errors.Wrapf(err, "lookupPath path=%s", a.Path)
+		}
+		actual, err := jsonText(v)
+		if err != nil {
+			return errors.Wrapf(err, "jsonText path=%s", a.Path)
+		}
+		if actual != a.Expected {
+			return errors.Errorf("assertion failed path=%s expected=%s actual=%s", a.Path, a.Expected, actual)
+		}
+	}
+	return nil
+}
+
+// lookupPath evaluates a simple JSONPath expression supporting child names (`$.a.b`),
+// quoted names (`$['a b']`) and array indexes (`$.items[0]`, negative counts from the end).
+func lookupPath(doc interface{}, path string) (interface{}, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, errors.New("path must start with $")
+	}
+	rest := path[1:]
+	cur := doc
+	for rest != "" {
+		var key string
+		index, isIndex := 0, false
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key, rest = rest[:end], rest[end:]
+			if key == "" {
+				return nil, errors.New("empty child name")
+			}
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, errors.New("unclosed bracket")
+			}
+			token := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			if len(token) >= 2 && (token[0] == '\'' || token[0] == '"') && token[len(token)-1] == token[0] {
+				key = token[1 : len(token)-1]
+				break
+			}
+			i, err := strconv.Atoi(token)
+			if err != nil {
+				return nil, errors.Errorf("invalid index %q", token)
+			}
+			index, isIndex = i, true
+		default:
+			return nil, errors.Errorf("unexpected character %q", rest[0])
+		}
+		if isIndex {
+			arr, ok := cur.([]interface{})
+			if !ok {
+				return nil, errors.Errorf("index %d applied on non array", index)
+			}
+			if index < 0 {
+				index += len(arr)
+			}
+			if index < 0 || index >= len(arr) {
+				return nil, errors.Errorf("index %d out of range", index)
+			}
+			cur = arr[index]
+			continue
+		}
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("child %s applied on non object", key)
+		}
+		if cur, ok = obj[key]; !ok {
+			return nil, errors.Errorf("child %s not found", key)
+		}
+	}
+	return cur, nil
+}
+
+func jsonText(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/meomap/citium/schema"
+)
+
+func TestCheckAssertions(t *testing.T) {
+	body := `{"status":"ok","count":2,"done":true,"items":[{"id":"a"},{"id":"b"}],"nested key":{"v":null}}`
+	for _, c := range []struct {
+		caseName   string
+		body       string
+		assertions []schema.Assertion
+		err        bool
+	}{
+		{
+			caseName: "no_assertions",
+			body:     "not json",
+		},
+		{
+			caseName: "all_matched",
+			body:     body,
+			assertions: []schema.Assertion{
+				{Path: "$.status", Expected: "ok"},
+				{Path: "$.count", Expected: "2"},
+				{Path: "$.done", Expected: "true"},
+				{Path: "$.items[1].id", Expected: "b"},
+				{Path: "$.items[-1].id", Expected: "b"},
+				{Path: "$['nested key'].v", Expected: "null"},
+				{Path: "$.items[0]", Expected: `{"id":"a"}`},
+			},
+		},
+		{
+			caseName: "value_mismatched",
+			body:     `{"status":"error"}`,
+			assertions: []schema.Assertion{
+				{Path: "$.status", Expected: "ok"},
+			},
+			err: true,
+		},
+		{
+			caseName: "path_not_found",
+			body:     body,
+			assertions: []schema.Assertion{
+				{Path: "$.missing", Expected: "ok"},
+			},
+			err: true,
+		},
+		{
+			caseName: "index_out_of_range",
+			body:     body,
+			assertions: []schema.Assertion{
+				{Path: "$.items[2].id", Expected: "c"},
+			},
+			err: true,
+		},
+		{
+			caseName: "invalid_path",
+			body:     body,
+			assertions: []schema.Assertion{
+				{Path: "status", Expected: "ok"},
+			},
+			err: true,
+		},
+		{
+			caseName: "body_not_json",
+			body:     "<html></html>",
+			assertions: []schema.Assertion{
+				{Path: "$.status", Expected: "ok"},
+			},
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			err := checkAssertions(c.assertions, c.body)
+			if c.err == true {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
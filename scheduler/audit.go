@@ -0,0 +1,118 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+)
+
+// State transitions of a scheduled request recorded by audit log
+const (
+	AuditCreated     = "created"
+	AuditLocked      = "locked"
+	AuditExecuted    = "executed"
+	AuditFailed      = "failed"
+	AuditUnlocked    = "unlocked"
+	AuditDeleted     = "deleted"
+	AuditRescheduled = "rescheduled"
+	AuditCancelled   = "cancelled"
+	AuditExpired     = "expired"
+)
+
+// AuditEvent records a state transition of a scheduled request
+type AuditEvent struct {
+	RequestID string `json:"RequestID"`
+	// Time of the transition, sort key of the events of a request
+	At     time.Time `json:"At"`
+	Action string    `json:"Action"`
+	// Who made the transition, the function for executions
+	Actor string `json:"Actor"`
+	// Optional context of the transition e.g. failure reason
+	Detail string `json:"Detail,omitempty"`
+}
+
+// AuditLog appends state transitions of scheduled requests to a table keyed by RequestID and At
+type AuditLog struct {
+	conn      dynamodbiface.DynamoDBAPI
+	tableName string
+	actor     string
+}
+
+// NewAuditLog returns audit log recording transitions made by given actor
+func NewAuditLog(conn dynamodbiface.DynamoDBAPI, tableName, actor string) *AuditLog {
+	return &AuditLog{
+		conn:      conn,
+		tableName: tableName,
+		actor:     actor,
+	}
+}
+
+// Record appends the transition of request, events are never overwritten
+func (a *AuditLog) Record(ctx context.Context, reqID, action, detail string) error {
+	if a == nil {
+		return nil
+	}
+	event := &AuditEvent{
+		RequestID: reqID,
+		At:        time.Now().UTC(),
+		Action:    action,
+		Actor:     a.actor,
+		Detail:    detail,
+	}
+	log.Printf("audit request table_name=%s id=%s action=%s actor=%s \n", a.tableName, reqID, action, a.actor)
+	av, err := dynamodbattribute.MarshalMap(event)
+	if err != nil {
+		return errors.Wrapf(err, "dynamodbattribute.MarshalMap id=%s action=%s", reqID, action)
+	}
+	if _, err = a.conn.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(a.tableName),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(RequestID)"),
+	}); err != nil {
+		return errors.Wrapf(err, "conn.PutItem id=%s action=%s table_name=%s", reqID, action, a.tableName)
+	}
+	return nil
+}
+
+// recordAudit records the transition made by execution, audit failures are logged without
+// failing the execution
+func recordAudit(ctx context.Context, audit *AuditLog, reqID, action, detail string) {
+	if err := audit.Record(ctx, reqID, action, detail); err != nil {
+		log.Printf("audit failed id=%s action=%s error=%s \n", reqID, action, err)
+	}
+}
+
+// History returns the recorded transitions of request, oldest first
+func (a *AuditLog) History(ctx context.Context, reqID string) ([]*AuditEvent, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(a.tableName),
+		KeyConditionExpression: aws.String("RequestID = :id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {
+				S: aws.String(reqID),
+			},
+		},
+	}
+	events := []*AuditEvent{}
+	for {
+		output, err := a.conn.QueryWithContext(ctx, input)
+		if err != nil {
+			return nil, errors.Wrapf(err, "conn.Query id=%s table_name=%s", reqID, a.tableName)
+		}
+		page := []*AuditEvent{}
+		if err = dynamodbattribute.UnmarshalListOfMaps(output.Items, &page); err != nil {
+			return nil, errors.Wrapf(err, "dynamodbattribute.UnmarshalListOfMaps id=%s table_name=%s", reqID, a.tableName)
+		}
+		events = append(events, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			return events, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
@@ -0,0 +1,124 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockAuditDB struct {
+	dynamodbiface.DynamoDBAPI
+	mu     sync.Mutex
+	puts   []*dynamodb.PutItemInput
+	putErr error
+	// pages of items returned by successive query calls
+	pages      [][]map[string]*dynamodb.AttributeValue
+	lastQueryQ *dynamodb.QueryInput
+}
+
+func (ma *mockAuditDB) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	ma.puts = append(ma.puts, input)
+	if ma.putErr != nil {
+		return nil, ma.putErr
+	}
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (ma *mockAuditDB) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	ma.lastQueryQ = input
+	output := &dynamodb.QueryOutput{}
+	if len(ma.pages) > 0 {
+		output.Items, ma.pages = ma.pages[0], ma.pages[1:]
+	}
+	if len(ma.pages) > 0 {
+		output.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{"RequestID": {S: aws.String("next")}}
+	}
+	return output, nil
+}
+
+// actions returns recorded actions of request in order
+func (ma *mockAuditDB) actions(t *testing.T, reqID string) []string {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	actions := []string{}
+	for _, input := range ma.puts {
+		event := new(AuditEvent)
+		require.NoError(t, dynamodbattribute.UnmarshalMap(input.Item, event))
+		if event.RequestID == reqID {
+			actions = append(actions, event.Action)
+		}
+	}
+	return actions
+}
+
+func TestAuditRecord(t *testing.T) {
+	for _, c := range []struct {
+		caseName string
+		conn     *mockAuditDB
+		err      bool
+	}{
+		{caseName: "ok", conn: new(mockAuditDB)},
+		{caseName: "put_error", conn: &mockAuditDB{putErr: errors.New("internal error")}, err: true},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			err := NewAuditLog(c.conn, "audit_test", "tester").Record(context.Background(), "test-audit", AuditFailed, "c.Do: connection refused")
+			if c.err == true {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, c.conn.puts, 1)
+			input := c.conn.puts[0]
+			assert.Equal(t, "audit_test", *input.TableName)
+			assert.Equal(t, "attribute_not_exists(RequestID)", *input.ConditionExpression)
+			event := new(AuditEvent)
+			require.NoError(t, dynamodbattribute.UnmarshalMap(input.Item, event))
+			assert.Equal(t, "test-audit", event.RequestID)
+			assert.Equal(t, AuditFailed, event.Action)
+			assert.Equal(t, "tester", event.Actor)
+			assert.Equal(t, "c.Do: connection refused", event.Detail)
+			assert.WithinDuration(t, time.Now(), event.At, 5*time.Second)
+		})
+	}
+}
+
+func TestAuditRecordDisabled(t *testing.T) {
+	var audit *AuditLog
+	assert.NoError(t, audit.Record(context.Background(), "test-audit", AuditCreated, ""))
+}
+
+func TestAuditHistory(t *testing.T) {
+	event := func(action string, at time.Time) map[string]*dynamodb.AttributeValue {
+		av, err := dynamodbattribute.MarshalMap(&AuditEvent{RequestID: "test-history", At: at, Action: action, Actor: "tester"})
+		require.NoError(t, err)
+		return av
+	}
+	at := time.Date(2018, time.September, 02, 0, 2, 3, 0, time.UTC)
+	conn := &mockAuditDB{
+		pages: [][]map[string]*dynamodb.AttributeValue{
+			{event(AuditCreated, at), event(AuditLocked, at.Add(time.Minute))},
+			{event(AuditExecuted, at.Add(2*time.Minute))},
+		},
+	}
+	events, err := NewAuditLog(conn, "audit_test", "tester").History(context.Background(), "test-history")
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, AuditCreated, events[0].Action)
+	assert.Equal(t, AuditExecuted, events[2].Action)
+	assert.Equal(t, at.Add(2*time.Minute), events[2].At)
+	assert.Equal(t, "test-history", *conn.lastQueryQ.ExpressionAttributeValues[":id"].S)
+	assert.Equal(t, "next", *conn.lastQueryQ.ExclusiveStartKey["RequestID"].S)
+}
@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker tracks consecutive execution failures per target host. Once a host reaches
+// the failure threshold its circuit opens and executions are deferred for the cooldown
+// period; the first attempt after cooldown closes it again on success or reopens it on failure.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	hosts     map[string]*hostState
+	now       func() time.Time
+}
+
+type hostState struct {
+	failures  int
+	openUntil time.Time
+}
+
+// circuitOpenError signals that an execution got skipped because target host circuit is open
+type circuitOpenError struct {
+	host  string
+	until time.Time
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open host=%s until=%s", e.host, e.until.Format(time.RFC3339))
+}
+
+// NewCircuitBreaker returns breaker opening after threshold consecutive failures of a host,
+// zero threshold disables it
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		hosts:     map[string]*hostState{},
+		now:       time.Now,
+	}
+}
+
+// Allow returns error if circuit of given host is currently open
+func (cb *CircuitBreaker) Allow(host string) error {
+	if cb == nil || cb.threshold <= 0 {
+		return nil
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	state, ok := cb.hosts[host]
+	if !ok || !cb.now().Before(state.openUntil) {
+		return nil
+	}
+	return &circuitOpenError{host: host, until: state.openUntil}
+}
+
+// Success resets failure count of given host
+func (cb *CircuitBreaker) Success(host string) {
+	if cb == nil || cb.threshold <= 0 {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	delete(cb.hosts, host)
+}
+
+// Failure records a failure of given host, opening its circuit once threshold is reached
+func (cb *CircuitBreaker) Failure(host string) {
+	if cb == nil || cb.threshold <= 0 {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	state, ok := cb.hosts[host]
+	if !ok {
+		state = new(hostState)
+		cb.hosts[host] = state
+	}
+	state.failures++
+	if state.failures >= cb.threshold {
+		state.openUntil = cb.now().Add(cb.cooldown)
+		log.Printf("open circuit host=%s failures=%d until=%s \n", host, state.failures, state.openUntil)
+	}
+}
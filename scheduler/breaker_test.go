@@ -0,0 +1,44 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	current := time.Date(2018, time.September, 01, 0, 2, 3, 0, time.UTC)
+	cb := NewCircuitBreaker(2, time.Minute)
+	cb.now = func() time.Time { return current }
+	host := "api.example.com"
+
+	cb.Failure(host)
+	assert.NoError(t, cb.Allow(host), "should stay closed below threshold")
+	cb.Success(host)
+	cb.Failure(host)
+	assert.NoError(t, cb.Allow(host), "success should reset failures")
+
+	cb.Failure(host)
+	err := cb.Allow(host)
+	assert.Error(t, err, "should open at threshold")
+	assert.Equal(t, current.Add(time.Minute), err.(*circuitOpenError).until)
+	assert.NoError(t, cb.Allow("other.example.com"), "should not affect other hosts")
+
+	current = current.Add(time.Minute)
+	assert.NoError(t, cb.Allow(host), "should allow attempt after cooldown")
+	cb.Failure(host)
+	assert.Error(t, cb.Allow(host), "should reopen on failure after cooldown")
+
+	current = current.Add(time.Minute)
+	cb.Success(host)
+	assert.NoError(t, cb.Allow(host), "should close on success after cooldown")
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	for _, cb := range []*CircuitBreaker{nil, NewCircuitBreaker(0, time.Minute)} {
+		cb.Failure("api.example.com")
+		cb.Failure("api.example.com")
+		assert.NoError(t, cb.Allow("api.example.com"))
+	}
+}
@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// CompletionEvent is the outcome of an execution posted to the CallbackURL of its request
+type CompletionEvent struct {
+	RequestID string `json:"request_id"`
+	// Either StatusSucceeded or StatusFailed
+	Status string `json:"status"`
+	// Response of a successful execution, or the error of a failed one
+	Response *schema.Response `json:"response,omitempty"`
+	Error    string           `json:"error,omitempty"`
+	// Executions tried so far including this one
+	Attempts int `json:"attempts"`
+	// Caller data of the request e.g. correlation ids
+	Metadata map[string]string `json:"metadata,omitempty"`
+	At       time.Time         `json:"at"`
+}
+
+// CompletionCallback posts the outcome of executions to the CallbackURL of their request
+type CompletionCallback struct {
+	client *http.Client
+}
+
+// NewCompletionCallback returns callback posting completion events
+func NewCompletionCallback() *CompletionCallback {
+	return &CompletionCallback{
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Post sends the completion event to callback url, failing unless it is answered with 2xx
+func (c *CompletionCallback) Post(ctx context.Context, callbackURL string, event *CompletionEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrap(err, "http.NewRequest")
+	}
+	req.Header.Set("Content-Type", jsonMIME)
+	log.Printf("post completion event status=%s id=%s \n", event.Status, event.RequestID)
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "c.client.Do")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected callback response code=%d", resp.StatusCode)
+	}
+	return nil
+}
+
+// notifyCompletion posts the outcome of executed request to its CallbackURL if it has one, either
+// its response or its error perr. The outcome is stored already thus a failed post is only logged
+func notifyCompletion(ctx context.Context, callback *CompletionCallback, req *schema.ScheduledRequest, resp *schema.Response, perr error) {
+	if callback == nil || req.CallbackURL == "" {
+		return
+	}
+	event := &CompletionEvent{
+		RequestID: req.ID,
+		Status:    schema.StatusSucceeded,
+		Response:  resp,
+		// the execution being reported is already counted
+		Attempts: req.Attempts + 1,
+		Metadata: req.Metadata,
+		At:       time.Now().UTC(),
+	}
+	if perr != nil {
+		event.Status, event.Error = schema.StatusFailed, perr.Error()
+	}
+	if err := callback.Post(ctx, req.CallbackURL, event); err != nil {
+		log.Printf("completion callback failed id=%s callback_url=%s error=%s \n", req.ID, req.CallbackURL, err)
+	}
+}
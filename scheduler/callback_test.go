@@ -0,0 +1,107 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/schema"
+)
+
+func TestExecuteCallback(t *testing.T) {
+	var received []*CompletionEvent
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		event := new(CompletionEvent)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(event))
+		received = append(received, event)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	mockConn := new(mockDynamoDB)
+	mockClient := new(mockHTTPClient)
+	svc := &Services{HTTP: mockClient, Callback: NewCompletionCallback()}
+	for _, c := range []struct {
+		caseName    string
+		callbackURL string
+		setup       func()
+		err         bool
+		wantStatus  string
+	}{
+		{
+			caseName:    "succeeded",
+			callbackURL: server.URL + "/done",
+			setup:       func() {},
+			wantStatus:  schema.StatusSucceeded,
+		},
+		{
+			caseName:    "failed",
+			callbackURL: server.URL + "/done",
+			setup: func() {
+				mockClient.requestErr = errors.New("connection refused")
+			},
+			err:        true,
+			wantStatus: schema.StatusFailed,
+		},
+		{
+			caseName:    "rejected",
+			callbackURL: server.URL + "/done",
+			setup: func() {
+				status = http.StatusInternalServerError
+			},
+			// the outcome is stored regardless
+			wantStatus: schema.StatusSucceeded,
+		},
+		{
+			caseName: "no_callback",
+			setup:    func() {},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			mockClient.clear()
+			received, status = nil, http.StatusOK
+			c.setup()
+			req := &schema.ScheduledRequest{
+				ID:          "test-callback",
+				Method:      "GET",
+				URL:         "/reports",
+				CallbackURL: c.callbackURL,
+				Attempts:    1,
+				Metadata:    map[string]string{"ticket": "OPS-42"},
+			}
+			err := execute(context.Background(), mockConn, svc, req, "ExecuteCallback_test", &runMetrics{})
+			if c.err {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			if c.wantStatus == "" {
+				assert.Empty(t, received)
+				return
+			}
+			require.Len(t, received, 1)
+			event := received[0]
+			assert.Equal(t, "test-callback", event.RequestID)
+			assert.Equal(t, c.wantStatus, event.Status)
+			assert.Equal(t, 2, event.Attempts)
+			assert.Equal(t, "OPS-42", event.Metadata["ticket"])
+			if c.wantStatus == schema.StatusFailed {
+				assert.Nil(t, event.Response)
+				assert.Contains(t, event.Error, "connection refused")
+			} else {
+				require.NotNil(t, event.Response)
+				assert.Empty(t, event.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+)
+
+// errCheckpointMoved is returned when the saved cursor was moved by a concurrent run meanwhile
+var errCheckpointMoved = errors.New("checkpoint moved")
+
+// Checkpoint keeps the scan cursor where the last run stopped fetching due requests, in a table
+// keyed by Name which is the name of the schedule table. The next run resumes from there rather
+// than scanning the same items again
+type Checkpoint struct {
+	conn      dynamodbiface.DynamoDBAPI
+	tableName string
+}
+
+// NewCheckpoint returns checkpoint stored in given table
+func NewCheckpoint(conn dynamodbiface.DynamoDBAPI, tableName string) *Checkpoint {
+	return &Checkpoint{
+		conn:      conn,
+		tableName: tableName,
+	}
+}
+
+// Load returns the saved cursor of schedule table, empty to start from the beginning
+func (c *Checkpoint) Load(ctx context.Context, name string) (string, error) {
+	output, err := c.conn.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(c.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Name": {
+				S: aws.String(name),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "conn.GetItem name=%s table_name=%s", name, c.tableName)
+	}
+	cursor := ""
+	if av, ok := output.Item["Cursor"]; ok {
+		cursor = aws.StringValue(av.S)
+	}
+	log.Printf("load checkpoint table_name=%s name=%s cursor=%s \n", c.tableName, name, cursor)
+	return cursor, nil
+}
+
+// Save replaces the cursor of schedule table provided that it is still the previous one,
+// errCheckpointMoved is returned otherwise
+func (c *Checkpoint) Save(ctx context.Context, name, cursor, previous string, now time.Time) error {
+	log.Printf("save checkpoint table_name=%s name=%s cursor=%s \n", c.tableName, name, cursor)
+	_, err := c.conn.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(c.tableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"Name":      {S: aws.String(name)},
+			"Cursor":    {S: aws.String(cursor)},
+			"UpdatedAt": {S: aws.String(now.UTC().Format(unixFormat))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#n) or #c = :p"),
+		ExpressionAttributeNames: map[string]*string{
+			"#n": aws.String("Name"),
+			"#c": aws.String("Cursor"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":p": {S: aws.String(previous)},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return errors.Wrapf(errCheckpointMoved, "name=%s table_name=%s", name, c.tableName)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "conn.PutItem name=%s table_name=%s", name, c.tableName)
+	}
+	return nil
+}
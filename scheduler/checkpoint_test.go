@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/config"
+)
+
+func TestCheckpoint(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	checkpoint := NewCheckpoint(mockConn, "checkpoint_test")
+	now := time.Date(2018, 9, 5, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		caseName   string
+		setup      func()
+		wantCursor string
+		errCause   error
+	}{
+		{
+			caseName: "first_run",
+			setup:    func() {},
+		},
+		{
+			caseName: "saved",
+			setup: func() {
+				mockConn.item = map[string]*dynamodb.AttributeValue{
+					"Name":   {S: aws.String("citium_schedule")},
+					"Cursor": {S: aws.String("cursor-1")},
+				}
+			},
+			wantCursor: "cursor-1",
+		},
+		{
+			caseName: "moved",
+			setup: func() {
+				mockConn.putErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			errCause: errCheckpointMoved,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			c.setup()
+			cursor, err := checkpoint.Load(context.Background(), "citium_schedule")
+			require.NoError(t, err)
+			assert.Equal(t, c.wantCursor, cursor)
+			err = checkpoint.Save(context.Background(), "citium_schedule", "cursor-2", cursor, now)
+			if c.errCause != nil {
+				assert.Equal(t, c.errCause, errors.Cause(err))
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, mockConn.lastPutItem)
+			assert.Equal(t, "cursor-2", aws.StringValue(mockConn.lastPutItem.Item["Cursor"].S))
+			assert.Equal(t, c.wantCursor, aws.StringValue(mockConn.lastPutItem.ExpressionAttributeValues[":p"].S))
+		})
+	}
+}
+
+func TestTriggerAPICheckpoint(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	mockClient := new(mockHTTPClient)
+	conf := &config.Configuration{
+		TableName:  "TriggerAPICheckpoint_test",
+		FetchLimit: 2,
+	}
+	svc := &Services{HTTP: mockClient, Checkpoint: NewCheckpoint(mockConn, "checkpoint_test")}
+	cursorOf := func(id string) string {
+		cursor, err := encodePageToken(map[string]*dynamodb.AttributeValue{"ID": {S: aws.String(id)}})
+		require.NoError(t, err)
+		return cursor
+	}
+	for _, c := range []struct {
+		caseName   string
+		cursor     string
+		wantIDs    []string
+		wantCursor string
+	}{
+		{
+			caseName:   "from_beginning",
+			wantIDs:    []string{"test-checkpoint-1", "test-checkpoint-2"},
+			wantCursor: cursorOf("test-checkpoint-2"),
+		},
+		{
+			caseName: "resumed_to_end",
+			cursor:   cursorOf("test-checkpoint-2"),
+			wantIDs:  []string{"test-checkpoint-3"},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			mockClient.clear()
+			mockConn.items = []map[string]*dynamodb.AttributeValue{
+				{"ID": {S: aws.String("test-checkpoint-1")}},
+				{"ID": {S: aws.String("test-checkpoint-2")}},
+				{"ID": {S: aws.String("test-checkpoint-3")}},
+			}
+			if c.cursor != "" {
+				mockConn.item = map[string]*dynamodb.AttributeValue{"Cursor": {S: aws.String(c.cursor)}}
+			}
+			summary, err := TriggerAPI(context.Background(), conf, mockConn, svc)
+			require.NoError(t, err)
+			assert.Equal(t, len(c.wantIDs), summary.Fetched)
+			mockClient.assertCalled(t, uint32(len(c.wantIDs)))
+			if c.cursor != "" {
+				assert.Contains(t, mockConn.lastScanQ, "test-checkpoint-2")
+			}
+			require.NotNil(t, mockConn.lastPutItem)
+			assert.Equal(t, c.wantCursor, aws.StringValue(mockConn.lastPutItem.Item["Cursor"].S))
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package scheduler
+
+import (
+	"context"
+	"net/url"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// limiter bounds concurrent executions overall and per target host, zero values are unbounded
+type limiter struct {
+	all     chan struct{}
+	perHost int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+func newLimiter(max, perHost int) *limiter {
+	l := &limiter{perHost: perHost, hosts: map[string]chan struct{}{}}
+	if max > 0 {
+		l.all = make(chan struct{}, max)
+	}
+	return l
+}
+
+// acquire blocks until an execution to host is allowed, the returned function releases it.
+// The host slot is taken first so that executions queued on a busy host do not hold overall
+// slots needed by other hosts.
+func (l *limiter) acquire(ctx context.Context, host string) (func(), error) {
+	var slots []chan struct{}
+	if l.perHost > 0 && host != "" {
+		l.mu.Lock()
+		hostSlots, ok := l.hosts[host]
+		if !ok {
+			hostSlots = make(chan struct{}, l.perHost)
+			l.hosts[host] = hostSlots
+		}
+		l.mu.Unlock()
+		slots = append(slots, hostSlots)
+	}
+	if l.all != nil {
+		slots = append(slots, l.all)
+	}
+	release := func(n int) {
+		for i := n - 1; i >= 0; i-- {
+			<-slots[i]
+		}
+	}
+	for i, s := range slots {
+		select {
+		case s <- struct{}{}:
+		case <-ctx.Done():
+			release(i)
+			return nil, errors.Wrapf(ctx.Err(), "wait for execution slot host=%s", host)
+		}
+	}
+	return func() { release(len(slots)) }, nil
+}
+
+// targetHost returns the host called by http request, relative urls are resolved against base
+// url. Other targets are not limited per host.
+func targetHost(req *schema.ScheduledRequest, baseURL string) string {
+	if req.Target() != schema.TargetHTTP {
+		return ""
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return ""
+	}
+	if u.Host != "" {
+		return u.Host
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+	return base.Host
+}
@@ -0,0 +1,128 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/schema"
+)
+
+func TestLimiter(t *testing.T) {
+	for _, c := range []struct {
+		caseName    string
+		max         int
+		perHost     int
+		hosts       []string
+		wantAll     int
+		wantPerHost int
+	}{
+		{
+			caseName:    "unbounded",
+			hosts:       []string{"a", "a", "a", "b"},
+			wantAll:     4,
+			wantPerHost: 3,
+		},
+		{
+			caseName:    "max",
+			max:         2,
+			hosts:       []string{"a", "a", "a", "b"},
+			wantAll:     2,
+			wantPerHost: 2,
+		},
+		{
+			caseName:    "per_host",
+			perHost:     1,
+			hosts:       []string{"a", "a", "a", "b", ""},
+			wantAll:     3,
+			wantPerHost: 1,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			l := newLimiter(c.max, c.perHost)
+			var (
+				mu       sync.Mutex
+				inFlight = map[string]int{}
+				all      int
+				maxAll   int
+				maxHost  int
+				wg       sync.WaitGroup
+			)
+			for _, host := range c.hosts {
+				host := host
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					release, err := l.acquire(context.Background(), host)
+					require.NoError(t, err)
+					mu.Lock()
+					all++
+					inFlight[host]++
+					if all > maxAll {
+						maxAll = all
+					}
+					if host != "" && inFlight[host] > maxHost {
+						maxHost = inFlight[host]
+					}
+					mu.Unlock()
+					time.Sleep(20 * time.Millisecond)
+					mu.Lock()
+					all--
+					inFlight[host]--
+					mu.Unlock()
+					release()
+				}()
+			}
+			wg.Wait()
+			assert.Equal(t, c.wantAll, maxAll)
+			assert.Equal(t, c.wantPerHost, maxHost)
+		})
+	}
+}
+
+func TestLimiterCanceled(t *testing.T) {
+	l := newLimiter(1, 0)
+	release, err := l.acquire(context.Background(), "a")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = l.acquire(ctx, "b")
+	require.Error(t, err)
+	release()
+	// slot is available again once released
+	release, err = l.acquire(context.Background(), "b")
+	require.NoError(t, err)
+	release()
+}
+
+func TestTargetHost(t *testing.T) {
+	for _, c := range []struct {
+		caseName string
+		req      *schema.ScheduledRequest
+		want     string
+	}{
+		{
+			caseName: "absolute",
+			req:      &schema.ScheduledRequest{URL: "https://api.foo.com/jobs"},
+			want:     "api.foo.com",
+		},
+		{
+			caseName: "relative",
+			req:      &schema.ScheduledRequest{URL: "/jobs"},
+			want:     "api.example.com:8443",
+		},
+		{
+			caseName: "other_target",
+			req:      &schema.ScheduledRequest{TargetType: schema.TargetSQS, URL: "https://api.foo.com/jobs"},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			assert.Equal(t, c.want, targetHost(c.req, "https://api.example.com:8443/v1"))
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+
+	"github.com/meomap/citium/schema"
+)
+
+// PayloadContracts checks the payload of requests against the JSON Schema of their PayloadSchema,
+// either inline or fetched from S3. Fetched schemas are cached as S3 references are expected to
+// be versioned by key
+type PayloadContracts struct {
+	conn s3iface.S3API
+
+	mu     sync.Mutex
+	cached map[string]*jsonSchema
+}
+
+// NewPayloadContracts returns contracts fetching the referenced schemas with conn, only inline
+// schemas are supported if it is nil
+func NewPayloadContracts(conn s3iface.S3API) *PayloadContracts {
+	return &PayloadContracts{conn: conn, cached: map[string]*jsonSchema{}}
+}
+
+// Check validates the payload of req rendered at the current time against its schema, if any.
+// Violations of the schema and invalid schemas are ErrValidation
+func (c *PayloadContracts) Check(ctx context.Context, req *schema.ScheduledRequest) error {
+	if req.PayloadSchema == "" {
+		return nil
+	}
+	contract, err := c.load(ctx, req.PayloadSchema)
+	if err != nil {
+		return err
+	}
+	payload, err := renderTemplate(req.Payload, time.Now().UTC())
+	if err != nil {
+		return errors.Wrapf(ErrValidation, "render payload id=%s: %s", req.ID, err)
+	}
+	var doc interface{}
+	if err = json.Unmarshal([]byte(payload), &doc); err != nil {
+		return errors.Wrapf(ErrValidation, "payload of id=%s is not JSON: %s", req.ID, err)
+	}
+	if err = contract.validate("payload", doc); err != nil {
+		return errors.Wrapf(ErrValidation, "payload of id=%s violates its schema: %s", req.ID, err)
+	}
+	return nil
+}
+
+// load returns the compiled schema given inline or referenced by s3://bucket/key
+func (c *PayloadContracts) load(ctx context.Context, ref string) (*jsonSchema, error) {
+	if !strings.HasPrefix(ref, "s3://") {
+		contract, err := compileSchema([]byte(ref))
+		if err != nil {
+			return nil, errors.Wrapf(ErrValidation, "invalid PayloadSchema: %s", err)
+		}
+		return contract, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if contract, ok := c.cached[ref]; ok {
+		return contract, nil
+	}
+	if c.conn == nil {
+		return nil, errors.Errorf("s3 client is not configured to fetch schema=%s", ref)
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Host == "" || len(u.Path) < 2 {
+		return nil, errors.Wrapf(ErrValidation, "invalid PayloadSchema reference %q, expect s3://bucket/key", ref)
+	}
+	log.Printf("fetch payload schema bucket=%s key=%s \n", u.Host, u.Path[1:])
+	output, err := c.conn.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(u.Host),
+		Key:    aws.String(u.Path[1:]),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "conn.GetObject schema=%s", ref)
+	}
+	defer output.Body.Close()
+	raw, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read schema=%s", ref)
+	}
+	contract, err := compileSchema(raw)
+	if err != nil {
+		return nil, errors.Wrapf(ErrValidation, "invalid PayloadSchema %s: %s", ref, err)
+	}
+	c.cached[ref] = contract
+	return contract, nil
+}
+
+// jsonSchema is the subset of JSON Schema checked by contracts: type, enum, const, the bounds of
+// numbers, strings and arrays, string pattern, object properties, required and
+// additionalProperties, and array items. Other keywords are ignored as the specification says
+type jsonSchema struct {
+	Type                 interface{}            `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Const                json.RawMessage        `json:"const"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+
+	types      []string
+	constValue interface{}
+	pattern    *regexp.Regexp
+	// schema of the properties not listed, nil if any is allowed
+	additional   *jsonSchema
+	noAdditional bool
+}
+
+// compileSchema parses the JSON Schema document raw
+func compileSchema(raw []byte) (*jsonSchema, error) {
+	s := new(jsonSchema)
+	if err := json.Unmarshal(raw, s); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal schema")
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *jsonSchema) compile() error {
+	switch t := s.Type.(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return errors.Errorf("invalid type %v", v)
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return errors.Errorf("invalid type %v", t)
+	}
+	if len(s.Const) > 0 {
+		if err := json.Unmarshal(s.Const, &s.constValue); err != nil {
+			return errors.Wrap(err, "json.Unmarshal const")
+		}
+	}
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return errors.Wrapf(err, "regexp.Compile pattern=%s", s.Pattern)
+		}
+		s.pattern = pattern
+	}
+	switch raw := strings.TrimSpace(string(s.AdditionalProperties)); raw {
+	case "", "true":
+	case "false":
+		s.noAdditional = true
+	default:
+		s.additional = new(jsonSchema)
+		if err := json.Unmarshal(s.AdditionalProperties, s.additional); err != nil {
+			return errors.Wrap(err, "json.Unmarshal additionalProperties")
+		}
+		if err := s.additional.compile(); err != nil {
+			return errors.Wrap(err, "additionalProperties")
+		}
+	}
+	for name, property := range s.Properties {
+		if err := property.compile(); err != nil {
+			return errors.Wrapf(err, "property %s", name)
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.compile(); err != nil {
+			return errors.Wrap(err, "items")
+		}
+	}
+	return nil
+}
+
+// validate returns every violation of the value at path
+func (s *jsonSchema) validate(path string, v interface{}) error {
+	if len(s.types) > 0 && !matchesType(s.types, v) {
+		return errors.Errorf("%s: expected %s, got %s", path, strings.Join(s.types, " or "), typeOf(v))
+	}
+	var errs error
+	fail := func(format string, args ...interface{}) {
+		errs = multierr.Append(errs, errors.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if jsonEqual(allowed, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("value is not one of the enum")
+		}
+	}
+	if len(s.Const) > 0 && !jsonEqual(s.constValue, v) {
+		fail("expected const %s", string(s.Const))
+	}
+	switch value := v.(type) {
+	case float64:
+		if s.Minimum != nil && value < *s.Minimum {
+			fail("%v is less than minimum %v", value, *s.Minimum)
+		}
+		if s.Maximum != nil && value > *s.Maximum {
+			fail("%v is greater than maximum %v", value, *s.Maximum)
+		}
+		if s.ExclusiveMinimum != nil && value <= *s.ExclusiveMinimum {
+			fail("%v is not greater than %v", value, *s.ExclusiveMinimum)
+		}
+		if s.ExclusiveMaximum != nil && value >= *s.ExclusiveMaximum {
+			fail("%v is not less than %v", value, *s.ExclusiveMaximum)
+		}
+	case string:
+		length := len([]rune(value))
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("length %d is less than minLength %d", length, *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("length %d is greater than maxLength %d", length, *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			fail("%q does not match pattern %s", value, s.Pattern)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(value) < *s.MinItems {
+			fail("%d items are less than minItems %d", len(value), *s.MinItems)
+		}
+		if s.MaxItems != nil && len(value) > *s.MaxItems {
+			fail("%d items are more than maxItems %d", len(value), *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range value {
+				errs = multierr.Append(errs, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item))
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				fail("missing required property %s", name)
+			}
+		}
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		// violations are reported in a stable order
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			switch {
+			case ok:
+				errs = multierr.Append(errs, property.validate(path+"."+name, value[name]))
+			case s.noAdditional:
+				fail("property %s is not allowed", name)
+			case s.additional != nil:
+				errs = multierr.Append(errs, s.additional.validate(path+"."+name, value[name]))
+			}
+		}
+	}
+	return errs
+}
+
+// matchesType tells whether v is of any of the JSON Schema types, integers are numbers too
+func matchesType(types []string, v interface{}) bool {
+	actual := typeOf(v)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON Schema type of the decoded JSON value v
+func typeOf(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// jsonEqual compares the decoded JSON values
+func jsonEqual(a, b interface{}) bool {
+	x, errA := json.Marshal(a)
+	y, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(x) == string(y)
+}
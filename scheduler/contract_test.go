@@ -0,0 +1,153 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/schema"
+)
+
+type mockS3 struct {
+	s3iface.S3API
+	objects map[string]string
+	calls   int
+}
+
+func (m *mockS3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	m.calls++
+	object, ok := m.objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)]
+	if !ok {
+		return nil, errors.New("NoSuchKey")
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(object))}, nil
+}
+
+func TestPayloadContracts(t *testing.T) {
+	invoiceSchema := `{
+		"type": "object",
+		"required": ["invoice", "period"],
+		"additionalProperties": false,
+		"properties": {
+			"invoice": {"type": "integer", "minimum": 1},
+			"period": {"enum": ["monthly", "yearly"]},
+			"emails": {"type": "array", "maxItems": 2, "items": {"type": "string", "pattern": "@"}},
+			"sent_at": {"type": "string", "minLength": 20}
+		}
+	}`
+	conn := &mockS3{objects: map[string]string{"contracts/invoice.json": invoiceSchema}}
+	contracts := NewPayloadContracts(conn)
+	for _, c := range []struct {
+		caseName      string
+		payload       string
+		payloadSchema string
+		err           bool
+		wantErr       []string
+	}{
+		{
+			caseName:      "valid",
+			payload:       `{"invoice": 42, "period": "monthly", "emails": ["billing@example.com"]}`,
+			payloadSchema: invoiceSchema,
+		},
+		{
+			caseName:      "valid_template",
+			payload:       `{"invoice": 42, "period": "monthly", "sent_at": "{{ now }}"}`,
+			payloadSchema: invoiceSchema,
+		},
+		{
+			caseName:      "referenced",
+			payload:       `{"invoice": 42, "period": "yearly"}`,
+			payloadSchema: "s3://contracts/invoice.json",
+		},
+		{
+			caseName: "no_schema",
+			payload:  "not json",
+		},
+		{
+			caseName:      "violations",
+			payload:       `{"invoice": 0.5, "period": "daily", "emails": ["a", "b@example.com", "c@example.com"], "note": "x"}`,
+			payloadSchema: invoiceSchema,
+			err:           true,
+			wantErr: []string{
+				"payload.invoice: expected integer, got number",
+				"payload.period: value is not one of the enum",
+				"payload.emails: 3 items are more than maxItems 2",
+				`payload.emails[0]: "a" does not match pattern @`,
+				"payload: property note is not allowed",
+			},
+		},
+		{
+			caseName:      "missing_required",
+			payload:       `{"period": "monthly"}`,
+			payloadSchema: "s3://contracts/invoice.json",
+			err:           true,
+			wantErr:       []string{"payload: missing required property invoice"},
+		},
+		{
+			caseName:      "not_json",
+			payload:       "invoice=42",
+			payloadSchema: invoiceSchema,
+			err:           true,
+			wantErr:       []string{"is not JSON"},
+		},
+		{
+			caseName:      "invalid_schema",
+			payload:       `{"invoice": 42}`,
+			payloadSchema: `{"type": 42}`,
+			err:           true,
+			wantErr:       []string{"invalid PayloadSchema"},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			req := &schema.ScheduledRequest{ID: "test-contract", Payload: c.payload, PayloadSchema: c.payloadSchema}
+			err := contracts.Check(context.Background(), req)
+			if !c.err {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Equal(t, ErrValidation, pkgerrors.Cause(err))
+			for _, want := range c.wantErr {
+				assert.Contains(t, err.Error(), want)
+			}
+		})
+	}
+	// the referenced schema is fetched once
+	assert.Equal(t, 1, conn.calls)
+
+	t.Run("case=fetch_error", func(t *testing.T) {
+		req := &schema.ScheduledRequest{ID: "test-contract", Payload: "{}", PayloadSchema: "s3://contracts/missing.json"}
+		err := contracts.Check(context.Background(), req)
+		require.Error(t, err)
+		// not the fault of request
+		assert.NotEqual(t, ErrValidation, pkgerrors.Cause(err))
+	})
+
+	t.Run("case=create_rejected", func(t *testing.T) {
+		mockConn := new(mockDynamoDB)
+		SetPayloadContracts(contracts)
+		defer SetPayloadContracts(NewPayloadContracts(nil))
+		req := &schema.ScheduledRequest{
+			ID:            "test-contract",
+			Method:        "POST",
+			URL:           "/invoices",
+			Payload:       `{"period": "monthly"}`,
+			PayloadSchema: "s3://contracts/invoice.json",
+		}
+		err := Create(context.Background(), mockConn, "contract_test", req)
+		require.Error(t, err)
+		assert.Equal(t, ErrValidation, pkgerrors.Cause(err))
+		assert.Nil(t, mockConn.lastPutItem)
+	})
+}
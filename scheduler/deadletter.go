@@ -0,0 +1,190 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// FailurePolicy decides what happens to a request after a failed execution
+type FailurePolicy struct {
+	// Failed executions of a request before it is given up, it is retried by next runs until then
+	MaxAttempts int
+	// Wait before the next run retries a failed request, doubled on each attempt
+	Backoff time.Duration
+	// Optional queue receiving given up requests, which otherwise stay locked for manual intervention
+	DeadLetter DeadLetterQueue
+}
+
+// DeadLetterQueue keeps requests given up by the failure policy until they are redriven
+type DeadLetterQueue interface {
+	// Put stores the full request along with its failure history
+	Put(ctx context.Context, req *schema.ScheduledRequest) error
+	// Redrive moves stored requests back into the schedule table to be executed at given time,
+	// returns number of redriven requests
+	Redrive(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, at time.Time) (int, error)
+}
+
+// handleFailure reschedules the failed request until it exhausts the policy attempts, then moves
+// it from the schedule table to the dead-letter queue if any
+func handleFailure(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, policy FailurePolicy, audit *AuditLog, req *schema.ScheduledRequest, now time.Time) error {
+	// failure being handled is already counted by logFailure
+	attempts := req.Attempts + 1
+	if attempts < policy.MaxAttempts {
+		at := now.Add(policy.Backoff << uint(attempts-1))
+		recordAudit(ctx, audit, req.ID, AuditUnlocked, fmt.Sprintf("retry attempts=%d effective_after=%s", attempts, at.Format(unixFormat)))
+		return errors.Wrapf(ignoreEdited(reschedule(ctx, conn, tableName, req.ID, req.Version, at), req), "reschedule id=%s attempts=%d", req.ID, attempts)
+	}
+	if policy.DeadLetter == nil {
+		return nil
+	}
+	log.Printf("dead-letter request table_name=%s id=%s attempts=%d \n", tableName, req.ID, attempts)
+	full, err := Get(ctx, conn, tableName, req.ID)
+	if err != nil {
+		return errors.Wrapf(err, "get id=%s table_name=%s", req.ID, tableName)
+	}
+	if err = policy.DeadLetter.Put(ctx, full); err != nil {
+		return errors.Wrapf(err, "deadLetter.Put id=%s", req.ID)
+	}
+	if err = removeRequest(ctx, conn, tableName, req.ID, full.Version); err != nil {
+		return errors.Wrapf(err, "removeRequest id=%s", req.ID)
+	}
+	recordAudit(ctx, audit, req.ID, AuditDeleted, fmt.Sprintf("dead-lettered attempts=%d", attempts))
+	return nil
+}
+
+// redrive resets execution state of a dead-lettered request so that it is scheduled again
+func redrive(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, req *schema.ScheduledRequest, at time.Time) error {
+	req.Locking = false
+	req.Attempts = 0
+	req.Status = schema.StatusPending
+	req.EffectiveAfter = at
+	return Create(ctx, conn, tableName, req)
+}
+
+// SQSDeadLetterQueue keeps given up requests as JSON messages of a queue
+type SQSDeadLetterQueue struct {
+	conn     sqsiface.SQSAPI
+	queueURL string
+}
+
+// NewSQSDeadLetterQueue returns dead-letter queue backed by given sqs queue
+func NewSQSDeadLetterQueue(conn sqsiface.SQSAPI, queueURL string) *SQSDeadLetterQueue {
+	return &SQSDeadLetterQueue{
+		conn:     conn,
+		queueURL: queueURL,
+	}
+}
+
+// Put sends the request as message
+func (q *SQSDeadLetterQueue) Put(ctx context.Context, req *schema.ScheduledRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrapf(err, "json.Marshal %s", req.ToString())
+	}
+	if _, err = q.conn.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.queueURL),
+		MessageBody: aws.String(string(body)),
+	}); err != nil {
+		return errors.Wrapf(err, "conn.SendMessage queue_url=%s id=%s", q.queueURL, req.ID)
+	}
+	return nil
+}
+
+// Redrive receives messages until the queue is drained, each message is deleted once its request
+// is stored back into the schedule table
+func (q *SQSDeadLetterQueue) Redrive(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, at time.Time) (int, error) {
+	redriven := 0
+	for {
+		output, err := q.conn.ReceiveMessage(&sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(q.queueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(1),
+		})
+		if err != nil {
+			return redriven, errors.Wrapf(err, "conn.ReceiveMessage queue_url=%s", q.queueURL)
+		}
+		if len(output.Messages) == 0 {
+			return redriven, nil
+		}
+		for _, msg := range output.Messages {
+			req := new(schema.ScheduledRequest)
+			if err = json.Unmarshal([]byte(aws.StringValue(msg.Body)), req); err != nil {
+				return redriven, errors.Wrapf(err, "json.Unmarshal message_id=%s", aws.StringValue(msg.MessageId))
+			}
+			if err = redrive(ctx, conn, tableName, req, at); err != nil {
+				return redriven, errors.Wrapf(err, "redrive id=%s", req.ID)
+			}
+			if _, err = q.conn.DeleteMessage(&sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(q.queueURL),
+				ReceiptHandle: msg.ReceiptHandle,
+			}); err != nil {
+				return redriven, errors.Wrapf(err, "conn.DeleteMessage queue_url=%s id=%s", q.queueURL, req.ID)
+			}
+			redriven++
+		}
+	}
+}
+
+// TableDeadLetterQueue keeps given up requests as items of a table sharing the schedule table key
+type TableDeadLetterQueue struct {
+	conn      dynamodbiface.DynamoDBAPI
+	tableName string
+}
+
+// NewTableDeadLetterQueue returns dead-letter queue backed by given dynamodb table
+func NewTableDeadLetterQueue(conn dynamodbiface.DynamoDBAPI, tableName string) *TableDeadLetterQueue {
+	return &TableDeadLetterQueue{
+		conn:      conn,
+		tableName: tableName,
+	}
+}
+
+// Put stores the request as item
+func (q *TableDeadLetterQueue) Put(ctx context.Context, req *schema.ScheduledRequest) error {
+	return Create(ctx, q.conn, q.tableName, req)
+}
+
+// Redrive scans all the items, each item is removed once its request is stored back into the
+// schedule table
+func (q *TableDeadLetterQueue) Redrive(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, at time.Time) (int, error) {
+	redriven := 0
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(q.tableName),
+	}
+	for {
+		output, err := q.conn.ScanWithContext(ctx, input)
+		if err != nil {
+			return redriven, errors.Wrapf(err, "conn.Scan table_name=%s", q.tableName)
+		}
+		requests := []*schema.ScheduledRequest{}
+		if err = dynamodbattribute.UnmarshalListOfMaps(output.Items, &requests); err != nil {
+			return redriven, errors.Wrapf(err, "dynamodbattribute.UnmarshalListOfMaps table_name=%s", q.tableName)
+		}
+		for _, req := range requests {
+			if err = redrive(ctx, conn, tableName, req, at); err != nil {
+				return redriven, errors.Wrapf(err, "redrive id=%s", req.ID)
+			}
+			if err = removeRequest(ctx, q.conn, q.tableName, req.ID, anyVersion); err != nil {
+				return redriven, errors.Wrapf(err, "removeRequest id=%s", req.ID)
+			}
+			redriven++
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			return redriven, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
@@ -0,0 +1,178 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/schema"
+)
+
+type mockDeadLetterQueue struct {
+	put    []*schema.ScheduledRequest
+	putErr error
+}
+
+func (mq *mockDeadLetterQueue) Put(ctx context.Context, req *schema.ScheduledRequest) error {
+	mq.put = append(mq.put, req)
+	return mq.putErr
+}
+
+func (mq *mockDeadLetterQueue) Redrive(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, at time.Time) (int, error) {
+	return 0, nil
+}
+
+func TestHandleFailure(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "handleFailure_test"
+	now := time.Date(2018, time.September, 02, 0, 2, 3, 0, time.UTC)
+	for _, c := range []struct {
+		caseName    string
+		attempts    int
+		policy      FailurePolicy
+		setup       func()
+		err         bool
+		rescheduled string
+		deadLetter  bool
+	}{
+		{
+			caseName: "zero policy left locked",
+			setup:    func() {},
+		},
+		{
+			caseName:    "first retry",
+			policy:      FailurePolicy{MaxAttempts: 3, Backoff: time.Minute},
+			setup:       func() {},
+			rescheduled: "2018-09-02T00:03:03Z",
+		},
+		{
+			caseName:    "backoff doubled",
+			attempts:    1,
+			policy:      FailurePolicy{MaxAttempts: 3, Backoff: time.Minute},
+			setup:       func() {},
+			rescheduled: "2018-09-02T00:04:03Z",
+		},
+		{
+			caseName: "exhausted without dead-letter queue",
+			attempts: 2,
+			policy:   FailurePolicy{MaxAttempts: 3, Backoff: time.Minute},
+			setup:    func() {},
+		},
+		{
+			caseName: "exhausted into dead-letter queue",
+			attempts: 2,
+			policy:   FailurePolicy{MaxAttempts: 3, Backoff: time.Minute, DeadLetter: new(mockDeadLetterQueue)},
+			setup: func() {
+				mockConn.item = map[string]*dynamodb.AttributeValue{
+					"ID":       {S: aws.String("test-handle-failure")},
+					"Attempts": {N: aws.String("3")},
+					"FailureHistory": {L: []*dynamodb.AttributeValue{
+						{M: map[string]*dynamodb.AttributeValue{
+							"At":     {S: aws.String("2018-09-02T00:02:03Z")},
+							"Reason": {S: aws.String("c.Do: connection refused")},
+						}},
+					}},
+				}
+			},
+			deadLetter: true,
+		},
+		{
+			caseName: "dead-letter queue error",
+			policy:   FailurePolicy{DeadLetter: &mockDeadLetterQueue{putErr: errors.New("internal error")}},
+			setup:    func() {},
+			err:      true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			c.setup()
+			req := &schema.ScheduledRequest{ID: "test-handle-failure", Attempts: c.attempts}
+			err := handleFailure(context.Background(), mockConn, table, c.policy, nil, req, now)
+			if c.err == true {
+				assert.Error(t, err)
+				assert.Nil(t, mockConn.lastDeleteItem)
+				return
+			}
+			require.NoError(t, err)
+			if c.rescheduled != "" {
+				require.NotNil(t, mockConn.lastUpdateItem)
+				assert.Equal(t, c.rescheduled, *mockConn.lastUpdateItem.ExpressionAttributeValues[":e"].S)
+			} else {
+				assert.Nil(t, mockConn.lastUpdateItem)
+			}
+			if c.deadLetter == true {
+				put := c.policy.DeadLetter.(*mockDeadLetterQueue).put
+				require.Len(t, put, 1)
+				assert.Equal(t, 3, put[0].Attempts)
+				require.Len(t, put[0].FailureHistory, 1)
+				assert.Equal(t, "c.Do: connection refused", put[0].FailureHistory[0].Reason)
+				require.NotNil(t, mockConn.lastDeleteItem)
+				assert.Equal(t, "test-handle-failure", *mockConn.lastDeleteItem.Key["ID"].S)
+			} else {
+				assert.Nil(t, mockConn.lastDeleteItem)
+			}
+		})
+	}
+}
+
+func TestSQSDeadLetterQueue(t *testing.T) {
+	conn := new(mockSQS)
+	q := NewSQSDeadLetterQueue(conn, "https://sqs.us-east-1.amazonaws.com/123456789012/dlq")
+	req := &schema.ScheduledRequest{ID: "test-sqs-dlq", Locking: true, Attempts: 3}
+	require.NoError(t, q.Put(context.Background(), req))
+	require.NotNil(t, conn.lastSendInput)
+	assert.Equal(t, "https://sqs.us-east-1.amazonaws.com/123456789012/dlq", *conn.lastSendInput.QueueUrl)
+
+	conn.received = [][]*sqs.Message{
+		{{MessageId: aws.String("1"), ReceiptHandle: aws.String("handle-1"), Body: conn.lastSendInput.MessageBody}},
+	}
+	mockConn := new(mockDynamoDB)
+	mockConn.clear()
+	at := time.Date(2018, time.September, 02, 0, 2, 3, 0, time.UTC)
+	redriven, err := q.Redrive(context.Background(), mockConn, "redrive_test", at)
+	require.NoError(t, err)
+	assert.Equal(t, 1, redriven)
+	assert.Equal(t, []string{"handle-1"}, conn.deleted)
+	require.NotNil(t, mockConn.lastPutItem)
+	stored := new(schema.ScheduledRequest)
+	require.NoError(t, dynamodbattribute.UnmarshalMap(mockConn.lastPutItem.Item, stored))
+	assert.Equal(t, "test-sqs-dlq", stored.ID)
+	assert.False(t, stored.Locking)
+	assert.Equal(t, 0, stored.Attempts)
+	assert.Equal(t, at, stored.EffectiveAfter)
+}
+
+func TestTableDeadLetterQueue(t *testing.T) {
+	dlqConn, schedConn := new(mockDynamoDB), new(mockDynamoDB)
+	dlqConn.clear()
+	schedConn.clear()
+	q := NewTableDeadLetterQueue(dlqConn, "dlq_test")
+	req := &schema.ScheduledRequest{ID: "test-table-dlq", Locking: true, Attempts: 3}
+	require.NoError(t, q.Put(context.Background(), req))
+	require.NotNil(t, dlqConn.lastPutItem)
+	assert.Equal(t, "dlq_test", *dlqConn.lastPutItem.TableName)
+
+	dlqConn.items = []map[string]*dynamodb.AttributeValue{dlqConn.lastPutItem.Item}
+	at := time.Date(2018, time.September, 02, 0, 2, 3, 0, time.UTC)
+	redriven, err := q.Redrive(context.Background(), schedConn, "redrive_test", at)
+	require.NoError(t, err)
+	assert.Equal(t, 1, redriven)
+	require.NotNil(t, dlqConn.lastDeleteItem)
+	assert.Equal(t, "test-table-dlq", *dlqConn.lastDeleteItem.Key["ID"].S)
+	require.NotNil(t, schedConn.lastPutItem)
+	assert.Equal(t, "redrive_test", *schedConn.lastPutItem.TableName)
+	stored := new(schema.ScheduledRequest)
+	require.NoError(t, dynamodbattribute.UnmarshalMap(schedConn.lastPutItem.Item, stored))
+	assert.False(t, stored.Locking)
+	assert.Equal(t, 0, stored.Attempts)
+}
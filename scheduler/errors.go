@@ -0,0 +1,29 @@
+package scheduler
+
+import "github.com/pkg/errors"
+
+// Kinds of errors returned by storage functions, wrapped along with the failed call so that
+// callers tell them by errors.Cause or errors.Is
+var (
+	// ErrNotFound is returned when the request does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyLocked is returned when the request is locked already, e.g. by a concurrent run
+	ErrAlreadyLocked = errors.New("already locked")
+	// ErrValidation is returned when a request given by a caller cannot be decoded or validated
+	ErrValidation = errors.New("invalid request")
+	// ErrConditionalCheck is matched by errors.Is when a write was refused as the stored request
+	// changed meanwhile, e.g. its id got taken or it was updated since it was read
+	ErrConditionalCheck = errors.New("conditional check failed")
+)
+
+// conditionError is a specific failure of conditional write, which is ErrConditionalCheck too
+type conditionError string
+
+func (e conditionError) Error() string {
+	return string(e)
+}
+
+// Is tells errors.Is that e is ErrConditionalCheck
+func (e conditionError) Is(target error) bool {
+	return target == ErrConditionalCheck
+}
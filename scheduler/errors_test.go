@@ -0,0 +1,28 @@
+package scheduler
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorKinds(t *testing.T) {
+	for _, c := range []struct {
+		caseName  string
+		err       error
+		condition bool
+	}{
+		{caseName: "version_conflict", err: errVersionConflict, condition: true},
+		{caseName: "already_exists", err: errAlreadyExists, condition: true},
+		{caseName: "not_found", err: ErrNotFound},
+		{caseName: "already_locked", err: ErrAlreadyLocked},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			err := errors.Wrapf(c.err, "id=%s", "test-error")
+			assert.Equal(t, c.err, errors.Cause(err))
+			assert.Equal(t, c.condition, errors.Is(err, ErrConditionalCheck))
+		})
+	}
+}
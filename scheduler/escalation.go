@@ -0,0 +1,157 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Default API endpoints of escalation services
+const (
+	PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	OpsgenieAlertsURL  = "https://api.opsgenie.com/v2/alerts"
+)
+
+// maxOpsgenieMessageLen is the limit of Opsgenie alert message
+const maxOpsgenieMessageLen = 130
+
+// Incident is opened on an escalation service, repeated incidents of the same dedup key are
+// grouped by the service rather than paging again
+type Incident struct {
+	DedupKey string
+	Summary  string
+	Details  interface{}
+}
+
+// incidentRequest builds the API request opening an incident on a specific service
+type incidentRequest func(incident *Incident) (*http.Request, error)
+
+// EscalationNotifier opens incidents on PagerDuty or Opsgenie when a request failed threshold
+// consecutive times or when every execution of a run failed, other notifications are dropped
+type EscalationNotifier struct {
+	service   string
+	threshold int
+	client    *http.Client
+	request   incidentRequest
+}
+
+func newEscalationNotifier(service string, threshold int, request incidentRequest) *EscalationNotifier {
+	return &EscalationNotifier{
+		service:   service,
+		threshold: threshold,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		request:   request,
+	}
+}
+
+// NewPagerDutyNotifier returns notifier triggering events of given Events API v2 integration,
+// eventsURL defaults to PagerDutyEventsURL and is set for other regions e.g.
+// https://events.eu.pagerduty.com/v2/enqueue
+func NewPagerDutyNotifier(routingKey, eventsURL string, threshold int) *EscalationNotifier {
+	if eventsURL == "" {
+		eventsURL = PagerDutyEventsURL
+	}
+	return newEscalationNotifier("pagerduty", threshold, func(incident *Incident) (*http.Request, error) {
+		payload, err := json.Marshal(map[string]interface{}{
+			"routing_key":  routingKey,
+			"event_action": "trigger",
+			"dedup_key":    incident.DedupKey,
+			"payload": map[string]interface{}{
+				"summary":        incident.Summary,
+				"source":         "citium",
+				"severity":       "error",
+				"custom_details": incident.Details,
+			},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "json.Marshal")
+		}
+		return http.NewRequest(http.MethodPost, eventsURL, bytes.NewReader(payload))
+	})
+}
+
+// NewOpsgenieNotifier returns notifier creating alerts with given API key, alertsURL defaults to
+// OpsgenieAlertsURL and is set for other regions e.g. https://api.eu.opsgenie.com/v2/alerts
+func NewOpsgenieNotifier(apiKey, alertsURL string, threshold int) *EscalationNotifier {
+	if alertsURL == "" {
+		alertsURL = OpsgenieAlertsURL
+	}
+	return newEscalationNotifier("opsgenie", threshold, func(incident *Incident) (*http.Request, error) {
+		details, err := json.Marshal(incident.Details)
+		if err != nil {
+			return nil, errors.Wrap(err, "json.Marshal")
+		}
+		message := incident.Summary
+		if len(message) > maxOpsgenieMessageLen {
+			message = message[:maxOpsgenieMessageLen]
+		}
+		payload, err := json.Marshal(map[string]interface{}{
+			"message":     message,
+			"alias":       incident.DedupKey,
+			"description": string(details),
+			"source":      "citium",
+			"priority":    "P2",
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "json.Marshal")
+		}
+		req, err := http.NewRequest(http.MethodPost, alertsURL, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "GenieKey "+apiKey)
+		return req, nil
+	})
+}
+
+// NotifyFailure opens an incident once the request failed threshold times
+func (n *EscalationNotifier) NotifyFailure(ctx context.Context, notification *FailureNotification) error {
+	if notification.Attempts < n.threshold {
+		return nil
+	}
+	summary := fmt.Sprintf("citium request %s failed %d times", notification.RequestID, notification.Attempts)
+	if notification.Description != "" {
+		summary += ": " + notification.Description
+	}
+	return n.open(ctx, &Incident{
+		DedupKey: "citium-request-" + notification.RequestID,
+		Summary:  summary,
+		Details:  notification,
+	})
+}
+
+// NotifySummary opens an incident if every execution of the run failed
+func (n *EscalationNotifier) NotifySummary(ctx context.Context, summary *RunSummary) error {
+	if summary.Failed == 0 || summary.Succeeded > 0 {
+		return nil
+	}
+	return n.open(ctx, &Incident{
+		DedupKey: "citium-run-" + summary.TableName,
+		Summary:  fmt.Sprintf("citium run of %s failed all %d executions", summary.TableName, summary.Failed),
+		Details:  summary,
+	})
+}
+
+func (n *EscalationNotifier) open(ctx context.Context, incident *Incident) error {
+	req, err := n.request(incident)
+	if err != nil {
+		return errors.Wrapf(err, "build incident request service=%s", n.service)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	log.Printf("open incident service=%s dedup_key=%s \n", n.service, incident.DedupKey)
+	resp, err := n.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "n.client.Do service=%s", n.service)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected incident response service=%s code=%d", n.service, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEscalationNotifier(t *testing.T) {
+	failure := func(attempts int) *FailureNotification {
+		return &FailureNotification{RequestID: "test-escalation", Target: "http", Error: "c.Do: connection refused", Attempts: attempts}
+	}
+	for _, c := range []struct {
+		caseName string
+		service  string
+		code     int
+		notify   func(n *EscalationNotifier) error
+		// expected dedup key, empty if no incident is opened
+		dedupKey string
+		err      bool
+	}{
+		{
+			caseName: "pagerduty_below_threshold",
+			service:  "pagerduty",
+			code:     http.StatusAccepted,
+			notify: func(n *EscalationNotifier) error {
+				return n.NotifyFailure(context.Background(), failure(2))
+			},
+		},
+		{
+			caseName: "pagerduty_failure",
+			service:  "pagerduty",
+			code:     http.StatusAccepted,
+			notify: func(n *EscalationNotifier) error {
+				return n.NotifyFailure(context.Background(), failure(3))
+			},
+			dedupKey: "citium-request-test-escalation",
+		},
+		{
+			caseName: "pagerduty_partial_run",
+			service:  "pagerduty",
+			code:     http.StatusAccepted,
+			notify: func(n *EscalationNotifier) error {
+				return n.NotifySummary(context.Background(), &RunSummary{TableName: "citium_schedule", Executed: 2, Succeeded: 1, Failed: 1})
+			},
+		},
+		{
+			caseName: "pagerduty_failed_run",
+			service:  "pagerduty",
+			code:     http.StatusAccepted,
+			notify: func(n *EscalationNotifier) error {
+				return n.NotifySummary(context.Background(), &RunSummary{TableName: "citium_schedule", Executed: 2, Failed: 2})
+			},
+			dedupKey: "citium-run-citium_schedule",
+		},
+		{
+			caseName: "opsgenie_failure",
+			service:  "opsgenie",
+			code:     http.StatusAccepted,
+			notify: func(n *EscalationNotifier) error {
+				return n.NotifyFailure(context.Background(), failure(4))
+			},
+			dedupKey: "citium-request-test-escalation",
+		},
+		{
+			caseName: "opsgenie_rejected",
+			service:  "opsgenie",
+			code:     http.StatusUnauthorized,
+			notify: func(n *EscalationNotifier) error {
+				return n.NotifyFailure(context.Background(), failure(3))
+			},
+			dedupKey: "citium-request-test-escalation",
+			err:      true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			var received map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				if c.service == "opsgenie" {
+					assert.Equal(t, "GenieKey test-key", r.Header.Get("Authorization"))
+				}
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+				w.WriteHeader(c.code)
+			}))
+			defer server.Close()
+			n := NewPagerDutyNotifier("test-key", server.URL, 3)
+			if c.service == "opsgenie" {
+				n = NewOpsgenieNotifier("test-key", server.URL, 3)
+			}
+			err := c.notify(n)
+			if c.err {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			if c.dedupKey == "" {
+				assert.Nil(t, received)
+				return
+			}
+			require.NotNil(t, received)
+			switch c.service {
+			case "pagerduty":
+				assert.Equal(t, "test-key", received["routing_key"])
+				assert.Equal(t, "trigger", received["event_action"])
+				assert.Equal(t, c.dedupKey, received["dedup_key"])
+				assert.NotEmpty(t, received["payload"].(map[string]interface{})["summary"])
+			case "opsgenie":
+				assert.Equal(t, c.dedupKey, received["alias"])
+				assert.NotEmpty(t, received["message"])
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package scheduler
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// Executor performs the action of requests of a target type, returning the response recorded as
+// their result
+type Executor interface {
+	Execute(ctx context.Context, req *schema.ScheduledRequest) (*schema.Response, error)
+}
+
+// ExecutorFunc is an Executor of a plain function
+type ExecutorFunc func(ctx context.Context, req *schema.ScheduledRequest) (*schema.Response, error)
+
+// Execute calls f
+func (f ExecutorFunc) Execute(ctx context.Context, req *schema.ScheduledRequest) (*schema.Response, error) {
+	return f(ctx, req)
+}
+
+// builtinExecutors perform the built-in target types with the clients of services
+var builtinExecutors = map[string]func(svc *Services) Executor{
+	schema.TargetHTTP: func(svc *Services) Executor {
+		return ExecutorFunc(func(ctx context.Context, req *schema.ScheduledRequest) (*schema.Response, error) {
+			return execRequest(ctx, svc.HTTP, req)
+		})
+	},
+	schema.TargetSQS: func(svc *Services) Executor {
+		return ExecutorFunc(func(ctx context.Context, req *schema.ScheduledRequest) (*schema.Response, error) {
+			return sendMessage(ctx, svc.SQS, req)
+		})
+	},
+	schema.TargetKinesis: func(svc *Services) Executor {
+		return ExecutorFunc(func(ctx context.Context, req *schema.ScheduledRequest) (*schema.Response, error) {
+			return putRecord(ctx, svc.Kinesis, req)
+		})
+	},
+	schema.TargetStepFunctions: func(svc *Services) Executor {
+		return ExecutorFunc(func(ctx context.Context, req *schema.ScheduledRequest) (*schema.Response, error) {
+			return startExecution(ctx, svc.StepFunctions, req)
+		})
+	},
+	schema.TargetKafka: func(svc *Services) Executor {
+		return ExecutorFunc(func(ctx context.Context, req *schema.ScheduledRequest) (*schema.Response, error) {
+			return produceMessage(ctx, svc.Kafka, req)
+		})
+	},
+	schema.TargetMQTT: func(svc *Services) Executor {
+		return ExecutorFunc(func(ctx context.Context, req *schema.ScheduledRequest) (*schema.Response, error) {
+			return publishMessage(ctx, svc.MQTT, req)
+		})
+	},
+	schema.TargetSSM: func(svc *Services) Executor {
+		return ExecutorFunc(func(ctx context.Context, req *schema.ScheduledRequest) (*schema.Response, error) {
+			return svc.SSM.runCommand(ctx, req)
+		})
+	},
+	schema.TargetDynamoDB: func(svc *Services) Executor {
+		return ExecutorFunc(func(ctx context.Context, req *schema.ScheduledRequest) (*schema.Response, error) {
+			return writeItem(ctx, svc.DynamoDB, req)
+		})
+	},
+	schema.TargetSteps: func(svc *Services) Executor {
+		return ExecutorFunc(func(ctx context.Context, req *schema.ScheduledRequest) (*schema.Response, error) {
+			return runSteps(ctx, svc.HTTP, req)
+		})
+	},
+}
+
+// RegisterExecutor performs the requests of targetType with executor, replacing the built-in one
+// if any. Requests of a new target type are accepted by validation from then on
+func (svc *Services) RegisterExecutor(targetType string, executor Executor) {
+	if svc.Executors == nil {
+		svc.Executors = map[string]Executor{}
+	}
+	svc.Executors[targetType] = executor
+	schema.RegisterTargetType(targetType)
+}
+
+// executor returns the registered executor of target type, or else the built-in one
+func (svc *Services) executor(targetType string) (Executor, bool) {
+	if executor, ok := svc.Executors[targetType]; ok {
+		return executor, true
+	}
+	newExecutor, ok := builtinExecutors[targetType]
+	if !ok {
+		return nil, false
+	}
+	return newExecutor(svc), true
+}
+
+// perform executes the action of request with the executor of its target type
+func perform(ctx context.Context, svc *Services, req *schema.ScheduledRequest) (*schema.Response, error) {
+	executor, ok := svc.executor(req.Target())
+	if !ok {
+		return nil, errors.Errorf("no executor of target type=%s", req.Target())
+	}
+	return executor.Execute(ctx, req)
+}
@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/schema"
+)
+
+func TestExecutors(t *testing.T) {
+	mockClient := new(mockHTTPClient)
+	svc := &Services{HTTP: mockClient}
+	var invoked []string
+	svc.RegisterExecutor("test-lambda", ExecutorFunc(func(ctx context.Context, req *schema.ScheduledRequest) (*schema.Response, error) {
+		invoked = append(invoked, req.ID)
+		return &schema.Response{Code: 200, Body: "invoked"}, nil
+	}))
+	for _, c := range []struct {
+		caseName   string
+		targetType string
+		err        bool
+		wantBody   string
+		wantHTTP   uint32
+		wantCustom int
+	}{
+		{
+			caseName: "builtin_default",
+			wantHTTP: 1,
+		},
+		{
+			caseName:   "builtin_http",
+			targetType: schema.TargetHTTP,
+			wantHTTP:   1,
+		},
+		{
+			caseName:   "registered",
+			targetType: "test-lambda",
+			wantBody:   "invoked",
+			wantCustom: 1,
+		},
+		{
+			caseName:   "unknown",
+			targetType: "test-unknown",
+			err:        true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockClient.clear()
+			invoked = nil
+			req := &schema.ScheduledRequest{
+				ID:             "test-executor",
+				CreatedAt:      time.Now().UTC(),
+				EffectiveAfter: time.Now().UTC(),
+				TargetType:     c.targetType,
+				Method:         "GET",
+				URL:            "/jobs",
+			}
+			resp, err := perform(context.Background(), svc, req)
+			if c.err {
+				assert.Error(t, err)
+				assert.Error(t, req.Validate())
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, req.Validate())
+			assert.Equal(t, c.wantBody, resp.Body)
+			mockClient.assertCalled(t, c.wantHTTP)
+			assert.Len(t, invoked, c.wantCustom)
+		})
+	}
+
+	t.Run("case=override_builtin", func(t *testing.T) {
+		mockClient.clear()
+		overridden := &Services{HTTP: mockClient}
+		overridden.RegisterExecutor(schema.TargetHTTP, ExecutorFunc(func(ctx context.Context, req *schema.ScheduledRequest) (*schema.Response, error) {
+			return &schema.Response{Code: 204}, nil
+		}))
+		resp, err := perform(context.Background(), overridden, &schema.ScheduledRequest{ID: "test-executor", Method: "GET", URL: "/jobs"})
+		require.NoError(t, err)
+		assert.Equal(t, 204, resp.Code)
+		mockClient.assertCalled(t, 0)
+	})
+}
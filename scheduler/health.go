@@ -0,0 +1,127 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+)
+
+// healthProbeID is the key of the item probed for permissions, it is never written
+const healthProbeID = "citium-health-probe"
+
+// HealthCheck is the outcome of a single check
+type HealthCheck struct {
+	Name    string  `json:"name"`
+	OK      bool    `json:"ok"`
+	Skipped bool    `json:"skipped,omitempty"`
+	Error   string  `json:"error,omitempty"`
+	Elapsed float64 `json:"elapsed_ms"`
+}
+
+// HealthReport gathers the checks, healthy if all of them passed
+type HealthReport struct {
+	Healthy   bool           `json:"healthy"`
+	Checks    []*HealthCheck `json:"checks"`
+	CheckedAt time.Time      `json:"checked_at"`
+}
+
+// ToString returns string representation
+func (r HealthReport) ToString() string {
+	parts := []string{fmt.Sprintf("healthy=%t", r.Healthy)}
+	for _, check := range r.Checks {
+		part := fmt.Sprintf("%s=%t", check.Name, check.OK)
+		if check.Error != "" {
+			part += fmt.Sprintf(" (%s)", check.Error)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+// CheckHealth verifies the table is reachable & active, the permissions to read, write and
+// delete its items are granted and base url answers, without altering any item
+func CheckHealth(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, client *http.Client, baseURL string) *HealthReport {
+	report := &HealthReport{Healthy: true, CheckedAt: time.Now().UTC()}
+	run := func(name string, check func() error) {
+		start := time.Now()
+		err := check()
+		result := &HealthCheck{
+			Name:    name,
+			OK:      err == nil,
+			Elapsed: float64(time.Since(start)) / float64(time.Millisecond),
+		}
+		if err != nil {
+			result.Error = err.Error()
+			report.Healthy = false
+		}
+		report.Checks = append(report.Checks, result)
+	}
+	run("table", func() error {
+		output, err := conn.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+		if err != nil {
+			return errors.Wrapf(err, "conn.DescribeTable table_name=%s", tableName)
+		}
+		if status := aws.StringValue(output.Table.TableStatus); status != dynamodb.TableStatusActive {
+			return errors.Errorf("table is not active table_name=%s status=%s", tableName, status)
+		}
+		return nil
+	})
+	run("read", func() error {
+		_, err := conn.ScanWithContext(ctx, &dynamodb.ScanInput{TableName: aws.String(tableName), Limit: aws.Int64(1)})
+		return errors.Wrapf(err, "conn.Scan table_name=%s", tableName)
+	})
+	// writes are conditioned on a missing item, so a failed condition proves the permission
+	run("write", func() error {
+		_, err := conn.UpdateItemWithContext(ctx, bumpVersion(&dynamodb.UpdateItemInput{
+			TableName:           aws.String(tableName),
+			Key:                 map[string]*dynamodb.AttributeValue{"ID": {S: aws.String(healthProbeID)}},
+			UpdateExpression:    aws.String("SET Locking = :l"),
+			ConditionExpression: aws.String("attribute_exists(ID)"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":l": {BOOL: aws.Bool(true)},
+			},
+		}))
+		return probeErr(errors.Wrapf(err, "conn.UpdateItem table_name=%s", tableName))
+	})
+	run("delete", func() error {
+		_, err := conn.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+			TableName:           aws.String(tableName),
+			Key:                 map[string]*dynamodb.AttributeValue{"ID": {S: aws.String(healthProbeID)}},
+			ConditionExpression: aws.String("attribute_exists(ID)"),
+		})
+		return probeErr(errors.Wrapf(err, "conn.DeleteItem table_name=%s", tableName))
+	})
+	if baseURL == "" {
+		report.Checks = append(report.Checks, &HealthCheck{Name: "base_url", OK: true, Skipped: true})
+		return report
+	}
+	run("base_url", func() error {
+		req, err := http.NewRequest(http.MethodHead, baseURL, nil)
+		if err != nil {
+			return errors.Wrapf(err, "http.NewRequest url=%s", baseURL)
+		}
+		// any answer proves reachability, whatever its status
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return errors.Wrapf(err, "client.Do url=%s", baseURL)
+		}
+		return resp.Body.Close()
+	})
+	return report
+}
+
+// probeErr returns nil if the conditional probe got rejected only by its condition
+func probeErr(err error) error {
+	if aerr, ok := errors.Cause(err).(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
+	return err
+}
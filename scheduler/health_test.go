@@ -0,0 +1,125 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockHealthDB struct {
+	dynamodbiface.DynamoDBAPI
+	status      string
+	describeErr error
+	scanErr     error
+	updateErr   error
+	delErr      error
+}
+
+func (mdb *mockHealthDB) DescribeTableWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.Option) (*dynamodb.DescribeTableOutput, error) {
+	if mdb.describeErr != nil {
+		return nil, mdb.describeErr
+	}
+	return &dynamodb.DescribeTableOutput{
+		Table: &dynamodb.TableDescription{TableName: input.TableName, TableStatus: aws.String(mdb.status)},
+	}, nil
+}
+
+func (mdb *mockHealthDB) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	return &dynamodb.ScanOutput{}, mdb.scanErr
+}
+
+func (mdb *mockHealthDB) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	return &dynamodb.UpdateItemOutput{}, mdb.updateErr
+}
+
+func (mdb *mockHealthDB) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	return &dynamodb.DeleteItemOutput{}, mdb.delErr
+}
+
+func TestCheckHealth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	conditionFailed := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	accessDenied := awserr.New("AccessDeniedException", "not authorized", nil)
+	for _, c := range []struct {
+		caseName string
+		conn     *mockHealthDB
+		baseURL  string
+		healthy  bool
+		failed   []string
+		skipped  []string
+	}{
+		{
+			caseName: "healthy",
+			conn:     &mockHealthDB{status: dynamodb.TableStatusActive, updateErr: conditionFailed, delErr: conditionFailed},
+			baseURL:  server.URL,
+			healthy:  true,
+		},
+		{
+			caseName: "no_base_url",
+			conn:     &mockHealthDB{status: dynamodb.TableStatusActive, updateErr: conditionFailed, delErr: conditionFailed},
+			healthy:  true,
+			skipped:  []string{"base_url"},
+		},
+		{
+			caseName: "table_not_found",
+			conn: &mockHealthDB{
+				describeErr: awserr.New(dynamodb.ErrCodeResourceNotFoundException, "table not found", nil),
+				scanErr:     awserr.New(dynamodb.ErrCodeResourceNotFoundException, "table not found", nil),
+				updateErr:   awserr.New(dynamodb.ErrCodeResourceNotFoundException, "table not found", nil),
+				delErr:      awserr.New(dynamodb.ErrCodeResourceNotFoundException, "table not found", nil),
+			},
+			baseURL: server.URL,
+			failed:  []string{"table", "read", "write", "delete"},
+		},
+		{
+			caseName: "table_not_active",
+			conn:     &mockHealthDB{status: dynamodb.TableStatusCreating, updateErr: conditionFailed, delErr: conditionFailed},
+			baseURL:  server.URL,
+			failed:   []string{"table"},
+		},
+		{
+			caseName: "write_denied",
+			conn:     &mockHealthDB{status: dynamodb.TableStatusActive, updateErr: accessDenied, delErr: accessDenied},
+			baseURL:  server.URL,
+			failed:   []string{"write", "delete"},
+		},
+		{
+			caseName: "base_url_unreachable",
+			conn:     &mockHealthDB{status: dynamodb.TableStatusActive, updateErr: conditionFailed, delErr: conditionFailed},
+			baseURL:  "http://127.0.0.1:1",
+			failed:   []string{"base_url"},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			report := CheckHealth(context.Background(), c.conn, "CheckHealth_test", server.Client(), c.baseURL)
+			require.Len(t, report.Checks, 5)
+			assert.Equal(t, c.healthy, report.Healthy, report.ToString())
+			var failed, skipped []string
+			for _, check := range report.Checks {
+				if !check.OK {
+					failed = append(failed, check.Name)
+					assert.NotEmpty(t, check.Error)
+				}
+				if check.Skipped {
+					skipped = append(skipped, check.Name)
+				}
+			}
+			assert.Equal(t, c.failed, failed)
+			assert.Equal(t, c.skipped, skipped)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// ExecutionHistory is what is known of the past executions of a request
+type ExecutionHistory struct {
+	ID string `json:"ID"`
+	// Time and response of the last successful execution kept by PersistentStore, nil if none
+	ExecutedAt *time.Time       `json:"ExecutedAt,omitempty"`
+	Result     *schema.Response `json:"Result,omitempty"`
+	// Executions tried, either succeeded or failed, and the time of the last one, nil if none
+	Attempts      int        `json:"Attempts"`
+	LastAttemptAt *time.Time `json:"LastAttemptAt,omitempty"`
+	// Failed executions, the reason of the last one is kept until the request is rescheduled
+	FailureReason string           `json:"FailureReason,omitempty"`
+	Failures      []schema.Failure `json:"Failures"`
+}
+
+// GetHistory returns the execution history stored along with the request of given id
+func GetHistory(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string) (*ExecutionHistory, error) {
+	req, err := Get(ctx, conn, tableName, reqID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get")
+	}
+	history := &ExecutionHistory{
+		ID:            req.ID,
+		Attempts:      req.Attempts,
+		FailureReason: req.FailureReason,
+		Failures:      req.FailureHistory,
+	}
+	if history.Failures == nil {
+		history.Failures = []schema.Failure{}
+	}
+	if !req.LastAttemptAt.IsZero() {
+		lastAttemptAt := req.LastAttemptAt
+		history.LastAttemptAt = &lastAttemptAt
+	}
+	if !req.ExecutedAt.IsZero() {
+		executedAt := req.ExecutedAt
+		history.ExecutedAt = &executedAt
+	}
+	if req.ExecutionResult != "" {
+		history.Result = new(schema.Response)
+		if err = json.Unmarshal([]byte(req.ExecutionResult), history.Result); err != nil {
+			return nil, errors.Wrapf(err, "json.Unmarshal result=%s", req.ExecutionResult)
+		}
+	}
+	return history, nil
+}
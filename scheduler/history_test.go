@@ -0,0 +1,101 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetHistory(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "history_test"
+	for _, c := range []struct {
+		caseName     string
+		setup        func()
+		wantExecuted bool
+		wantCode     int
+		wantFailures int
+		err          bool
+	}{
+		{
+			caseName: "executed",
+			setup: func() {
+				mockConn.item = map[string]*dynamodb.AttributeValue{
+					"ID":              {S: aws.String("test-history")},
+					"ExecutedAt":      {S: aws.String("2018-09-02T00:02:03Z")},
+					"ExecutionResult": {S: aws.String(`{"code":200,"body":"ok","duration_ms":12.5}`)},
+					"Attempts":        {N: aws.String("2")},
+					"LastAttemptAt":   {S: aws.String("2018-09-02T00:02:03Z")},
+					"FailureHistory": {L: []*dynamodb.AttributeValue{
+						{M: map[string]*dynamodb.AttributeValue{
+							"At":     {S: aws.String("2018-09-01T00:02:03Z")},
+							"Reason": {S: aws.String("status 500")},
+						}},
+					}},
+				}
+			},
+			wantExecuted: true,
+			wantCode:     200,
+			wantFailures: 1,
+		},
+		{
+			caseName: "never_executed",
+			setup: func() {
+				mockConn.item = map[string]*dynamodb.AttributeValue{
+					"ID":         {S: aws.String("test-history")},
+					"ExecutedAt": {S: aws.String("0001-01-01T00:00:00Z")},
+				}
+			},
+		},
+		{
+			caseName: "not_found",
+			setup:    func() {},
+			err:      true,
+		},
+		{
+			caseName: "invalid_result",
+			setup: func() {
+				mockConn.item = map[string]*dynamodb.AttributeValue{
+					"ID":              {S: aws.String("test-history")},
+					"ExecutionResult": {S: aws.String("{")},
+				}
+			},
+			err: true,
+		},
+		{
+			caseName: "get_error",
+			setup: func() {
+				mockConn.getErr = errors.New("internal error")
+			},
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			c.setup()
+			history, err := GetHistory(context.Background(), mockConn, table, "test-history")
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "test-history", history.ID)
+			assert.Equal(t, c.wantExecuted, history.ExecutedAt != nil)
+			// the successful execution was the last attempt
+			assert.Equal(t, c.wantExecuted, history.LastAttemptAt != nil)
+			if c.wantCode > 0 {
+				require.NotNil(t, history.Result)
+				assert.Equal(t, c.wantCode, history.Result.Code)
+			} else {
+				assert.Nil(t, history.Result)
+			}
+			assert.Len(t, history.Failures, c.wantFailures)
+		})
+	}
+}
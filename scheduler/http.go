@@ -1,14 +1,26 @@
 package scheduler
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
 	"github.com/pkg/errors"
 	"go.uber.org/multierr"
 
@@ -23,26 +35,163 @@ type Requester interface {
 	DoRequest(ctx context.Context, method, urlStr string, headers map[string]string, body string) (*schema.Response, error)
 }
 
+// ResultStreamer abstracts request interface storing response body into S3 object
+type ResultStreamer interface {
+	StreamRequest(ctx context.Context, method, urlStr string, headers map[string]string, body, key string) (*schema.Response, error)
+}
+
 // HTTPClient manages http request communication
 type HTTPClient struct {
 	*http.Client
+	dialer    *net.Dialer
 	baseURL   *url.URL
 	userAgent string
-	token     string
+	// headers sent with every call unless given by the request
+	defaultHeaders map[string]string
+	token          string
+	tokens         TokenSource
+	// tokens of specific hosts, taking precedence over token and its source
+	hostTokens  map[string]string
+	maxBodySize int64
+	gzipMinSize int64
+	// response streaming destination
+	uploader     s3manageriface.UploaderAPI
+	resultBucket string
+	resultPrefix string
+	// retry policy by response status within a single execution
+	retryOnStatus string
+	maxRetries    int
+	retryBackoff  time.Duration
+	// longest Retry-After honored by waiting within the execution
+	retryAfterMaxWait time.Duration
+	// kept across invocations of a warm function
+	breaker *CircuitBreaker
+	logger  Logger
+	tracer  Tracer
 }
 
-// NewClient returns initialized http client
-func NewClient(conf *config.Configuration) (*HTTPClient, error) {
+// NewClient returns http client initialized by configuration, which opts customize further
+func NewClient(conf *config.Configuration, opts ...Option) (*HTTPClient, error) {
 	baseURL, err := url.Parse(conf.BaseURL)
 	if err != nil {
 		return nil, errors.Wrapf(err, "url.Parse")
 	}
-	return &HTTPClient{
-		Client:    http.DefaultClient,
-		baseURL:   baseURL,
-		userAgent: conf.UserAgent,
-		token:     conf.Token,
-	}, nil
+	dialer := newDialer(conf.DNSServer)
+	client := &HTTPClient{
+		Client:            &http.Client{Transport: newTransport(conf, dialer)},
+		dialer:            dialer,
+		baseURL:           baseURL,
+		userAgent:         conf.UserAgent,
+		defaultHeaders:    conf.DefaultHeaders,
+		token:             conf.Token,
+		hostTokens:        conf.HostTokens,
+		maxBodySize:       conf.MaxBodySize,
+		gzipMinSize:       conf.GzipMinSize,
+		resultBucket:      conf.ResultBucket,
+		resultPrefix:      conf.ResultPrefix,
+		retryOnStatus:     conf.RetryOnStatus,
+		maxRetries:        conf.MaxRetries,
+		retryBackoff:      conf.RetryBackoff,
+		retryAfterMaxWait: conf.RetryAfterMaxWait,
+		breaker:           NewCircuitBreaker(conf.BreakerThreshold, conf.BreakerCooldown),
+		logger:            log.Default(),
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
+	if client.retryOnStatus != "" {
+		if _, err = schema.MatchStatus(client.retryOnStatus, http.StatusOK); err != nil {
+			return nil, errors.Wrapf(err, "invalid retry policy on_status=%s", client.retryOnStatus)
+		}
+	}
+	// wraps the client as customized by every other option
+	if client.tracer != nil {
+		client.Client = client.tracer(client.Client)
+	}
+	return client, nil
+}
+
+// SetUploader enables streaming response bodies to S3 with given uploader
+func (c *HTTPClient) SetUploader(uploader s3manageriface.UploaderAPI) {
+	c.uploader = uploader
+}
+
+// SetTokenSource makes the bearer token be provided by given source instead of static token
+func (c *HTTPClient) SetTokenSource(tokens TokenSource) {
+	c.tokens = tokens
+}
+
+// newTransport returns a dedicated transport so that connection pooling could be tuned for
+// bursts of concurrent executions to the same host
+func newTransport(conf *config.Configuration, dialer *net.Dialer) *http.Transport {
+	protocols := new(http.Protocols)
+	switch conf.HTTP2Mode {
+	case config.HTTP2Off:
+		protocols.SetHTTP1(true)
+	case config.HTTP2PriorKnowledge:
+		// without HTTP/1 enabled, cleartext targets are spoken to in h2c with prior knowledge
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	}
+	return &http.Transport{
+		Protocols:             protocols,
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           overrideDial(dialer, conf.HostOverrides),
+		MaxIdleConns:          conf.MaxIdleConns,
+		MaxIdleConnsPerHost:   conf.MaxIdleConnsPerHost,
+		IdleConnTimeout:       conf.IdleConnTimeout,
+		TLSHandshakeTimeout:   conf.TLSHandshakeTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// newDialer returns dialer resolving with given DNS server, or system resolver if empty
+func newDialer(dnsServer string) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if dnsServer != "" {
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return new(net.Dialer).DialContext(ctx, network, dnsServer)
+			},
+		}
+	}
+	return dialer
+}
+
+// overrideDial connects to the overridden address of a host (split-horizon setups) while TLS
+// verification still happens against the original host name. Overrides keyed by host:port
+// take precedence over ones keyed by host, an override without port keeps the original one.
+func overrideDial(dialer *net.Dialer, overrides map[string]string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "net.SplitHostPort addr=%s", addr)
+		}
+		target, ok := overrides[addr]
+		if !ok {
+			target, ok = overrides[host]
+		}
+		if ok {
+			if _, _, serr := net.SplitHostPort(target); serr != nil {
+				target = net.JoinHostPort(target, port)
+			}
+			log.Printf("override dial addr=%s target=%s \n", addr, target)
+			addr = target
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// SetResolver plugs a custom resolver used to look up target hosts
+func (c *HTTPClient) SetResolver(resolver *net.Resolver) {
+	c.dialer.Resolver = resolver
 }
 
 // Must ensures http client is properly initialized
@@ -55,55 +204,400 @@ func Must(client *HTTPClient, err error) *HTTPClient {
 
 // DoRequest performs http request call by given parameters
 func (c *HTTPClient) DoRequest(ctx context.Context, method, urlStr string, headers map[string]string, body string) (*schema.Response, error) {
-	rel, err := url.Parse(urlStr)
+	resp, trace, err := c.send(ctx, method, urlStr, headers, body)
 	if err != nil {
-		return nil, errors.Wrapf(err, "url.Parse rawurl=%s", urlStr)
+		return nil, errors.Wrapf(err, "c.send method=%s url=%s", method, urlStr)
 	}
-	// method & url
-	u := c.baseURL.ResolveReference(rel)
-	buf := strings.NewReader(body)
-	log.Printf("do method=%s url=%s \n", method, u.String())
-	req, err := http.NewRequest(method, u.String(), buf)
+	defer func() {
+		if rerr := resp.Body.Close(); rerr != nil {
+			err = multierr.Append(err, rerr)
+		}
+	}()
+	reader, err := decodedBody(resp)
 	if err != nil {
-		return nil, errors.Wrapf(err, "http.NewRequest method=%s url=%s", method, u.String())
+		return nil, errors.Wrap(err, "decodedBody")
 	}
-	// headers
-	for k, v := range headers {
-		req.Header.Add(k, v)
+	// read one extra byte over the limit to detect whether body got truncated
+	var limited io.Reader = reader
+	if c.maxBodySize > 0 {
+		limited = io.LimitReader(reader, c.maxBodySize+1)
 	}
-	if c.userAgent != "" {
-		req.Header.Add("User-Agent", c.userAgent)
+	raw, err := ioutil.ReadAll(limited)
+	if err != nil {
+		return nil, errors.Wrap(err, "ioutil.ReadAll resp.Body")
 	}
-	if c.token != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
+	if err = reader.Close(); err != nil {
+		return nil, errors.Wrap(err, "reader.Close")
 	}
+	truncated := c.maxBodySize > 0 && int64(len(raw)) > c.maxBodySize
+	if truncated {
+		c.logf("truncate response body url=%s max_body_size=%d \n", urlStr, c.maxBodySize)
+		raw = raw[:c.maxBodySize]
+	}
+	return &schema.Response{
+		Code:       resp.StatusCode,
+		Body:       string(raw),
+		Truncated:  truncated,
+		RetryAfter: resp.Header.Get("Retry-After"),
+		Timing:     trace.timing(time.Now()),
+	}, nil
+}
 
-	req = req.WithContext(ctx)
-	resp, err := c.Do(req)
+// StreamRequest performs http request call and pipes the response body straight into a S3
+// object under given key instead of buffering it in memory
+func (c *HTTPClient) StreamRequest(ctx context.Context, method, urlStr string, headers map[string]string, body, key string) (*schema.Response, error) {
+	if c.uploader == nil || c.resultBucket == "" {
+		return nil, errors.New("result streaming requires uploader and RESULT_BUCKET to be configured")
+	}
+	resp, trace, err := c.send(ctx, method, urlStr, headers, body)
 	if err != nil {
-		return nil, errors.Wrap(err, "c.Do")
+		return nil, errors.Wrapf(err, "c.send method=%s url=%s", method, urlStr)
 	}
 	defer func() {
 		if rerr := resp.Body.Close(); rerr != nil {
 			err = multierr.Append(err, rerr)
 		}
 	}()
-	raw, err := ioutil.ReadAll(resp.Body)
+	reader, err := decodedBody(resp)
 	if err != nil {
-		return nil, errors.Wrap(err, "ioutil.ReadAll resp.Body")
+		return nil, errors.Wrap(err, "decodedBody")
+	}
+	key = c.resultPrefix + key
+	c.logf("stream response body url=%s bucket=%s key=%s \n", urlStr, c.resultBucket, key)
+	_, err = c.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(c.resultBucket),
+		Key:         aws.String(key),
+		Body:        reader,
+		ContentType: aws.String(resp.Header.Get("Content-Type")),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "c.uploader.UploadWithContext bucket=%s key=%s", c.resultBucket, key)
+	}
+	if err = reader.Close(); err != nil {
+		return nil, errors.Wrap(err, "reader.Close")
 	}
 	return &schema.Response{
-		Code: resp.StatusCode,
-		Body: string(raw),
+		Code:       resp.StatusCode,
+		ObjectKey:  key,
+		RetryAfter: resp.Header.Get("Retry-After"),
+		Timing:     trace.timing(time.Now()),
 	}, nil
 }
 
+// send builds the http request with common headers and executes it, retrying within the
+// configured budget while response status matches the retry policy. Caller is responsible
+// for closing response body. Returned trace times the last attempt.
+func (c *HTTPClient) send(ctx context.Context, method, urlStr string, headers map[string]string, body string) (*http.Response, *timingTrace, error) {
+	rel, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "url.Parse rawurl=%s", urlStr)
+	}
+	// method & url
+	u := c.baseURL.ResolveReference(rel)
+	if err = c.breaker.Allow(u.Host); err != nil {
+		return nil, nil, err
+	}
+	raw := []byte(body)
+	compress := c.gzipMinSize > 0 && int64(len(body)) >= c.gzipMinSize && !hasHeader(headers, "Content-Encoding")
+	if compress {
+		compressed, gerr := gzipBody(body)
+		if gerr != nil {
+			return nil, nil, errors.Wrap(gerr, "gzipBody")
+		}
+		raw = compressed.Bytes()
+	}
+	var (
+		req    *http.Request
+		resp   *http.Response
+		trace  *timingTrace
+		token  = c.token
+		tokens = c.tokens
+		// token of source is refetched once per call when rejected
+		reauthorized bool
+		// a short Retry-After is waited out once per call beyond the retry policy
+		throttledOnce bool
+	)
+	if hostToken, ok := c.hostToken(u); ok {
+		token, tokens = hostToken, nil
+	}
+	// only the last attempt is recorded to breaker, so that an execution retrying a failing host
+	// counts as a single failure. Negative outcome is a transport error, zero means no attempt.
+	var outcome int
+	defer func() {
+		switch {
+		case outcome == 0:
+		case outcome < 0 || outcome >= http.StatusInternalServerError:
+			c.breaker.Failure(u.Host)
+		default:
+			c.breaker.Success(u.Host)
+		}
+	}()
+	for attempt := 1; ; attempt++ {
+		if tokens != nil {
+			if token, err = tokens.Token(ctx); err != nil {
+				return nil, nil, errors.Wrap(err, "c.tokens.Token")
+			}
+		}
+		c.logf("do method=%s url=%s gzip=%t attempt=%d \n", method, u.String(), compress, attempt)
+		req, err = http.NewRequest(method, u.String(), bytes.NewReader(raw))
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "http.NewRequest method=%s url=%s", method, u.String())
+		}
+		// headers
+		for k, v := range c.defaultHeaders {
+			if !hasHeader(headers, k) {
+				req.Header.Set(k, v)
+			}
+		}
+		for k, v := range headers {
+			req.Header.Add(k, v)
+		}
+		if compress {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		if c.userAgent != "" {
+			req.Header.Add("User-Agent", c.userAgent)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		}
+
+		trace = newTimingTrace()
+		req = req.WithContext(httptrace.WithClientTrace(ctx, trace.clientTrace()))
+		resp, err = c.Do(req)
+		if err != nil {
+			outcome = -1
+			return nil, nil, errors.Wrap(err, "c.Do")
+		}
+		outcome = resp.StatusCode
+		if resp.StatusCode == http.StatusUnauthorized && tokens != nil && !reauthorized {
+			// token may have been rotated since it was cached, which is not counted as retry
+			reauthorized = true
+			tokens.Invalidate()
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			if err = resp.Body.Close(); err != nil {
+				return nil, nil, errors.Wrap(err, "resp.Body.Close")
+			}
+			c.logf("refresh token method=%s url=%s \n", method, u.String())
+			attempt--
+			continue
+		}
+		after, throttled := parseRetryAfter(resp.StatusCode, resp.Header.Get("Retry-After"), time.Now())
+		if throttled && after > c.retryAfterMaxWait {
+			// too long to wait within this run, leave it to the caller to reschedule
+			return resp, trace, nil
+		}
+		retry := attempt <= c.maxRetries && c.shouldRetry(resp.StatusCode)
+		if !retry && (!throttled || throttledOnce) {
+			return resp, trace, nil
+		}
+		wait := c.retryBackoff << uint(attempt-1)
+		if throttled {
+			wait = after
+		}
+		if !retry {
+			// target asked for a short wait though its status is not retried, which is not counted as retry
+			throttledOnce = true
+			attempt--
+		}
+		// drain body so that connection could be reused by next attempt
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		if err = resp.Body.Close(); err != nil {
+			return nil, nil, errors.Wrap(err, "resp.Body.Close")
+		}
+		c.logf("retry method=%s url=%s code=%d wait=%s \n", method, u.String(), resp.StatusCode, wait)
+		select {
+		case <-ctx.Done():
+			return nil, nil, errors.Wrap(ctx.Err(), "wait for retry")
+		case <-time.After(wait):
+		}
+	}
+}
+
+// parseRetryAfter returns the wait asked by Retry-After header value, given either in seconds
+// or as HTTP date. Only throttling and temporary unavailability statuses are honored.
+func parseRetryAfter(code int, value string, now time.Time) (time.Duration, bool) {
+	if value == "" || (code != http.StatusTooManyRequests && code != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := at.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// retryAfterError signals that target asked to be called again later
+type retryAfterError struct {
+	code int
+	at   time.Time
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("target answered code=%d retry_at=%s", e.code, e.at.Format(time.RFC3339))
+}
+
+// unexpectedStatusError signals that target answered a status code not matching expect_status
+type unexpectedStatusError struct {
+	code   int
+	expect string
+}
+
+func (e *unexpectedStatusError) Error() string {
+	return fmt.Sprintf("unexpected response status code=%d expect_status=%s", e.code, e.expect)
+}
+
+// hostToken returns the token configured for host of u, matched with port first
+func (c *HTTPClient) hostToken(u *url.URL) (string, bool) {
+	if token, ok := c.hostTokens[u.Host]; ok {
+		return token, true
+	}
+	token, ok := c.hostTokens[u.Hostname()]
+	return token, ok
+}
+
+func (c *HTTPClient) shouldRetry(code int) bool {
+	if c.retryOnStatus == "" {
+		return false
+	}
+	// spec is already validated by NewClient
+	matched, _ := schema.MatchStatus(c.retryOnStatus, code)
+	return matched
+}
+
+// decodedBody returns reader of the decompressed response body. Transport only decompresses
+// transparently when it asked for gzip itself, not when Accept-Encoding was given by request headers.
+// Closing the reader leaves response body to be closed by caller.
+func decodedBody(resp *http.Response) (io.ReadCloser, error) {
+	if resp.Uncompressed || !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return ioutil.NopCloser(resp.Body), nil
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "gzip.NewReader resp.Body")
+	}
+	return gz, nil
+}
+
+func hasHeader(headers map[string]string, name string) bool {
+	for k := range headers {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func gzipBody(body string) (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	if _, err := gz.Write([]byte(body)); err != nil {
+		return nil, errors.Wrap(err, "gz.Write")
+	}
+	if err := gz.Close(); err != nil {
+		return nil, errors.Wrap(err, "gz.Close")
+	}
+	return buf, nil
+}
+
 func execRequest(ctx context.Context, client Requester, req *schema.ScheduledRequest) (*schema.Response, error) {
+	return execRequestVars(ctx, client, req, nil)
+}
+
+// execRequestVars executes request rendered with variables captured by previous steps
+func execRequestVars(ctx context.Context, client Requester, req *schema.ScheduledRequest, vars map[string]string) (*schema.Response, error) {
 	log.Printf("execute request %s \n", req.ToString())
-	resp, err := client.DoRequest(ctx, req.Method, req.URL, req.Headers, req.Payload)
+	urlStr, headers, payload, err := renderRequest(req, time.Now().UTC(), vars)
+	if err != nil {
+		return nil, errors.Wrapf(err, "renderRequest id=%s", req.ID)
+	}
+	var resp *schema.Response
+	if req.StreamResultToS3 {
+		streamer, ok := client.(ResultStreamer)
+		if !ok {
+			return nil, errors.New("client does not support streaming result to S3")
+		}
+		key := fmt.Sprintf("%s/%d", req.ID, time.Now().UnixNano())
+		resp, err = streamer.StreamRequest(ctx, req.Method, urlStr, headers, payload, key)
+	} else {
+		resp, err = client.DoRequest(ctx, req.Method, urlStr, headers, payload)
+	}
 	if err != nil {
 		return nil, errors.Wrapf(err, "client.DoRequest method=%s url=%s", req.Method, req.URL)
 	}
 	log.Printf("receive reponse %s \n", resp.ToString())
+	now := time.Now().UTC()
+	if after, ok := parseRetryAfter(resp.Code, resp.RetryAfter, now); ok {
+		return nil, &retryAfterError{code: resp.Code, at: now.Add(after)}
+	}
+	matched, err := schema.MatchStatus(req.ExpectStatus, resp.Code)
+	if err != nil {
+		return nil, errors.Wrapf(err, "schema.MatchStatus expect_status=%s", req.ExpectStatus)
+	}
+	if !matched {
+		return nil, &unexpectedStatusError{code: resp.Code, expect: req.ExpectStatus}
+	}
+	if req.StreamResultToS3 {
+		// streamed body is not available for assertions
+		return resp, nil
+	}
+	if err = checkAssertions(req.Assertions, resp.Body); err != nil {
+		return nil, errors.Wrapf(err, "checkAssertions id=%s", req.ID)
+	}
 	return resp, nil
 }
+
+// renderRequest substitutes template placeholders of request url, headers and payload
+func renderRequest(req *schema.ScheduledRequest, now time.Time, vars map[string]string) (string, map[string]string, string, error) {
+	urlStr, err := renderTemplateVars(req.URL, now, vars)
+	if err != nil {
+		return "", nil, "", errors.Wrap(err, "renderTemplate url")
+	}
+	var headers map[string]string
+	if req.Headers != nil {
+		headers = make(map[string]string, len(req.Headers))
+		for k, v := range req.Headers {
+			if headers[k], err = renderTemplateVars(v, now, vars); err != nil {
+				return "", nil, "", errors.Wrapf(err, "renderTemplate header=%s", k)
+			}
+		}
+	}
+	payload, err := renderPayload(req, now, vars)
+	if err != nil {
+		return "", nil, "", errors.Wrap(err, "renderPayload")
+	}
+	if req.PayloadEncoding == schema.PayloadJSON {
+		if !json.Valid([]byte(payload)) {
+			return "", nil, "", errors.Errorf("rendered payload is not valid json id=%s", req.ID)
+		}
+		if !hasHeader(headers, "Content-Type") {
+			if headers == nil {
+				headers = map[string]string{}
+			}
+			headers["Content-Type"] = jsonMIME
+		}
+	}
+	return urlStr, headers, payload, nil
+}
+
+// renderPayload decodes binary payload or substitutes template placeholders of text one
+func renderPayload(req *schema.ScheduledRequest, now time.Time, vars map[string]string) (string, error) {
+	if req.PayloadEncoding == schema.PayloadBase64 {
+		raw, err := base64.StdEncoding.DecodeString(req.Payload)
+		if err != nil {
+			return "", errors.Wrap(err, "base64.StdEncoding.DecodeString payload")
+		}
+		return string(raw), nil
+	}
+	payload, err := renderTemplateVars(req.Payload, now, vars)
+	if err != nil {
+		return "", errors.Wrap(err, "renderTemplate payload")
+	}
+	return payload, nil
+}
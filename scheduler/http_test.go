@@ -1,14 +1,23 @@
 package scheduler
 
 import (
+	"compress/gzip"
 	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -18,9 +27,13 @@ import (
 
 func TestNewClient(t *testing.T) {
 	conf := &config.Configuration{
-		BaseURL:   "test-baseurl",
-		UserAgent: "test-useragent",
-		Token:     "test-token",
+		BaseURL:             "test-baseurl",
+		UserAgent:           "test-useragent",
+		Token:               "test-token",
+		MaxIdleConns:        50,
+		MaxIdleConnsPerHost: 20,
+		IdleConnTimeout:     time.Minute,
+		TLSHandshakeTimeout: 5 * time.Second,
 	}
 	for _, c := range []struct {
 		caseName string
@@ -48,6 +61,12 @@ func TestNewClient(t *testing.T) {
 				require.NoError(t, err)
 				assert.NotNil(t, client)
 				assert.NotNil(t, client.baseURL)
+				transport, ok := client.Transport.(*http.Transport)
+				require.True(t, ok)
+				assert.Equal(t, 50, transport.MaxIdleConns)
+				assert.Equal(t, 20, transport.MaxIdleConnsPerHost)
+				assert.Equal(t, time.Minute, transport.IdleConnTimeout)
+				assert.Equal(t, 5*time.Second, transport.TLSHandshakeTimeout)
 			}
 		})
 	}
@@ -198,11 +217,82 @@ func TestExecRequest(t *testing.T) {
 				Body: "{\"id\":\"test-post-payload-id\"}",
 			},
 		},
+		{
+			caseName:    "method_post_with_base64_payload",
+			description: "should pass with decoded binary payload",
+			setup: func() {
+				req.Method = http.MethodPost
+				req.Headers = map[string]string{
+					"Content-Type": "application/x-protobuf",
+				}
+				req.URL = "test-post-with-base64-payload"
+				req.Payload = "CAESBHRlc3T/AA=="
+				req.PayloadEncoding = schema.PayloadBase64
+				mockSrv.mux.HandleFunc("/test-post-with-base64-payload", func(w http.ResponseWriter, r *http.Request) {
+					want := []byte{0x08, 0x01, 0x12, 0x04, 't', 'e', 's', 't', 0xff, 0x00}
+					assert.Equal(t, int64(len(want)), r.ContentLength)
+					raw, rerr := ioutil.ReadAll(r.Body)
+					require.NoError(t, rerr)
+					assert.Equal(t, want, raw)
+					w.WriteHeader(http.StatusCreated)
+				})
+			},
+			want: schema.Response{
+				Code: http.StatusCreated,
+			},
+		},
+		{
+			caseName:    "method_post_with_invalid_base64_payload",
+			description: "should raise error",
+			setup: func() {
+				req.Payload = "not-base64-%"
+				mockSrv.mux.HandleFunc("/test-post-with-invalid-base64-payload", func(w http.ResponseWriter, r *http.Request) {
+					assert.Fail(t, "should never reach server")
+				})
+				req.URL = "test-post-with-invalid-base64-payload"
+			},
+			err: true,
+		},
+		{
+			caseName:    "method_post_with_json_payload",
+			description: "should pass with rendered document sent as json",
+			setup: func() {
+				req.Headers = nil
+				req.URL = "test-post-with-json-payload"
+				req.Payload = `{"unix":{{ now | unix }},"tags":["a","b"]}`
+				req.PayloadEncoding = schema.PayloadJSON
+				mockSrv.mux.HandleFunc("/test-post-with-json-payload", func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+					body := map[string]interface{}{}
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+					assert.Contains(t, body, "unix")
+					assert.Equal(t, []interface{}{"a", "b"}, body["tags"])
+					w.WriteHeader(http.StatusCreated)
+				})
+			},
+			want: schema.Response{
+				Code: http.StatusCreated,
+			},
+		},
+		{
+			caseName:    "method_post_with_invalid_rendered_json_payload",
+			description: "should raise error",
+			setup: func() {
+				req.URL = "test-post-with-invalid-json-payload"
+				req.Payload = `{"at":{{ now }}}`
+				mockSrv.mux.HandleFunc("/test-post-with-invalid-json-payload", func(w http.ResponseWriter, r *http.Request) {
+					assert.Fail(t, "should never reach server")
+				})
+			},
+			err: true,
+		},
 		{
 			caseName:    "method_put_ok",
 			description: "should pass",
 			setup: func() {
 				req.Method = http.MethodPut
+				req.Payload = ""
+				req.PayloadEncoding = ""
 				req.URL = "test-put-ok"
 				mockSrv.mux.HandleFunc("/test-put-ok", func(w http.ResponseWriter, r *http.Request) {
 					w.WriteHeader(http.StatusOK)
@@ -261,6 +351,44 @@ func TestExecRequest(t *testing.T) {
 				Body: "404 page not found\n",
 			},
 		},
+		{
+			caseName:    "expect_status_class_matched",
+			description: "should pass with status code within expected class",
+			setup: func() {
+				req.Method = http.MethodGet
+				req.URL = "test-expect-status-matched"
+				req.ExpectStatus = "2xx,404"
+				mockSrv.mux.HandleFunc("/test-expect-status-matched", func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusAccepted)
+				})
+			},
+			want: schema.Response{
+				Code: http.StatusAccepted,
+			},
+		},
+		{
+			caseName:    "expect_status_mismatched",
+			description: "should raise error",
+			setup: func() {
+				req.Method = http.MethodGet
+				req.URL = "test-expect-status-mismatched"
+				req.ExpectStatus = "200,204"
+				mockSrv.mux.HandleFunc("/test-expect-status-mismatched", func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusInternalServerError)
+				})
+			},
+			err: true,
+		},
+		{
+			caseName:    "expect_status_invalid",
+			description: "should raise error",
+			setup: func() {
+				req.Method = http.MethodGet
+				req.URL = "test-expect-status-matched"
+				req.ExpectStatus = "2xy"
+			},
+			err: true,
+		},
 	} {
 		t.Run(fmt.Sprintf("case=%s/description=%s", c.caseName, c.description), func(t *testing.T) {
 			// safeguard against this case `method_get_with_absolute_base_url` consequence
@@ -271,13 +399,23 @@ func TestExecRequest(t *testing.T) {
 				assert.Error(t, err)
 			} else {
 				require.NoError(t, err)
-				assert.NotNil(t, resp)
+				require.NotNil(t, resp)
+				assertTiming(t, resp)
 				assert.Equal(t, c.want, *resp)
 			}
 		})
 	}
 }
 
+// assertTiming checks the attempt got timed then drops timing so that response could be
+// compared as a whole
+func assertTiming(t *testing.T, resp *schema.Response) {
+	require.NotNil(t, resp.Timing)
+	assert.True(t, resp.Timing.TTFB > 0)
+	assert.True(t, resp.Timing.Total >= resp.Timing.TTFB)
+	resp.Timing = nil
+}
+
 func TestMustNewClient(t *testing.T) {
 	cli := Must(&HTTPClient{}, nil)
 	assert.NotNil(t, cli)
@@ -285,3 +423,584 @@ func TestMustNewClient(t *testing.T) {
 		Must(nil, errors.New("Can't create new client"))
 	})
 }
+
+func TestDoRequestMaxBodySize(t *testing.T) {
+	mockSrv, client := setupMockSrv(t)
+	defer mockSrv.teardown(t)
+	mockSrv.mux.HandleFunc("/test-large-body", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, werr := w.Write([]byte("0123456789"))
+		require.NoError(t, werr)
+	})
+	for _, c := range []struct {
+		caseName    string
+		maxBodySize int64
+		want        schema.Response
+	}{
+		{
+			caseName: "unlimited",
+			want: schema.Response{
+				Code: http.StatusOK,
+				Body: "0123456789",
+			},
+		},
+		{
+			caseName:    "below_limit",
+			maxBodySize: 10,
+			want: schema.Response{
+				Code: http.StatusOK,
+				Body: "0123456789",
+			},
+		},
+		{
+			caseName:    "over_limit",
+			maxBodySize: 4,
+			want: schema.Response{
+				Code:      http.StatusOK,
+				Body:      "0123",
+				Truncated: true,
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			client.maxBodySize = c.maxBodySize
+			resp, err := client.DoRequest(context.Background(), http.MethodGet, "test-large-body", nil, "")
+			require.NoError(t, err)
+			assertTiming(t, resp)
+			assert.Equal(t, c.want, *resp)
+		})
+	}
+}
+
+func TestDoRequestGzip(t *testing.T) {
+	mockSrv, client := setupMockSrv(t)
+	defer mockSrv.teardown(t)
+	payload := "{\"data\":\"test-gzip-payload-data\"}"
+	mockSrv.mux.HandleFunc("/test-gzip", func(w http.ResponseWriter, r *http.Request) {
+		var reader io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, gerr := gzip.NewReader(r.Body)
+			require.NoError(t, gerr)
+			reader = gz
+		}
+		raw, rerr := ioutil.ReadAll(reader)
+		require.NoError(t, rerr)
+		assert.Equal(t, payload, string(raw))
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			_, werr := w.Write(raw)
+			require.NoError(t, werr)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		_, werr := gz.Write(raw)
+		require.NoError(t, werr)
+		require.NoError(t, gz.Close())
+	})
+	for _, c := range []struct {
+		caseName    string
+		gzipMinSize int64
+		headers     map[string]string
+	}{
+		{
+			caseName: "plain",
+		},
+		{
+			caseName:    "compress_payload",
+			gzipMinSize: 8,
+		},
+		{
+			caseName:    "payload_below_threshold",
+			gzipMinSize: 1024,
+		},
+		{
+			caseName: "decompress_response",
+			headers: map[string]string{
+				"Accept-Encoding": "gzip",
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			client.gzipMinSize = c.gzipMinSize
+			resp, err := client.DoRequest(context.Background(), http.MethodPost, "test-gzip", c.headers, payload)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, payload, resp.Body)
+		})
+	}
+}
+
+func TestDoRequestHTTP2Mode(t *testing.T) {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, werr := w.Write([]byte(r.Proto))
+		require.NoError(t, werr)
+	}))
+	srv.Config.Protocols = protocols
+	srv.Start()
+	defer srv.Close()
+	for _, c := range []struct {
+		caseName string
+		mode     string
+		want     string
+	}{
+		{
+			caseName: "auto",
+			mode:     config.HTTP2Auto,
+			want:     "HTTP/1.1",
+		},
+		{
+			caseName: "off",
+			mode:     config.HTTP2Off,
+			want:     "HTTP/1.1",
+		},
+		{
+			caseName: "h2c_prior_knowledge",
+			mode:     config.HTTP2PriorKnowledge,
+			want:     "HTTP/2.0",
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			client, err := NewClient(&config.Configuration{
+				BaseURL:   srv.URL,
+				HTTP2Mode: c.mode,
+			})
+			require.NoError(t, err)
+			resp, err := client.DoRequest(context.Background(), http.MethodGet, "test-protocol", nil, "")
+			require.NoError(t, err)
+			assert.Equal(t, c.want, resp.Body)
+		})
+	}
+}
+
+type mockUploader struct {
+	s3manageriface.UploaderAPI
+	lastInput *s3manager.UploadInput
+	body      string
+	err       error
+}
+
+func (mu *mockUploader) UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	mu.lastInput = input
+	if mu.err != nil {
+		return nil, mu.err
+	}
+	raw, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	mu.body = string(raw)
+	return &s3manager.UploadOutput{
+		Location: fmt.Sprintf("https://%s.s3.amazonaws.com/%s", *input.Bucket, *input.Key),
+	}, nil
+}
+
+func TestStreamRequestGzip(t *testing.T) {
+	mockSrv, client := setupMockSrv(t)
+	defer mockSrv.teardown(t)
+	mockSrv.mux.HandleFunc("/test-report-gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		_, werr := gz.Write([]byte("id,total\n1,100\n"))
+		require.NoError(t, werr)
+		require.NoError(t, gz.Close())
+	})
+	uploader := new(mockUploader)
+	client.SetUploader(uploader)
+	client.resultBucket = "test-bucket"
+	client.resultPrefix = "results/"
+	headers := map[string]string{"Accept-Encoding": "gzip"}
+	resp, err := client.StreamRequest(context.Background(), http.MethodGet, "test-report-gzip", headers, "", "test-report/1")
+	require.NoError(t, err)
+	assert.Equal(t, "id,total\n1,100\n", uploader.body, "should upload decompressed body")
+	assert.Equal(t, "results/test-report/1", resp.ObjectKey)
+}
+
+func TestExecRequestStreamResultToS3(t *testing.T) {
+	mockSrv, client := setupMockSrv(t)
+	defer mockSrv.teardown(t)
+	mockSrv.mux.HandleFunc("/test-report", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		_, werr := w.Write([]byte("id,total\n1,100\n"))
+		require.NoError(t, werr)
+	})
+	req := &schema.ScheduledRequest{
+		ID:               "test-stream-report",
+		Method:           http.MethodGet,
+		URL:              "test-report",
+		StreamResultToS3: true,
+		Assertions: []schema.Assertion{
+			{Path: "$.ignored", Expected: "for-streamed-body"},
+		},
+	}
+	for _, c := range []struct {
+		caseName string
+		setup    func() *mockUploader
+		err      bool
+	}{
+		{
+			caseName: "uploader_not_configured",
+			setup: func() *mockUploader {
+				client.uploader = nil
+				return nil
+			},
+			err: true,
+		},
+		{
+			caseName: "upload_error",
+			setup: func() *mockUploader {
+				uploader := &mockUploader{err: errors.New("internal error")}
+				client.SetUploader(uploader)
+				return uploader
+			},
+			err: true,
+		},
+		{
+			caseName: "ok",
+			setup: func() *mockUploader {
+				uploader := new(mockUploader)
+				client.SetUploader(uploader)
+				return uploader
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			client.resultBucket = "test-bucket"
+			client.resultPrefix = "results/"
+			uploader := c.setup()
+			resp, err := execRequest(context.Background(), client, req)
+			if c.err == true {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, http.StatusOK, resp.Code)
+				assert.Empty(t, resp.Body)
+				assert.Equal(t, "id,total\n1,100\n", uploader.body)
+				assert.Equal(t, "text/csv", *uploader.lastInput.ContentType)
+				assert.Regexp(t, "^results/test-stream-report/[0-9]+$", *uploader.lastInput.Key)
+				assert.Equal(t, *uploader.lastInput.Key, resp.ObjectKey)
+			}
+		})
+	}
+}
+
+func TestDoRequestRetryOnStatus(t *testing.T) {
+	mockSrv, client := setupMockSrv(t)
+	defer mockSrv.teardown(t)
+	var calls int
+	client.retryOnStatus = "429,5xx"
+	client.retryBackoff = time.Millisecond
+	for _, c := range []struct {
+		caseName   string
+		maxRetries int
+		statuses   []int
+		wantCode   int
+		wantCalls  int
+	}{
+		{
+			caseName:  "retry_disabled",
+			statuses:  []int{http.StatusTooManyRequests, http.StatusOK},
+			wantCode:  http.StatusTooManyRequests,
+			wantCalls: 1,
+		},
+		{
+			caseName:   "recovered_after_retries",
+			maxRetries: 3,
+			statuses:   []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusOK},
+			wantCode:   http.StatusOK,
+			wantCalls:  3,
+		},
+		{
+			caseName:   "retries_exhausted",
+			maxRetries: 2,
+			statuses:   []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusOK},
+			wantCode:   http.StatusBadGateway,
+			wantCalls:  3,
+		},
+		{
+			caseName:   "status_not_retried",
+			maxRetries: 2,
+			statuses:   []int{http.StatusBadRequest, http.StatusOK},
+			wantCode:   http.StatusBadRequest,
+			wantCalls:  1,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			calls = 0
+			client.maxRetries = c.maxRetries
+			path := fmt.Sprintf("/test-retry-%s", c.caseName)
+			statuses := c.statuses
+			mockSrv.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+				raw, rerr := ioutil.ReadAll(r.Body)
+				require.NoError(t, rerr)
+				assert.Equal(t, "test-retry-payload", string(raw))
+				w.WriteHeader(statuses[calls])
+				calls++
+			})
+			resp, err := client.DoRequest(context.Background(), http.MethodPost, path, nil, "test-retry-payload")
+			require.NoError(t, err)
+			assert.Equal(t, c.wantCode, resp.Code)
+			assert.Equal(t, c.wantCalls, calls)
+		})
+	}
+}
+
+func TestDoRequestRetryAfter(t *testing.T) {
+	mockSrv, client := setupMockSrv(t)
+	defer mockSrv.teardown(t)
+	var calls int
+	// throttling status is not retried by policy
+	client.retryOnStatus = "5xx"
+	client.maxRetries = 2
+	client.retryBackoff = time.Millisecond
+	client.retryAfterMaxWait = time.Second
+	for _, c := range []struct {
+		caseName   string
+		retryAfter []string
+		statuses   []int
+		wantCode   int
+		wantCalls  int
+	}{
+		{
+			caseName:   "short_wait",
+			retryAfter: []string{"0", ""},
+			statuses:   []int{http.StatusTooManyRequests, http.StatusOK},
+			wantCode:   http.StatusOK,
+			wantCalls:  2,
+		},
+		{
+			caseName:   "short_wait_once",
+			retryAfter: []string{"0", "0", ""},
+			statuses:   []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusOK},
+			wantCode:   http.StatusTooManyRequests,
+			wantCalls:  2,
+		},
+		{
+			caseName:   "long_wait",
+			retryAfter: []string{"60", ""},
+			statuses:   []int{http.StatusTooManyRequests, http.StatusOK},
+			wantCode:   http.StatusTooManyRequests,
+			wantCalls:  1,
+		},
+		{
+			caseName:   "without_header",
+			retryAfter: []string{"", ""},
+			statuses:   []int{http.StatusTooManyRequests, http.StatusOK},
+			wantCode:   http.StatusTooManyRequests,
+			wantCalls:  1,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			calls = 0
+			path := fmt.Sprintf("/test-retry-after-%s", c.caseName)
+			retryAfter, statuses := c.retryAfter, c.statuses
+			mockSrv.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+				if retryAfter[calls] != "" {
+					w.Header().Set("Retry-After", retryAfter[calls])
+				}
+				w.WriteHeader(statuses[calls])
+				calls++
+			})
+			resp, err := client.DoRequest(context.Background(), http.MethodGet, path, nil, "")
+			require.NoError(t, err)
+			assert.Equal(t, c.wantCode, resp.Code)
+			assert.Equal(t, c.wantCalls, calls)
+		})
+	}
+}
+
+func TestDoRequestCircuitBreaker(t *testing.T) {
+	mockSrv, client := setupMockSrv(t)
+	defer mockSrv.teardown(t)
+	client.breaker = NewCircuitBreaker(2, time.Hour)
+	var calls int
+	mockSrv.mux.HandleFunc("/test-circuit-breaker", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	for i := 0; i < 2; i++ {
+		resp, err := client.DoRequest(context.Background(), http.MethodGet, "test-circuit-breaker", nil, "")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadGateway, resp.Code)
+	}
+	_, err := client.DoRequest(context.Background(), http.MethodGet, "test-circuit-breaker", nil, "")
+	require.Error(t, err)
+	_, ok := errors.Cause(err).(*circuitOpenError)
+	assert.True(t, ok)
+	assert.Equal(t, 2, calls, "should skip calling host with open circuit")
+}
+
+func TestDoRequestCircuitBreakerRetries(t *testing.T) {
+	mockSrv, client := setupMockSrv(t)
+	defer mockSrv.teardown(t)
+	client.breaker = NewCircuitBreaker(2, time.Hour)
+	client.retryOnStatus = "5xx"
+	client.retryBackoff = time.Millisecond
+	client.maxRetries = 2
+	var calls int
+	statuses := []int{
+		// recovered after retries
+		http.StatusBadGateway, http.StatusBadGateway, http.StatusOK,
+		// retries exhausted
+		http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway,
+		http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway,
+	}
+	mockSrv.mux.HandleFunc("/test-circuit-breaker-retries", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statuses[calls])
+		calls++
+	})
+	for _, c := range []struct {
+		caseName  string
+		wantCode  int
+		wantCalls int
+	}{
+		{caseName: "recovered", wantCode: http.StatusOK, wantCalls: 3},
+		{caseName: "first_failure", wantCode: http.StatusBadGateway, wantCalls: 6},
+		{caseName: "second_failure", wantCode: http.StatusBadGateway, wantCalls: 9},
+	} {
+		resp, err := client.DoRequest(context.Background(), http.MethodGet, "test-circuit-breaker-retries", nil, "")
+		require.NoError(t, err, c.caseName)
+		assert.Equal(t, c.wantCode, resp.Code, c.caseName)
+		assert.Equal(t, c.wantCalls, calls, c.caseName)
+	}
+	// each execution counts once however many attempts it made
+	_, err := client.DoRequest(context.Background(), http.MethodGet, "test-circuit-breaker-retries", nil, "")
+	require.Error(t, err)
+	_, ok := errors.Cause(err).(*circuitOpenError)
+	assert.True(t, ok)
+	assert.Equal(t, 9, calls, "should skip calling host with open circuit")
+}
+
+func TestDoRequestHostOverrides(t *testing.T) {
+	mockSrv, _ := setupMockSrv(t)
+	defer mockSrv.teardown(t)
+	mockSrv.mux.HandleFunc("/test-host-override", func(w http.ResponseWriter, r *http.Request) {
+		_, werr := w.Write([]byte(r.Host))
+		require.NoError(t, werr)
+	})
+	srvURL, err := url.Parse(mockSrv.srv.URL)
+	require.NoError(t, err)
+	for _, c := range []struct {
+		caseName  string
+		baseURL   string
+		overrides map[string]string
+		want      string
+	}{
+		{
+			caseName:  "override_host_keep_port",
+			baseURL:   fmt.Sprintf("http://api.internal:%s", srvURL.Port()),
+			overrides: map[string]string{"api.internal": srvURL.Hostname()},
+			want:      fmt.Sprintf("api.internal:%s", srvURL.Port()),
+		},
+		{
+			caseName:  "override_host_and_port",
+			baseURL:   "http://api.internal",
+			overrides: map[string]string{"api.internal:80": srvURL.Host},
+			want:      "api.internal",
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			client, err := NewClient(&config.Configuration{
+				BaseURL:       c.baseURL,
+				HostOverrides: c.overrides,
+			})
+			require.NoError(t, err)
+			resp, err := client.DoRequest(context.Background(), http.MethodGet, "test-host-override", nil, "")
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, c.want, resp.Body)
+		})
+	}
+}
+
+func TestSetResolver(t *testing.T) {
+	client, err := NewClient(&config.Configuration{DNSServer: "10.0.0.2:53"})
+	require.NoError(t, err)
+	assert.NotNil(t, client.dialer.Resolver)
+	resolver := &net.Resolver{PreferGo: true}
+	client.SetResolver(resolver)
+	assert.Equal(t, resolver, client.dialer.Resolver)
+}
+
+func TestDoRequestHostTokens(t *testing.T) {
+	mockSrv, client := setupMockSrv(t)
+	defer mockSrv.teardown(t)
+	client.token = "default-token"
+	var auth string
+	mockSrv.mux.HandleFunc("/test-host-tokens", func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, c := range []struct {
+		caseName   string
+		hostTokens map[string]string
+		tokens     TokenSource
+		want       string
+	}{
+		{
+			caseName: "default",
+			want:     "Bearer default-token",
+		},
+		{
+			caseName:   "host_with_port",
+			hostTokens: map[string]string{client.baseURL.Host: "port-token", "127.0.0.1": "host-token"},
+			want:       "Bearer port-token",
+		},
+		{
+			caseName:   "host",
+			hostTokens: map[string]string{"127.0.0.1": "host-token"},
+			tokens:     NewSecretToken(&mockSecretsManager{values: []string{"secret-token"}}, "citium", time.Hour),
+			want:       "Bearer host-token",
+		},
+		{
+			caseName:   "other_host",
+			hostTokens: map[string]string{"api.foo.com": "foo-token"},
+			tokens:     NewSecretToken(&mockSecretsManager{values: []string{"secret-token"}}, "citium", time.Hour),
+			want:       "Bearer secret-token",
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			client.hostTokens = c.hostTokens
+			client.SetTokenSource(c.tokens)
+			_, err := client.DoRequest(context.Background(), http.MethodGet, "/test-host-tokens", nil, "")
+			require.NoError(t, err)
+			assert.Equal(t, c.want, auth)
+		})
+	}
+}
+
+func TestDoRequestDefaultHeaders(t *testing.T) {
+	mockSrv, client := setupMockSrv(t)
+	defer mockSrv.teardown(t)
+	client.defaultHeaders = map[string]string{"X-Tenant": "acme", "X-Api-Version": "2"}
+	var received http.Header
+	mockSrv.mux.HandleFunc("/test-default-headers", func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, c := range []struct {
+		caseName string
+		headers  map[string]string
+		want     map[string][]string
+	}{
+		{
+			caseName: "defaults",
+			want:     map[string][]string{"X-Tenant": {"acme"}, "X-Api-Version": {"2"}},
+		},
+		{
+			caseName: "request_wins",
+			headers:  map[string]string{"x-api-version": "3", "X-Trace": "abc"},
+			want:     map[string][]string{"X-Tenant": {"acme"}, "X-Api-Version": {"3"}, "X-Trace": {"abc"}},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			_, err := client.DoRequest(context.Background(), http.MethodGet, "/test-default-headers", c.headers, "")
+			require.NoError(t, err)
+			for k, v := range c.want {
+				assert.Equal(t, v, received.Values(k), k)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/schema"
+)
+
+// StatusIndex is the global secondary index of schedule table keyed by Status and EffectiveAfter,
+// queried by ListByStatus. Items stored before Status was maintained are left out until migrated
+const StatusIndex = "Status-index"
+
+// TagAttribute returns the attribute of stored items holding the value of tag key, which keys the
+// index of the tag
+func TagAttribute(key string) string {
+	return "Tag_" + key
+}
+
+// TagIndex returns the global secondary index of schedule table keyed by the value of tag key and
+// EffectiveAfter, queried by ListByTag
+func TagIndex(key string) string {
+	return TagAttribute(key) + "-index"
+}
+
+// marshalItem returns the item of request along with the attributes of its tags
+func marshalItem(req *schema.ScheduledRequest) (map[string]*dynamodb.AttributeValue, error) {
+	av, err := dynamodbattribute.MarshalMap(req)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range req.Tags {
+		// empty strings are no valid index keys
+		if value != "" {
+			av[TagAttribute(key)] = &dynamodb.AttributeValue{S: aws.String(value)}
+		}
+	}
+	return av, nil
+}
+
+// ListByStatus queries the status index for a page of the records of status matching the filter
+// of opts, in order of EffectiveAfter. The continuation token is returned as List does
+func ListByStatus(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, status string, opts ListOptions) ([]*schema.ScheduledRequest, string, error) {
+	// the key attribute is matched by the key condition, DynamoDB rejects filters referencing it
+	opts.Filter.Status = ""
+	return queryIndex(ctx, conn, tableName, StatusIndex, "Status", status, opts)
+}
+
+// ListByTag queries the index of tag key for a page of the records carrying the tag with value and
+// matching the filter of opts, in order of EffectiveAfter. The continuation token is returned as
+// List does
+func ListByTag(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, key, value string, opts ListOptions) ([]*schema.ScheduledRequest, string, error) {
+	// the queried tag is matched by the key condition, keeping the filter of the caller intact
+	tags := make(map[string]string, len(opts.Filter.Tags))
+	for k, v := range opts.Filter.Tags {
+		if k != key {
+			tags[k] = v
+		}
+	}
+	opts.Filter.Tags = tags
+	return queryIndex(ctx, conn, tableName, TagIndex(key), TagAttribute(key), value, opts)
+}
+
+// ListIndexed lists a page of the records of opts as List does, querying the status index or the
+// index of a filtered tag instead of scanning the table when conf sets them up
+func ListIndexed(ctx context.Context, conn dynamodbiface.DynamoDBAPI, conf *config.Configuration, opts ListOptions) ([]*schema.ScheduledRequest, string, error) {
+	if conf.StatusIndex && opts.Filter.Status != "" {
+		return ListByStatus(ctx, conn, conf.TableName, opts.Filter.Status, opts)
+	}
+	indexed := map[string]bool{}
+	for _, key := range conf.IndexedTags {
+		indexed[key] = true
+	}
+	keys := make([]string, 0, len(opts.Filter.Tags))
+	for key, value := range opts.Filter.Tags {
+		if indexed[key] && value != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		return ListByTag(ctx, conn, conf.TableName, keys[0], opts.Filter.Tags[keys[0]], opts)
+	}
+	return List(ctx, conn, conf.TableName, opts)
+}
+
+// queryIndex reads a page of the records of index whose key attribute has value, the filter of
+// opts still applying to them. The due dates of the filter bound the EffectiveAfter sort key of
+// index in the key condition, as the filter of a query must not reference key attributes
+func queryIndex(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, index, attribute, value string, opts ListOptions) ([]*schema.ScheduledRequest, string, error) {
+	filter := opts.Filter
+	keyCondition := "#key = :key"
+	keyValues := map[string]*dynamodb.AttributeValue{":key": {S: aws.String(value)}}
+	before, after := filter.DueBefore, filter.DueAfter
+	switch {
+	case !before.IsZero() && !after.IsZero():
+		if after.After(before) {
+			// DynamoDB rejects a between condition of reversed bounds
+			return []*schema.ScheduledRequest{}, "", nil
+		}
+		keyCondition += " and EffectiveAfter between :after and :before"
+	case !before.IsZero():
+		keyCondition += " and EffectiveAfter <= :before"
+	case !after.IsZero():
+		keyCondition += " and EffectiveAfter >= :after"
+	}
+	if !before.IsZero() {
+		keyValues[":before"] = &dynamodb.AttributeValue{S: aws.String(before.UTC().Format(unixFormat))}
+	}
+	if !after.IsZero() {
+		keyValues[":after"] = &dynamodb.AttributeValue{S: aws.String(after.UTC().Format(unixFormat))}
+	}
+	filter.DueBefore, filter.DueAfter = time.Time{}, time.Time{}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(tableName),
+		IndexName:                 aws.String(index),
+		KeyConditionExpression:    aws.String(keyCondition),
+		ExpressionAttributeNames:  map[string]*string{"#key": aws.String(attribute)},
+		ExpressionAttributeValues: keyValues,
+	}
+	if expr, names, values := filter.expression(); expr != "" {
+		input.FilterExpression = aws.String(expr)
+		for name, ref := range names {
+			input.ExpressionAttributeNames[name] = ref
+		}
+		for name, v := range values {
+			input.ExpressionAttributeValues[name] = v
+		}
+	}
+	log.Printf("query requests table_name=%s index=%s value=%s filter=%s limit=%d \n", tableName, index, value, aws.StringValue(input.FilterExpression), opts.Limit)
+	return listPage(tableName, opts, func(startKey map[string]*dynamodb.AttributeValue, limit *int64) ([]map[string]*dynamodb.AttributeValue, map[string]*dynamodb.AttributeValue, error) {
+		input.ExclusiveStartKey, input.Limit = startKey, limit
+		output, err := conn.QueryWithContext(ctx, input)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "conn.Query table_name=%s index=%s", tableName, index)
+		}
+		return output.Items, output.LastEvaluatedKey, nil
+	})
+}
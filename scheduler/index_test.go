@@ -0,0 +1,232 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/schema"
+)
+
+type mockIndexDB struct {
+	dynamodbiface.DynamoDBAPI
+	items    []map[string]*dynamodb.AttributeValue
+	queryErr error
+	queries  []*dynamodb.QueryInput
+	scans    int
+}
+
+func (mdb *mockIndexDB) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	copied := *input
+	mdb.queries = append(mdb.queries, &copied)
+	if mdb.queryErr != nil {
+		return nil, mdb.queryErr
+	}
+	items := mdb.items
+	if input.ExclusiveStartKey != nil {
+		for i, item := range items {
+			if aws.StringValue(item["ID"].S) == aws.StringValue(input.ExclusiveStartKey["ID"].S) {
+				items = items[i+1:]
+				break
+			}
+		}
+	}
+	var lastKey map[string]*dynamodb.AttributeValue
+	if input.Limit != nil && int(*input.Limit) < len(items) {
+		items = items[:*input.Limit]
+		lastKey = map[string]*dynamodb.AttributeValue{"ID": items[len(items)-1]["ID"]}
+	}
+	return &dynamodb.QueryOutput{Items: items, LastEvaluatedKey: lastKey}, nil
+}
+
+func (mdb *mockIndexDB) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	mdb.scans++
+	return &dynamodb.ScanOutput{Items: mdb.items}, nil
+}
+
+func TestListIndexed(t *testing.T) {
+	table := "list_indexed_test"
+	for _, c := range []struct {
+		caseName  string
+		conf      *config.Configuration
+		opts      ListOptions
+		queryErr  error
+		err       bool
+		wantIndex string
+		wantKey   string
+		wantValue string
+		// the key condition after #key = :key, and the filter left of the one of opts
+		wantRange  string
+		wantFilter string
+		wantNext   bool
+		wantEmpty  bool
+	}{
+		{
+			caseName:  "status",
+			conf:      &config.Configuration{TableName: table, StatusIndex: true},
+			opts:      ListOptions{Filter: ListFilter{Status: schema.StatusFailed}},
+			wantIndex: StatusIndex,
+			wantKey:   "Status",
+			wantValue: schema.StatusFailed,
+		},
+		{
+			caseName: "status_filtered",
+			conf:     &config.Configuration{TableName: table, StatusIndex: true},
+			opts: ListOptions{Filter: ListFilter{
+				Status:    schema.StatusPending,
+				Locked:    aws.Bool(false),
+				DueBefore: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			}},
+			wantIndex:  StatusIndex,
+			wantKey:    "Status",
+			wantValue:  schema.StatusPending,
+			wantRange:  " and EffectiveAfter <= :before",
+			wantFilter: "Locking = :locked",
+		},
+		{
+			caseName: "status_due_between",
+			conf:     &config.Configuration{TableName: table, StatusIndex: true},
+			opts: ListOptions{Filter: ListFilter{
+				Status:    schema.StatusPending,
+				DueAfter:  time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+				DueBefore: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			}},
+			wantIndex: StatusIndex,
+			wantKey:   "Status",
+			wantValue: schema.StatusPending,
+			wantRange: " and EffectiveAfter between :after and :before",
+		},
+		{
+			caseName: "status_due_reversed",
+			conf:     &config.Configuration{TableName: table, StatusIndex: true},
+			opts: ListOptions{Filter: ListFilter{
+				Status:    schema.StatusPending,
+				DueAfter:  time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+				DueBefore: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+			}},
+			wantEmpty: true,
+		},
+		{
+			caseName: "status_not_indexed",
+			conf:     &config.Configuration{TableName: table},
+			opts:     ListOptions{Filter: ListFilter{Status: schema.StatusFailed}},
+		},
+		{
+			caseName:   "tag",
+			conf:       &config.Configuration{TableName: table, IndexedTags: []string{"team", "env"}},
+			opts:       ListOptions{Filter: ListFilter{Tags: map[string]string{"team": "billing", "env": "prod", "owner": "ops"}}},
+			wantIndex:  "Tag_env-index",
+			wantKey:    "Tag_env",
+			wantValue:  "prod",
+			wantFilter: "Tags.#tag0 = :tag0 and Tags.#tag1 = :tag1",
+		},
+		{
+			caseName: "tag_not_indexed",
+			conf:     &config.Configuration{TableName: table, IndexedTags: []string{"team"}},
+			opts:     ListOptions{Filter: ListFilter{Tags: map[string]string{"owner": "ops"}}},
+		},
+		{
+			caseName:  "limit",
+			conf:      &config.Configuration{TableName: table, StatusIndex: true},
+			opts:      ListOptions{Limit: 1, Filter: ListFilter{Status: schema.StatusPending}},
+			wantIndex: StatusIndex,
+			wantKey:   "Status",
+			wantValue: schema.StatusPending,
+			wantNext:  true,
+		},
+		{
+			caseName: "query_error",
+			conf:     &config.Configuration{TableName: table, StatusIndex: true},
+			opts:     ListOptions{Filter: ListFilter{Status: schema.StatusFailed}},
+			queryErr: errors.New("The table does not have the specified index"),
+			err:      true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			conn := &mockIndexDB{
+				items: []map[string]*dynamodb.AttributeValue{
+					{"ID": {S: aws.String("test-index-1")}},
+					{"ID": {S: aws.String("test-index-2")}},
+				},
+				queryErr: c.queryErr,
+			}
+			records, next, err := ListIndexed(context.Background(), conn, c.conf, c.opts)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.wantNext, next != "")
+			if c.wantEmpty {
+				assert.Empty(t, records)
+				assert.Empty(t, conn.queries)
+				assert.Zero(t, conn.scans)
+				return
+			}
+			if c.wantIndex == "" {
+				assert.Empty(t, conn.queries)
+				assert.Equal(t, 1, conn.scans)
+				assert.Len(t, records, 2)
+				return
+			}
+			assert.Zero(t, conn.scans)
+			require.Len(t, conn.queries, 1)
+			query := conn.queries[0]
+			assert.Equal(t, c.wantIndex, aws.StringValue(query.IndexName))
+			assert.Equal(t, "#key = :key"+c.wantRange, aws.StringValue(query.KeyConditionExpression))
+			assert.Equal(t, c.wantKey, aws.StringValue(query.ExpressionAttributeNames["#key"]))
+			assert.Equal(t, c.wantValue, aws.StringValue(query.ExpressionAttributeValues[":key"].S))
+			// the key attributes of index are left out of the filter, the rest of it still applies
+			assert.Equal(t, c.wantFilter, aws.StringValue(query.FilterExpression))
+			assert.NotContains(t, query.ExpressionAttributeValues, ":status")
+			for ref, name := range query.ExpressionAttributeNames {
+				if ref != "#key" {
+					// neither the status nor the queried env tag
+					assert.NotContains(t, []string{"Status", "env"}, aws.StringValue(name))
+				}
+			}
+			if !c.opts.Filter.DueBefore.IsZero() {
+				assert.Equal(t, c.opts.Filter.DueBefore.Format(unixFormat), aws.StringValue(query.ExpressionAttributeValues[":before"].S))
+			}
+		})
+	}
+}
+
+func TestListByTagPages(t *testing.T) {
+	conn := &mockIndexDB{
+		items: []map[string]*dynamodb.AttributeValue{
+			{"ID": {S: aws.String("test-index-1")}},
+			{"ID": {S: aws.String("test-index-2")}},
+			{"ID": {S: aws.String("test-index-3")}},
+		},
+	}
+	opts := ListOptions{Limit: 2}
+	records, next, err := ListByTag(context.Background(), conn, "list_by_tag_test", "team", "billing", opts)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.NotEmpty(t, next)
+	opts.StartKey = next
+	records, next, err = ListByTag(context.Background(), conn, "list_by_tag_test", "team", "billing", opts)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "test-index-3", records[0].ID)
+	assert.Empty(t, next)
+}
+
+func TestMarshalItemTags(t *testing.T) {
+	item, err := marshalItem(&schema.ScheduledRequest{ID: "test-index", Tags: map[string]string{"team": "billing", "env": ""}})
+	require.NoError(t, err)
+	assert.Equal(t, "billing", aws.StringValue(item["Tag_team"].S))
+	assert.NotContains(t, item, "Tag_env")
+	assert.Equal(t, "billing", aws.StringValue(item["Tags"].M["team"].S))
+}
@@ -0,0 +1,83 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// Ingest creates the request defined by JSON body of a message sent by an upstream service. A
+// request without CreatedAt is created now and one without EffectiveAfter is due at once.
+// Delivering the same message again is not an error, the stored request is left as it is
+func Ingest(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, audit *AuditLog, body string, now time.Time) (*schema.ScheduledRequest, error) {
+	req, err := decodeRequest(body, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "decodeRequest")
+	}
+	err = createNew(ctx, conn, tableName, req)
+	if errors.Cause(err) == errAlreadyExists {
+		log.Printf("skip ingested request already created %s \n", req.ToString())
+		return req, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "createNew")
+	}
+	recordAudit(ctx, audit, req.ID, AuditCreated, "ingest")
+	return req, nil
+}
+
+// IngestSQS creates the requests sent as messages of SQS event, the failed messages are reported
+// to be delivered again, then dead-lettered by the redrive policy of the queue
+func IngestSQS(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, audit *AuditLog, event events.SQSEvent) events.SQSEventResponse {
+	resp := events.SQSEventResponse{BatchItemFailures: []events.SQSBatchItemFailure{}}
+	for _, msg := range event.Records {
+		if _, err := Ingest(ctx, conn, tableName, audit, msg.Body, time.Now().UTC()); err != nil {
+			log.Printf("ingest message failed message_id=%s error=%s \n", msg.MessageId, err)
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: msg.MessageId})
+		}
+	}
+	return resp
+}
+
+// IngestEventDetailType is the detail type of the EventBridge events scheduling a request, the
+// rule of the template only routes these to the function
+const IngestEventDetailType = "Scheduled Request"
+
+// IngestEvent creates the request defined by the detail of a custom EventBridge event, emitted by
+// any AWS service or application to schedule a call. It is created when the event was sent unless
+// its CreatedAt is set
+func IngestEvent(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, audit *AuditLog, event events.CloudWatchEvent) (*schema.ScheduledRequest, error) {
+	log.Printf("ingest event id=%s source=%s detail_type=%s \n", event.ID, event.Source, event.DetailType)
+	now := event.Time.UTC()
+	if now.IsZero() {
+		now = time.Now().UTC()
+	}
+	req, err := Ingest(ctx, conn, tableName, audit, string(event.Detail), now)
+	return req, errors.Wrapf(err, "ingest event id=%s", event.ID)
+}
+
+// decodeRequest returns the valid request of JSON body, created now unless CreatedAt is set and
+// due at once unless EffectiveAfter is set
+func decodeRequest(body string, now time.Time) (*schema.ScheduledRequest, error) {
+	req := new(schema.ScheduledRequest)
+	if err := json.Unmarshal([]byte(body), req); err != nil {
+		return nil, errors.Wrapf(ErrValidation, "json.Unmarshal error=%s", err)
+	}
+	if req.CreatedAt.IsZero() {
+		req.CreatedAt = now
+	}
+	if req.EffectiveAfter.IsZero() {
+		req.EffectiveAfter = req.CreatedAt
+	}
+	if err := req.Validate(); err != nil {
+		return nil, errors.Wrapf(ErrValidation, "validate %s error=%s", req.ToString(), err)
+	}
+	return req, nil
+}
@@ -0,0 +1,156 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIngest(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "ingest_test"
+	now := time.Date(2018, 9, 5, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		caseName      string
+		body          string
+		setup         func()
+		wantEffective time.Time
+		wantMetadata  map[string]string
+		wantPut       bool
+		err           bool
+	}{
+		{
+			caseName:      "due_later",
+			body:          `{"ID":"test-ingest","Method":"POST","URL":"/reports","EffectiveAfter":"2018-09-06T00:00:00Z"}`,
+			setup:         func() {},
+			wantEffective: time.Date(2018, 9, 6, 0, 0, 0, 0, time.UTC),
+			wantPut:       true,
+		},
+		{
+			caseName:      "due_now",
+			body:          `{"ID":"test-ingest","Method":"GET","URL":"/reports"}`,
+			setup:         func() {},
+			wantEffective: now,
+			wantPut:       true,
+		},
+		{
+			caseName:      "metadata",
+			body:          `{"ID":"test-ingest","Method":"GET","URL":"/reports","Metadata":{"ticket":"OPS-42","correlation_id":"c0ffee"}}`,
+			setup:         func() {},
+			wantEffective: now,
+			wantMetadata:  map[string]string{"ticket": "OPS-42", "correlation_id": "c0ffee"},
+			wantPut:       true,
+		},
+		{
+			caseName: "redelivered",
+			body:     `{"ID":"test-ingest","Method":"GET","URL":"/reports"}`,
+			setup: func() {
+				mockConn.putErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			wantEffective: now,
+			wantPut:       true,
+		},
+		{
+			caseName: "invalid_json",
+			body:     `{"ID":`,
+			setup:    func() {},
+			err:      true,
+		},
+		{
+			caseName: "invalid_request",
+			body:     `{"ID":"test-ingest","Method":"FETCH","URL":"/reports"}`,
+			setup:    func() {},
+			err:      true,
+		},
+		{
+			caseName: "put_error",
+			body:     `{"ID":"test-ingest","Method":"GET","URL":"/reports"}`,
+			setup: func() {
+				mockConn.putErr = errors.New("internal error")
+			},
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			c.setup()
+			req, err := Ingest(context.Background(), mockConn, table, nil, c.body, now)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, now, req.CreatedAt)
+			assert.Equal(t, c.wantEffective, req.EffectiveAfter)
+			require.NotNil(t, mockConn.lastPutItem)
+			assert.Equal(t, "attribute_not_exists(ID)", aws.StringValue(mockConn.lastPutItem.ConditionExpression))
+			assert.Equal(t, c.wantMetadata, req.Metadata)
+			for k, v := range c.wantMetadata {
+				assert.Equal(t, v, aws.StringValue(mockConn.lastPutItem.Item["Metadata"].M[k].S))
+			}
+		})
+	}
+}
+
+func TestIngestSQS(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	mockConn.clear()
+	resp := IngestSQS(context.Background(), mockConn, "ingest_sqs_test", nil, events.SQSEvent{
+		Records: []events.SQSMessage{
+			{MessageId: "msg-1", Body: `{"ID":"test-ingest-1","Method":"GET","URL":"/reports"}`},
+			{MessageId: "msg-2", Body: `not json`},
+			{MessageId: "msg-3", Body: `{"ID":"test-ingest-3","Method":"GET","URL":"/reports"}`},
+		},
+	})
+	require.Len(t, resp.BatchItemFailures, 1)
+	assert.Equal(t, "msg-2", resp.BatchItemFailures[0].ItemIdentifier)
+}
+
+func TestIngestEvent(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	sent := time.Date(2018, 9, 5, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		caseName string
+		detail   string
+		err      bool
+	}{
+		{
+			caseName: "created",
+			detail:   `{"ID":"test-ingest-event","Method":"GET","URL":"/reports"}`,
+		},
+		{
+			caseName: "invalid_detail",
+			detail:   `{"ID":"test-ingest-event","Method":"FETCH","URL":"/reports"}`,
+			err:      true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			req, err := IngestEvent(context.Background(), mockConn, "ingest_event_test", nil, events.CloudWatchEvent{
+				ID:         "event-1",
+				DetailType: IngestEventDetailType,
+				Source:     "billing",
+				Time:       sent,
+				Detail:     []byte(c.detail),
+			})
+			if c.err {
+				assert.Error(t, err)
+				assert.Nil(t, mockConn.lastPutItem)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, sent, req.CreatedAt)
+			assert.Equal(t, sent, req.EffectiveAfter)
+			require.NotNil(t, mockConn.lastPutItem)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/config"
+)
+
+// Actions of a direct invocation
+const (
+	// InvokeTrigger executes the request of given id at once, or the due requests without id
+	InvokeTrigger = "trigger"
+	// InvokeCreate creates the given request unless its id is taken
+	InvokeCreate = "create"
+	// InvokeGet returns the request of given id
+	InvokeGet = "get"
+	// InvokeList returns a page of the requests matching filter
+	InvokeList = "list"
+	// InvokeCancel keeps the request of given id as CANCELLED so that it is never executed
+	InvokeCancel = "cancel"
+)
+
+// Invocation is the payload of a direct Invoke managing the stored requests. The scheduled event
+// carries no action and triggers the due requests
+type Invocation struct {
+	Action string `json:"action"`
+	// Shard of the triggered due requests, all of them if nil
+	Shard *int `json:"shard"`
+	// Id of the request to trigger, get or cancel
+	ID string `json:"id"`
+	// Request item to create, defaulted like the ingested ones
+	Request json.RawMessage `json:"request"`
+	// Paging and conditions of listed requests
+	Filter    ListFilter `json:"filter"`
+	Limit     int        `json:"limit"`
+	NextToken string     `json:"next_token"`
+	// State of request execution passed by the workflow actions
+	State *WorkflowState `json:"state"`
+}
+
+// CancelResult is the result of a cancelled request
+type CancelResult struct {
+	ID        string `json:"id"`
+	Cancelled bool   `json:"cancelled"`
+}
+
+// Router performs the actions of direct invocations on the stored requests
+type Router struct {
+	conf *config.Configuration
+	conn dynamodbiface.DynamoDBAPI
+	svc  *Services
+}
+
+// NewRouter returns router managing the requests of configured table
+func NewRouter(conf *config.Configuration, conn dynamodbiface.DynamoDBAPI, svc *Services) *Router {
+	return &Router{conf: conf, conn: conn, svc: svc}
+}
+
+// Invoke performs the action of invocation, returning its result as Lambda response. Triggering
+// the due requests is left to the caller, which runs them as the scheduled event does
+func (r *Router) Invoke(ctx context.Context, inv *Invocation) (interface{}, error) {
+	log.Printf("invoke action=%s id=%s \n", inv.Action, inv.ID)
+	if inv.ID == "" && (inv.Action == InvokeTrigger || inv.Action == InvokeGet || inv.Action == InvokeCancel) {
+		return nil, errors.Wrapf(ErrValidation, "missing id of action=%s", inv.Action)
+	}
+	switch inv.Action {
+	case InvokeTrigger:
+		summary, err := TriggerRequest(ctx, r.conf, r.conn, r.svc, inv.ID)
+		return summary, errors.Wrap(err, "TriggerRequest")
+	case InvokeCreate:
+		req, err := decodeRequest(string(inv.Request), time.Now().UTC())
+		if err != nil {
+			return nil, errors.Wrap(err, "decodeRequest")
+		}
+		if err = createNew(ctx, r.conn, r.conf.TableName, req); err != nil {
+			return nil, errors.Wrap(err, "createNew")
+		}
+		recordAudit(ctx, r.svc.Audit, req.ID, AuditCreated, "invoke")
+		return req, nil
+	case InvokeGet:
+		req, err := Get(ctx, r.conn, r.conf.TableName, inv.ID)
+		if err != nil {
+			return nil, errors.Wrap(err, "Get")
+		}
+		return req, nil
+	case InvokeList:
+		reqs, next, err := ListIndexed(ctx, r.conn, r.conf, ListOptions{Limit: inv.Limit, StartKey: inv.NextToken, Filter: inv.Filter})
+		if err != nil {
+			return nil, errors.Wrap(err, "ListIndexed")
+		}
+		return &APIListResult{Requests: reqs, NextToken: next}, nil
+	case InvokeCancel:
+		if err := Cancel(ctx, r.conn, r.conf.TableName, inv.ID); err != nil {
+			return nil, errors.Wrap(err, "Cancel")
+		}
+		recordAudit(ctx, r.svc.Audit, inv.ID, AuditCancelled, "invoke")
+		return &CancelResult{ID: inv.ID, Cancelled: true}, nil
+	case WorkflowLock, WorkflowCall, WorkflowRecord, WorkflowUnlock:
+		if inv.State == nil {
+			return nil, errors.Wrapf(ErrValidation, "missing state of action=%s", inv.Action)
+		}
+		return RunWorkflowStep(ctx, r.conf, r.conn, r.svc, inv.Action, inv.State)
+	}
+	return nil, errors.Wrapf(ErrValidation, "unknown action=%s", inv.Action)
+}
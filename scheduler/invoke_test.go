@@ -0,0 +1,154 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/schema"
+)
+
+func TestRouterInvoke(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	mockClient := new(mockHTTPClient)
+	conf := &config.Configuration{
+		TableName: "RouterInvoke_test",
+	}
+	router := NewRouter(conf, mockConn, &Services{HTTP: mockClient})
+	storedItem := func() {
+		mockConn.item = map[string]*dynamodb.AttributeValue{
+			"ID":     {S: aws.String("test-invoke")},
+			"Method": {S: aws.String("GET")},
+			"URL":    {S: aws.String("/reports")},
+		}
+	}
+	for _, c := range []struct {
+		caseName string
+		payload  string
+		setup    func()
+		errCause error
+		verify   func(t *testing.T, result interface{})
+	}{
+		{
+			caseName: "trigger",
+			payload:  `{"action":"trigger","id":"test-invoke"}`,
+			setup:    storedItem,
+			verify: func(t *testing.T, result interface{}) {
+				require.IsType(t, &RunSummary{}, result)
+				assert.Equal(t, 1, result.(*RunSummary).Succeeded)
+			},
+		},
+		{
+			caseName: "create",
+			payload:  `{"action":"create","request":{"ID":"test-invoke","Method":"GET","URL":"/reports"}}`,
+			setup:    func() {},
+			verify: func(t *testing.T, result interface{}) {
+				require.IsType(t, &schema.ScheduledRequest{}, result)
+				assert.Equal(t, "test-invoke", result.(*schema.ScheduledRequest).ID)
+				require.NotNil(t, mockConn.lastPutItem)
+				assert.Equal(t, "attribute_not_exists(ID)", aws.StringValue(mockConn.lastPutItem.ConditionExpression))
+			},
+		},
+		{
+			caseName: "create_invalid",
+			payload:  `{"action":"create","request":{"ID":"test-invoke","Method":"FETCH"}}`,
+			setup:    func() {},
+			errCause: ErrValidation,
+		},
+		{
+			caseName: "create_taken",
+			payload:  `{"action":"create","request":{"ID":"test-invoke","Method":"GET","URL":"/reports"}}`,
+			setup: func() {
+				mockConn.putErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			errCause: errAlreadyExists,
+		},
+		{
+			caseName: "get",
+			payload:  `{"action":"get","id":"test-invoke"}`,
+			setup:    storedItem,
+			verify: func(t *testing.T, result interface{}) {
+				require.IsType(t, &schema.ScheduledRequest{}, result)
+				assert.Equal(t, "/reports", result.(*schema.ScheduledRequest).URL)
+			},
+		},
+		{
+			caseName: "get_not_found",
+			payload:  `{"action":"get","id":"test-invoke"}`,
+			setup:    func() {},
+			errCause: ErrNotFound,
+		},
+		{
+			caseName: "list",
+			payload:  `{"action":"list","filter":{"locked":true,"tags":{"team":"billing"}},"limit":10}`,
+			setup: func() {
+				mockConn.items = []map[string]*dynamodb.AttributeValue{
+					{"ID": {S: aws.String("test-invoke")}},
+				}
+			},
+			verify: func(t *testing.T, result interface{}) {
+				require.IsType(t, &APIListResult{}, result)
+				assert.Len(t, result.(*APIListResult).Requests, 1)
+				assert.Contains(t, mockConn.lastScanQ, "Locking")
+				assert.Contains(t, mockConn.lastScanQ, "billing")
+			},
+		},
+		{
+			caseName: "cancel",
+			payload:  `{"action":"cancel","id":"test-invoke"}`,
+			setup:    func() {},
+			verify: func(t *testing.T, result interface{}) {
+				assert.Equal(t, &CancelResult{ID: "test-invoke", Cancelled: true}, result)
+				// the request is kept as cancelled rather than deleted
+				assert.Nil(t, mockConn.lastDeleteItem)
+				require.NotNil(t, mockConn.lastUpdateItem)
+				assert.Equal(t, schema.StatusCancelled, aws.StringValue(mockConn.lastUpdateItem.ExpressionAttributeValues[":st"].S))
+			},
+		},
+		{
+			caseName: "cancel_not_found",
+			payload:  `{"action":"cancel","id":"test-invoke"}`,
+			setup: func() {
+				mockConn.updateErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			errCause: ErrNotFound,
+		},
+		{
+			caseName: "missing_id",
+			payload:  `{"action":"cancel"}`,
+			setup:    func() {},
+			errCause: ErrValidation,
+		},
+		{
+			caseName: "unknown_action",
+			payload:  `{"action":"pause","id":"test-invoke"}`,
+			setup:    func() {},
+			errCause: ErrValidation,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			mockClient.clear()
+			c.setup()
+			inv := new(Invocation)
+			require.NoError(t, json.Unmarshal([]byte(c.payload), inv))
+			result, err := router.Invoke(context.Background(), inv)
+			if c.errCause != nil {
+				require.Error(t, err)
+				assert.Equal(t, c.errCause, errors.Cause(err))
+				return
+			}
+			require.NoError(t, err)
+			c.verify(t, result)
+		})
+	}
+}
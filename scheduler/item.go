@@ -0,0 +1,142 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// writeItem performs dynamodb target by putting or updating an item with the rendered payload
+func writeItem(ctx context.Context, conn dynamodbiface.DynamoDBAPI, req *schema.ScheduledRequest) (*schema.Response, error) {
+	if conn == nil {
+		return nil, errors.New("dynamodb client is not configured")
+	}
+	target := req.DynamoDB
+	if target == nil {
+		return nil, errors.New("missing dynamodb target")
+	}
+	now := time.Now().UTC()
+	payload, err := renderPayload(req, now, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "renderPayload")
+	}
+	values, err := jsonAttributeMap(payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "jsonAttributeMap payload")
+	}
+	var names map[string]*string
+	if len(target.ExpressionAttributeNames) > 0 {
+		names = aws.StringMap(target.ExpressionAttributeNames)
+	}
+	var condition *string
+	if target.ConditionExpression != "" {
+		condition = aws.String(target.ConditionExpression)
+	}
+	log.Printf("write item id=%s table_name=%s operation=%s \n", req.ID, target.TableName, target.Operation)
+	switch target.Operation {
+	case schema.OperationPutItem:
+		_, err = conn.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName:                aws.String(target.TableName),
+			Item:                     values,
+			ConditionExpression:      condition,
+			ExpressionAttributeNames: names,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "conn.PutItem table_name=%s", target.TableName)
+		}
+		return &schema.Response{}, nil
+	case schema.OperationUpdateItem:
+		var (
+			keyText string
+			key     map[string]*dynamodb.AttributeValue
+			output  *dynamodb.UpdateItemOutput
+			body    []byte
+		)
+		if keyText, err = renderTemplate(target.Key, now); err != nil {
+			return nil, errors.Wrap(err, "renderTemplate key")
+		}
+		if key, err = jsonAttributeMap(keyText); err != nil {
+			return nil, errors.Wrap(err, "jsonAttributeMap key")
+		}
+		output, err = conn.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(target.TableName),
+			Key:                       key,
+			UpdateExpression:          aws.String(target.UpdateExpression),
+			ConditionExpression:       condition,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+			ReturnValues:              aws.String(dynamodb.ReturnValueUpdatedNew),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "conn.UpdateItem table_name=%s", target.TableName)
+		}
+		if len(output.Attributes) == 0 {
+			return &schema.Response{}, nil
+		}
+		// updated attributes are stored as result
+		var updated map[string]interface{}
+		if err = dynamodbattribute.UnmarshalMap(output.Attributes, &updated); err != nil {
+			return nil, errors.Wrap(err, "dynamodbattribute.UnmarshalMap")
+		}
+		if body, err = json.Marshal(updated); err != nil {
+			return nil, errors.Wrap(err, "json.Marshal")
+		}
+		return &schema.Response{Body: string(body)}, nil
+	default:
+		return nil, errors.Errorf("unsupported operation %s", target.Operation)
+	}
+}
+
+// jsonAttributeMap converts a JSON object into attribute values, keeping numbers precision.
+// Empty text results in nil map.
+func jsonAttributeMap(text string) (map[string]*dynamodb.AttributeValue, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return nil, errors.Wrap(err, "dec.Decode")
+	}
+	m := make(map[string]*dynamodb.AttributeValue, len(obj))
+	for k, v := range obj {
+		m[k] = jsonAttributeValue(v)
+	}
+	return m, nil
+}
+
+func jsonAttributeValue(v interface{}) *dynamodb.AttributeValue {
+	switch t := v.(type) {
+	case string:
+		return &dynamodb.AttributeValue{S: aws.String(t)}
+	case json.Number:
+		return &dynamodb.AttributeValue{N: aws.String(t.String())}
+	case bool:
+		return &dynamodb.AttributeValue{BOOL: aws.Bool(t)}
+	case []interface{}:
+		l := make([]*dynamodb.AttributeValue, 0, len(t))
+		for _, e := range t {
+			l = append(l, jsonAttributeValue(e))
+		}
+		return &dynamodb.AttributeValue{L: l}
+	case map[string]interface{}:
+		m := make(map[string]*dynamodb.AttributeValue, len(t))
+		for k, e := range t {
+			m[k] = jsonAttributeValue(e)
+		}
+		return &dynamodb.AttributeValue{M: m}
+	default:
+		return &dynamodb.AttributeValue{NULL: aws.Bool(true)}
+	}
+}
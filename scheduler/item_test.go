@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/schema"
+)
+
+func TestWriteItem(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	for _, c := range []struct {
+		caseName string
+		noConn   bool
+		setup    func()
+		req      *schema.ScheduledRequest
+		err      bool
+		verify   func(t *testing.T)
+	}{
+		{
+			caseName: "client_not_configured",
+			noConn:   true,
+			req: &schema.ScheduledRequest{
+				ID:       "test-item-no-client",
+				DynamoDB: &schema.DynamoDBTarget{TableName: "flags", Operation: schema.OperationPutItem},
+			},
+			err: true,
+		},
+		{
+			caseName: "missing_target",
+			req:      &schema.ScheduledRequest{ID: "test-item-missing-target"},
+			err:      true,
+		},
+		{
+			caseName: "invalid_payload",
+			req: &schema.ScheduledRequest{
+				ID:       "test-item-invalid-payload",
+				Payload:  "[1,2]",
+				DynamoDB: &schema.DynamoDBTarget{TableName: "flags", Operation: schema.OperationPutItem},
+			},
+			err: true,
+		},
+		{
+			caseName: "put_error",
+			setup: func() {
+				mockConn.putErr = errors.New("ConditionalCheckFailedException")
+			},
+			req: &schema.ScheduledRequest{
+				ID:       "test-item-put-error",
+				Payload:  `{"ID":"checkout-v2"}`,
+				DynamoDB: &schema.DynamoDBTarget{TableName: "flags", Operation: schema.OperationPutItem},
+			},
+			err: true,
+		},
+		{
+			caseName: "put_item",
+			req: &schema.ScheduledRequest{
+				ID:      "test-item-put",
+				Payload: `{"ID":"checkout-v2","Enabled":true,"Rollout":12345678901234567890,"Tags":["web",null],"Meta":{"By":"citium"}}`,
+				DynamoDB: &schema.DynamoDBTarget{
+					TableName:           "flags",
+					Operation:           schema.OperationPutItem,
+					ConditionExpression: "attribute_not_exists(ID)",
+				},
+			},
+			verify: func(t *testing.T) {
+				input := mockConn.lastPutItem
+				require.NotNil(t, input)
+				assert.Equal(t, "flags", *input.TableName)
+				assert.Equal(t, "attribute_not_exists(ID)", *input.ConditionExpression)
+				assert.Equal(t, map[string]*dynamodb.AttributeValue{
+					"ID":      {S: aws.String("checkout-v2")},
+					"Enabled": {BOOL: aws.Bool(true)},
+					"Rollout": {N: aws.String("12345678901234567890")},
+					"Tags":    {L: []*dynamodb.AttributeValue{{S: aws.String("web")}, {NULL: aws.Bool(true)}}},
+					"Meta":    {M: map[string]*dynamodb.AttributeValue{"By": {S: aws.String("citium")}}},
+				}, input.Item)
+			},
+		},
+		{
+			caseName: "update_item",
+			req: &schema.ScheduledRequest{
+				ID:      "test-item-update",
+				Payload: `{":enabled":true}`,
+				DynamoDB: &schema.DynamoDBTarget{
+					TableName:                "flags",
+					Operation:                schema.OperationUpdateItem,
+					Key:                      `{"ID":"checkout-v2"}`,
+					UpdateExpression:         "SET #e = :enabled",
+					ExpressionAttributeNames: map[string]string{"#e": "Enabled"},
+				},
+			},
+			verify: func(t *testing.T) {
+				input := mockConn.lastUpdateItem
+				require.NotNil(t, input)
+				assert.Equal(t, "flags", *input.TableName)
+				assert.Equal(t, "checkout-v2", *input.Key["ID"].S)
+				assert.Equal(t, "SET #e = :enabled", *input.UpdateExpression)
+				assert.Equal(t, "Enabled", *input.ExpressionAttributeNames["#e"])
+				assert.True(t, *input.ExpressionAttributeValues[":enabled"].BOOL)
+				assert.Nil(t, input.ConditionExpression)
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			if c.setup != nil {
+				c.setup()
+			}
+			var (
+				resp *schema.Response
+				err  error
+			)
+			if c.noConn {
+				resp, err = writeItem(context.Background(), nil, c.req)
+			} else {
+				resp, err = writeItem(context.Background(), mockConn, c.req)
+			}
+			if c.err == true {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.NotNil(t, resp)
+				c.verify(t)
+			}
+		})
+	}
+}
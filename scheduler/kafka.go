@@ -0,0 +1,214 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/schema"
+)
+
+// Producer publishes messages to Kafka topics
+type Producer interface {
+	Produce(ctx context.Context, target *schema.KafkaTarget, key, value []byte, headers map[string]string) (*schema.Response, error)
+}
+
+// KafkaClient produces messages with a short lived writer per execution
+type KafkaClient struct {
+	username string
+	password string
+	creds    *credentials.Credentials
+	region   string
+}
+
+// NewKafkaClient returns kafka client authenticating with configured credentials for SASL PLAIN
+// and given aws credentials for MSK IAM
+func NewKafkaClient(conf *config.Configuration, creds *credentials.Credentials, region string) *KafkaClient {
+	return &KafkaClient{
+		username: conf.KafkaUsername,
+		password: conf.KafkaPassword,
+		creds:    creds,
+		region:   region,
+	}
+}
+
+// Produce writes a single message to target topic and waits for all in-sync replicas
+// acknowledging it
+func (c *KafkaClient) Produce(ctx context.Context, target *schema.KafkaTarget, key, value []byte, headers map[string]string) (*schema.Response, error) {
+	mechanism, err := c.mechanism(target.SASLMechanism)
+	if err != nil {
+		return nil, errors.Wrapf(err, "mechanism sasl_mechanism=%s", target.SASLMechanism)
+	}
+	transport := &kafka.Transport{SASL: mechanism}
+	if target.TLS || mechanism != nil {
+		transport.TLS = &tls.Config{}
+	}
+	defer transport.CloseIdleConnections()
+
+	var written kafka.Message
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(target.Brokers...),
+		Topic:        target.Topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		Transport:    transport,
+		Completion: func(messages []kafka.Message, werr error) {
+			if werr == nil && len(messages) > 0 {
+				written = messages[0]
+			}
+		},
+	}
+	msg := kafka.Message{Key: key, Value: value}
+	for k, v := range headers {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+	err = w.WriteMessages(ctx, msg)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "w.WriteMessages topic=%s", target.Topic)
+	}
+	return &schema.Response{
+		Body: fmt.Sprintf("partition=%d offset=%d", written.Partition, written.Offset),
+	}, nil
+}
+
+// mechanism returns SASL mechanism by name, nil if authentication is disabled
+func (c *KafkaClient) mechanism(name string) (sasl.Mechanism, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case schema.SASLPlain:
+		if c.username == "" {
+			return nil, errors.New("missing KAFKA_USERNAME")
+		}
+		return plain.Mechanism{Username: c.username, Password: c.password}, nil
+	case schema.SASLAWSMSKIAM:
+		if c.creds == nil {
+			return nil, errors.New("missing aws credentials")
+		}
+		return &mskIAMMechanism{signer: v4.NewSigner(c.creds), region: c.region}, nil
+	default:
+		return nil, errors.Errorf("unsupported sasl mechanism %s", name)
+	}
+}
+
+const (
+	mskIAMVersion = "2020_10_22"
+	mskIAMService = "kafka-cluster"
+	mskIAMAction  = "kafka-cluster:Connect"
+	mskIAMExpiry  = 5 * time.Minute
+)
+
+// mskIAMMechanism implements AWS_MSK_IAM SASL mechanism, which sends a sigv4 presigned
+// connect action of the broker host as the only authentication message
+type mskIAMMechanism struct {
+	signer *v4.Signer
+	region string
+	now    func() time.Time
+}
+
+func (m *mskIAMMechanism) Name() string {
+	return schema.SASLAWSMSKIAM
+}
+
+func (m *mskIAMMechanism) Start(ctx context.Context) (sasl.StateMachine, []byte, error) {
+	meta := sasl.MetadataFromContext(ctx)
+	if meta == nil {
+		return nil, nil, errors.New("missing sasl metadata")
+	}
+	query := url.Values{
+		"Action":        {mskIAMAction},
+		"X-Amz-Expires": {strconv.Itoa(int(mskIAMExpiry / time.Second))},
+	}
+	signURL := url.URL{Scheme: "kafka", Host: meta.Host, Path: "/", RawQuery: query.Encode()}
+	req, err := http.NewRequest(http.MethodGet, signURL.String(), nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "http.NewRequest")
+	}
+	signTime := time.Now()
+	if m.now != nil {
+		signTime = m.now()
+	}
+	header, err := m.signer.Presign(req, nil, mskIAMService, m.region, mskIAMExpiry, signTime)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "signer.Presign")
+	}
+	signed := map[string]string{
+		"version":    mskIAMVersion,
+		"host":       meta.Host,
+		"user-agent": "citium",
+		"action":     mskIAMAction,
+	}
+	// the protocol requires lowercase keys
+	for k, v := range header {
+		signed[strings.ToLower(k)] = v[0]
+	}
+	for k, v := range req.URL.Query() {
+		signed[strings.ToLower(k)] = v[0]
+	}
+	ir, err := json.Marshal(signed)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "json.Marshal")
+	}
+	return m, ir, nil
+}
+
+func (m *mskIAMMechanism) Next(ctx context.Context, challenge []byte) (bool, []byte, error) {
+	// broker closes the connection on rejected credentials thus getting here means success
+	return true, nil, nil
+}
+
+// produceMessage performs kafka target by producing the rendered payload as message value
+func produceMessage(ctx context.Context, producer Producer, req *schema.ScheduledRequest) (*schema.Response, error) {
+	if producer == nil {
+		return nil, errors.New("kafka producer is not configured")
+	}
+	if req.Kafka == nil {
+		return nil, errors.New("missing kafka target")
+	}
+	now := time.Now().UTC()
+	value, err := renderPayload(req, now, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "renderPayload")
+	}
+	key, err := renderTemplate(req.Kafka.Key, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "renderTemplate key")
+	}
+	var headers map[string]string
+	if req.Kafka.Headers != nil {
+		headers = make(map[string]string, len(req.Kafka.Headers))
+		for k, v := range req.Kafka.Headers {
+			if headers[k], err = renderTemplate(v, now); err != nil {
+				return nil, errors.Wrapf(err, "renderTemplate header=%s", k)
+			}
+		}
+	}
+	var keyBytes []byte
+	if key != "" {
+		keyBytes = []byte(key)
+	}
+	log.Printf("produce message id=%s topic=%s \n", req.ID, req.Kafka.Topic)
+	resp, err := producer.Produce(ctx, req.Kafka, keyBytes, []byte(value), headers)
+	if err != nil {
+		return nil, errors.Wrapf(err, "producer.Produce topic=%s", req.Kafka.Topic)
+	}
+	return resp, nil
+}
@@ -0,0 +1,156 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/schema"
+)
+
+type mockProducer struct {
+	target  *schema.KafkaTarget
+	key     []byte
+	value   []byte
+	headers map[string]string
+	err     error
+}
+
+func (mp *mockProducer) Produce(ctx context.Context, target *schema.KafkaTarget, key, value []byte, headers map[string]string) (*schema.Response, error) {
+	mp.target, mp.key, mp.value, mp.headers = target, key, value, headers
+	if mp.err != nil {
+		return nil, mp.err
+	}
+	return &schema.Response{Body: "partition=0 offset=42"}, nil
+}
+
+func TestProduceMessage(t *testing.T) {
+	target := &schema.KafkaTarget{
+		Brokers: []string{"localhost:9092"},
+		Topic:   "test-topic",
+		Key:     "test-key",
+		Headers: map[string]string{"source": "citium"},
+	}
+	for _, c := range []struct {
+		caseName string
+		producer *mockProducer
+		req      *schema.ScheduledRequest
+		err      bool
+	}{
+		{
+			caseName: "producer_not_configured",
+			req:      &schema.ScheduledRequest{ID: "test-kafka-no-producer", Kafka: target},
+			err:      true,
+		},
+		{
+			caseName: "missing_target",
+			producer: new(mockProducer),
+			req:      &schema.ScheduledRequest{ID: "test-kafka-missing-target"},
+			err:      true,
+		},
+		{
+			caseName: "produce_error",
+			producer: &mockProducer{err: errors.New("leader not available")},
+			req:      &schema.ScheduledRequest{ID: "test-kafka-produce-error", Kafka: target},
+			err:      true,
+		},
+		{
+			caseName: "ok",
+			producer: new(mockProducer),
+			req: &schema.ScheduledRequest{
+				ID:      "test-kafka-ok",
+				Payload: "{\"job\":\"test-job\"}",
+				Kafka:   target,
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			var producer Producer
+			if c.producer != nil {
+				producer = c.producer
+			}
+			resp, err := produceMessage(context.Background(), producer, c.req)
+			if c.err == true {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "partition=0 offset=42", resp.Body)
+				assert.Equal(t, target, c.producer.target)
+				assert.Equal(t, []byte("test-key"), c.producer.key)
+				assert.Equal(t, []byte(c.req.Payload), c.producer.value)
+				assert.Equal(t, map[string]string{"source": "citium"}, c.producer.headers)
+			}
+		})
+	}
+}
+
+func TestKafkaClientMechanism(t *testing.T) {
+	creds := credentials.NewStaticCredentials("AKID", "SECRET", "")
+	for _, c := range []struct {
+		caseName string
+		conf     *config.Configuration
+		creds    *credentials.Credentials
+		name     string
+		expected string
+		err      bool
+	}{
+		{caseName: "disabled", conf: &config.Configuration{}},
+		{caseName: "plain", conf: &config.Configuration{KafkaUsername: "user", KafkaPassword: "pass"}, name: schema.SASLPlain, expected: "PLAIN"},
+		{caseName: "plain_missing_username", conf: &config.Configuration{}, name: schema.SASLPlain, err: true},
+		{caseName: "msk_iam", conf: &config.Configuration{}, creds: creds, name: schema.SASLAWSMSKIAM, expected: "AWS_MSK_IAM"},
+		{caseName: "msk_iam_missing_credentials", conf: &config.Configuration{}, name: schema.SASLAWSMSKIAM, err: true},
+		{caseName: "unsupported", conf: &config.Configuration{}, name: "GSSAPI", err: true},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			m, err := NewKafkaClient(c.conf, c.creds, "us-east-1").mechanism(c.name)
+			if c.err == true {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if c.expected == "" {
+				assert.Nil(t, m)
+			} else {
+				assert.Equal(t, c.expected, m.Name())
+			}
+		})
+	}
+}
+
+func TestMSKIAMMechanism(t *testing.T) {
+	client := NewKafkaClient(&config.Configuration{}, credentials.NewStaticCredentials("AKID", "SECRET", ""), "us-east-1")
+	m, err := client.mechanism(schema.SASLAWSMSKIAM)
+	require.NoError(t, err)
+	m.(*mskIAMMechanism).now = func() time.Time { return time.Date(2018, 9, 2, 0, 0, 0, 0, time.UTC) }
+
+	_, _, err = m.Start(context.Background())
+	assert.Error(t, err, "should fail without broker metadata")
+
+	ctx := sasl.WithMetadata(context.Background(), &sasl.Metadata{Host: "b-1.msk.example.com", Port: 9098})
+	sm, ir, err := m.Start(ctx)
+	require.NoError(t, err)
+	var signed map[string]string
+	require.NoError(t, json.Unmarshal(ir, &signed))
+	assert.Equal(t, "2020_10_22", signed["version"])
+	assert.Equal(t, "b-1.msk.example.com", signed["host"])
+	assert.Equal(t, "kafka-cluster:Connect", signed["action"])
+	assert.Equal(t, "AWS4-HMAC-SHA256", signed["x-amz-algorithm"])
+	assert.Equal(t, "AKID/20180902/us-east-1/kafka-cluster/aws4_request", signed["x-amz-credential"])
+	assert.Equal(t, "20180902T000000Z", signed["x-amz-date"])
+	assert.Equal(t, "300", signed["x-amz-expires"])
+	assert.NotEmpty(t, signed["x-amz-signature"])
+
+	done, resp, err := sm.Next(ctx, nil)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Nil(t, resp)
+}
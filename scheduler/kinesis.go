@@ -0,0 +1,40 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// putRecord performs kinesis target by putting the rendered payload as record data
+func putRecord(ctx context.Context, conn kinesisiface.KinesisAPI, req *schema.ScheduledRequest) (*schema.Response, error) {
+	if conn == nil {
+		return nil, errors.New("kinesis client is not configured")
+	}
+	if req.Kinesis == nil {
+		return nil, errors.New("missing kinesis target")
+	}
+	data, err := renderPayload(req, time.Now().UTC(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "renderPayload")
+	}
+	log.Printf("put record id=%s stream_name=%s \n", req.ID, req.Kinesis.StreamName)
+	output, err := conn.PutRecordWithContext(ctx, &kinesis.PutRecordInput{
+		StreamName:   aws.String(req.Kinesis.StreamName),
+		PartitionKey: aws.String(req.Kinesis.PartitionKey),
+		Data:         []byte(data),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "conn.PutRecord stream_name=%s", req.Kinesis.StreamName)
+	}
+	return &schema.Response{
+		Body: aws.StringValue(output.SequenceNumber),
+	}, nil
+}
@@ -0,0 +1,102 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/schema"
+)
+
+type mockKinesis struct {
+	kinesisiface.KinesisAPI
+	lastPutInput *kinesis.PutRecordInput
+	putErr       error
+}
+
+func (mk *mockKinesis) PutRecordWithContext(ctx aws.Context, input *kinesis.PutRecordInput, opts ...request.Option) (*kinesis.PutRecordOutput, error) {
+	mk.lastPutInput = input
+	if mk.putErr != nil {
+		return nil, mk.putErr
+	}
+	return &kinesis.PutRecordOutput{
+		SequenceNumber: aws.String("test-sequence-number"),
+		ShardId:        aws.String("shardId-000000000000"),
+	}, nil
+}
+
+func TestPutRecord(t *testing.T) {
+	target := &schema.KinesisTarget{StreamName: "test-stream", PartitionKey: "test-key"}
+	for _, c := range []struct {
+		caseName string
+		conn     *mockKinesis
+		req      *schema.ScheduledRequest
+		data     string
+		err      bool
+	}{
+		{
+			caseName: "client_not_configured",
+			req:      &schema.ScheduledRequest{ID: "test-kinesis-no-client", Kinesis: target},
+			err:      true,
+		},
+		{
+			caseName: "missing_target",
+			conn:     new(mockKinesis),
+			req:      &schema.ScheduledRequest{ID: "test-kinesis-missing-target"},
+			err:      true,
+		},
+		{
+			caseName: "put_error",
+			conn:     &mockKinesis{putErr: errors.New("internal error")},
+			req:      &schema.ScheduledRequest{ID: "test-kinesis-put-error", Kinesis: target},
+			err:      true,
+		},
+		{
+			caseName: "ok",
+			conn:     new(mockKinesis),
+			req: &schema.ScheduledRequest{
+				ID:      "test-kinesis-ok",
+				Payload: "{\"pipeline\":\"daily\"}",
+				Kinesis: target,
+			},
+			data: "{\"pipeline\":\"daily\"}",
+		},
+		{
+			caseName: "base64_payload",
+			conn:     new(mockKinesis),
+			req: &schema.ScheduledRequest{
+				ID:              "test-kinesis-base64",
+				Payload:         "AAEC",
+				PayloadEncoding: schema.PayloadBase64,
+				Kinesis:         target,
+			},
+			data: "\x00\x01\x02",
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			var conn kinesisiface.KinesisAPI
+			if c.conn != nil {
+				conn = c.conn
+			}
+			resp, err := putRecord(context.Background(), conn, c.req)
+			if c.err == true {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "test-sequence-number", resp.Body)
+				input := c.conn.lastPutInput
+				assert.Equal(t, "test-stream", *input.StreamName)
+				assert.Equal(t, "test-key", *input.PartitionKey)
+				assert.Equal(t, []byte(c.data), input.Data)
+			}
+		})
+	}
+}
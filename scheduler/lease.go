@@ -0,0 +1,63 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+)
+
+// Lease grants the ownership of a schedule to a single holder, e.g. the active region of a
+// deployment running in two regions against a global table. The holder renews it every run while
+// others stand by, taking it over once it has not been renewed for the lease duration
+type Lease struct {
+	conn      dynamodbiface.DynamoDBAPI
+	tableName string
+	holder    string
+	duration  time.Duration
+}
+
+// NewLease returns lease of given holder stored in a table keyed by Name
+func NewLease(conn dynamodbiface.DynamoDBAPI, tableName, holder string, duration time.Duration) *Lease {
+	return &Lease{
+		conn:      conn,
+		tableName: tableName,
+		holder:    holder,
+		duration:  duration,
+	}
+}
+
+// Acquire takes or renews the lease of schedule, returns false if another holder owns it
+func (l *Lease) Acquire(ctx context.Context, name string, now time.Time) (bool, error) {
+	expires := now.Add(l.duration).UTC().Format(unixFormat)
+	_, err := l.conn.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(l.tableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"Name":      {S: aws.String(name)},
+			"Holder":    {S: aws.String(l.holder)},
+			"ExpiresAt": {S: aws.String(expires)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#n) or Holder = :h or ExpiresAt < :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#n": aws.String("Name"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":h":   {S: aws.String(l.holder)},
+			":now": {S: aws.String(now.UTC().Format(unixFormat))},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		log.Printf("lease held by another holder table_name=%s name=%s holder=%s \n", l.tableName, name, l.holder)
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "conn.PutItem name=%s table_name=%s", name, l.tableName)
+	}
+	log.Printf("lease acquired table_name=%s name=%s holder=%s expires_at=%s \n", l.tableName, name, l.holder, expires)
+	return true, nil
+}
@@ -0,0 +1,84 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/config"
+)
+
+func TestLeaseAcquire(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	lease := NewLease(mockConn, "lease_test", "us-east-1", 15*time.Minute)
+	now := time.Date(2018, 9, 5, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		caseName string
+		setup    func()
+		wantHeld bool
+		err      bool
+	}{
+		{
+			caseName: "acquired",
+			setup:    func() {},
+			wantHeld: true,
+		},
+		{
+			caseName: "held_elsewhere",
+			setup: func() {
+				mockConn.putErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+		},
+		{
+			caseName: "put_error",
+			setup: func() {
+				mockConn.putErr = errors.New("internal error")
+			},
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			c.setup()
+			held, err := lease.Acquire(context.Background(), "citium_schedule", now)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.wantHeld, held)
+			input := mockConn.lastPutItem
+			require.NotNil(t, input)
+			assert.Equal(t, "us-east-1", aws.StringValue(input.Item["Holder"].S))
+			assert.Equal(t, "2018-09-05T00:15:00Z", aws.StringValue(input.Item["ExpiresAt"].S))
+			assert.Equal(t, "2018-09-05T00:00:00Z", aws.StringValue(input.ExpressionAttributeValues[":now"].S))
+		})
+	}
+}
+
+func TestTriggerAPIStandby(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	mockConn.clear()
+	mockConn.items = []map[string]*dynamodb.AttributeValue{
+		{"ID": {S: aws.String("test-standby")}},
+	}
+	mockConn.putErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	mockClient := new(mockHTTPClient)
+	mockClient.clear()
+	conf := &config.Configuration{TableName: "TriggerAPIStandby_test"}
+	svc := &Services{HTTP: mockClient, Lease: NewLease(mockConn, "lease_test", "eu-west-1", 15*time.Minute)}
+	summary, err := TriggerAPI(context.Background(), conf, mockConn, svc)
+	require.NoError(t, err)
+	assert.True(t, summary.Standby)
+	assert.Equal(t, 0, summary.Fetched)
+	assert.Equal(t, 0, mockConn.scanCalls)
+	mockClient.assertCalled(t, 0)
+}
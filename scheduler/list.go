@@ -0,0 +1,198 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// ListFilter selects the listed records, all the set conditions must hold
+type ListFilter struct {
+	// Lock state and whether the last execution failed, nil matches both
+	Locked *bool `json:"locked"`
+	Failed *bool `json:"failed"`
+	// Bounds of EffectiveAfter, zero values are unbounded
+	DueBefore time.Time `json:"due_before"`
+	DueAfter  time.Time `json:"due_after"`
+	// Upper bound of ExecutedAt, matching only executed records if set
+	ExecutedBefore time.Time `json:"executed_before"`
+	// Substring of URL
+	URLContains string `json:"url_contains"`
+	// Tags the record must carry with the same values
+	Tags map[string]string `json:"tags"`
+	// Status of the record, records stored before Status was maintained have none
+	Status string `json:"status"`
+}
+
+// expression returns the scan filter expression of the conditions, empty if none is set
+func (f ListFilter) expression() (string, map[string]*string, map[string]*dynamodb.AttributeValue) {
+	var conditions []string
+	names := map[string]*string{}
+	values := map[string]*dynamodb.AttributeValue{}
+	if f.Locked != nil {
+		conditions = append(conditions, "Locking = :locked")
+		values[":locked"] = &dynamodb.AttributeValue{BOOL: f.Locked}
+	}
+	if f.Failed != nil {
+		values[":empty"] = &dynamodb.AttributeValue{S: aws.String("")}
+		if *f.Failed {
+			conditions = append(conditions, "(attribute_exists(FailureReason) and FailureReason <> :empty)")
+		} else {
+			conditions = append(conditions, "(attribute_not_exists(FailureReason) or FailureReason = :empty)")
+		}
+	}
+	if !f.DueBefore.IsZero() {
+		conditions = append(conditions, "EffectiveAfter <= :before")
+		values[":before"] = &dynamodb.AttributeValue{S: aws.String(f.DueBefore.UTC().Format(unixFormat))}
+	}
+	if !f.DueAfter.IsZero() {
+		conditions = append(conditions, "EffectiveAfter >= :after")
+		values[":after"] = &dynamodb.AttributeValue{S: aws.String(f.DueAfter.UTC().Format(unixFormat))}
+	}
+	if !f.ExecutedBefore.IsZero() {
+		conditions = append(conditions, "ExecutedAt > :never and ExecutedAt <= :executed")
+		values[":never"] = &dynamodb.AttributeValue{S: aws.String(time.Time{}.Format(unixFormat))}
+		values[":executed"] = &dynamodb.AttributeValue{S: aws.String(f.ExecutedBefore.UTC().Format(unixFormat))}
+	}
+	if f.URLContains != "" {
+		conditions = append(conditions, "contains(URL, :url)")
+		values[":url"] = &dynamodb.AttributeValue{S: aws.String(f.URLContains)}
+	}
+	if f.Status != "" {
+		// Status is a reserved word
+		conditions = append(conditions, "#st = :status")
+		names["#st"] = aws.String("Status")
+		values[":status"] = &dynamodb.AttributeValue{S: aws.String(f.Status)}
+	}
+	keys := make([]string, 0, len(f.Tags))
+	for k := range f.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		name, value := fmt.Sprintf("#tag%d", i), fmt.Sprintf(":tag%d", i)
+		conditions = append(conditions, fmt.Sprintf("Tags.%s = %s", name, value))
+		names[name] = aws.String(k)
+		values[value] = &dynamodb.AttributeValue{S: aws.String(f.Tags[k])}
+	}
+	if len(names) == 0 {
+		names = nil
+	}
+	if len(values) == 0 {
+		values = nil
+	}
+	return strings.Join(conditions, " and "), names, values
+}
+
+// ListOptions select a page of listed records
+type ListOptions struct {
+	// Most records of the page, zero or less lists all of them
+	Limit int `json:"limit"`
+	// Continuation token returned along with the previous page, empty to start from the first one
+	StartKey string     `json:"start_key"`
+	Filter   ListFilter `json:"filter"`
+}
+
+// List scans for a page of the records matching the filter of opts, returning them along with
+// the continuation token of next page, empty when there are no more records
+func List(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, opts ListOptions) ([]*schema.ScheduledRequest, string, error) {
+	input := &dynamodb.ScanInput{TableName: aws.String(tableName)}
+	if expr, names, values := opts.Filter.expression(); expr != "" {
+		input.FilterExpression = aws.String(expr)
+		input.ExpressionAttributeNames = names
+		input.ExpressionAttributeValues = values
+	}
+	log.Printf("list requests table_name=%s filter=%s limit=%d \n", tableName, aws.StringValue(input.FilterExpression), opts.Limit)
+	return listPage(tableName, opts, func(startKey map[string]*dynamodb.AttributeValue, limit *int64) ([]map[string]*dynamodb.AttributeValue, map[string]*dynamodb.AttributeValue, error) {
+		input.ExclusiveStartKey, input.Limit = startKey, limit
+		output, err := conn.ScanWithContext(ctx, input)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "conn.Scan table_name=%s input=%s", tableName, input.GoString())
+		}
+		return output.Items, output.LastEvaluatedKey, nil
+	})
+}
+
+// listPage reads pages of items with read from the start key of opts until the limit of opts is
+// reached or no item is left, returning their records along with the continuation token
+func listPage(tableName string, opts ListOptions, read func(startKey map[string]*dynamodb.AttributeValue, limit *int64) ([]map[string]*dynamodb.AttributeValue, map[string]*dynamodb.AttributeValue, error)) ([]*schema.ScheduledRequest, string, error) {
+	var startKey map[string]*dynamodb.AttributeValue
+	if opts.StartKey != "" {
+		var err error
+		if startKey, err = decodePageToken(opts.StartKey); err != nil {
+			return nil, "", errors.Wrapf(err, "decodePageToken token=%s", opts.StartKey)
+		}
+	}
+	var items []map[string]*dynamodb.AttributeValue
+	for {
+		var limit *int64
+		if opts.Limit > 0 {
+			// evaluate no more items than remaining so that matches never overflow the limit
+			limit = aws.Int64(int64(opts.Limit - len(items)))
+		}
+		page, lastKey, err := read(startKey, limit)
+		if err != nil {
+			return nil, "", err
+		}
+		items = append(items, page...)
+		startKey = lastKey
+		if len(lastKey) == 0 || (opts.Limit > 0 && len(items) >= opts.Limit) {
+			break
+		}
+	}
+	records := []*schema.ScheduledRequest{}
+	if err := dynamodbattribute.UnmarshalListOfMaps(items, &records); err != nil {
+		return nil, "", errors.Wrapf(err, "dynamodbattribute.UnmarshalListOfMaps table_name=%s", tableName)
+	}
+	if len(startKey) == 0 {
+		return records, "", nil
+	}
+	next, err := encodePageToken(startKey)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "encodePageToken")
+	}
+	return records, next, nil
+}
+
+// ListRequests is List of the records matching filter, at most limit of them if limit is positive,
+// starting from the page token of a previous call if any
+func ListRequests(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, filter ListFilter, limit int, token string) ([]*schema.ScheduledRequest, string, error) {
+	return List(ctx, conn, tableName, ListOptions{Limit: limit, StartKey: token, Filter: filter})
+}
+
+// encodePageToken returns the opaque form of scan start key passed between list calls
+func encodePageToken(key map[string]*dynamodb.AttributeValue) (string, error) {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", errors.Wrap(err, "json.Marshal")
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodePageToken(token string) (map[string]*dynamodb.AttributeValue, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.Wrap(err, "base64.DecodeString")
+	}
+	var key map[string]*dynamodb.AttributeValue
+	if err = json.Unmarshal(data, &key); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	if len(key) == 0 {
+		return nil, errors.New("empty start key")
+	}
+	return key, nil
+}
@@ -0,0 +1,242 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/schema"
+)
+
+func TestListFilterExpression(t *testing.T) {
+	yes, no := true, false
+	due := time.Date(2018, 9, 2, 0, 2, 3, 0, time.UTC)
+	for _, c := range []struct {
+		caseName   string
+		filter     ListFilter
+		wantExpr   string
+		wantNames  map[string]string
+		wantValues map[string]string
+	}{
+		{
+			caseName: "empty",
+		},
+		{
+			caseName: "locked",
+			filter:   ListFilter{Locked: &yes},
+			wantExpr: "Locking = :locked",
+		},
+		{
+			caseName:   "failed",
+			filter:     ListFilter{Failed: &yes},
+			wantExpr:   "(attribute_exists(FailureReason) and FailureReason <> :empty)",
+			wantValues: map[string]string{":empty": ""},
+		},
+		{
+			caseName:   "not_failed",
+			filter:     ListFilter{Failed: &no},
+			wantExpr:   "(attribute_not_exists(FailureReason) or FailureReason = :empty)",
+			wantValues: map[string]string{":empty": ""},
+		},
+		{
+			caseName:   "due_range",
+			filter:     ListFilter{DueBefore: due, DueAfter: due.Add(-time.Hour)},
+			wantExpr:   "EffectiveAfter <= :before and EffectiveAfter >= :after",
+			wantValues: map[string]string{":before": "2018-09-02T00:02:03Z", ":after": "2018-09-01T23:02:03Z"},
+		},
+		{
+			caseName:   "executed_before",
+			filter:     ListFilter{ExecutedBefore: due},
+			wantExpr:   "ExecutedAt > :never and ExecutedAt <= :executed",
+			wantValues: map[string]string{":never": "0001-01-01T00:00:00Z", ":executed": "2018-09-02T00:02:03Z"},
+		},
+		{
+			caseName:   "url_and_tags",
+			filter:     ListFilter{URLContains: "/orders", Tags: map[string]string{"team": "billing", "env": "prod"}},
+			wantExpr:   "contains(URL, :url) and Tags.#tag0 = :tag0 and Tags.#tag1 = :tag1",
+			wantNames:  map[string]string{"#tag0": "env", "#tag1": "team"},
+			wantValues: map[string]string{":url": "/orders", ":tag0": "prod", ":tag1": "billing"},
+		},
+		{
+			caseName:   "status",
+			filter:     ListFilter{Status: schema.StatusFailed},
+			wantExpr:   "#st = :status",
+			wantNames:  map[string]string{"#st": "Status"},
+			wantValues: map[string]string{":status": "FAILED"},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			expr, names, values := c.filter.expression()
+			assert.Equal(t, c.wantExpr, expr)
+			assert.Len(t, names, len(c.wantNames))
+			for k, v := range c.wantNames {
+				assert.Equal(t, v, aws.StringValue(names[k]))
+			}
+			for k, v := range c.wantValues {
+				require.Contains(t, values, k)
+				assert.Equal(t, v, aws.StringValue(values[k].S))
+			}
+		})
+	}
+}
+
+func TestListRequests(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "list_requests_test"
+	locked := true
+	for _, c := range []struct {
+		caseName string
+		filter   ListFilter
+		limit    int
+		token    string
+		setup    func()
+		wantLen  int
+		wantQ    string
+		wantNext bool
+		err      bool
+	}{
+		{
+			caseName: "no_filter",
+			setup: func() {
+				mockConn.items = []map[string]*dynamodb.AttributeValue{
+					{"ID": {S: aws.String("test-list-1")}},
+					{"ID": {S: aws.String("test-list-2")}},
+				}
+			},
+			wantLen: 2,
+		},
+		{
+			caseName: "locked",
+			filter:   ListFilter{Locked: &locked},
+			setup: func() {
+				mockConn.items = []map[string]*dynamodb.AttributeValue{
+					{"ID": {S: aws.String("test-list-1")}, "Locking": {BOOL: aws.Bool(true)}},
+				}
+			},
+			wantLen: 1,
+			wantQ:   "Locking = :locked",
+		},
+		{
+			caseName: "limit",
+			limit:    2,
+			setup: func() {
+				mockConn.items = []map[string]*dynamodb.AttributeValue{
+					{"ID": {S: aws.String("test-list-1")}},
+					{"ID": {S: aws.String("test-list-2")}},
+					{"ID": {S: aws.String("test-list-3")}},
+				}
+			},
+			wantLen:  2,
+			wantNext: true,
+		},
+		{
+			caseName: "next_token",
+			limit:    2,
+			token:    mustPageToken("test-list-2"),
+			setup: func() {
+				mockConn.items = []map[string]*dynamodb.AttributeValue{
+					{"ID": {S: aws.String("test-list-1")}},
+					{"ID": {S: aws.String("test-list-2")}},
+					{"ID": {S: aws.String("test-list-3")}},
+				}
+			},
+			wantLen: 1,
+		},
+		{
+			caseName: "invalid_token",
+			token:    "not a token",
+			setup:    func() {},
+			err:      true,
+		},
+		{
+			caseName: "scan_error",
+			setup: func() {
+				mockConn.scanErr = errors.New("internal error")
+			},
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			c.setup()
+			records, next, err := ListRequests(context.Background(), mockConn, table, c.filter, c.limit, c.token)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, records, c.wantLen)
+			assert.Equal(t, c.wantNext, next != "")
+			if c.wantQ != "" {
+				assert.Contains(t, mockConn.lastScanQ, c.wantQ)
+			} else {
+				assert.NotContains(t, mockConn.lastScanQ, "FilterExpression")
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "list_test"
+	for _, c := range []struct {
+		caseName  string
+		opts      ListOptions
+		wantPages [][]string
+	}{
+		{
+			caseName:  "all",
+			wantPages: [][]string{{"test-list-1", "test-list-2", "test-list-3"}},
+		},
+		{
+			caseName:  "paged",
+			opts:      ListOptions{Limit: 2},
+			wantPages: [][]string{{"test-list-1", "test-list-2"}, {"test-list-3"}},
+		},
+		{
+			caseName:  "start_key",
+			opts:      ListOptions{Limit: 1, StartKey: mustPageToken("test-list-1")},
+			wantPages: [][]string{{"test-list-2"}, {"test-list-3"}},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			mockConn.items = []map[string]*dynamodb.AttributeValue{
+				{"ID": {S: aws.String("test-list-1")}},
+				{"ID": {S: aws.String("test-list-2")}},
+				{"ID": {S: aws.String("test-list-3")}},
+			}
+			opts := c.opts
+			var pages [][]string
+			for {
+				records, next, err := List(context.Background(), mockConn, table, opts)
+				require.NoError(t, err)
+				var ids []string
+				for _, record := range records {
+					ids = append(ids, record.ID)
+				}
+				pages = append(pages, ids)
+				if next == "" {
+					break
+				}
+				opts.StartKey = next
+			}
+			assert.Equal(t, c.wantPages, pages)
+		})
+	}
+}
+
+func mustPageToken(id string) string {
+	token, err := encodePageToken(map[string]*dynamodb.AttributeValue{"ID": {S: aws.String(id)}})
+	if err != nil {
+		panic(err)
+	}
+	return token
+}
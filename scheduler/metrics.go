@@ -0,0 +1,237 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// metricsOutput receives EMF documents, which CloudWatch extracts from function logs
+var metricsOutput io.Writer = os.Stdout
+
+// Execution outcomes of a due request within a run
+const (
+	outcomeExecuted = iota
+	outcomeFailed
+	// rescheduled on target demand or open circuit
+	outcomeSkipped
+	// locked by a concurrent run in the meantime
+	outcomeLockConflict
+	// left unlocked for the next run as the deadline of this one is near
+	outcomeDeferred
+	// started as workflow execution, which performs it
+	outcomeDispatched
+	// kept as EXPIRED as it is later than the max lateness
+	outcomeExpired
+)
+
+// outcomeStatuses are the statuses of execution outcomes by outcome
+var outcomeStatuses = map[int]string{
+	outcomeExecuted:     "executed",
+	outcomeFailed:       "failed",
+	outcomeSkipped:      "skipped",
+	outcomeLockConflict: "lock_conflict",
+	outcomeDeferred:     "deferred",
+	outcomeDispatched:   "dispatched",
+	outcomeExpired:      "expired",
+}
+
+// ExecutionOutcome is what happened to a due request within a run
+type ExecutionOutcome struct {
+	ID string `json:"id"`
+	// One of executed, failed, skipped, lock_conflict, deferred, dispatched or expired
+	Status string `json:"status"`
+	// Status code answered by target, if any
+	Code int `json:"code,omitempty"`
+	// Time spent performing the action, zero if it was not performed
+	Duration time.Duration `json:"duration,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// runMetrics collects per-run execution counters, safe for concurrent use
+type runMetrics struct {
+	mu            sync.Mutex
+	due           int
+	executed      int
+	failed        int
+	skipped       int
+	lockConflicts int
+	deferred      int
+	dispatched    int
+	expired       int
+	latencies     []time.Duration
+	// errors of failed requests by id
+	errors map[string]string
+	// outcomes of requests in order of completion, indexed by id
+	outcomes     []*ExecutionOutcome
+	outcomeIndex map[string]int
+}
+
+// record counts an outcome, latency of performing the action is ignored if zero
+func (m *runMetrics) record(outcome int, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch outcome {
+	case outcomeExecuted:
+		m.executed++
+	case outcomeFailed:
+		m.failed++
+	case outcomeSkipped:
+		m.skipped++
+	case outcomeLockConflict:
+		// lock conflicts are skipped as well
+		m.skipped++
+		m.lockConflicts++
+	case outcomeDeferred:
+		// deferred requests are skipped as well
+		m.skipped++
+		m.deferred++
+	case outcomeDispatched:
+		m.dispatched++
+	case outcomeExpired:
+		// expired requests are skipped as well
+		m.skipped++
+		m.expired++
+	}
+	if latency > 0 {
+		m.latencies = append(m.latencies, latency)
+	}
+}
+
+// recordOutcome counts the outcome of request like record does and keeps it along with the
+// status code answered by target, zero if there is none
+func (m *runMetrics) recordOutcome(reqID string, outcome, code int, latency time.Duration) {
+	m.record(outcome, latency)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := m.outcome(reqID)
+	result.Status = outcomeStatuses[outcome]
+	result.Duration = latency
+	result.Code = code
+}
+
+// recordError keeps the error of failed request
+func (m *runMetrics) recordError(reqID string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.errors == nil {
+		m.errors = map[string]string{}
+	}
+	m.errors[reqID] = err.Error()
+	result := m.outcome(reqID)
+	if result.Status == "" {
+		// failed before any outcome was counted, e.g. waiting for a slot
+		result.Status = outcomeStatuses[outcomeFailed]
+	}
+	result.Error = err.Error()
+}
+
+// outcome returns the kept outcome of request, added if there is none. Callers hold the lock
+func (m *runMetrics) outcome(reqID string) *ExecutionOutcome {
+	if i, ok := m.outcomeIndex[reqID]; ok {
+		return m.outcomes[i]
+	}
+	if m.outcomeIndex == nil {
+		m.outcomeIndex = map[string]int{}
+	}
+	m.outcomeIndex[reqID] = len(m.outcomes)
+	result := &ExecutionOutcome{ID: reqID}
+	m.outcomes = append(m.outcomes, result)
+	return result
+}
+
+// summary returns the run outcomes
+func (m *runMetrics) summary(table string, started, now time.Time) *RunSummary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &RunSummary{
+		TableName:     table,
+		Fetched:       m.due,
+		Executed:      m.executed + m.failed,
+		Succeeded:     m.executed,
+		Failed:        m.failed,
+		Errors:        m.errors,
+		Outcomes:      m.outcomes,
+		Skipped:       m.skipped,
+		LockConflicts: m.lockConflicts,
+		Deferred:      m.deferred,
+		Dispatched:    m.dispatched,
+		Expired:       m.expired,
+		StartedAt:     started,
+		Duration:      now.Sub(started),
+	}
+}
+
+// percentile returns the nearest-rank p-th percentile of latencies in milliseconds
+func (m *runMetrics) percentile(p float64) float64 {
+	sorted := make([]time.Duration, len(m.latencies))
+	copy(sorted, m.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return float64(sorted[rank]) / float64(time.Millisecond)
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+// emit writes run metrics as a CloudWatch Embedded Metric Format document
+func (m *runMetrics) emit(w io.Writer, namespace, tableName string, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	doc := map[string]interface{}{
+		"TableName":     tableName,
+		"Due":           m.due,
+		"Executed":      m.executed,
+		"Failed":        m.failed,
+		"Skipped":       m.skipped,
+		"LockConflicts": m.lockConflicts,
+	}
+	metrics := []emfMetric{
+		{Name: "Due", Unit: "Count"},
+		{Name: "Executed", Unit: "Count"},
+		{Name: "Failed", Unit: "Count"},
+		{Name: "Skipped", Unit: "Count"},
+		{Name: "LockConflicts", Unit: "Count"},
+	}
+	if len(m.latencies) > 0 {
+		doc["LatencyP50"] = m.percentile(50)
+		doc["LatencyP99"] = m.percentile(99)
+		metrics = append(metrics,
+			emfMetric{Name: "LatencyP50", Unit: "Milliseconds"},
+			emfMetric{Name: "LatencyP99", Unit: "Milliseconds"},
+		)
+	}
+	doc["_aws"] = map[string]interface{}{
+		"Timestamp": now.UnixNano() / int64(time.Millisecond),
+		"CloudWatchMetrics": []map[string]interface{}{
+			{
+				"Namespace":  namespace,
+				"Dimensions": [][]string{{"TableName"}},
+				"Metrics":    metrics,
+			},
+		},
+	}
+	serialized, err := json.Marshal(doc)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+	if _, err = fmt.Fprintln(w, string(serialized)); err != nil {
+		return errors.Wrap(err, "fmt.Fprintln")
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunMetricsEmit(t *testing.T) {
+	m := &runMetrics{due: 5}
+	for i := 1; i <= 3; i++ {
+		m.record(outcomeExecuted, time.Duration(i*100)*time.Millisecond)
+	}
+	m.record(outcomeFailed, 50*time.Millisecond)
+	m.record(outcomeLockConflict, 0)
+
+	var buf bytes.Buffer
+	require.NoError(t, m.emit(&buf, "Citium", "citium_schedule", time.Date(2018, time.September, 02, 0, 2, 3, 0, time.UTC)))
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, "citium_schedule", doc["TableName"])
+	assert.Equal(t, float64(5), doc["Due"])
+	assert.Equal(t, float64(3), doc["Executed"])
+	assert.Equal(t, float64(1), doc["Failed"])
+	assert.Equal(t, float64(1), doc["Skipped"])
+	assert.Equal(t, float64(1), doc["LockConflicts"])
+	// nearest-rank of latencies 50, 100, 200, 300
+	assert.Equal(t, float64(100), doc["LatencyP50"])
+	assert.Equal(t, float64(300), doc["LatencyP99"])
+
+	meta := doc["_aws"].(map[string]interface{})
+	assert.Equal(t, float64(1535846523000), meta["Timestamp"])
+	directive := meta["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "Citium", directive["Namespace"])
+	assert.Len(t, directive["Metrics"], 7)
+}
+
+func TestRunMetricsEmitWithoutLatencies(t *testing.T) {
+	m := &runMetrics{}
+	var buf bytes.Buffer
+	require.NoError(t, m.emit(&buf, "Citium", "citium_schedule", time.Now()))
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, float64(0), doc["Due"])
+	assert.NotContains(t, doc, "LatencyP50")
+	directive := doc["_aws"].(map[string]interface{})["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	assert.Len(t, directive["Metrics"], 5)
+}
+
+func TestRunMetricsOutcomes(t *testing.T) {
+	m := &runMetrics{}
+	m.recordOutcome("test-1", outcomeExecuted, 200, 100*time.Millisecond)
+	m.recordError("test-2", errors.New("context deadline exceeded"))
+	m.recordOutcome("test-3", outcomeFailed, 0, 50*time.Millisecond)
+	m.recordError("test-3", errors.New("connection refused"))
+	m.recordOutcome("test-4", outcomeSkipped, 429, 10*time.Millisecond)
+
+	summary := m.summary("citium_schedule", time.Now(), time.Now())
+	assert.Equal(t, 1, summary.Succeeded)
+	assert.Equal(t, 1, summary.Failed)
+	assert.Len(t, summary.Errors, 2)
+	assert.Equal(t, []*ExecutionOutcome{
+		{ID: "test-1", Status: "executed", Code: 200, Duration: 100 * time.Millisecond},
+		{ID: "test-2", Status: "failed", Error: "context deadline exceeded"},
+		{ID: "test-3", Status: "failed", Duration: 50 * time.Millisecond, Error: "connection refused"},
+		{ID: "test-4", Status: "skipped", Code: 429, Duration: 10 * time.Millisecond},
+	}, summary.Outcomes)
+}
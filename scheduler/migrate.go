@@ -0,0 +1,161 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// MigrationReport is the progress of a migration, final once Migrate returns
+type MigrationReport struct {
+	TableName string `json:"table_name"`
+	DryRun    bool   `json:"dry_run"`
+	// Items read and items missing attributes, which are updated unless dry run
+	Scanned  int `json:"scanned"`
+	Migrated int `json:"migrated"`
+	// Items updated by others since they were read, left to the next migration
+	Conflicts int `json:"conflicts"`
+	// Count of migrated items by backfilled attribute
+	Attributes map[string]int `json:"attributes"`
+}
+
+// ToString returns string representation
+func (r MigrationReport) ToString() string {
+	return fmt.Sprintf("table_name=%s dry_run=%t scanned=%d migrated=%d conflicts=%d", r.TableName, r.DryRun, r.Scanned, r.Migrated, r.Conflicts)
+}
+
+// Migrate scans the whole table for the items stored before attributes were added to requests,
+// setting the missing ones to the values a request created now would have. Status is derived
+// from the lock, result and failure of the item. Existing attributes are never overwritten and
+// Version is incremented like by any other write, starting from zero when it is the missing one,
+// so that writers holding an item read before its migration fail their conditional writes
+// rather than drop the backfilled attributes. Pages of pageSize items are scanned,
+// the default size of DynamoDB if zero, and progress is called with the report after each of
+// them. Nothing is written if dryRun is set
+func Migrate(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, pageSize int, dryRun bool, progress func(*MigrationReport)) (*MigrationReport, error) {
+	log.Printf("migrate table_name=%s dry_run=%t \n", tableName, dryRun)
+	report := &MigrationReport{TableName: tableName, DryRun: dryRun, Attributes: map[string]int{}}
+	input := &dynamodb.ScanInput{TableName: aws.String(tableName), ConsistentRead: aws.Bool(true)}
+	if pageSize > 0 {
+		input.Limit = aws.Int64(int64(pageSize))
+	}
+	for {
+		output, err := conn.ScanWithContext(ctx, input)
+		if err != nil {
+			return report, errors.Wrapf(err, "conn.Scan table_name=%s", tableName)
+		}
+		for _, item := range output.Items {
+			report.Scanned++
+			missing, err := missingAttributes(item)
+			if err != nil {
+				return report, errors.Wrapf(err, "missingAttributes id=%s", aws.StringValue(item["ID"].S))
+			}
+			if len(missing) == 0 {
+				continue
+			}
+			if !dryRun {
+				err = backfill(ctx, conn, tableName, aws.StringValue(item["ID"].S), missing)
+				if err == errVersionConflict {
+					report.Conflicts++
+					continue
+				}
+				if err != nil {
+					return report, err
+				}
+			}
+			report.Migrated++
+			for name := range missing {
+				report.Attributes[name]++
+			}
+		}
+		if progress != nil {
+			progress(report)
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+	}
+	log.Printf("migrated %s \n", report.ToString())
+	return report, nil
+}
+
+// missingAttributes returns the attributes of a request created now which item lacks, along with
+// their values. Attributes of no value such as unset targets are not missing
+func missingAttributes(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+	req := new(schema.ScheduledRequest)
+	if err := dynamodbattribute.UnmarshalMap(item, req); err != nil {
+		return nil, errors.Wrap(err, "dynamodbattribute.UnmarshalMap")
+	}
+	req.Status = req.CurrentStatus()
+	current, err := marshalItem(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshalItem")
+	}
+	missing := map[string]*dynamodb.AttributeValue{}
+	for name, value := range current {
+		if _, ok := item[name]; ok || aws.BoolValue(value.NULL) {
+			continue
+		}
+		missing[name] = value
+	}
+	return missing, nil
+}
+
+// backfill sets the missing attributes of record provided that none of them was set meanwhile,
+// errVersionConflict is returned otherwise
+func backfill(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string, missing map[string]*dynamodb.AttributeValue) error {
+	names := make([]string, 0, len(missing))
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	log.Printf("backfill request table_name=%s id=%s attributes=%s \n", tableName, reqID, strings.Join(names, ","))
+	var sets []string
+	conditions := []string{"attribute_exists(ID)"}
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(reqID),
+			},
+		},
+		ExpressionAttributeNames:  map[string]*string{},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{},
+	}
+	for i, name := range names {
+		if name == "Version" {
+			// set by the increment of bumpVersion
+			conditions = append(conditions, "attribute_not_exists(Version)")
+			continue
+		}
+		// placeholders as some attribute names e.g. Status are reserved words
+		ref, value := fmt.Sprintf("#a%d", i), fmt.Sprintf(":a%d", i)
+		sets = append(sets, ref+" = "+value)
+		conditions = append(conditions, "attribute_not_exists("+ref+")")
+		input.ExpressionAttributeNames[ref] = aws.String(name)
+		input.ExpressionAttributeValues[value] = missing[name]
+	}
+	input.UpdateExpression = aws.String("SET " + strings.Join(sets, ", "))
+	input.ConditionExpression = aws.String(strings.Join(conditions, " and "))
+	_, err := conn.UpdateItemWithContext(ctx, bumpVersion(input))
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		log.Printf("skip request updated meanwhile table_name=%s id=%s \n", tableName, reqID)
+		return errVersionConflict
+	}
+	if err != nil {
+		return errors.Wrapf(err, "conn.UpdateItem id=%s table_name=%s", reqID, tableName)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/schema"
+)
+
+func TestMigrate(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "migrate_test"
+	// stored before Status, Version, LastAttemptAt and the attributes of tags were added
+	legacy := map[string]*dynamodb.AttributeValue{
+		"ID":             {S: aws.String("test-migrate-legacy")},
+		"CreatedAt":      {S: aws.String("2018-09-01T00:00:00Z")},
+		"EffectiveAfter": {S: aws.String("2018-09-02T00:00:00Z")},
+		"ExecutedAt":     {S: aws.String("0001-01-01T00:00:00Z")},
+		"Locking":        {BOOL: aws.Bool(true)},
+		"FailureReason":  {S: aws.String("status 500")},
+		"Attempts":       {N: aws.String("1")},
+		"Method":         {S: aws.String("GET")},
+		"URL":            {S: aws.String("/reports")},
+		"Tags":           {M: map[string]*dynamodb.AttributeValue{"team": {S: aws.String("billing")}}},
+	}
+	current, err := dynamodbattribute.MarshalMap(withPending(&schema.ScheduledRequest{
+		ID:             "test-migrate-current",
+		CreatedAt:      time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+		EffectiveAfter: time.Date(2018, 9, 2, 0, 0, 0, 0, time.UTC),
+		Method:         "GET",
+		URL:            "/reports",
+	}))
+	require.NoError(t, err)
+	for _, c := range []struct {
+		caseName   string
+		dryRun     bool
+		setup      func()
+		err        bool
+		wantReport *MigrationReport
+		wantUpdate bool
+	}{
+		{
+			caseName: "backfilled",
+			setup:    func() {},
+			wantReport: &MigrationReport{
+				Scanned:    2,
+				Migrated:   1,
+				Attributes: map[string]int{"Status": 1, "Version": 1, "LastAttemptAt": 1, "PersistentStore": 1, "StreamResultToS3": 1, "Shard": 1, "Tag_team": 1},
+			},
+			wantUpdate: true,
+		},
+		{
+			caseName: "dry_run",
+			dryRun:   true,
+			setup:    func() {},
+			wantReport: &MigrationReport{
+				DryRun:     true,
+				Scanned:    2,
+				Migrated:   1,
+				Attributes: map[string]int{"Status": 1, "Version": 1, "LastAttemptAt": 1, "PersistentStore": 1, "StreamResultToS3": 1, "Shard": 1, "Tag_team": 1},
+			},
+		},
+		{
+			caseName: "updated_meanwhile",
+			setup: func() {
+				mockConn.updateErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			wantReport: &MigrationReport{Scanned: 2, Conflicts: 1, Attributes: map[string]int{}},
+			wantUpdate: true,
+		},
+		{
+			caseName: "scan_error",
+			setup: func() {
+				mockConn.scanErr = errors.New("internal error")
+			},
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			mockConn.items = []map[string]*dynamodb.AttributeValue{legacy, current}
+			c.setup()
+			pages := 0
+			report, err := Migrate(context.Background(), mockConn, table, 1, c.dryRun, func(*MigrationReport) { pages++ })
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			c.wantReport.TableName = table
+			assert.Equal(t, c.wantReport, report)
+			// progress is reported after every page of a single item
+			assert.Equal(t, 2, pages)
+			if !c.wantUpdate {
+				assert.Nil(t, mockConn.lastUpdateItem)
+				return
+			}
+			require.NotNil(t, mockConn.lastUpdateItem)
+			update := mockConn.lastUpdateItem
+			assert.Equal(t, "test-migrate-legacy", aws.StringValue(update.Key["ID"].S))
+			values := map[string]*dynamodb.AttributeValue{}
+			for ref, name := range update.ExpressionAttributeNames {
+				values[aws.StringValue(name)] = update.ExpressionAttributeValues[":"+ref[1:]]
+				assert.Contains(t, aws.StringValue(update.ConditionExpression), "attribute_not_exists("+ref+")")
+			}
+			assert.Equal(t, schema.StatusFailed, aws.StringValue(values["Status"].S))
+			// missing Version is created by the increment
+			assert.NotContains(t, values, "Version")
+			assert.Contains(t, aws.StringValue(update.ConditionExpression), "attribute_not_exists(Version)")
+			assert.True(t, strings.HasSuffix(aws.StringValue(update.UpdateExpression), " ADD Version :vone"))
+			assert.Equal(t, "1", aws.StringValue(update.ExpressionAttributeValues[":vone"].N))
+			assert.Equal(t, "billing", aws.StringValue(values["Tag_team"].S))
+			// present attributes are kept
+			assert.NotContains(t, values, "Attempts")
+			assert.NotContains(t, values, "Locking")
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iotdataplane"
+	"github.com/aws/aws-sdk-go/service/iotdataplane/iotdataplaneiface"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/schema"
+)
+
+// Publisher publishes messages to MQTT topics
+type Publisher interface {
+	Publish(ctx context.Context, target *schema.MQTTTarget, topic string, payload []byte) (*schema.Response, error)
+}
+
+// MQTTClient publishes via AWS IoT Core data plane or connects to a generic broker per execution
+type MQTTClient struct {
+	iot      iotdataplaneiface.IoTDataPlaneAPI
+	username string
+	password string
+	timeout  time.Duration
+}
+
+// mqttTimeout bounds connecting to generic broker and waiting for publish acknowledgement
+const mqttTimeout = 10 * time.Second
+
+// NewMQTTClient returns mqtt client, iot could be nil if IoT Core endpoint is not configured
+func NewMQTTClient(conf *config.Configuration, iot iotdataplaneiface.IoTDataPlaneAPI) *MQTTClient {
+	return &MQTTClient{
+		iot:      iot,
+		username: conf.MQTTUsername,
+		password: conf.MQTTPassword,
+		timeout:  mqttTimeout,
+	}
+}
+
+// Publish sends payload to topic of target broker, or AWS IoT Core if broker is not set
+func (c *MQTTClient) Publish(ctx context.Context, target *schema.MQTTTarget, topic string, payload []byte) (*schema.Response, error) {
+	if target.Broker == "" {
+		return c.publishIoT(ctx, target, topic, payload)
+	}
+	clientID, err := newUUID()
+	if err != nil {
+		return nil, errors.Wrap(err, "newUUID")
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(target.Broker).
+		SetClientID("citium-" + clientID).
+		SetUsername(c.username).
+		SetPassword(c.password).
+		SetConnectTimeout(c.timeout).
+		SetAutoReconnect(false)
+	client := mqtt.NewClient(opts)
+	if err = waitToken(ctx, client.Connect(), c.timeout); err != nil {
+		return nil, errors.Wrapf(err, "client.Connect broker=%s", target.Broker)
+	}
+	defer client.Disconnect(250)
+
+	token := client.Publish(topic, byte(target.QoS), target.Retain, payload)
+	if err = waitToken(ctx, token, c.timeout); err != nil {
+		return nil, errors.Wrapf(err, "client.Publish broker=%s topic=%s", target.Broker, topic)
+	}
+	body := fmt.Sprintf("topic=%s qos=%d", topic, target.QoS)
+	if pt, ok := token.(*mqtt.PublishToken); ok && target.QoS > 0 {
+		body = fmt.Sprintf("%s message_id=%d", body, pt.MessageID())
+	}
+	return &schema.Response{Body: body}, nil
+}
+
+func (c *MQTTClient) publishIoT(ctx context.Context, target *schema.MQTTTarget, topic string, payload []byte) (*schema.Response, error) {
+	if c.iot == nil {
+		return nil, errors.New("missing IOT_ENDPOINT")
+	}
+	if target.Retain {
+		return nil, errors.New("retained messages are not supported by AWS IoT Core")
+	}
+	_, err := c.iot.Publish(&iotdataplane.PublishInput{
+		Topic:   aws.String(topic),
+		Qos:     aws.Int64(int64(target.QoS)),
+		Payload: payload,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "iot.Publish topic=%s", topic)
+	}
+	return &schema.Response{Body: fmt.Sprintf("topic=%s qos=%d", topic, target.QoS)}, nil
+}
+
+// waitToken blocks until mqtt flow of token completes, context is done or timeout elapses
+func waitToken(ctx context.Context, token mqtt.Token, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return errors.Errorf("timed out after %s", timeout)
+	}
+}
+
+// publishMessage performs mqtt target by publishing the rendered payload
+func publishMessage(ctx context.Context, publisher Publisher, req *schema.ScheduledRequest) (*schema.Response, error) {
+	if publisher == nil {
+		return nil, errors.New("mqtt publisher is not configured")
+	}
+	if req.MQTT == nil {
+		return nil, errors.New("missing mqtt target")
+	}
+	now := time.Now().UTC()
+	topic, err := renderTemplate(req.MQTT.Topic, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "renderTemplate topic")
+	}
+	payload, err := renderPayload(req, now, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "renderPayload")
+	}
+	log.Printf("publish message id=%s topic=%s qos=%d \n", req.ID, topic, req.MQTT.QoS)
+	resp, err := publisher.Publish(ctx, req.MQTT, topic, []byte(payload))
+	if err != nil {
+		return nil, errors.Wrapf(err, "publisher.Publish topic=%s", topic)
+	}
+	return resp, nil
+}
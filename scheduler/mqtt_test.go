@@ -0,0 +1,197 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/iotdataplane"
+	"github.com/aws/aws-sdk-go/service/iotdataplane/iotdataplaneiface"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/schema"
+)
+
+type mockIoTDataPlane struct {
+	iotdataplaneiface.IoTDataPlaneAPI
+	lastPublishInput *iotdataplane.PublishInput
+	publishErr       error
+}
+
+func (mi *mockIoTDataPlane) Publish(input *iotdataplane.PublishInput) (*iotdataplane.PublishOutput, error) {
+	mi.lastPublishInput = input
+	if mi.publishErr != nil {
+		return nil, mi.publishErr
+	}
+	return &iotdataplane.PublishOutput{}, nil
+}
+
+type mockPublisher struct {
+	topic   string
+	payload []byte
+	err     error
+}
+
+func (mp *mockPublisher) Publish(ctx context.Context, target *schema.MQTTTarget, topic string, payload []byte) (*schema.Response, error) {
+	mp.topic, mp.payload = topic, payload
+	if mp.err != nil {
+		return nil, mp.err
+	}
+	return &schema.Response{Body: "topic=" + topic}, nil
+}
+
+// serveMQTT accepts a single client connection, acknowledges its connect & publish packets
+// then returns the published one
+func serveMQTT(t *testing.T, l net.Listener) <-chan *packets.PublishPacket {
+	published := make(chan *packets.PublishPacket, 1)
+	go func() {
+		defer close(published)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			cp, rerr := packets.ReadPacket(conn)
+			if rerr != nil {
+				return
+			}
+			switch p := cp.(type) {
+			case *packets.ConnectPacket:
+				ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+				assert.NoError(t, ack.Write(conn))
+			case *packets.PublishPacket:
+				if p.Qos > 0 {
+					ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+					ack.MessageID = p.MessageID
+					assert.NoError(t, ack.Write(conn))
+				}
+				published <- p
+			case *packets.DisconnectPacket:
+				return
+			}
+		}
+	}()
+	return published
+}
+
+func TestMQTTClientPublish(t *testing.T) {
+	t.Run("case=iot_core", func(t *testing.T) {
+		iot := new(mockIoTDataPlane)
+		client := NewMQTTClient(&config.Configuration{}, iot)
+		resp, err := client.Publish(context.Background(), &schema.MQTTTarget{QoS: 1}, "devices/1/cmd", []byte("reboot"))
+		require.NoError(t, err)
+		assert.Equal(t, "topic=devices/1/cmd qos=1", resp.Body)
+		assert.Equal(t, "devices/1/cmd", *iot.lastPublishInput.Topic)
+		assert.Equal(t, int64(1), *iot.lastPublishInput.Qos)
+		assert.Equal(t, []byte("reboot"), iot.lastPublishInput.Payload)
+	})
+	t.Run("case=iot_core_error", func(t *testing.T) {
+		client := NewMQTTClient(&config.Configuration{}, &mockIoTDataPlane{publishErr: errors.New("throttled")})
+		_, err := client.Publish(context.Background(), &schema.MQTTTarget{}, "devices/1/cmd", []byte("reboot"))
+		assert.Error(t, err)
+	})
+	t.Run("case=iot_core_not_configured", func(t *testing.T) {
+		client := NewMQTTClient(&config.Configuration{}, nil)
+		_, err := client.Publish(context.Background(), &schema.MQTTTarget{}, "devices/1/cmd", []byte("reboot"))
+		assert.Error(t, err)
+	})
+	t.Run("case=iot_core_retain", func(t *testing.T) {
+		client := NewMQTTClient(&config.Configuration{}, new(mockIoTDataPlane))
+		_, err := client.Publish(context.Background(), &schema.MQTTTarget{Retain: true}, "devices/1/cmd", []byte("reboot"))
+		assert.Error(t, err)
+	})
+	for _, qos := range []int{0, 1} {
+		t.Run(fmt.Sprintf("case=broker_qos_%d", qos), func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer l.Close()
+			published := serveMQTT(t, l)
+
+			client := NewMQTTClient(&config.Configuration{}, nil)
+			target := &schema.MQTTTarget{Broker: "tcp://" + l.Addr().String(), QoS: qos, Retain: true}
+			resp, err := client.Publish(context.Background(), target, "devices/1/cmd", []byte("reboot"))
+			require.NoError(t, err)
+			assert.Contains(t, resp.Body, fmt.Sprintf("topic=devices/1/cmd qos=%d", qos))
+
+			p := <-published
+			require.NotNil(t, p)
+			assert.Equal(t, "devices/1/cmd", p.TopicName)
+			assert.Equal(t, byte(qos), p.Qos)
+			assert.True(t, p.Retain)
+			assert.Equal(t, []byte("reboot"), p.Payload)
+		})
+	}
+	t.Run("case=broker_unreachable", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := l.Addr().String()
+		l.Close()
+
+		client := NewMQTTClient(&config.Configuration{}, nil)
+		client.timeout = time.Second
+		_, err = client.Publish(context.Background(), &schema.MQTTTarget{Broker: "tcp://" + addr}, "devices/1/cmd", []byte("reboot"))
+		assert.Error(t, err)
+	})
+}
+
+func TestPublishMessage(t *testing.T) {
+	require.NoError(t, os.Setenv("CITIUM_VAR_DEVICE", "test-device"))
+	defer os.Unsetenv("CITIUM_VAR_DEVICE")
+	for _, c := range []struct {
+		caseName  string
+		publisher *mockPublisher
+		req       *schema.ScheduledRequest
+		err       bool
+	}{
+		{
+			caseName: "publisher_not_configured",
+			req:      &schema.ScheduledRequest{ID: "test-mqtt-no-publisher", MQTT: &schema.MQTTTarget{Topic: "devices/1/cmd"}},
+			err:      true,
+		},
+		{
+			caseName:  "missing_target",
+			publisher: new(mockPublisher),
+			req:       &schema.ScheduledRequest{ID: "test-mqtt-missing-target"},
+			err:       true,
+		},
+		{
+			caseName:  "publish_error",
+			publisher: &mockPublisher{err: errors.New("connection refused")},
+			req:       &schema.ScheduledRequest{ID: "test-mqtt-publish-error", MQTT: &schema.MQTTTarget{Topic: "devices/1/cmd"}},
+			err:       true,
+		},
+		{
+			caseName:  "ok",
+			publisher: new(mockPublisher),
+			req: &schema.ScheduledRequest{
+				ID:      "test-mqtt-ok",
+				Payload: "{\"command\":\"reboot\"}",
+				MQTT:    &schema.MQTTTarget{Topic: "devices/{{ env DEVICE }}/cmd", QoS: 1},
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			var publisher Publisher
+			if c.publisher != nil {
+				publisher = c.publisher
+			}
+			resp, err := publishMessage(context.Background(), publisher, c.req)
+			if c.err == true {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "topic=devices/test-device/cmd", resp.Body)
+				assert.Equal(t, "devices/test-device/cmd", c.publisher.topic)
+				assert.Equal(t, []byte(c.req.Payload), c.publisher.payload)
+			}
+		})
+	}
+}
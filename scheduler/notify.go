@@ -0,0 +1,136 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/multierr"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/schema"
+)
+
+// Notifier delivers failure alerts and run summaries to an external channel
+type Notifier interface {
+	NotifyFailure(ctx context.Context, notification *FailureNotification) error
+	NotifySummary(ctx context.Context, summary *RunSummary) error
+}
+
+// FailureNotification is the alert sent when an execution failed
+type FailureNotification struct {
+	RequestID   string    `json:"request_id"`
+	Description string    `json:"description,omitempty"`
+	Target      string    `json:"target"`
+	URL         string    `json:"url,omitempty"`
+	Error       string    `json:"error"`
+	Attempts    int       `json:"attempts"`
+	FailedAt    time.Time `json:"failed_at"`
+}
+
+func newFailureNotification(req *schema.ScheduledRequest, ferr error, now time.Time) *FailureNotification {
+	return &FailureNotification{
+		RequestID:   req.ID,
+		Description: req.Description,
+		Target:      req.Target(),
+		URL:         req.URL,
+		Error:       ferr.Error(),
+		// failure being notified is already counted by logFailure
+		Attempts: req.Attempts + 1,
+		FailedAt: now,
+	}
+}
+
+// RunSummary reports the outcomes of due requests within a run
+type RunSummary struct {
+	TableName string `json:"table_name"`
+	// Shard of the run, nil if it ran the whole table
+	Shard *int `json:"shard,omitempty"`
+	// Due requests fetched from the table
+	Fetched int `json:"fetched"`
+	// Requests executed, either succeeded or failed
+	Executed  int `json:"executed"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	// Requests rescheduled, locked by a concurrent run, deferred to the next run near deadline or
+	// expired as too late
+	Skipped       int `json:"skipped"`
+	LockConflicts int `json:"lock_conflicts"`
+	Deferred      int `json:"deferred"`
+	Expired       int `json:"expired"`
+	// Requests started as workflow executions, which perform them
+	Dispatched int `json:"dispatched"`
+	// Whether the run stood by as the lease of schedule is held elsewhere
+	Standby bool `json:"standby,omitempty"`
+	// Errors raised by requests by request id, including failures to store their outcome
+	Errors map[string]string `json:"errors,omitempty"`
+	// Outcome of every due request in order of completion
+	Outcomes  []*ExecutionOutcome `json:"outcomes,omitempty"`
+	StartedAt time.Time           `json:"started_at"`
+	Duration  time.Duration       `json:"duration"`
+}
+
+// ToString returns string representation
+func (s RunSummary) ToString() string {
+	return fmt.Sprintf("table_name=%s fetched=%d executed=%d succeeded=%d failed=%d skipped=%d lock_conflicts=%d deferred=%d expired=%d dispatched=%d duration=%s",
+		s.TableName, s.Fetched, s.Executed, s.Succeeded, s.Failed, s.Skipped, s.LockConflicts, s.Deferred, s.Expired, s.Dispatched, s.Duration)
+}
+
+// Severity of the summary is warning if any execution failed
+func (s *RunSummary) Severity() string {
+	if s.Failed > 0 {
+		return config.SeverityWarning
+	}
+	return config.SeverityInfo
+}
+
+var severityRanks = map[string]int{
+	config.SeverityInfo:    0,
+	config.SeverityWarning: 1,
+	config.SeverityError:   2,
+}
+
+// severityNotifier drops notifications below its minimum severity
+type severityNotifier struct {
+	Notifier
+	min string
+}
+
+// WithSeverity returns notifier delivering only notifications at least as severe as min.
+// Failure alerts are of error severity.
+func WithSeverity(n Notifier, min string) Notifier {
+	return &severityNotifier{Notifier: n, min: min}
+}
+
+func (n *severityNotifier) NotifyFailure(ctx context.Context, notification *FailureNotification) error {
+	if severityRanks[config.SeverityError] < severityRanks[n.min] {
+		return nil
+	}
+	return n.Notifier.NotifyFailure(ctx, notification)
+}
+
+func (n *severityNotifier) NotifySummary(ctx context.Context, summary *RunSummary) error {
+	if severityRanks[summary.Severity()] < severityRanks[n.min] {
+		return nil
+	}
+	return n.Notifier.NotifySummary(ctx, summary)
+}
+
+// notifyFailure alerts every notifier of the failed execution
+func notifyFailure(ctx context.Context, notifiers []Notifier, req *schema.ScheduledRequest, ferr error) error {
+	var err error
+	notification := newFailureNotification(req, ferr, time.Now().UTC())
+	for _, n := range notifiers {
+		err = multierr.Append(err, n.NotifyFailure(ctx, notification))
+	}
+	return err
+}
+
+// notifySummary reports the run to every notifier
+func notifySummary(ctx context.Context, notifiers []Notifier, summary *RunSummary) error {
+	var err error
+	for _, n := range notifiers {
+		err = multierr.Append(err, n.NotifySummary(ctx, summary))
+	}
+	return err
+}
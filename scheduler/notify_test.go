@@ -0,0 +1,84 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/schema"
+)
+
+type mockNotifier struct {
+	failures  []*FailureNotification
+	summaries []*RunSummary
+	err       error
+}
+
+func (mn *mockNotifier) NotifyFailure(ctx context.Context, notification *FailureNotification) error {
+	mn.failures = append(mn.failures, notification)
+	return mn.err
+}
+
+func (mn *mockNotifier) NotifySummary(ctx context.Context, summary *RunSummary) error {
+	mn.summaries = append(mn.summaries, summary)
+	return mn.err
+}
+
+func TestWithSeverity(t *testing.T) {
+	req := &schema.ScheduledRequest{ID: "test-severity"}
+	for _, c := range []struct {
+		min           string
+		failed        int
+		expectFailure bool
+		expectSummary bool
+	}{
+		{min: config.SeverityInfo, expectFailure: true, expectSummary: true},
+		{min: config.SeverityWarning, expectFailure: true},
+		{min: config.SeverityWarning, failed: 1, expectFailure: true, expectSummary: true},
+		{min: config.SeverityError, failed: 1, expectFailure: true},
+	} {
+		t.Run(fmt.Sprintf("case=min_%s_failed_%d", c.min, c.failed), func(t *testing.T) {
+			mn := new(mockNotifier)
+			notifiers := []Notifier{WithSeverity(mn, c.min)}
+			require.NoError(t, notifyFailure(context.Background(), notifiers, req, errors.New("failed")))
+			require.NoError(t, notifySummary(context.Background(), notifiers, &RunSummary{Fetched: 1, Failed: c.failed}))
+			assert.Equal(t, c.expectFailure, len(mn.failures) == 1)
+			assert.Equal(t, c.expectSummary, len(mn.summaries) == 1)
+		})
+	}
+}
+
+func TestNotifyErrors(t *testing.T) {
+	ok, failing := new(mockNotifier), &mockNotifier{err: errors.New("unreachable")}
+	err := notifyFailure(context.Background(), []Notifier{failing, ok}, &schema.ScheduledRequest{ID: "test-notify-errors"}, errors.New("failed"))
+	assert.Error(t, err)
+	// a failing notifier does not prevent the others from being notified
+	require.Len(t, ok.failures, 1)
+	assert.Equal(t, "test-notify-errors", ok.failures[0].RequestID)
+	assert.Equal(t, 1, ok.failures[0].Attempts)
+}
+
+func TestRunSummary(t *testing.T) {
+	started := time.Date(2018, time.September, 02, 0, 2, 3, 0, time.UTC)
+	m := &runMetrics{due: 3}
+	m.record(outcomeExecuted, time.Second)
+	m.record(outcomeLockConflict, 0)
+	summary := m.summary("citium_schedule", started, started.Add(2*time.Second))
+	assert.Equal(t, &RunSummary{
+		TableName:     "citium_schedule",
+		Fetched:       3,
+		Executed:      1,
+		Succeeded:     1,
+		Skipped:       1,
+		LockConflicts: 1,
+		StartedAt:     started,
+		Duration:      2 * time.Second,
+	}, summary)
+	assert.Equal(t, config.SeverityInfo, summary.Severity())
+}
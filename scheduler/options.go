@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// Option customizes the HTTPClient returned by NewClient beyond its configuration
+type Option func(*HTTPClient)
+
+// Logger prints the progress of calls, *log.Logger is one
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Tracer wraps the client to record its outgoing requests, e.g. xray.Client
+type Tracer func(*http.Client) *http.Client
+
+// RetryPolicy retries a call within the same execution while its response status matches
+type RetryPolicy struct {
+	// status codes or classes retried, e.g. `429,5xx`, none if empty
+	OnStatus   string
+	MaxRetries int
+	// wait before the first retry, doubled by each next one
+	Backoff time.Duration
+	// longest Retry-After of target honored by waiting, the call is returned as is beyond
+	MaxRetryAfter time.Duration
+}
+
+// WithTransport sends requests through transport instead of the dedicated one tuned by
+// configuration, so that DNSServer, HostOverrides and SetResolver do not apply
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *HTTPClient) {
+		c.Transport = transport
+	}
+}
+
+// WithTimeout limits each attempt of a call including reading its response, none by default
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *HTTPClient) {
+		c.Timeout = timeout
+	}
+}
+
+// WithRetryPolicy replaces the retry policy of configuration
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *HTTPClient) {
+		c.retryOnStatus = policy.OnStatus
+		c.maxRetries = policy.MaxRetries
+		c.retryBackoff = policy.Backoff
+		c.retryAfterMaxWait = policy.MaxRetryAfter
+	}
+}
+
+// WithLogger prints the progress of calls with logger instead of the standard one
+func WithLogger(logger Logger) Option {
+	return func(c *HTTPClient) {
+		c.logger = logger
+	}
+}
+
+// WithTracer records outgoing requests with tracer, which wraps the client once every other option
+// is applied
+func WithTracer(tracer Tracer) Option {
+	return func(c *HTTPClient) {
+		c.tracer = tracer
+	}
+}
+
+// logf prints with the logger of client, the standard one if unset
+func (c *HTTPClient) logf(format string, v ...interface{}) {
+	if c.logger == nil {
+		log.Printf(format, v...)
+		return
+	}
+	c.logger.Printf(format, v...)
+}
@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/config"
+)
+
+type countingTransport struct {
+	calls uint32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddUint32(&t.calls, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestNewClientOptions(t *testing.T) {
+	var hits uint32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(50 * time.Millisecond)
+		case "/flaky":
+			// fails twice in a row then succeeds
+			if atomic.AddUint32(&hits, 1)%3 != 0 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	conf := &config.Configuration{BaseURL: srv.URL, RetryOnStatus: "503", MaxRetries: 1}
+	transport := new(countingTransport)
+	logs := new(bytes.Buffer)
+	var traced uint32
+	tracer := func(client *http.Client) *http.Client {
+		atomic.AddUint32(&traced, 1)
+		// the transport of other options is wrapped
+		_, ok := client.Transport.(*countingTransport)
+		assert.True(t, ok)
+		return client
+	}
+	for _, c := range []struct {
+		caseName string
+		opts     []Option
+		path     string
+		newErr   bool
+		err      bool
+		wantCode int
+		verify   func(t *testing.T)
+	}{
+		{
+			caseName: "defaults",
+			path:     "/flaky",
+			wantCode: http.StatusBadGateway,
+		},
+		{
+			caseName: "transport",
+			opts:     []Option{WithTransport(transport)},
+			path:     "/ok",
+			wantCode: http.StatusOK,
+			verify: func(t *testing.T) {
+				assert.Equal(t, uint32(1), atomic.LoadUint32(&transport.calls))
+			},
+		},
+		{
+			caseName: "timeout",
+			opts:     []Option{WithTimeout(10 * time.Millisecond)},
+			path:     "/slow",
+			err:      true,
+		},
+		{
+			caseName: "retry_policy",
+			opts:     []Option{WithRetryPolicy(RetryPolicy{OnStatus: "5xx", MaxRetries: 2, Backoff: time.Millisecond})},
+			path:     "/flaky",
+			wantCode: http.StatusOK,
+		},
+		{
+			caseName: "invalid_retry_policy",
+			opts:     []Option{WithRetryPolicy(RetryPolicy{OnStatus: "5xy"})},
+			newErr:   true,
+		},
+		{
+			caseName: "logger",
+			opts:     []Option{WithLogger(log.New(logs, "", 0))},
+			path:     "/ok",
+			wantCode: http.StatusOK,
+			verify: func(t *testing.T) {
+				assert.Contains(t, logs.String(), "do method=GET url="+srv.URL+"/ok")
+			},
+		},
+		{
+			caseName: "tracer",
+			opts:     []Option{WithTracer(tracer), WithTransport(transport)},
+			path:     "/ok",
+			wantCode: http.StatusOK,
+			verify: func(t *testing.T) {
+				assert.Equal(t, uint32(1), atomic.LoadUint32(&traced))
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			atomic.StoreUint32(&hits, 0)
+			client, err := NewClient(conf, c.opts...)
+			if c.newErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			resp, err := client.DoRequest(context.Background(), http.MethodGet, c.path, nil, "")
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.wantCode, resp.Code)
+			if c.verify != nil {
+				c.verify(t)
+			}
+		})
+	}
+}
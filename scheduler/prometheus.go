@@ -0,0 +1,126 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// latencyBuckets are the upper bounds in seconds of execution latency histogram
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// outcomeLabels are the label values of executions counter by outcome
+var outcomeLabels = []string{"executed", "failed", "skipped", "lock_conflict", "expired"}
+
+// Prometheus accumulates execution counters and latency histogram across runs, exposed in
+// Prometheus text format. It is safe for concurrent use.
+type Prometheus struct {
+	mu           sync.Mutex
+	runs         float64
+	due          float64
+	executions   map[string]float64
+	bucketCounts []float64
+	latencySum   float64
+	latencyCount float64
+}
+
+// NewPrometheus returns empty collector
+func NewPrometheus() *Prometheus {
+	return &Prometheus{
+		executions:   make(map[string]float64, len(outcomeLabels)),
+		bucketCounts: make([]float64, len(latencyBuckets)),
+	}
+}
+
+// observe adds counters & latencies of a finished run
+func (p *Prometheus) observe(m *runMetrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.runs++
+	p.due += float64(m.due)
+	p.executions["executed"] += float64(m.executed)
+	p.executions["failed"] += float64(m.failed)
+	p.executions["skipped"] += float64(m.skipped - m.lockConflicts - m.expired)
+	p.executions["lock_conflict"] += float64(m.lockConflicts)
+	p.executions["expired"] += float64(m.expired)
+	for _, latency := range m.latencies {
+		seconds := latency.Seconds()
+		for i, bound := range latencyBuckets {
+			if seconds <= bound {
+				p.bucketCounts[i]++
+			}
+		}
+		p.latencySum += seconds
+		p.latencyCount++
+	}
+}
+
+// WriteTo writes metrics in Prometheus text exposition format
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var b strings.Builder
+	b.WriteString("# HELP citium_runs_total Number of finished scheduler runs.\n")
+	b.WriteString("# TYPE citium_runs_total counter\n")
+	fmt.Fprintf(&b, "citium_runs_total %g\n", p.runs)
+	b.WriteString("# HELP citium_due_requests_total Number of due requests fetched by runs.\n")
+	b.WriteString("# TYPE citium_due_requests_total counter\n")
+	fmt.Fprintf(&b, "citium_due_requests_total %g\n", p.due)
+	b.WriteString("# HELP citium_executions_total Number of due requests by execution outcome.\n")
+	b.WriteString("# TYPE citium_executions_total counter\n")
+	for _, outcome := range outcomeLabels {
+		fmt.Fprintf(&b, "citium_executions_total{outcome=%q} %g\n", outcome, p.executions[outcome])
+	}
+	b.WriteString("# HELP citium_execution_duration_seconds Latency of performed actions.\n")
+	b.WriteString("# TYPE citium_execution_duration_seconds histogram\n")
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(&b, "citium_execution_duration_seconds_bucket{le=\"%g\"} %g\n", bound, p.bucketCounts[i])
+	}
+	fmt.Fprintf(&b, "citium_execution_duration_seconds_bucket{le=\"+Inf\"} %g\n", p.latencyCount)
+	fmt.Fprintf(&b, "citium_execution_duration_seconds_sum %g\n", p.latencySum)
+	fmt.Fprintf(&b, "citium_execution_duration_seconds_count %g\n", p.latencyCount)
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP exposes metrics to Prometheus scrapes
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if _, err := p.WriteTo(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Push replaces metrics of job group on a Prometheus pushgateway
+func (p *Prometheus) Push(ctx context.Context, gatewayURL, job string) error {
+	var buf bytes.Buffer
+	if _, err := p.WriteTo(&buf); err != nil {
+		return errors.Wrap(err, "p.WriteTo")
+	}
+	urlStr := fmt.Sprintf("%s/metrics/job/%s", strings.TrimSuffix(gatewayURL, "/"), job)
+	req, err := http.NewRequest(http.MethodPut, urlStr, &buf)
+	if err != nil {
+		return errors.Wrapf(err, "http.NewRequest url=%s", urlStr)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "http.DefaultClient.Do url=%s", urlStr)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected pushgateway response code=%d url=%s", resp.StatusCode, urlStr)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusObserve(t *testing.T) {
+	p := NewPrometheus()
+	for i := 0; i < 2; i++ {
+		m := &runMetrics{due: 3}
+		m.record(outcomeExecuted, 80*time.Millisecond)
+		m.record(outcomeFailed, 2*time.Second)
+		m.record(outcomeLockConflict, 0)
+		m.record(outcomeExpired, 0)
+		p.observe(m)
+	}
+	var buf bytes.Buffer
+	_, err := p.WriteTo(&buf)
+	require.NoError(t, err)
+	for _, line := range []string{
+		"citium_runs_total 2",
+		"citium_due_requests_total 6",
+		`citium_executions_total{outcome="executed"} 2`,
+		`citium_executions_total{outcome="failed"} 2`,
+		`citium_executions_total{outcome="skipped"} 0`,
+		`citium_executions_total{outcome="lock_conflict"} 2`,
+		`citium_executions_total{outcome="expired"} 2`,
+		`citium_execution_duration_seconds_bucket{le="0.05"} 0`,
+		`citium_execution_duration_seconds_bucket{le="0.1"} 2`,
+		`citium_execution_duration_seconds_bucket{le="2.5"} 4`,
+		`citium_execution_duration_seconds_bucket{le="+Inf"} 4`,
+		"citium_execution_duration_seconds_sum 4.16",
+		"citium_execution_duration_seconds_count 4",
+	} {
+		assert.Contains(t, buf.String(), line+"\n")
+	}
+}
+
+func TestPrometheusServeHTTP(t *testing.T) {
+	p := NewPrometheus()
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, rec.Body.String(), "citium_runs_total 0\n")
+}
+
+func TestPrometheusPush(t *testing.T) {
+	for _, c := range []struct {
+		caseName string
+		code     int
+		err      bool
+	}{
+		{caseName: "accepted", code: http.StatusOK},
+		{caseName: "rejected", code: http.StatusBadRequest, err: true},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			var method, path, body string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				method, path = r.Method, r.URL.Path
+				raw, rerr := ioutil.ReadAll(r.Body)
+				require.NoError(t, rerr)
+				body = string(raw)
+				w.WriteHeader(c.code)
+			}))
+			defer srv.Close()
+
+			err := NewPrometheus().Push(context.Background(), srv.URL+"/", "citium")
+			if c.err == true {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, http.MethodPut, method)
+			assert.Equal(t, "/metrics/job/citium", path)
+			assert.Contains(t, body, "citium_runs_total 0\n")
+		})
+	}
+}
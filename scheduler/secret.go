@@ -0,0 +1,75 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/pkg/errors"
+)
+
+// TokenSource provides the bearer token of http targets, Invalidate is called when the token
+// got rejected so that a rotated one is fetched
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+	Invalidate()
+}
+
+// SecretToken is bearer token stored in Secrets Manager, cached for refresh interval
+type SecretToken struct {
+	conn     secretsmanageriface.SecretsManagerAPI
+	secretID string
+	refresh  time.Duration
+
+	mu        sync.Mutex
+	token     string
+	fetchedAt time.Time
+}
+
+// NewSecretToken returns token source of given secret arn or name
+func NewSecretToken(conn secretsmanageriface.SecretsManagerAPI, secretID string, refresh time.Duration) *SecretToken {
+	return &SecretToken{conn: conn, secretID: secretID, refresh: refresh}
+}
+
+// Token returns the cached token, fetching the current secret value once refresh interval elapsed.
+// The secret is either the plain token or a JSON object with "token" key.
+func (s *SecretToken) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Since(s.fetchedAt) < s.refresh {
+		return s.token, nil
+	}
+	output, err := s.conn.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(s.secretID),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "conn.GetSecretValue secret_id=%s", s.secretID)
+	}
+	token := aws.StringValue(output.SecretString)
+	if strings.HasPrefix(strings.TrimSpace(token), "{") {
+		var value struct {
+			Token string `json:"token"`
+		}
+		if err = json.Unmarshal([]byte(token), &value); err != nil {
+			return "", errors.Wrapf(err, "json.Unmarshal secret_id=%s", s.secretID)
+		}
+		token = value.Token
+	}
+	if token == "" {
+		return "", errors.Errorf("empty token secret_id=%s", s.secretID)
+	}
+	s.token, s.fetchedAt = token, time.Now()
+	return s.token, nil
+}
+
+// Invalidate drops the cached token
+func (s *SecretToken) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ""
+}
@@ -0,0 +1,124 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	values []string
+	err    error
+	calls  int
+}
+
+func (m *mockSecretsManager) GetSecretValueWithContext(ctx aws.Context, input *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	value := m.values[m.calls]
+	m.calls++
+	return &secretsmanager.GetSecretValueOutput{ARN: input.SecretId, SecretString: aws.String(value)}, nil
+}
+
+func TestSecretToken(t *testing.T) {
+	for _, c := range []struct {
+		caseName   string
+		conn       *mockSecretsManager
+		refresh    time.Duration
+		invalidate bool
+		want       []string
+		wantCalls  int
+		err        bool
+	}{
+		{
+			caseName:  "cached",
+			conn:      &mockSecretsManager{values: []string{"token-1", "token-2"}},
+			refresh:   time.Hour,
+			want:      []string{"token-1", "token-1"},
+			wantCalls: 1,
+		},
+		{
+			caseName:  "refreshed",
+			conn:      &mockSecretsManager{values: []string{"token-1", "token-2"}},
+			want:      []string{"token-1", "token-2"},
+			wantCalls: 2,
+		},
+		{
+			caseName:   "invalidated",
+			conn:       &mockSecretsManager{values: []string{"token-1", "token-2"}},
+			refresh:    time.Hour,
+			invalidate: true,
+			want:       []string{"token-1", "token-2"},
+			wantCalls:  2,
+		},
+		{
+			caseName:  "json_secret",
+			conn:      &mockSecretsManager{values: []string{`{"token": "token-1"}`}},
+			refresh:   time.Hour,
+			want:      []string{"token-1"},
+			wantCalls: 1,
+		},
+		{
+			caseName: "empty_secret",
+			conn:     &mockSecretsManager{values: []string{`{"username": "citium"}`}},
+			err:      true,
+		},
+		{
+			caseName: "access_denied",
+			conn:     &mockSecretsManager{err: awserr.New("AccessDeniedException", "not authorized", nil)},
+			err:      true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			tokens := NewSecretToken(c.conn, "arn:aws:secretsmanager:us-east-1:123456789012:secret:citium", c.refresh)
+			if c.err {
+				_, err := tokens.Token(context.Background())
+				require.Error(t, err)
+				return
+			}
+			var got []string
+			for range c.want {
+				token, err := tokens.Token(context.Background())
+				require.NoError(t, err)
+				got = append(got, token)
+				if c.invalidate {
+					tokens.Invalidate()
+				}
+			}
+			assert.Equal(t, c.want, got)
+			assert.Equal(t, c.wantCalls, c.conn.calls)
+		})
+	}
+}
+
+func TestDoRequestRotatedToken(t *testing.T) {
+	mockSrv, client := setupMockSrv(t)
+	defer mockSrv.teardown(t)
+	client.SetTokenSource(NewSecretToken(&mockSecretsManager{values: []string{"stale-token", "rotated-token"}}, "citium", time.Hour))
+	var auths []string
+	mockSrv.mux.HandleFunc("/test-rotated-token", func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		auths = append(auths, auth)
+		if auth != "Bearer rotated-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	resp, err := client.DoRequest(context.Background(), http.MethodGet, "/test-rotated-token", nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, []string{"Bearer stale-token", "Bearer rotated-token"}, auths)
+}
@@ -0,0 +1,112 @@
+package scheduler
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/iotdataplane"
+	"github.com/aws/aws-sdk-go/service/iotdataplane/iotdataplaneiface"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/sfn"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/config"
+)
+
+// NewServices returns the clients performing scheduled actions as configured, the optional prom
+// collects metrics across builds
+func NewServices(conf *config.Configuration, sess *session.Session, prom *Prometheus) (*Services, *HTTPClient, error) {
+	if conf.TracingEnabled {
+		sess = xray.AWSSession(sess)
+	}
+	SetSizeLimits(conf.SizeLimits())
+	SetPayloadContracts(NewPayloadContracts(s3.New(sess)))
+	dbconn := dynamodb.New(sess, NewDynamoDBConfig(conf.DynamoDBEndpoint))
+	opts := []Option{}
+	if conf.TracingEnabled {
+		opts = append(opts, WithTracer(xray.Client))
+	}
+	client, err := NewClient(conf, opts...)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "NewClient")
+	}
+	if conf.TokenSecretARN != "" {
+		tokens := NewSecretToken(secretsmanager.New(sess), conf.TokenSecretARN, conf.TokenRefreshInterval)
+		// fail at cold start rather than on every execution
+		if _, err = tokens.Token(context.Background()); err != nil {
+			return nil, nil, errors.Wrap(err, "tokens.Token")
+		}
+		client.SetTokenSource(tokens)
+	}
+	if conf.ResultBucket != "" {
+		client.SetUploader(s3manager.NewUploader(sess))
+	}
+	var iot iotdataplaneiface.IoTDataPlaneAPI
+	if conf.IoTEndpoint != "" {
+		iot = iotdataplane.New(sess, aws.NewConfig().WithEndpoint(conf.IoTEndpoint))
+	}
+	svc := &Services{
+		HTTP:          client,
+		SQS:           sqs.New(sess),
+		Kinesis:       kinesis.New(sess),
+		StepFunctions: sfn.New(sess),
+		Kafka:         NewKafkaClient(conf, sess.Config.Credentials, aws.StringValue(sess.Config.Region)),
+		MQTT:          NewMQTTClient(conf, iot),
+		SSM:           NewSSMClient(conf, ssm.New(sess)),
+		DynamoDB:      dbconn,
+		Prometheus:    prom,
+		Tracing:       conf.TracingEnabled,
+		Callback:      NewCompletionCallback(),
+	}
+	svc.Failure = FailurePolicy{
+		MaxAttempts: conf.MaxAttempts,
+		Backoff:     conf.AttemptBackoff,
+	}
+	switch {
+	case conf.DLQQueueURL != "":
+		svc.Failure.DeadLetter = NewSQSDeadLetterQueue(svc.SQS, conf.DLQQueueURL)
+	case conf.DLQTable != "":
+		svc.Failure.DeadLetter = NewTableDeadLetterQueue(dbconn, conf.DLQTable)
+	}
+	if conf.AuditTable != "" {
+		svc.Audit = NewAuditLog(dbconn, conf.AuditTable, conf.AuditActor)
+	}
+	if conf.CheckpointTable != "" {
+		svc.Checkpoint = NewCheckpoint(dbconn, conf.CheckpointTable)
+	}
+	if conf.LeaseTable != "" {
+		svc.Lease = NewLease(dbconn, conf.LeaseTable, conf.LeaseHolder, conf.LeaseDuration)
+	}
+	if conf.FailureTopicARN != "" {
+		// topic pages on-call thus only failures are published
+		svc.Notifiers = append(svc.Notifiers, WithSeverity(NewSNSNotifier(sns.New(sess), conf.FailureTopicARN), config.SeverityError))
+	}
+	if conf.SlackWebhookURL != "" {
+		svc.Notifiers = append(svc.Notifiers, WithSeverity(NewSlackNotifier(conf.SlackWebhookURL), conf.SlackSeverity))
+	}
+	if conf.PagerDutyRoutingKey != "" {
+		svc.Notifiers = append(svc.Notifiers, NewPagerDutyNotifier(conf.PagerDutyRoutingKey, conf.PagerDutyEventsURL, conf.EscalationThreshold))
+	}
+	if conf.OpsgenieAPIKey != "" {
+		svc.Notifiers = append(svc.Notifiers, NewOpsgenieNotifier(conf.OpsgenieAPIKey, conf.OpsgenieAlertsURL, conf.EscalationThreshold))
+	}
+	return svc, client, nil
+}
+
+// NewDynamoDBConfig returns the client config of DynamoDB at endpoint, the regional one if empty
+func NewDynamoDBConfig(endpoint string) *aws.Config {
+	cfg := aws.NewConfig()
+	if endpoint != "" {
+		cfg = cfg.WithEndpoint(endpoint)
+	}
+	return cfg
+}
@@ -0,0 +1,51 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sfn"
+	"github.com/aws/aws-sdk-go/service/sfn/sfniface"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// startExecution performs sfn target by starting state machine execution with the rendered payload
+// as input document
+func startExecution(ctx context.Context, conn sfniface.SFNAPI, req *schema.ScheduledRequest) (*schema.Response, error) {
+	if conn == nil {
+		return nil, errors.New("sfn client is not configured")
+	}
+	if req.StepFunctions == nil {
+		return nil, errors.New("missing sfn target")
+	}
+	now := time.Now().UTC()
+	input, err := renderPayload(req, now, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "renderPayload")
+	}
+	params := &sfn.StartExecutionInput{
+		StateMachineArn: aws.String(req.StepFunctions.StateMachineARN),
+	}
+	if input != "" {
+		params.Input = aws.String(input)
+	}
+	if req.StepFunctions.Name != "" {
+		name, rerr := renderTemplate(req.StepFunctions.Name, now)
+		if rerr != nil {
+			return nil, errors.Wrap(rerr, "renderTemplate name")
+		}
+		params.Name = aws.String(name)
+	}
+	log.Printf("start execution id=%s state_machine_arn=%s \n", req.ID, req.StepFunctions.StateMachineARN)
+	output, err := conn.StartExecutionWithContext(ctx, params)
+	if err != nil {
+		return nil, errors.Wrapf(err, "conn.StartExecution state_machine_arn=%s", req.StepFunctions.StateMachineARN)
+	}
+	return &schema.Response{
+		Body: aws.StringValue(output.ExecutionArn),
+	}, nil
+}
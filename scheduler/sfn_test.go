@@ -0,0 +1,112 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sfn"
+	"github.com/aws/aws-sdk-go/service/sfn/sfniface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/schema"
+)
+
+type mockSFN struct {
+	sfniface.SFNAPI
+	lastStartInput *sfn.StartExecutionInput
+	startErr       error
+}
+
+func (ms *mockSFN) StartExecutionWithContext(ctx aws.Context, input *sfn.StartExecutionInput, opts ...request.Option) (*sfn.StartExecutionOutput, error) {
+	ms.lastStartInput = input
+	if ms.startErr != nil {
+		return nil, ms.startErr
+	}
+	return &sfn.StartExecutionOutput{
+		ExecutionArn: aws.String("arn:aws:states:us-east-1:123456789012:execution:test:run"),
+	}, nil
+}
+
+func TestStartExecution(t *testing.T) {
+	arn := "arn:aws:states:us-east-1:123456789012:stateMachine:test"
+	for _, c := range []struct {
+		caseName string
+		conn     *mockSFN
+		req      *schema.ScheduledRequest
+		err      bool
+		verify   func(t *testing.T, input *sfn.StartExecutionInput)
+	}{
+		{
+			caseName: "client_not_configured",
+			req: &schema.ScheduledRequest{
+				ID:            "test-sfn-no-client",
+				StepFunctions: &schema.StepFunctionsTarget{StateMachineARN: arn},
+			},
+			err: true,
+		},
+		{
+			caseName: "missing_target",
+			conn:     new(mockSFN),
+			req:      &schema.ScheduledRequest{ID: "test-sfn-missing-target"},
+			err:      true,
+		},
+		{
+			caseName: "start_error",
+			conn:     &mockSFN{startErr: errors.New("ExecutionAlreadyExists")},
+			req: &schema.ScheduledRequest{
+				ID:            "test-sfn-start-error",
+				StepFunctions: &schema.StepFunctionsTarget{StateMachineARN: arn},
+			},
+			err: true,
+		},
+		{
+			caseName: "empty_input",
+			conn:     new(mockSFN),
+			req: &schema.ScheduledRequest{
+				ID:            "test-sfn-empty-input",
+				StepFunctions: &schema.StepFunctionsTarget{StateMachineARN: arn},
+			},
+			verify: func(t *testing.T, input *sfn.StartExecutionInput) {
+				assert.Nil(t, input.Input)
+				assert.Nil(t, input.Name)
+			},
+		},
+		{
+			caseName: "rendered_input_and_name",
+			conn:     new(mockSFN),
+			req: &schema.ScheduledRequest{
+				ID:      "test-sfn-rendered",
+				Payload: "{\"report\":\"monthly\"}",
+				StepFunctions: &schema.StepFunctionsTarget{
+					StateMachineARN: arn,
+					Name:            "report-{{ now | unix }}",
+				},
+			},
+			verify: func(t *testing.T, input *sfn.StartExecutionInput) {
+				assert.Equal(t, "{\"report\":\"monthly\"}", *input.Input)
+				assert.Regexp(t, "^report-[0-9]+$", *input.Name)
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			var conn sfniface.SFNAPI
+			if c.conn != nil {
+				conn = c.conn
+			}
+			resp, err := startExecution(context.Background(), conn, c.req)
+			if c.err == true {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "arn:aws:states:us-east-1:123456789012:execution:test:run", resp.Body)
+				assert.Equal(t, arn, *c.conn.lastStartInput.StateMachineArn)
+				c.verify(t, c.conn.lastStartInput)
+			}
+		})
+	}
+}
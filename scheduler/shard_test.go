@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/config"
+)
+
+func TestFetchShard(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	shardOf := func(n int) *int { return &n }
+	for _, c := range []struct {
+		caseName   string
+		shard      *int
+		wantFilter string
+	}{
+		{
+			caseName:   "unsharded",
+			wantFilter: "EffectiveAfter <= :d and Locking = :l",
+		},
+		{
+			caseName:   "first_shard",
+			shard:      shardOf(0),
+			wantFilter: "EffectiveAfter <= :d and Locking = :l and (attribute_not_exists(#s) or #s = :s)",
+		},
+		{
+			caseName:   "other_shard",
+			shard:      shardOf(3),
+			wantFilter: "EffectiveAfter <= :d and Locking = :l and #s = :s",
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			_, _, err := fetchFrom(context.Background(), mockConn, "FetchShard_test", time.Now(), 0, 0, "", c.shard, nil)
+			require.NoError(t, err)
+			assert.Contains(t, mockConn.lastScanQ, fmt.Sprintf("FilterExpression: %q", c.wantFilter))
+			if c.shard != nil {
+				assert.Contains(t, mockConn.lastScanQ, fmt.Sprintf("N: %q", fmt.Sprint(*c.shard)))
+			}
+		})
+	}
+}
+
+func TestTriggerShard(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	mockConn.clear()
+	mockConn.items = []map[string]*dynamodb.AttributeValue{
+		{"ID": {S: aws.String("test-shard")}, "Shard": {N: aws.String("2")}},
+	}
+	mockClient := new(mockHTTPClient)
+	mockClient.clear()
+	conf := &config.Configuration{TableName: "TriggerShard_test"}
+	svc := &Services{HTTP: mockClient, Checkpoint: NewCheckpoint(mockConn, "checkpoint_test")}
+	summary, err := TriggerShard(context.Background(), conf, mockConn, svc, 2)
+	require.NoError(t, err)
+	require.NotNil(t, summary.Shard)
+	assert.Equal(t, 2, *summary.Shard)
+	assert.Equal(t, 1, summary.Fetched)
+	// the shard resumes from its own checkpoint
+	require.NotNil(t, mockConn.lastPutItem)
+	assert.Equal(t, "TriggerShard_test#2", aws.StringValue(mockConn.lastPutItem.Item["Name"].S))
+
+	_, err = TriggerShard(context.Background(), conf, mockConn, svc, -1)
+	assert.Equal(t, ErrValidation, errors.Cause(err))
+}
@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SlackNotifier posts notifications to a Slack incoming webhook
+type SlackNotifier struct {
+	webhookURL string
+	client     *http.Client
+}
+
+// NewSlackNotifier returns notifier posting to given incoming webhook url
+func NewSlackNotifier(webhookURL string) *SlackNotifier {
+	return &SlackNotifier{
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// NotifyFailure posts an alert of the failed execution
+func (n *SlackNotifier) NotifyFailure(ctx context.Context, notification *FailureNotification) error {
+	text := fmt.Sprintf(":rotating_light: Execution failed id=`%s` target=%s attempts=%d", notification.RequestID, notification.Target, notification.Attempts)
+	if notification.URL != "" {
+		text += fmt.Sprintf(" url=%s", notification.URL)
+	}
+	if notification.Description != "" {
+		text += fmt.Sprintf("\n>%s", notification.Description)
+	}
+	text += fmt.Sprintf("\n```%s```", notification.Error)
+	return n.post(ctx, text)
+}
+
+// NotifySummary posts the outcomes of the run
+func (n *SlackNotifier) NotifySummary(ctx context.Context, summary *RunSummary) error {
+	icon := ":white_check_mark:"
+	if summary.Failed > 0 {
+		icon = ":warning:"
+	}
+	text := fmt.Sprintf("%s Run summary table=`%s` fetched=%d succeeded=%d failed=%d skipped=%d lock_conflicts=%d duration=%s",
+		icon, summary.TableName, summary.Fetched, summary.Succeeded, summary.Failed, summary.Skipped, summary.LockConflicts, summary.Duration)
+	return n.post(ctx, text)
+}
+
+func (n *SlackNotifier) post(ctx context.Context, text string) error {
+	payload, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+	req, err := http.NewRequest(http.MethodPost, n.webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrap(err, "http.NewRequest")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	log.Printf("post slack notification \n")
+	resp, err := n.client.Do(req.WithContext(ctx))
+	if err != nil {
+		// url holds the webhook secret thus it is left out of the error
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return errors.Wrap(err, "n.client.Do")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected slack webhook response code=%d", resp.StatusCode)
+	}
+	return nil
+}
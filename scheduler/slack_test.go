@@ -0,0 +1,95 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlackNotifier(t *testing.T) {
+	failure := &FailureNotification{
+		RequestID:   "test-slack",
+		Description: "Nightly export of the jobs report",
+		Target:      "http",
+		URL:         "https://api.example.com/jobs",
+		Error:       "c.Do: connection refused",
+		Attempts:    1,
+	}
+	summary := &RunSummary{
+		TableName: "citium_schedule",
+		Fetched:   2,
+		Executed:  2,
+		Succeeded: 1,
+		Failed:    1,
+		Duration:  1500 * time.Millisecond,
+	}
+	for _, c := range []struct {
+		caseName string
+		code     int
+		notify   func(n *SlackNotifier) error
+		text     []string
+		err      bool
+	}{
+		{
+			caseName: "failure",
+			code:     http.StatusOK,
+			notify: func(n *SlackNotifier) error {
+				return n.NotifyFailure(context.Background(), failure)
+			},
+			text: []string{"Execution failed id=`test-slack`", "url=https://api.example.com/jobs", "\n>Nightly export of the jobs report", "```c.Do: connection refused```"},
+		},
+		{
+			caseName: "summary",
+			code:     http.StatusOK,
+			notify: func(n *SlackNotifier) error {
+				return n.NotifySummary(context.Background(), summary)
+			},
+			text: []string{":warning:", "table=`citium_schedule`", "fetched=2 succeeded=1 failed=1", "duration=1.5s"},
+		},
+		{
+			caseName: "rejected",
+			code:     http.StatusForbidden,
+			notify: func(n *SlackNotifier) error {
+				return n.NotifySummary(context.Background(), summary)
+			},
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			var payload map[string]string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+				w.WriteHeader(c.code)
+			}))
+			defer srv.Close()
+
+			err := c.notify(NewSlackNotifier(srv.URL + "/services/T000/B000/secret"))
+			if c.err == true {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			for _, text := range c.text {
+				assert.Contains(t, payload["text"], text)
+			}
+		})
+	}
+}
+
+func TestSlackNotifierUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	webhookURL := srv.URL + "/services/T000/B000/secret"
+	srv.Close()
+	err := NewSlackNotifier(webhookURL).NotifySummary(context.Background(), &RunSummary{})
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret")
+}
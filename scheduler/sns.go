@@ -0,0 +1,59 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/pkg/errors"
+)
+
+// maxSubjectLen is the limit of SNS message subject
+const maxSubjectLen = 100
+
+// SNSNotifier publishes notifications to a topic as JSON messages
+type SNSNotifier struct {
+	conn     snsiface.SNSAPI
+	topicARN string
+}
+
+// NewSNSNotifier returns notifier publishing to given topic
+func NewSNSNotifier(conn snsiface.SNSAPI, topicARN string) *SNSNotifier {
+	return &SNSNotifier{
+		conn:     conn,
+		topicARN: topicARN,
+	}
+}
+
+// NotifyFailure publishes the failure of request execution so on-call gets paged
+func (n *SNSNotifier) NotifyFailure(ctx context.Context, notification *FailureNotification) error {
+	return n.publish(fmt.Sprintf("citium execution failed id=%s", notification.RequestID), notification)
+}
+
+// NotifySummary publishes the run summary
+func (n *SNSNotifier) NotifySummary(ctx context.Context, summary *RunSummary) error {
+	return n.publish(fmt.Sprintf("citium run summary table_name=%s", summary.TableName), summary)
+}
+
+func (n *SNSNotifier) publish(subject string, v interface{}) error {
+	message, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+	if len(subject) > maxSubjectLen {
+		subject = subject[:maxSubjectLen]
+	}
+	log.Printf("publish notification topic_arn=%s subject=%s \n", n.topicARN, subject)
+	if _, err = n.conn.Publish(&sns.PublishInput{
+		TopicArn: aws.String(n.topicARN),
+		Subject:  aws.String(subject),
+		Message:  aws.String(string(message)),
+	}); err != nil {
+		return errors.Wrapf(err, "conn.Publish topic_arn=%s subject=%s", n.topicARN, subject)
+	}
+	return nil
+}
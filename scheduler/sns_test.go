@@ -0,0 +1,104 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/schema"
+)
+
+type mockSNS struct {
+	snsiface.SNSAPI
+	lastPublishInput *sns.PublishInput
+	publishErr       error
+}
+
+func (ms *mockSNS) Publish(input *sns.PublishInput) (*sns.PublishOutput, error) {
+	ms.lastPublishInput = input
+	if ms.publishErr != nil {
+		return nil, ms.publishErr
+	}
+	return &sns.PublishOutput{MessageId: aws.String("test-message-id")}, nil
+}
+
+func TestSNSNotifyFailure(t *testing.T) {
+	topic := "arn:aws:sns:us-east-1:123456789012:citium-failures"
+	for _, c := range []struct {
+		caseName string
+		conn     *mockSNS
+		req      *schema.ScheduledRequest
+		err      bool
+	}{
+		{
+			caseName: "publish_error",
+			conn:     &mockSNS{publishErr: errors.New("internal error")},
+			req:      &schema.ScheduledRequest{ID: "test-notify-error"},
+			err:      true,
+		},
+		{
+			caseName: "ok",
+			conn:     new(mockSNS),
+			req: &schema.ScheduledRequest{
+				ID:       "test-notify",
+				URL:      "https://api.example.com/jobs",
+				Attempts: 2,
+			},
+		},
+		{
+			caseName: "long_subject",
+			conn:     new(mockSNS),
+			req:      &schema.ScheduledRequest{ID: strings.Repeat("x", 120)},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			notification := newFailureNotification(c.req, errors.New("c.Do: connection refused"), time.Now().UTC())
+			err := NewSNSNotifier(c.conn, topic).NotifyFailure(context.Background(), notification)
+			if c.err == true {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			input := c.conn.lastPublishInput
+			require.NotNil(t, input)
+			assert.Equal(t, topic, *input.TopicArn)
+			assert.True(t, len(*input.Subject) <= maxSubjectLen)
+			var published FailureNotification
+			require.NoError(t, json.Unmarshal([]byte(*input.Message), &published))
+			assert.Equal(t, c.req.ID, published.RequestID)
+			assert.Equal(t, schema.TargetHTTP, published.Target)
+			assert.Equal(t, c.req.URL, published.URL)
+			assert.Equal(t, "c.Do: connection refused", published.Error)
+			assert.Equal(t, c.req.Attempts+1, published.Attempts)
+			assert.False(t, published.FailedAt.IsZero())
+		})
+	}
+}
+
+func TestSNSNotifySummary(t *testing.T) {
+	conn := new(mockSNS)
+	err := NewSNSNotifier(conn, "arn:aws:sns:us-east-1:123456789012:citium").NotifySummary(context.Background(), &RunSummary{
+		TableName: "citium_schedule",
+		Fetched:   3,
+		Executed:  3,
+		Succeeded: 2,
+		Failed:    1,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, conn.lastPublishInput)
+	assert.Equal(t, "citium run summary table_name=citium_schedule", *conn.lastPublishInput.Subject)
+	var published RunSummary
+	require.NoError(t, json.Unmarshal([]byte(*conn.lastPublishInput.Message), &published))
+	assert.Equal(t, 3, published.Fetched)
+	assert.Equal(t, 1, published.Failed)
+}
@@ -0,0 +1,55 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// sendMessage performs sqs target by sending the rendered payload as message body
+func sendMessage(ctx context.Context, conn sqsiface.SQSAPI, req *schema.ScheduledRequest) (*schema.Response, error) {
+	if conn == nil {
+		return nil, errors.New("sqs client is not configured")
+	}
+	if req.SQS == nil {
+		return nil, errors.New("missing sqs target")
+	}
+	body, err := renderPayload(req, time.Now().UTC(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "renderPayload")
+	}
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(req.SQS.QueueURL),
+		MessageBody: aws.String(body),
+	}
+	if len(req.SQS.MessageAttributes) > 0 {
+		input.MessageAttributes = map[string]*sqs.MessageAttributeValue{}
+		for k, v := range req.SQS.MessageAttributes {
+			input.MessageAttributes[k] = &sqs.MessageAttributeValue{
+				DataType:    aws.String("String"),
+				StringValue: aws.String(v),
+			}
+		}
+	}
+	if req.SQS.GroupID != "" {
+		input.MessageGroupId = aws.String(req.SQS.GroupID)
+	}
+	if req.SQS.DeduplicationID != "" {
+		input.MessageDeduplicationId = aws.String(req.SQS.DeduplicationID)
+	}
+	log.Printf("send message id=%s queue_url=%s \n", req.ID, req.SQS.QueueURL)
+	output, err := conn.SendMessageWithContext(ctx, input)
+	if err != nil {
+		return nil, errors.Wrapf(err, "conn.SendMessage queue_url=%s", req.SQS.QueueURL)
+	}
+	return &schema.Response{
+		Body: aws.StringValue(output.MessageId),
+	}, nil
+}
@@ -0,0 +1,129 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/schema"
+)
+
+type mockSQS struct {
+	sqsiface.SQSAPI
+	lastSendInput *sqs.SendMessageInput
+	sendErr       error
+	// batches of messages returned by successive receive calls
+	received [][]*sqs.Message
+	deleted  []string
+}
+
+func (ms *mockSQS) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	output := &sqs.ReceiveMessageOutput{}
+	if len(ms.received) > 0 {
+		output.Messages, ms.received = ms.received[0], ms.received[1:]
+	}
+	return output, nil
+}
+
+func (ms *mockSQS) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	ms.deleted = append(ms.deleted, *input.ReceiptHandle)
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (ms *mockSQS) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	ms.lastSendInput = input
+	if ms.sendErr != nil {
+		return nil, ms.sendErr
+	}
+	return &sqs.SendMessageOutput{
+		MessageId: aws.String("test-message-id"),
+	}, nil
+}
+
+func TestSendMessage(t *testing.T) {
+	for _, c := range []struct {
+		caseName string
+		conn     *mockSQS
+		req      *schema.ScheduledRequest
+		err      bool
+	}{
+		{
+			caseName: "client_not_configured",
+			req: &schema.ScheduledRequest{
+				ID:  "test-sqs-no-client",
+				SQS: &schema.SQSTarget{QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/test"},
+			},
+			err: true,
+		},
+		{
+			caseName: "missing_target",
+			conn:     new(mockSQS),
+			req: &schema.ScheduledRequest{
+				ID: "test-sqs-missing-target",
+			},
+			err: true,
+		},
+		{
+			caseName: "invalid_base64_payload",
+			conn:     new(mockSQS),
+			req: &schema.ScheduledRequest{
+				ID:              "test-sqs-invalid-base64",
+				Payload:         "not base64!",
+				PayloadEncoding: schema.PayloadBase64,
+				SQS:             &schema.SQSTarget{QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/test"},
+			},
+			err: true,
+		},
+		{
+			caseName: "send_error",
+			conn:     &mockSQS{sendErr: errors.New("internal error")},
+			req: &schema.ScheduledRequest{
+				ID:  "test-sqs-send-error",
+				SQS: &schema.SQSTarget{QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/test"},
+			},
+			err: true,
+		},
+		{
+			caseName: "ok",
+			conn:     new(mockSQS),
+			req: &schema.ScheduledRequest{
+				ID:      "test-sqs-ok",
+				Payload: "{\"job\":\"test-job\"}",
+				SQS: &schema.SQSTarget{
+					QueueURL:          "https://sqs.us-east-1.amazonaws.com/123456789012/test.fifo",
+					MessageAttributes: map[string]string{"source": "citium"},
+					GroupID:           "test-group",
+					DeduplicationID:   "test-dedup",
+				},
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			var conn sqsiface.SQSAPI
+			if c.conn != nil {
+				conn = c.conn
+			}
+			resp, err := sendMessage(context.Background(), conn, c.req)
+			if c.err == true {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "test-message-id", resp.Body)
+				input := c.conn.lastSendInput
+				assert.Equal(t, c.req.SQS.QueueURL, *input.QueueUrl)
+				assert.Equal(t, c.req.Payload, *input.MessageBody)
+				assert.Equal(t, "citium", *input.MessageAttributes["source"].StringValue)
+				assert.Equal(t, "test-group", *input.MessageGroupId)
+				assert.Equal(t, "test-dedup", *input.MessageDeduplicationId)
+			}
+		})
+	}
+}
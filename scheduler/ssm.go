@@ -0,0 +1,124 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/schema"
+)
+
+// SSMClient sends Systems Manager commands and polls their status until completion
+type SSMClient struct {
+	conn         ssmiface.SSMAPI
+	pollInterval time.Duration
+	pollTimeout  time.Duration
+}
+
+// NewSSMClient returns ssm client polling by configured interval & timeout
+func NewSSMClient(conf *config.Configuration, conn ssmiface.SSMAPI) *SSMClient {
+	return &SSMClient{
+		conn:         conn,
+		pollInterval: conf.SSMPollInterval,
+		pollTimeout:  conf.SSMPollTimeout,
+	}
+}
+
+// commandResult is the stored execution result of ssm target
+type commandResult struct {
+	CommandID      string `json:"command_id"`
+	Status         string `json:"status"`
+	TargetCount    int64  `json:"target_count"`
+	CompletedCount int64  `json:"completed_count"`
+	ErrorCount     int64  `json:"error_count"`
+}
+
+// runCommand performs ssm target by sending the command then polling its status until it is
+// completed or poll timeout elapses, in which case the last seen status is stored
+func (c *SSMClient) runCommand(ctx context.Context, req *schema.ScheduledRequest) (*schema.Response, error) {
+	if c == nil || c.conn == nil {
+		return nil, errors.New("ssm client is not configured")
+	}
+	if req.SSM == nil {
+		return nil, errors.New("missing ssm target")
+	}
+	input := &ssm.SendCommandInput{
+		DocumentName: aws.String(req.SSM.DocumentName),
+	}
+	if len(req.SSM.InstanceIDs) > 0 {
+		input.InstanceIds = aws.StringSlice(req.SSM.InstanceIDs)
+	}
+	for k, v := range req.SSM.Targets {
+		input.Targets = append(input.Targets, &ssm.Target{Key: aws.String(k), Values: aws.StringSlice(v)})
+	}
+	if len(req.SSM.Parameters) > 0 {
+		input.Parameters = make(map[string][]*string, len(req.SSM.Parameters))
+		for k, v := range req.SSM.Parameters {
+			input.Parameters[k] = aws.StringSlice(v)
+		}
+	}
+	if req.SSM.Comment != "" {
+		input.Comment = aws.String(req.SSM.Comment)
+	}
+	log.Printf("send command id=%s document_name=%s \n", req.ID, req.SSM.DocumentName)
+	output, err := c.conn.SendCommandWithContext(ctx, input)
+	if err != nil {
+		return nil, errors.Wrapf(err, "conn.SendCommand document_name=%s", req.SSM.DocumentName)
+	}
+	cmd, err := c.poll(ctx, output.Command)
+	if err != nil {
+		return nil, errors.Wrapf(err, "poll command_id=%s", aws.StringValue(output.Command.CommandId))
+	}
+	result := commandResult{
+		CommandID:      aws.StringValue(cmd.CommandId),
+		Status:         aws.StringValue(cmd.Status),
+		TargetCount:    aws.Int64Value(cmd.TargetCount),
+		CompletedCount: aws.Int64Value(cmd.CompletedCount),
+		ErrorCount:     aws.Int64Value(cmd.ErrorCount),
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Marshal")
+	}
+	switch result.Status {
+	case ssm.CommandStatusFailed, ssm.CommandStatusTimedOut, ssm.CommandStatusCancelled:
+		return nil, errors.Errorf("command %s", body)
+	}
+	return &schema.Response{Body: string(body)}, nil
+}
+
+// poll refreshes command until it reaches a terminal status or poll timeout elapses
+func (c *SSMClient) poll(ctx context.Context, cmd *ssm.Command) (*ssm.Command, error) {
+	deadline := time.Now().Add(c.pollTimeout)
+	for !commandDone(cmd) && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.pollInterval):
+		}
+		output, err := c.conn.ListCommandsWithContext(ctx, &ssm.ListCommandsInput{CommandId: cmd.CommandId})
+		if err != nil {
+			return nil, errors.Wrap(err, "conn.ListCommands")
+		}
+		if len(output.Commands) == 0 {
+			return nil, errors.New("command not found")
+		}
+		cmd = output.Commands[0]
+	}
+	return cmd, nil
+}
+
+func commandDone(cmd *ssm.Command) bool {
+	switch aws.StringValue(cmd.Status) {
+	case ssm.CommandStatusPending, ssm.CommandStatusInProgress, ssm.CommandStatusCancelling:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/schema"
+)
+
+type mockSSM struct {
+	ssmiface.SSMAPI
+	lastSendInput *ssm.SendCommandInput
+	sendErr       error
+	// statuses returned by successive ListCommands calls, the last one is repeated
+	statuses []string
+	listed   int
+}
+
+func (ms *mockSSM) command(status string) *ssm.Command {
+	return &ssm.Command{
+		CommandId:      aws.String("test-command-id"),
+		Status:         aws.String(status),
+		TargetCount:    aws.Int64(2),
+		CompletedCount: aws.Int64(2),
+	}
+}
+
+func (ms *mockSSM) SendCommandWithContext(ctx aws.Context, input *ssm.SendCommandInput, opts ...request.Option) (*ssm.SendCommandOutput, error) {
+	ms.lastSendInput = input
+	if ms.sendErr != nil {
+		return nil, ms.sendErr
+	}
+	return &ssm.SendCommandOutput{Command: ms.command(ssm.CommandStatusPending)}, nil
+}
+
+func (ms *mockSSM) ListCommandsWithContext(ctx aws.Context, input *ssm.ListCommandsInput, opts ...request.Option) (*ssm.ListCommandsOutput, error) {
+	i := ms.listed
+	if i >= len(ms.statuses) {
+		i = len(ms.statuses) - 1
+	}
+	ms.listed++
+	return &ssm.ListCommandsOutput{Commands: []*ssm.Command{ms.command(ms.statuses[i])}}, nil
+}
+
+func TestRunCommand(t *testing.T) {
+	target := &schema.SSMTarget{
+		DocumentName: "AWS-RunShellScript",
+		Targets:      map[string][]string{"tag:Env": {"prod"}},
+		Parameters:   map[string][]string{"commands": {"uptime"}},
+	}
+	for _, c := range []struct {
+		caseName    string
+		conn        *mockSSM
+		pollTimeout time.Duration
+		req         *schema.ScheduledRequest
+		status      string
+		err         bool
+	}{
+		{
+			caseName: "client_not_configured",
+			req:      &schema.ScheduledRequest{ID: "test-ssm-no-client", SSM: target},
+			err:      true,
+		},
+		{
+			caseName: "missing_target",
+			conn:     new(mockSSM),
+			req:      &schema.ScheduledRequest{ID: "test-ssm-missing-target"},
+			err:      true,
+		},
+		{
+			caseName: "send_error",
+			conn:     &mockSSM{sendErr: errors.New("InvalidDocument")},
+			req:      &schema.ScheduledRequest{ID: "test-ssm-send-error", SSM: target},
+			err:      true,
+		},
+		{
+			caseName:    "polled_until_success",
+			conn:        &mockSSM{statuses: []string{ssm.CommandStatusInProgress, ssm.CommandStatusSuccess}},
+			pollTimeout: time.Second,
+			req:         &schema.ScheduledRequest{ID: "test-ssm-success", SSM: target},
+			status:      ssm.CommandStatusSuccess,
+		},
+		{
+			caseName:    "polled_until_failed",
+			conn:        &mockSSM{statuses: []string{ssm.CommandStatusFailed}},
+			pollTimeout: time.Second,
+			req:         &schema.ScheduledRequest{ID: "test-ssm-failed", SSM: target},
+			err:         true,
+		},
+		{
+			caseName:    "poll_timeout",
+			conn:        &mockSSM{statuses: []string{ssm.CommandStatusInProgress}},
+			pollTimeout: 20 * time.Millisecond,
+			req:         &schema.ScheduledRequest{ID: "test-ssm-poll-timeout", SSM: target},
+			status:      ssm.CommandStatusInProgress,
+		},
+		{
+			caseName: "polling_disabled",
+			conn:     new(mockSSM),
+			req:      &schema.ScheduledRequest{ID: "test-ssm-no-polling", SSM: target},
+			status:   ssm.CommandStatusPending,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			var client *SSMClient
+			if c.conn != nil {
+				client = NewSSMClient(&config.Configuration{
+					SSMPollInterval: time.Millisecond,
+					SSMPollTimeout:  c.pollTimeout,
+				}, c.conn)
+			}
+			resp, err := client.runCommand(context.Background(), c.req)
+			if c.err == true {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.JSONEq(t, fmt.Sprintf(`{"command_id":"test-command-id","status":"%s","target_count":2,"completed_count":2,"error_count":0}`, c.status), resp.Body)
+				input := c.conn.lastSendInput
+				assert.Equal(t, "AWS-RunShellScript", *input.DocumentName)
+				require.Len(t, input.Targets, 1)
+				assert.Equal(t, "tag:Env", *input.Targets[0].Key)
+				assert.Equal(t, []string{"prod"}, aws.StringValueSlice(input.Targets[0].Values))
+				assert.Equal(t, []string{"uptime"}, aws.StringValueSlice(input.Parameters["commands"]))
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package scheduler
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// States of a stored request counted by table stats, each request is in exactly one of them
+const (
+	// Unlocked, waiting for its effective date or the next run
+	StatePending = "pending"
+	// Locked without result, being executed or stuck by an interrupted execution
+	StateLocked = "locked"
+	// Locked by a failed execution until it is retried
+	StateFailed = "failed"
+	// Executed and kept by PersistentStore
+	StateExecuted = "executed"
+)
+
+// TableStats is the operational overview of the requests of a table
+type TableStats struct {
+	TableName string `json:"table_name"`
+	Total     int    `json:"total"`
+	// Count of requests by state
+	States map[string]int `json:"states"`
+	// Count of requests by Status, derived for the records stored before it was maintained
+	Statuses map[string]int `json:"statuses"`
+	// Pending requests whose effective date is past
+	Due int `json:"due"`
+	// Pending requests to be run next, earliest first
+	NextDue []*schema.ScheduledRequest `json:"next_due"`
+	// Running request which was due the earliest, the likeliest stuck one, nil if none is running
+	OldestLock *schema.ScheduledRequest `json:"oldest_lock,omitempty"`
+	ComputedAt time.Time                `json:"computed_at"`
+}
+
+// requestState returns the state of stored request
+func requestState(req *schema.ScheduledRequest) string {
+	switch {
+	case req.FailureReason != "":
+		return StateFailed
+	case !req.ExecutedAt.IsZero():
+		return StateExecuted
+	case req.Locking:
+		return StateLocked
+	default:
+		return StatePending
+	}
+}
+
+// ComputeStats scans the whole table to count its requests by state, listing at most next of
+// the pending requests to be run next
+func ComputeStats(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, next int, now time.Time) (*TableStats, error) {
+	reqs, _, err := List(ctx, conn, tableName, ListOptions{Filter: ListFilter{}})
+	if err != nil {
+		return nil, errors.Wrap(err, "List")
+	}
+	stats := &TableStats{
+		TableName: tableName,
+		Total:     len(reqs),
+		States: map[string]int{
+			StatePending:  0,
+			StateLocked:   0,
+			StateFailed:   0,
+			StateExecuted: 0,
+		},
+		Statuses:   map[string]int{},
+		NextDue:    []*schema.ScheduledRequest{},
+		ComputedAt: now,
+	}
+	for _, req := range reqs {
+		state := requestState(req)
+		stats.States[state]++
+		stats.Statuses[req.CurrentStatus()]++
+		switch state {
+		case StatePending:
+			if !req.EffectiveAfter.After(now) {
+				stats.Due++
+			}
+			stats.NextDue = append(stats.NextDue, req)
+		case StateLocked:
+			// cancelled and expired requests stay locked for good
+			if req.CurrentStatus() != schema.StatusRunning {
+				continue
+			}
+			if stats.OldestLock == nil || req.EffectiveAfter.Before(stats.OldestLock.EffectiveAfter) {
+				stats.OldestLock = req
+			}
+		}
+	}
+	sort.SliceStable(stats.NextDue, func(i, j int) bool {
+		return stats.NextDue[i].EffectiveAfter.Before(stats.NextDue[j].EffectiveAfter)
+	})
+	if len(stats.NextDue) > next {
+		stats.NextDue = stats.NextDue[:next]
+	}
+	return stats, nil
+}
@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/schema"
+)
+
+func TestComputeStats(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "stats_test"
+	now := time.Date(2018, 9, 5, 0, 0, 0, 0, time.UTC)
+	item := func(id, effective string, attrs map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+		item := map[string]*dynamodb.AttributeValue{
+			"ID":             {S: aws.String(id)},
+			"EffectiveAfter": {S: aws.String(effective)},
+			"ExecutedAt":     {S: aws.String("0001-01-01T00:00:00Z")},
+		}
+		for k, v := range attrs {
+			item[k] = v
+		}
+		return item
+	}
+	locked := map[string]*dynamodb.AttributeValue{"Locking": {BOOL: aws.Bool(true)}}
+	for _, c := range []struct {
+		caseName       string
+		setup          func()
+		next           int
+		wantStates     map[string]int
+		wantStatuses   map[string]int
+		wantDue        int
+		wantNext       []string
+		wantOldestLock string
+		err            bool
+	}{
+		{
+			caseName: "mixed",
+			setup: func() {
+				mockConn.items = []map[string]*dynamodb.AttributeValue{
+					item("test-stats-future", "2018-09-06T00:00:00Z", nil),
+					item("test-stats-due", "2018-09-04T00:00:00Z", nil),
+					item("test-stats-later", "2018-09-07T00:00:00Z", nil),
+					item("test-stats-locked", "2018-09-03T00:00:00Z", locked),
+					item("test-stats-stuck", "2018-09-01T00:00:00Z", locked),
+					item("test-stats-failed", "2018-09-01T00:00:00Z", map[string]*dynamodb.AttributeValue{
+						"Locking":       {BOOL: aws.Bool(true)},
+						"FailureReason": {S: aws.String("status 500")},
+					}),
+					item("test-stats-executed", "2018-09-01T00:00:00Z", map[string]*dynamodb.AttributeValue{
+						"Locking":    {BOOL: aws.Bool(true)},
+						"ExecutedAt": {S: aws.String("2018-09-01T00:00:01Z")},
+					}),
+					item("test-stats-cancelled", "2018-08-31T00:00:00Z", map[string]*dynamodb.AttributeValue{
+						"Locking": {BOOL: aws.Bool(true)},
+						"Status":  {S: aws.String(schema.StatusCancelled)},
+					}),
+				}
+			},
+			next:       2,
+			wantStates: map[string]int{StatePending: 3, StateLocked: 3, StateFailed: 1, StateExecuted: 1},
+			wantStatuses: map[string]int{
+				schema.StatusPending:   3,
+				schema.StatusRunning:   2,
+				schema.StatusFailed:    1,
+				schema.StatusSucceeded: 1,
+				schema.StatusCancelled: 1,
+			},
+			wantDue:        1,
+			wantNext:       []string{"test-stats-due", "test-stats-future"},
+			wantOldestLock: "test-stats-stuck",
+		},
+		{
+			caseName:     "empty",
+			setup:        func() {},
+			next:         10,
+			wantStates:   map[string]int{StatePending: 0, StateLocked: 0, StateFailed: 0, StateExecuted: 0},
+			wantStatuses: map[string]int{},
+			wantNext:     []string{},
+		},
+		{
+			caseName: "scan_error",
+			setup: func() {
+				mockConn.scanErr = errors.New("internal error")
+			},
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			c.setup()
+			stats, err := ComputeStats(context.Background(), mockConn, table, c.next, now)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.wantStates, stats.States)
+			assert.Equal(t, c.wantStatuses, stats.Statuses)
+			assert.Equal(t, c.wantDue, stats.Due)
+			next := []string{}
+			for _, req := range stats.NextDue {
+				next = append(next, req.ID)
+			}
+			assert.Equal(t, c.wantNext, next)
+			if c.wantOldestLock == "" {
+				assert.Nil(t, stats.OldestLock)
+			} else {
+				require.NotNil(t, stats.OldestLock)
+				assert.Equal(t, c.wantOldestLock, stats.OldestLock.ID)
+			}
+		})
+	}
+}
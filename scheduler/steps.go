@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// runSteps performs steps target by executing its http calls in order, passing values captured
+// from each response to the following ones. The response of the last step is the result.
+func runSteps(ctx context.Context, client Requester, req *schema.ScheduledRequest) (*schema.Response, error) {
+	if client == nil {
+		return nil, errors.New("http client is not configured")
+	}
+	if len(req.Steps) == 0 {
+		return nil, errors.New("missing steps")
+	}
+	vars := map[string]string{}
+	var resp *schema.Response
+	for i, step := range req.Steps {
+		log.Printf("execute step id=%s step=%s index=%d \n", req.ID, step.Name, i)
+		stepReq := &schema.ScheduledRequest{
+			ID:           req.ID,
+			Method:       step.Method,
+			URL:          step.URL,
+			Headers:      step.Headers,
+			Payload:      step.Payload,
+			ExpectStatus: step.ExpectStatus,
+		}
+		var err error
+		if resp, err = execRequestVars(ctx, client, stepReq, vars); err != nil {
+			return nil, errors.Wrapf(err, "execRequest step=%s", step.Name)
+		}
+		if err = captureVars(step.Capture, resp.Body, vars); err != nil {
+			return nil, errors.Wrapf(err, "captureVars step=%s", step.Name)
+		}
+	}
+	return resp, nil
+}
+
+// captureVars stores the values of JSON response body at the capture paths into vars
+func captureVars(capture map[string]string, body string, vars map[string]string) error {
+	if len(capture) == 0 {
+		return nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		return errors.Wrap(err, "json.Unmarshal response body")
+	}
+	for name, path := range capture {
+		v, err := lookupPath(doc, path)
+		if err != nil {
+			return errors.Wrapf(err, "lookupPath path=%s", path)
+		}
+		if vars[name], err = jsonText(v); err != nil {
+			return errors.Wrapf(err, "jsonText path=%s", path)
+		}
+	}
+	return nil
+}
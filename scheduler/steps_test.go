@@ -0,0 +1,120 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/schema"
+)
+
+func TestRunSteps(t *testing.T) {
+	mockSrv, client := setupMockSrv(t)
+	defer mockSrv.teardown(t)
+	mockSrv.mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, werr := w.Write([]byte(`{"access_token":"test-token","user":{"id":7}}`))
+		require.NoError(t, werr)
+	})
+	mockSrv.mux.HandleFunc("/users/7/reports", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, rerr := ioutil.ReadAll(r.Body)
+		require.NoError(t, rerr)
+		w.WriteHeader(http.StatusOK)
+		_, werr := w.Write([]byte(fmt.Sprintf(`{"requested":%s}`, body)))
+		require.NoError(t, werr)
+	})
+	login := schema.Step{
+		Name:         "login",
+		Method:       http.MethodPost,
+		URL:          "/login",
+		ExpectStatus: "201",
+		Capture:      map[string]string{"token": "$.access_token", "user": "$.user.id"},
+	}
+	for _, c := range []struct {
+		caseName string
+		client   Requester
+		steps    []schema.Step
+		err      bool
+		want     schema.Response
+	}{
+		{
+			caseName: "client_not_configured",
+			steps:    []schema.Step{login},
+			err:      true,
+		},
+		{
+			caseName: "missing_steps",
+			client:   client,
+			err:      true,
+		},
+		{
+			caseName: "captured_values_passed_to_next_step",
+			client:   client,
+			steps: []schema.Step{login, {
+				Name:    "report",
+				Method:  http.MethodPost,
+				URL:     "/users/{{ var user }}/reports",
+				Headers: map[string]string{"Authorization": "Bearer {{ var token }}"},
+				Payload: `"{{ var user }}"`,
+			}},
+			want: schema.Response{Code: http.StatusOK, Body: `{"requested":"7"}`},
+		},
+		{
+			caseName: "unexpected_status_fails_remaining_steps",
+			client:   client,
+			steps: []schema.Step{{
+				Name:         "report",
+				Method:       http.MethodPost,
+				URL:          "/users/7/reports",
+				ExpectStatus: "2xx",
+			}},
+			err: true,
+		},
+		{
+			caseName: "undefined_variable",
+			client:   client,
+			steps: []schema.Step{{
+				Name:   "report",
+				Method: http.MethodPost,
+				URL:    "/users/{{ var user }}/reports",
+			}},
+			err: true,
+		},
+		{
+			caseName: "capture_path_not_found",
+			client:   client,
+			steps: []schema.Step{{
+				Name:    "login",
+				Method:  http.MethodPost,
+				URL:     "/login",
+				Capture: map[string]string{"token": "$.refresh_token"},
+			}},
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			req := &schema.ScheduledRequest{
+				ID:         "test-steps",
+				TargetType: schema.TargetSteps,
+				Steps:      c.steps,
+			}
+			resp, err := runSteps(context.Background(), c.client, req)
+			if c.err == true {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, c.want.Code, resp.Code)
+				assert.Equal(t, c.want.Body, resp.Body)
+			}
+		})
+	}
+}
@@ -3,10 +3,15 @@ package scheduler
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
@@ -20,7 +25,19 @@ const unixFormat = "2006-01-02T15:04:05Z"
 // FetchSchedRequests lookup for all the scheduled records from dynamodb matching the conditions:
 // - EffectiveAfter >= time.Now().Unix()
 // - Locking == false
-func FetchSchedRequests(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, current time.Time) ([]*schema.ScheduledRequest, error) {
+// - Tags carrying all the given tags with the same values, if any
+// Scanning stops once limit records are found, the rest is left to next runs. Each scan call
+// evaluates at most pageSize items. Zero values disable both bounds.
+func FetchSchedRequests(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, current time.Time, limit, pageSize int, tags map[string]string) ([]*schema.ScheduledRequest, error) {
+	records, _, err := fetchFrom(ctx, conn, tableName, current, limit, pageSize, "", nil, tags)
+	return records, err
+}
+
+// fetchFrom scans for the scheduled records like FetchSchedRequests does, starting after the
+// item of cursor unless it is empty. Only the records of shard are fetched unless it is nil, the
+// ones stored without shard belong to the first. The cursor where scanning stopped is returned
+// along, empty once the whole table is scanned
+func fetchFrom(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, current time.Time, limit, pageSize int, cursor string, shard *int, tags map[string]string) ([]*schema.ScheduledRequest, string, error) {
 	currentStr := current.Format(unixFormat)
 	input := &dynamodb.ScanInput{
 		TableName:        aws.String(tableName),
@@ -34,27 +51,111 @@ func FetchSchedRequests(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tab
 			},
 		},
 	}
-	log.Printf("fetch the scheduled requests table_name=%s current=%s \n", tableName, currentStr)
-	output, err := conn.Scan(input)
-	if err != nil {
-		return nil, errors.Wrapf(err, "conn.Scan table_name=%s input=%s", tableName, input.GoString())
+	if shard != nil {
+		cond := "#s = :s"
+		if *shard == 0 {
+			cond = "(attribute_not_exists(#s) or #s = :s)"
+		}
+		input.FilterExpression = aws.String(aws.StringValue(input.FilterExpression) + " and " + cond)
+		input.ExpressionAttributeNames = map[string]*string{"#s": aws.String("Shard")}
+		input.ExpressionAttributeValues[":s"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(*shard))}
+	}
+	if expr, names, values := (ListFilter{Tags: tags}).expression(); expr != "" {
+		input.FilterExpression = aws.String(aws.StringValue(input.FilterExpression) + " and " + expr)
+		if input.ExpressionAttributeNames == nil {
+			input.ExpressionAttributeNames = map[string]*string{}
+		}
+		for k, v := range names {
+			input.ExpressionAttributeNames[k] = v
+		}
+		for k, v := range values {
+			input.ExpressionAttributeValues[k] = v
+		}
+	}
+	if pageSize > 0 {
+		input.Limit = aws.Int64(int64(pageSize))
 	}
-	log.Printf("found %d records\n", len(output.Items))
+	if cursor != "" {
+		start, err := decodePageToken(cursor)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "decodePageToken cursor=%s", cursor)
+		}
+		input.ExclusiveStartKey = start
+	}
+	log.Printf("fetch the scheduled requests table_name=%s current=%s limit=%d page_size=%d cursor=%s filter=%s \n", tableName, currentStr, limit, pageSize, cursor, aws.StringValue(input.FilterExpression))
+	var items []map[string]*dynamodb.AttributeValue
+	next := ""
+	for {
+		output, err := conn.ScanWithContext(ctx, input)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "conn.Scan table_name=%s input=%s", tableName, input.GoString())
+		}
+		items = append(items, output.Items...)
+		if limit > 0 && len(items) >= limit {
+			if len(items) > limit || len(output.LastEvaluatedKey) > 0 {
+				log.Printf("fetch limit reached table_name=%s limit=%d \n", tableName, limit)
+				// resume right after the last fetched record
+				if next, err = encodePageToken(map[string]*dynamodb.AttributeValue{"ID": items[limit-1]["ID"]}); err != nil {
+					return nil, "", errors.Wrap(err, "encodePageToken")
+				}
+			}
+			items = items[:limit]
+			break
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	log.Printf("found %d records\n", len(items))
 	records := []*schema.ScheduledRequest{}
-	if err = dynamodbattribute.UnmarshalListOfMaps(output.Items, &records); err != nil {
-		return nil, errors.Wrapf(err, "dynamodbattribute.UnmarshalListOfMaps table_name=%s output=%s", tableName, output.GoString())
+	if err := dynamodbattribute.UnmarshalListOfMaps(items, &records); err != nil {
+		return nil, "", errors.Wrapf(err, "dynamodbattribute.UnmarshalListOfMaps table_name=%s", tableName)
+	}
+	return records, next, nil
+}
+
+// sizeLimits bound the requests put into storage, see SetSizeLimits
+var sizeLimits = schema.SizeLimits{
+	Payload:    schema.DefaultMaxPayloadSize,
+	Headers:    schema.DefaultMaxHeaders,
+	HeaderSize: schema.DefaultMaxHeaderSize,
+}
+
+// SetSizeLimits changes the limits of payload and headers of the requests put into storage
+func SetSizeLimits(limits schema.SizeLimits) {
+	sizeLimits = limits
+}
+
+// payloadContracts validate the payload of requests put into storage, see SetPayloadContracts
+var payloadContracts = NewPayloadContracts(nil)
+
+// SetPayloadContracts changes the contracts validating the payload of requests put into storage,
+// the default ones support inline schemas only
+func SetPayloadContracts(contracts *PayloadContracts) {
+	payloadContracts = contracts
+}
+
+// checkStorable returns ErrValidation telling the exceeded limit if req is too large to store,
+// or the violations of its payload schema
+func checkStorable(ctx context.Context, req *schema.ScheduledRequest) error {
+	if err := req.CheckSize(sizeLimits); err != nil {
+		return errors.Wrapf(ErrValidation, "%s id=%s", err, req.ID)
 	}
-	return records, nil
+	return payloadContracts.Check(ctx, req)
 }
 
 // Create put new record into storage
 func Create(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, req *schema.ScheduledRequest) error {
 	log.Printf("store request table_name=%s %s\n", tableName, req.ToString())
-	av, err := dynamodbattribute.MarshalMap(req)
+	if err := checkStorable(ctx, req); err != nil {
+		return err
+	}
+	av, err := marshalItem(withPending(req))
 	if err != nil {
-		return errors.Wrapf(err, "dynamodbattribute.MarshalMap req %s", req.ToString())
+		return errors.Wrapf(err, "marshalItem req %s", req.ToString())
 	}
-	if _, err := conn.PutItem(&dynamodb.PutItemInput{
+	if _, err := conn.PutItemWithContext(ctx, &dynamodb.PutItemInput{
 		Item:      av,
 		TableName: aws.String(tableName),
 	}); err != nil {
@@ -63,7 +164,58 @@ func Create(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName strin
 	return nil
 }
 
-// Get retrieve record from storage
+// maxBatchWriteItems is the limit of put requests per BatchWriteItem call
+const maxBatchWriteItems = 25
+
+// batchWriteBackoff is the first wait before writing unprocessed items again, doubled each time
+var batchWriteBackoff = 50 * time.Millisecond
+
+// BatchCreate puts new records into storage in batches, items left unprocessed due to throttling
+// are written again with backoff
+func BatchCreate(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, reqs []*schema.ScheduledRequest) error {
+	log.Printf("store requests table_name=%s count=%d\n", tableName, len(reqs))
+	// nothing is written unless every request fits
+	for _, req := range reqs {
+		if err := checkStorable(ctx, req); err != nil {
+			return err
+		}
+	}
+	for start := 0; start < len(reqs); start += maxBatchWriteItems {
+		end := start + maxBatchWriteItems
+		if end > len(reqs) {
+			end = len(reqs)
+		}
+		writes := make([]*dynamodb.WriteRequest, 0, end-start)
+		for _, req := range reqs[start:end] {
+			av, err := marshalItem(withPending(req))
+			if err != nil {
+				return errors.Wrapf(err, "marshalItem req %s", req.ToString())
+			}
+			writes = append(writes, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: av}})
+		}
+		items := map[string][]*dynamodb.WriteRequest{tableName: writes}
+		for attempt := 0; len(items[tableName]) > 0; attempt++ {
+			if attempt > 0 {
+				if attempt > 5 {
+					return errors.Errorf("unprocessed items table_name=%s count=%d", tableName, len(items[tableName]))
+				}
+				select {
+				case <-ctx.Done():
+					return errors.Wrap(ctx.Err(), "wait for unprocessed items")
+				case <-time.After(batchWriteBackoff << uint(attempt-1)):
+				}
+			}
+			output, err := conn.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{RequestItems: items})
+			if err != nil {
+				return errors.Wrapf(err, "conn.BatchWriteItem table_name=%s", tableName)
+			}
+			items = output.UnprocessedItems
+		}
+	}
+	return nil
+}
+
+// Get retrieve record from storage, ErrNotFound is returned if there is none
 func Get(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string) (*schema.ScheduledRequest, error) {
 	log.Printf("get request table_name=%s id=%s\n", tableName, reqID)
 	input := &dynamodb.GetItemInput{
@@ -74,10 +226,13 @@ func Get(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID s
 			},
 		},
 	}
-	output, err := conn.GetItem(input)
+	output, err := conn.GetItemWithContext(ctx, input)
 	if err != nil {
 		return nil, errors.Wrapf(err, "conn.GetItem table_name=%s id=%s", tableName, reqID)
 	}
+	if len(output.Item) == 0 {
+		return nil, errors.Wrapf(ErrNotFound, "id=%s table_name=%s", reqID, tableName)
+	}
 	req := new(schema.ScheduledRequest)
 	if err = dynamodbattribute.UnmarshalMap(output.Item, req); err != nil {
 		return nil, errors.Wrapf(err, "dynamodbattribute.UnmarshalMap table_name=%s output=%s", tableName, output.GoString())
@@ -85,21 +240,23 @@ func Get(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID s
 	return req, nil
 }
 
-func updateResult(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string, resp *schema.Response, current time.Time) error {
-	log.Printf("store execution result table_name=%s id=%s %s\n", tableName, reqID, resp.ToString())
+// updateResult stores the result of executed record provided that it is still at version unless
+// that is anyVersion, errVersionConflict is returned otherwise
+func updateResult(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string, version int64, resp *schema.Response, current time.Time) error {
+	log.Printf("store execution result table_name=%s id=%s version=%d %s\n", tableName, reqID, version, resp.ToString())
 	serialized, err := json.Marshal(resp)
 	if err != nil {
 		return errors.Wrapf(err, "json.Marshal resp %s", resp.ToString())
 	}
 	result := string(serialized)
-	if _, err = conn.UpdateItem(&dynamodb.UpdateItemInput{
+	_, err = conn.UpdateItemWithContext(ctx, withVersion(bumpVersion(withStatus(&dynamodb.UpdateItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]*dynamodb.AttributeValue{
 			"ID": {
 				S: aws.String(reqID),
 			},
 		},
-		UpdateExpression: aws.String("SET ExecutionResult = :r, ExecutedAt = :e"),
+		UpdateExpression: aws.String("SET ExecutionResult = :r, ExecutedAt = :e, LastAttemptAt = :e ADD Attempts :one"),
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":r": {
 				S: aws.String(result),
@@ -107,78 +264,716 @@ func updateResult(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName
 			":e": {
 				S: aws.String(current.Format(unixFormat)),
 			},
+			":one": {
+				N: aws.String("1"),
+			},
 		},
-	}); err != nil {
+	}, schema.StatusSucceeded)), version))
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return errors.Wrapf(errVersionConflict, "id=%s table_name=%s version=%d", reqID, tableName, version)
+	}
+	if err != nil {
 		return errors.Wrapf(err, "conn.UpdateItem id=%s table_name=%s result=%s", reqID, tableName, result)
 	}
 	return nil
 }
 
-func removeRequest(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string) error {
-	log.Printf("remove request table_name=%s id=%s\n", tableName, reqID)
-	if _, err := conn.DeleteItem(&dynamodb.DeleteItemInput{
+// removeRequest deletes the record provided that it is still at version unless that is anyVersion,
+// errVersionConflict is returned otherwise
+func removeRequest(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string, version int64) error {
+	log.Printf("remove request table_name=%s id=%s version=%d\n", tableName, reqID, version)
+	input := &dynamodb.DeleteItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]*dynamodb.AttributeValue{
 			"ID": {
 				S: aws.String(reqID),
 			},
 		},
-	}); err != nil {
+	}
+	if version != anyVersion {
+		cond, value := versionCondition(version)
+		input.ConditionExpression = aws.String(cond)
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{":v": value}
+	}
+	_, err := conn.DeleteItemWithContext(ctx, input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return errors.Wrapf(errVersionConflict, "id=%s table_name=%s version=%d", reqID, tableName, version)
+	}
+	if err != nil {
 		return errors.Wrapf(err, "conn.DeleteItem id=%s table_name=%s", reqID, tableName)
 	}
 	return nil
 }
 
-func logFailure(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string, lerr error) error {
-	log.Printf("log execution failure result table_name=%s id=%s \n", tableName, reqID)
+// anyVersion matches the stored record whatever its version
+const anyVersion int64 = -1
+
+// errVersionConflict is returned when the record was updated since it was read
+var errVersionConflict error = conditionError("version conflict")
+
+// versionCondition returns the condition of the stored record being at version along with the
+// value of its :v placeholder. Records stored before versioning have no version, which is zero
+func versionCondition(version int64) (string, *dynamodb.AttributeValue) {
+	value := &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(version, 10))}
+	if version == 0 {
+		return "(attribute_not_exists(Version) or Version = :v)", value
+	}
+	return "Version = :v", value
+}
+
+// withStatus adds the move of record to status to the update of input, which sets attributes
+func withStatus(input *dynamodb.UpdateItemInput, status string) *dynamodb.UpdateItemInput {
+	// Status is a reserved word
+	input.UpdateExpression = aws.String("SET #st = :st, " + strings.TrimPrefix(aws.StringValue(input.UpdateExpression), "SET "))
+	if input.ExpressionAttributeNames == nil {
+		input.ExpressionAttributeNames = map[string]*string{}
+	}
+	input.ExpressionAttributeNames["#st"] = aws.String("Status")
+	if input.ExpressionAttributeValues == nil {
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{}
+	}
+	input.ExpressionAttributeValues[":st"] = &dynamodb.AttributeValue{S: aws.String(status)}
+	return input
+}
+
+// withPending returns the new record to store, PENDING unless its status is given
+func withPending(req *schema.ScheduledRequest) *schema.ScheduledRequest {
+	if req.Status != "" {
+		return req
+	}
+	pending := *req
+	pending.Status = schema.StatusPending
+	return &pending
+}
+
+// withVersion adds the condition of the stored record being at version to the update of input,
+// unless that is anyVersion
+func withVersion(input *dynamodb.UpdateItemInput, version int64) *dynamodb.UpdateItemInput {
+	if version == anyVersion {
+		return input
+	}
+	cond, value := versionCondition(version)
+	if input.ConditionExpression != nil {
+		cond = aws.StringValue(input.ConditionExpression) + " and " + cond
+	}
+	input.ConditionExpression = aws.String(cond)
+	if input.ExpressionAttributeValues == nil {
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{}
+	}
+	input.ExpressionAttributeValues[":v"] = value
+	return input
+}
+
+// bumpVersion adds the increment of record Version to the update of input
+func bumpVersion(input *dynamodb.UpdateItemInput) *dynamodb.UpdateItemInput {
+	update := aws.StringValue(input.UpdateExpression)
+	if strings.Contains(update, " ADD ") {
+		update += ", Version :vone"
+	} else {
+		update += " ADD Version :vone"
+	}
+	input.UpdateExpression = aws.String(update)
+	if input.ExpressionAttributeValues == nil {
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{}
+	}
+	input.ExpressionAttributeValues[":vone"] = &dynamodb.AttributeValue{N: aws.String("1")}
+	return input
+}
+
+// logFailure records the failure reason, appends it to failure history and counts the attempt
+// provided that record is still at version unless that is anyVersion, errVersionConflict is
+// returned otherwise
+func logFailure(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string, version int64, lerr error, current time.Time) error {
+	log.Printf("log execution failure result table_name=%s id=%s version=%d \n", tableName, reqID, version)
 	failure := lerr.Error()
-	if _, err := conn.UpdateItem(&dynamodb.UpdateItemInput{
+	_, err := conn.UpdateItemWithContext(ctx, withVersion(bumpVersion(withStatus(&dynamodb.UpdateItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]*dynamodb.AttributeValue{
 			"ID": {
 				S: aws.String(reqID),
 			},
 		},
-		UpdateExpression: aws.String("SET FailureReason = :f"),
+		UpdateExpression: aws.String("SET FailureReason = :f, LastAttemptAt = :at, FailureHistory = list_append(if_not_exists(FailureHistory, :empty), :h) ADD Attempts :one"),
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":f": {
 				S: aws.String(failure),
 			},
+			":at": {
+				S: aws.String(current.Format(unixFormat)),
+			},
+			":h": {
+				L: []*dynamodb.AttributeValue{
+					{M: map[string]*dynamodb.AttributeValue{
+						"At":     {S: aws.String(current.Format(unixFormat))},
+						"Reason": {S: aws.String(failure)},
+					}},
+				},
+			},
+			":empty": {
+				L: []*dynamodb.AttributeValue{},
+			},
+			":one": {
+				N: aws.String("1"),
+			},
 		},
-	}); err != nil {
+	}, schema.StatusFailed)), version))
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return errors.Wrapf(errVersionConflict, "id=%s table_name=%s version=%d", reqID, tableName, version)
+	}
+	if err != nil {
 		return errors.Wrapf(err, "conn.UpdateItem id=%s table_name=%s failure_reason=%s", reqID, tableName, failure)
 	}
 	return nil
 }
 
-// Lock set record Locking=true
+// reschedule moves record EffectiveAfter to given time and releases its execution lock provided
+// that it is still at version unless that is anyVersion, errVersionConflict is returned otherwise
+func reschedule(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string, version int64, at time.Time) error {
+	log.Printf("reschedule request table_name=%s id=%s version=%d effective_after=%s \n", tableName, reqID, version, at)
+	_, err := conn.UpdateItemWithContext(ctx, withVersion(bumpVersion(withStatus(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(reqID),
+			},
+		},
+		UpdateExpression: aws.String("SET EffectiveAfter = :e, Locking = :l"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":e": {
+				S: aws.String(at.Format(unixFormat)),
+			},
+			":l": {
+				BOOL: aws.Bool(false),
+			},
+		},
+	}, schema.StatusPending)), version))
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return errors.Wrapf(errVersionConflict, "id=%s table_name=%s version=%d", reqID, tableName, version)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "conn.UpdateItem id=%s table_name=%s", reqID, tableName)
+	}
+	return nil
+}
+
+// acquireLock set record Locking=true only if it is not locked yet, and it is still at version
+// unless that is anyVersion. A record updated since it was read is not executed as it was
+func acquireLock(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string, version int64) error {
+	return lockAs(ctx, conn, tableName, reqID, version, schema.StatusRunning)
+}
+
+// lockAs locks the record like acquireLock does, moving it to status
+func lockAs(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string, version int64, status string) error {
+	log.Printf("acquire lock table_name=%s id=%s version=%d status=%s \n", tableName, reqID, version, status)
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(reqID),
+			},
+		},
+		UpdateExpression:    aws.String("SET Locking = :t"),
+		ConditionExpression: aws.String("Locking = :f"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":t": {
+				BOOL: aws.Bool(true),
+			},
+			":f": {
+				BOOL: aws.Bool(false),
+			},
+		},
+	}
+	if version != anyVersion {
+		cond, value := versionCondition(version)
+		input.ConditionExpression = aws.String("Locking = :f and " + cond)
+		input.ExpressionAttributeValues[":v"] = value
+	}
+	_, err := conn.UpdateItemWithContext(ctx, bumpVersion(withStatus(input, status)))
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return ErrAlreadyLocked
+	}
+	if err != nil {
+		return errors.Wrapf(err, "conn.UpdateItem id=%s table_name=%s", reqID, tableName)
+	}
+	return nil
+}
+
+// Lock set record Locking=true, ErrAlreadyLocked is returned if it is locked already and
+// ErrNotFound if there is no record. Like the other commands of operators by ID, it applies to the
+// record whatever its version, which it increments so that an execution in flight fails its
+// conditional writes and leaves the command in effect
 func Lock(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string) error {
 	return setLocking(ctx, conn, tableName, reqID, true)
 }
 
+// setLocking is deliberately not conditioned on record version, see Lock
 func setLocking(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string, status bool) error {
 	log.Printf("setLocking record table_name=%s id=%s status=%t \n", tableName, reqID, status)
-	if _, err := conn.UpdateItem(&dynamodb.UpdateItemInput{
+	input := &dynamodb.UpdateItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]*dynamodb.AttributeValue{
 			"ID": {
 				S: aws.String(reqID),
 			},
 		},
-		UpdateExpression: aws.String("SET Locking = :l"),
+		UpdateExpression:    aws.String("SET Locking = :l"),
+		ConditionExpression: aws.String("attribute_exists(ID)"),
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":l": {
 				BOOL: aws.Bool(status),
 			},
 		},
-	}); err != nil {
+		// tells a locked record from a missing one when the condition fails
+		ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+	}
+	if status {
+		input.ConditionExpression = aws.String("attribute_exists(ID) and not Locking = :l")
+	} else {
+		// a manual lock keeps the status, e.g. pending requests put on hold
+		input = withStatus(input, schema.StatusPending)
+	}
+	_, err := conn.UpdateItemWithContext(ctx, bumpVersion(input))
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		if failed, ok := err.(*dynamodb.ConditionalCheckFailedException); ok && len(failed.Item) > 0 {
+			return errors.Wrapf(ErrAlreadyLocked, "id=%s table_name=%s", reqID, tableName)
+		}
+		return errors.Wrapf(ErrNotFound, "id=%s table_name=%s", reqID, tableName)
+	}
+	if err != nil {
 		return errors.Wrapf(err, "conn.UpdateItem id=%s table_name=%s", reqID, tableName)
 	}
 	return nil
-
 }
 
-// Unlock set record Locking=false
+// Unlock set record Locking=false, ErrNotFound is returned if there is no record. It applies
+// whatever the record version, like Lock
 func Unlock(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string) error {
 	return setLocking(ctx, conn, tableName, reqID, false)
 }
+
+// Cancel locks an existing record for good, keeping it as CANCELLED, ErrNotFound is returned if
+// there is none. It applies whatever the record version, like Lock
+func Cancel(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string) error {
+	log.Printf("cancel request table_name=%s id=%s \n", tableName, reqID)
+	_, err := conn.UpdateItemWithContext(ctx, bumpVersion(withStatus(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(reqID),
+			},
+		},
+		UpdateExpression:    aws.String("SET Locking = :l"),
+		ConditionExpression: aws.String("attribute_exists(ID)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":l": {
+				BOOL: aws.Bool(true),
+			},
+		},
+	}, schema.StatusCancelled)))
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return errors.Wrapf(ErrNotFound, "id=%s table_name=%s", reqID, tableName)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "conn.UpdateItem id=%s table_name=%s", reqID, tableName)
+	}
+	return nil
+}
+
+// Reschedule moves the effective date of an existing record and clears its last failure, the
+// record is unlocked as well if unlock is true. It applies whatever the record version, like Lock
+func Reschedule(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string, at time.Time, unlock bool) error {
+	log.Printf("reschedule request table_name=%s id=%s effective_after=%s unlock=%t \n", tableName, reqID, at, unlock)
+	update := "SET EffectiveAfter = :e"
+	values := map[string]*dynamodb.AttributeValue{
+		":e": {
+			S: aws.String(at.UTC().Format(unixFormat)),
+		},
+	}
+	if unlock {
+		update += ", Locking = :l"
+		values[":l"] = &dynamodb.AttributeValue{BOOL: aws.Bool(false)}
+	}
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(reqID),
+			},
+		},
+		UpdateExpression:          aws.String(update + " REMOVE FailureReason"),
+		ConditionExpression:       aws.String("attribute_exists(ID)"),
+		ExpressionAttributeValues: values,
+	}
+	if unlock {
+		input = withStatus(input, schema.StatusPending)
+	}
+	_, err := conn.UpdateItemWithContext(ctx, bumpVersion(input))
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return errors.Wrapf(ErrNotFound, "id=%s table_name=%s", reqID, tableName)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "conn.UpdateItem id=%s table_name=%s", reqID, tableName)
+	}
+	return nil
+}
+
+// RetryFailed unlocks the records left locked by a failed execution and clears their failure so
+// that they are run again, at the given time if it is set or else at their current effective
+// date. The ids of retried records are returned
+func RetryFailed(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, at time.Time) ([]string, error) {
+	locked, failed := true, true
+	reqs, _, err := List(ctx, conn, tableName, ListOptions{Filter: ListFilter{Locked: &locked, Failed: &failed}})
+	if err != nil {
+		return nil, errors.Wrap(err, "List")
+	}
+	retried := make([]string, 0, len(reqs))
+	for _, req := range reqs {
+		effective := req.EffectiveAfter
+		if !at.IsZero() {
+			effective = at
+		}
+		if err = Reschedule(ctx, conn, tableName, req.ID, effective, true); err != nil {
+			return retried, errors.Wrapf(err, "Reschedule %s", req.ToString())
+		}
+		retried = append(retried, req.ID)
+	}
+	return retried, nil
+}
+
+// Purge deletes the records executed before the given time, which are the ones kept with their
+// result by PersistentStore. They are copied into archiveTable first if it is set. The ids of
+// purged records are returned
+func Purge(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, before time.Time, archiveTable string) ([]string, error) {
+	reqs, _, err := List(ctx, conn, tableName, ListOptions{Filter: ListFilter{ExecutedBefore: before}})
+	if err != nil {
+		return nil, errors.Wrap(err, "List")
+	}
+	purged := make([]string, 0, len(reqs))
+	for _, req := range reqs {
+		if archiveTable != "" {
+			if err = archiveRequest(ctx, conn, archiveTable, req); err != nil {
+				return purged, errors.Wrapf(err, "archive %s", req.ToString())
+			}
+		}
+		if err = removeRequest(ctx, conn, tableName, req.ID, req.Version); err != nil {
+			return purged, errors.Wrapf(err, "removeRequest %s", req.ToString())
+		}
+		purged = append(purged, req.ID)
+	}
+	return purged, nil
+}
+
+// archiveRequest copies the record into archiveTable as stored, without the checks of Create which
+// may have been tightened since. A copy of the same id is only replaced at the same version,
+// errVersionConflict is returned otherwise
+func archiveRequest(ctx context.Context, conn dynamodbiface.DynamoDBAPI, archiveTable string, req *schema.ScheduledRequest) error {
+	av, err := marshalItem(req)
+	if err != nil {
+		return errors.Wrapf(err, "marshalItem req %s", req.ToString())
+	}
+	cond, value := versionCondition(req.Version)
+	_, err = conn.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		Item:                      av,
+		TableName:                 aws.String(archiveTable),
+		ConditionExpression:       aws.String("attribute_not_exists(ID) or " + cond),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":v": value},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return errors.Wrapf(errVersionConflict, "id=%s table_name=%s version=%d", req.ID, archiveTable, req.Version)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "conn.PutItem req %s table_name=%s", req.ToString(), archiveTable)
+	}
+	return nil
+}
+
+// ExpiredResults returns the executed records whose ExecutionResult is past its ResultRetention at
+// now
+func ExpiredResults(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, now time.Time) ([]*schema.ScheduledRequest, error) {
+	reqs, _, err := List(ctx, conn, tableName, ListOptions{Filter: ListFilter{ExecutedBefore: now}})
+	if err != nil {
+		return nil, errors.Wrap(err, "List")
+	}
+	expired := []*schema.ScheduledRequest{}
+	for _, req := range reqs {
+		if req.ResultExpired(now) {
+			expired = append(expired, req)
+		}
+	}
+	return expired, nil
+}
+
+// TrimResults clears the ExecutionResult of the records past their ResultRetention at now, keeping
+// the records. A record updated since it was read is left to the next cleanup. The ids of trimmed
+// records are returned
+func TrimResults(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, now time.Time) ([]string, error) {
+	reqs, err := ExpiredResults(ctx, conn, tableName, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "ExpiredResults")
+	}
+	trimmed := make([]string, 0, len(reqs))
+	for _, req := range reqs {
+		err = clearResult(ctx, conn, tableName, req.ID, req.Version)
+		if errors.Cause(err) == errVersionConflict {
+			log.Printf("skip request updated meanwhile table_name=%s id=%s \n", tableName, req.ID)
+			continue
+		}
+		if err != nil {
+			return trimmed, errors.Wrapf(err, "clearResult %s", req.ToString())
+		}
+		trimmed = append(trimmed, req.ID)
+	}
+	return trimmed, nil
+}
+
+// clearResult removes the ExecutionResult of record provided that it is still at version
+func clearResult(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string, version int64) error {
+	log.Printf("clear execution result table_name=%s id=%s version=%d \n", tableName, reqID, version)
+	cond, value := versionCondition(version)
+	_, err := conn.UpdateItemWithContext(ctx, bumpVersion(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(reqID),
+			},
+		},
+		UpdateExpression:          aws.String("REMOVE ExecutionResult"),
+		ConditionExpression:       aws.String("attribute_exists(ID) and " + cond),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":v": value},
+	}))
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return errors.Wrapf(errVersionConflict, "id=%s table_name=%s version=%d", reqID, tableName, version)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "conn.UpdateItem id=%s table_name=%s", reqID, tableName)
+	}
+	return nil
+}
+
+// Delete removes an existing record, ErrNotFound is returned if there is none
+func Delete(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID string) error {
+	log.Printf("delete request table_name=%s id=%s\n", tableName, reqID)
+	_, err := conn.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(reqID),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(ID)"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return errors.Wrapf(ErrNotFound, "id=%s table_name=%s", reqID, tableName)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "conn.DeleteItem id=%s table_name=%s", reqID, tableName)
+	}
+	return nil
+}
+
+// errAlreadyExists is returned when the created record id is taken
+var errAlreadyExists error = conditionError("already exists")
+
+// Replace puts the record in place of the stored one of its id, provided that the stored one is
+// still at the version of record, or creates it if there is none. The version is incremented,
+// errVersionConflict is returned if the stored record was updated meanwhile
+func Replace(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, req *schema.ScheduledRequest) error {
+	log.Printf("replace request table_name=%s version=%d %s\n", tableName, req.Version, req.ToString())
+	if err := checkStorable(ctx, req); err != nil {
+		return err
+	}
+	replaced := *withPending(req)
+	replaced.Version++
+	av, err := marshalItem(&replaced)
+	if err != nil {
+		return errors.Wrapf(err, "marshalItem req %s", req.ToString())
+	}
+	cond, value := versionCondition(req.Version)
+	_, err = conn.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		Item:                      av,
+		TableName:                 aws.String(tableName),
+		ConditionExpression:       aws.String("attribute_not_exists(ID) or " + cond),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":v": value},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return errors.Wrapf(errVersionConflict, "id=%s table_name=%s version=%d", req.ID, tableName, req.Version)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "conn.PutItem req %s table_name=%s", req.ToString(), tableName)
+	}
+	req.Version = replaced.Version
+	return nil
+}
+
+// upsertCondition lets Upsert replace a record unless it is running. Items without Status, stored
+// before it was maintained, tell it by their lock instead
+const upsertCondition = "(attribute_not_exists(#st) and (attribute_not_exists(Locking) or Locking = :unlocked)) or #st <> :running"
+
+// Upsert puts the record in place of the stored one of its id whatever its version, or creates it
+// if there is none, in a single conditional call so that systems declaring their schedules on
+// every deploy do it idempotently. Every attribute is set as given, PENDING unless its status is
+// given, while the stored version is incremented so that readers of the replaced record see their
+// conditional writes fail. ErrAlreadyLocked is returned while the stored record is running, or
+// locked for items stored before Status was maintained, for its execution to complete first.
+// Returns whether the record was created
+func Upsert(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, req *schema.ScheduledRequest) (bool, error) {
+	log.Printf("upsert request table_name=%s %s\n", tableName, req.ToString())
+	if err := checkStorable(ctx, req); err != nil {
+		return false, err
+	}
+	av, err := marshalItem(withPending(req))
+	if err != nil {
+		return false, errors.Wrapf(err, "marshalItem req %s", req.ToString())
+	}
+	names := make([]string, 0, len(av))
+	for name := range av {
+		// the key is never set, the version is incremented and Status is set along with the condition
+		if name != "ID" && name != "Version" && name != "Status" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	// Status is a reserved word
+	sets := []string{"#st = :st"}
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(req.ID),
+			},
+		},
+		ConditionExpression:      aws.String(upsertCondition),
+		ExpressionAttributeNames: map[string]*string{"#st": aws.String("Status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":st":       av["Status"],
+			":running":  {S: aws.String(schema.StatusRunning)},
+			":unlocked": {BOOL: aws.Bool(false)},
+			":one":      {N: aws.String("1")},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	}
+	for i, name := range names {
+		ref, value := fmt.Sprintf("#a%d", i), fmt.Sprintf(":a%d", i)
+		sets = append(sets, ref+" = "+value)
+		input.ExpressionAttributeNames[ref] = aws.String(name)
+		input.ExpressionAttributeValues[value] = av[name]
+	}
+	input.UpdateExpression = aws.String("SET " + strings.Join(sets, ", ") + " ADD Version :one")
+	output, err := conn.UpdateItemWithContext(ctx, input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, errors.Wrapf(ErrAlreadyLocked, "id=%s table_name=%s status=%s", req.ID, tableName, schema.StatusRunning)
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "conn.UpdateItem req %s table_name=%s", req.ToString(), tableName)
+	}
+	stored := new(schema.ScheduledRequest)
+	if err = dynamodbattribute.UnmarshalMap(output.Attributes, stored); err != nil {
+		return false, errors.Wrapf(err, "dynamodbattribute.UnmarshalMap id=%s", req.ID)
+	}
+	req.Version = stored.Version + 1
+	if err = removeStaleTags(ctx, conn, tableName, req, av, output.Attributes); err != nil {
+		return false, err
+	}
+	return len(output.Attributes) == 0, nil
+}
+
+// removeStaleTags removes the tag attributes of the replaced item which the upserted one of req no
+// longer carries, unless the record was updated since
+func removeStaleTags(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, req *schema.ScheduledRequest, item, replaced map[string]*dynamodb.AttributeValue) error {
+	var stale []string
+	for name := range replaced {
+		if _, ok := item[name]; !ok && strings.HasPrefix(name, TagAttribute("")) {
+			stale = append(stale, name)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	sort.Strings(stale)
+	log.Printf("remove stale tags table_name=%s id=%s attributes=%s \n", tableName, req.ID, strings.Join(stale, ","))
+	cond, value := versionCondition(req.Version)
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {
+				S: aws.String(req.ID),
+			},
+		},
+		ConditionExpression:       aws.String(cond),
+		ExpressionAttributeNames:  map[string]*string{},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":v": value},
+	}
+	refs := make([]string, len(stale))
+	for i, name := range stale {
+		refs[i] = fmt.Sprintf("#t%d", i)
+		input.ExpressionAttributeNames[refs[i]] = aws.String(name)
+	}
+	input.UpdateExpression = aws.String("REMOVE " + strings.Join(refs, ", "))
+	_, err := conn.UpdateItemWithContext(ctx, input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		// the record was written again meanwhile, along with its own tags
+		log.Printf("skip stale tags of request updated meanwhile table_name=%s id=%s \n", tableName, req.ID)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "conn.UpdateItem id=%s table_name=%s", req.ID, tableName)
+	}
+	return nil
+}
+
+// Clone copies the request of given id into a new one of newID created by createdBy and due at
+// the given time, left without any execution state. An existing request of newID is never
+// overwritten
+func Clone(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName, reqID, newID, createdBy string, at, now time.Time) (*schema.ScheduledRequest, error) {
+	src, err := Get(ctx, conn, tableName, reqID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get")
+	}
+	req := *src
+	req.ID = newID
+	req.CreatedAt = now
+	req.CreatedBy = createdBy
+	req.Version = 0
+	req.EffectiveAfter = at
+	req.ExecutedAt = time.Time{}
+	req.Locking = false
+	req.FailureReason = ""
+	req.Attempts = 0
+	req.LastAttemptAt = time.Time{}
+	req.FailureHistory = nil
+	req.ExecutionResult = ""
+	req.Status = schema.StatusPending
+	if err = req.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "validate %s", req.ToString())
+	}
+	log.Printf("clone request table_name=%s id=%s \n", tableName, reqID)
+	if err = createNew(ctx, conn, tableName, &req); err != nil {
+		return nil, errors.Wrap(err, "createNew")
+	}
+	return &req, nil
+}
+
+// createNew puts the new record into storage unless its id is taken, errAlreadyExists is returned
+// then
+func createNew(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, req *schema.ScheduledRequest) error {
+	log.Printf("store new request table_name=%s %s\n", tableName, req.ToString())
+	if err := checkStorable(ctx, req); err != nil {
+		return err
+	}
+	av, err := marshalItem(withPending(req))
+	if err != nil {
+		return errors.Wrapf(err, "marshalItem req %s", req.ToString())
+	}
+	_, err = conn.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return errors.Wrapf(errAlreadyExists, "id=%s table_name=%s", req.ID, tableName)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "conn.PutItem req %s table_name=%s", req.ToString(), tableName)
+	}
+	return nil
+}
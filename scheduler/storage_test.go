@@ -5,13 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	pkgerrors "github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -22,8 +27,9 @@ type mockDynamoDB struct {
 	dynamodbiface.DynamoDBAPI
 	once *sync.Once
 	mu   *sync.Mutex
-	// scan function
+	// scan function, items are paged by scan limit
 	lastScanQ string
+	scanCalls int
 	items     []map[string]*dynamodb.AttributeValue
 	scanErr   error
 	// get function
@@ -39,6 +45,14 @@ type mockDynamoDB struct {
 	// delete function
 	lastDeleteItem *dynamodb.DeleteItemInput
 	delErr         error
+	// batch write function, the first call leaves unprocessed items unwritten
+	batches     [][]*dynamodb.WriteRequest
+	unprocessed int
+	batchErr    error
+	// calls fail once their context is done, which cancel does after cancelAfter calls started
+	calls       int32
+	cancel      context.CancelFunc
+	cancelAfter int32
 }
 
 func (mdb *mockDynamoDB) clear() {
@@ -46,28 +60,70 @@ func (mdb *mockDynamoDB) clear() {
 	mdb.mu = new(sync.Mutex)
 	mdb.items = []map[string]*dynamodb.AttributeValue{}
 	mdb.lastScanQ = ""
+	mdb.scanCalls = 0
 	mdb.scanErr = nil
 	mdb.lastPutItem = nil
 	mdb.putErr = nil
 	mdb.lastUpdateItem = nil
 	mdb.updateErr = nil
+	mdb.lastDeleteItem = nil
+	mdb.delErr = nil
+	mdb.batches = nil
+	mdb.unprocessed = 0
+	mdb.batchErr = nil
 	mdb.item = map[string]*dynamodb.AttributeValue{}
 	mdb.lastGetQ = ""
 	mdb.getErr = nil
+	mdb.calls = 0
+	mdb.cancel = nil
+	mdb.cancelAfter = 0
 }
 
-func (mdb *mockDynamoDB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+// begin fails the call with the error of SDK if ctx is done, or else counts it
+func (mdb *mockDynamoDB) begin(ctx aws.Context) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	if atomic.AddInt32(&mdb.calls, 1) == mdb.cancelAfter && mdb.cancel != nil {
+		mdb.cancel()
+	}
+	return nil
+}
+
+func (mdb *mockDynamoDB) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	if err := mdb.begin(ctx); err != nil {
+		return nil, err
+	}
 	mdb.lastScanQ = input.GoString()
+	mdb.scanCalls++
 	if mdb.scanErr != nil {
 		return nil, mdb.scanErr
 	}
+	items := mdb.items
+	if input.ExclusiveStartKey != nil {
+		for i, item := range items {
+			if aws.StringValue(item["ID"].S) == aws.StringValue(input.ExclusiveStartKey["ID"].S) {
+				items = items[i+1:]
+				break
+			}
+		}
+	}
+	var lastKey map[string]*dynamodb.AttributeValue
+	if input.Limit != nil && int(*input.Limit) < len(items) {
+		items = items[:*input.Limit]
+		lastKey = map[string]*dynamodb.AttributeValue{"ID": items[len(items)-1]["ID"]}
+	}
 	return &dynamodb.ScanOutput{
-		ScannedCount: aws.Int64(int64(len(mdb.items))),
-		Items:        mdb.items,
+		ScannedCount:     aws.Int64(int64(len(items))),
+		Items:            items,
+		LastEvaluatedKey: lastKey,
 	}, nil
 }
 
-func (mdb *mockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+func (mdb *mockDynamoDB) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	if err := mdb.begin(ctx); err != nil {
+		return nil, err
+	}
 	mdb.lastGetQ = input.GoString()
 	if mdb.getErr != nil {
 		return nil, mdb.getErr
@@ -77,7 +133,10 @@ func (mdb *mockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetIte
 	}, nil
 }
 
-func (mdb *mockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+func (mdb *mockDynamoDB) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if err := mdb.begin(ctx); err != nil {
+		return nil, err
+	}
 	mdb.lastPutItem = input
 	if mdb.putErr != nil {
 		return nil, mdb.putErr
@@ -85,7 +144,10 @@ func (mdb *mockDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutIte
 	return &dynamodb.PutItemOutput{}, nil
 }
 
-func (mdb *mockDynamoDB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+func (mdb *mockDynamoDB) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	if err := mdb.begin(ctx); err != nil {
+		return nil, err
+	}
 	mdb.mu.Lock()
 	mdb.lastDeleteItem = input
 	mdb.mu.Unlock()
@@ -95,7 +157,10 @@ func (mdb *mockDynamoDB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.
 	return &dynamodb.DeleteItemOutput{}, nil
 }
 
-func (mdb *mockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+func (mdb *mockDynamoDB) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	if err := mdb.begin(ctx); err != nil {
+		return nil, err
+	}
 	mdb.mu.Lock()
 	mdb.lastUpdateItem = input
 	mdb.mu.Unlock()
@@ -106,17 +171,59 @@ func (mdb *mockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.
 	if err != nil {
 		return nil, mdb.updateErr
 	}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+		return &dynamodb.UpdateItemOutput{Attributes: mdb.item}, nil
+	}
 	return &dynamodb.UpdateItemOutput{}, nil
 }
 
+func (mdb *mockDynamoDB) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	if err := mdb.begin(ctx); err != nil {
+		return nil, err
+	}
+	if mdb.batchErr != nil {
+		return nil, mdb.batchErr
+	}
+	output := &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]*dynamodb.WriteRequest{}}
+	for table, writes := range input.RequestItems {
+		if mdb.unprocessed > 0 {
+			output.UnprocessedItems[table] = writes[len(writes)-mdb.unprocessed:]
+			writes = writes[:len(writes)-mdb.unprocessed]
+			mdb.unprocessed = 0
+		}
+		mdb.batches = append(mdb.batches, writes)
+	}
+	return output, nil
+}
+
 func TestFetchSchedRequests(t *testing.T) {
 	mockConn := new(mockDynamoDB)
 	table := "FetchSchedRequests_test"
+	setupMultiRecords := func() {
+		mockConn.items = []map[string]*dynamodb.AttributeValue{
+			{
+				"ID":             {S: aws.String("test-multiple-records-1")},
+				"EffectiveAfter": {S: aws.String("2018-09-02T00:02:03Z")},
+			},
+			{
+				"ID":             {S: aws.String("test-multiple-records-2")},
+				"EffectiveAfter": {S: aws.String("2018-09-03T00:02:03Z")},
+			},
+			{
+				"ID":             {S: aws.String("test-multiple-records-3")},
+				"EffectiveAfter": {S: aws.String("2018-09-04T00:02:03Z")},
+			},
+		}
+	}
 	for _, c := range []struct {
-		caseName string
-		setup    func()
-		err      bool
-		wantLen  int
+		caseName  string
+		setup     func()
+		limit     int
+		pageSize  int
+		tags      map[string]string
+		err       bool
+		wantLen   int
+		wantCalls int
 	}{
 		{
 			caseName: "empty",
@@ -137,24 +244,39 @@ func TestFetchSchedRequests(t *testing.T) {
 			wantLen: 1,
 		},
 		{
-			caseName: "multi_records",
-			setup: func() {
-				mockConn.items = []map[string]*dynamodb.AttributeValue{
-					{
-						"ID":             {S: aws.String("test-multiple-records-1")},
-						"EffectiveAfter": {S: aws.String("2018-09-02T00:02:03Z")},
-					},
-					{
-						"ID":             {S: aws.String("test-multiple-records-2")},
-						"EffectiveAfter": {S: aws.String("2018-09-03T00:02:03Z")},
-					},
-					{
-						"ID":             {S: aws.String("test-multiple-records-3")},
-						"EffectiveAfter": {S: aws.String("2018-09-04T00:02:03Z")},
-					},
-				}
-			},
-			wantLen: 3,
+			caseName:  "multi_records",
+			setup:     setupMultiRecords,
+			wantLen:   3,
+			wantCalls: 1,
+		},
+		{
+			caseName:  "paginated",
+			setup:     setupMultiRecords,
+			pageSize:  2,
+			wantLen:   3,
+			wantCalls: 2,
+		},
+		{
+			caseName:  "limit_across_pages",
+			setup:     setupMultiRecords,
+			limit:     2,
+			pageSize:  1,
+			wantLen:   2,
+			wantCalls: 2,
+		},
+		{
+			caseName:  "limit_within_page",
+			setup:     setupMultiRecords,
+			limit:     2,
+			wantLen:   2,
+			wantCalls: 1,
+		},
+		{
+			caseName:  "tags",
+			setup:     setupMultiRecords,
+			tags:      map[string]string{"team": "payments"},
+			wantLen:   3,
+			wantCalls: 1,
 		},
 		{
 			caseName: "scan_error",
@@ -168,15 +290,23 @@ func TestFetchSchedRequests(t *testing.T) {
 			mockConn.clear()
 			c.setup()
 			current := time.Now().UTC()
-			records, err := FetchSchedRequests(context.Background(), mockConn, table, current)
+			records, err := FetchSchedRequests(context.Background(), mockConn, table, current, c.limit, c.pageSize, c.tags)
 			if c.err == true {
 				assert.Error(t, err)
 			} else {
 				require.NoError(t, err)
 				lenRecords := len(records)
 				assert.Equal(t, c.wantLen, lenRecords)
+				if c.wantCalls > 0 {
+					assert.Equal(t, c.wantCalls, mockConn.scanCalls)
+				}
 				// must scan with date time in ISO format
 				assert.Contains(t, mockConn.lastScanQ, current.Format(unixFormat))
+				for k, v := range c.tags {
+					assert.Contains(t, mockConn.lastScanQ, "Tags.#tag0 = :tag0")
+					assert.Contains(t, mockConn.lastScanQ, k)
+					assert.Contains(t, mockConn.lastScanQ, v)
+				}
 				// to prevent duplicate data bug
 				for i := 0; i < lenRecords-1; i++ {
 					assert.NotEqual(t, records[i].ID, records[i+1].ID)
@@ -186,6 +316,89 @@ func TestFetchSchedRequests(t *testing.T) {
 	}
 }
 
+func TestBatchCreate(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "batch_create_test"
+	batchWriteBackoff = time.Millisecond
+	newRequests := func(n int) []*schema.ScheduledRequest {
+		reqs := make([]*schema.ScheduledRequest, n)
+		for i := range reqs {
+			reqs[i] = &schema.ScheduledRequest{
+				ID:             fmt.Sprintf("test-batch-create-%d", i),
+				CreatedAt:      time.Now().UTC(),
+				EffectiveAfter: time.Now().Add(time.Hour).UTC(),
+			}
+		}
+		return reqs
+	}
+	for _, c := range []struct {
+		caseName    string
+		reqs        []*schema.ScheduledRequest
+		unprocessed int
+		batchErr    error
+		wantBatches []int
+		err         bool
+	}{
+		{
+			caseName:    "single_batch",
+			reqs:        newRequests(3),
+			wantBatches: []int{3},
+		},
+		{
+			caseName:    "multiple_batches",
+			reqs:        newRequests(60),
+			wantBatches: []int{25, 25, 10},
+		},
+		{
+			caseName:    "unprocessed_items",
+			reqs:        newRequests(5),
+			unprocessed: 2,
+			wantBatches: []int{3, 2},
+		},
+		{
+			caseName: "batch_error",
+			reqs:     newRequests(5),
+			batchErr: errors.New("internal error"),
+			err:      true,
+		},
+		{
+			caseName: "oversized_request",
+			reqs: func() []*schema.ScheduledRequest {
+				reqs := newRequests(30)
+				reqs[27].Payload = strings.Repeat("x", schema.DefaultMaxPayloadSize+1)
+				return reqs
+			}(),
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			mockConn.unprocessed = c.unprocessed
+			mockConn.batchErr = c.batchErr
+			err := BatchCreate(context.Background(), mockConn, table, c.reqs)
+			if c.err {
+				require.Error(t, err)
+				if c.batchErr == nil {
+					// no batch is written unless every request fits
+					assert.Empty(t, mockConn.batches)
+				}
+				return
+			}
+			require.NoError(t, err)
+			var sizes []int
+			written := map[string]bool{}
+			for _, batch := range mockConn.batches {
+				sizes = append(sizes, len(batch))
+				for _, w := range batch {
+					written[aws.StringValue(w.PutRequest.Item["ID"].S)] = true
+				}
+			}
+			assert.Equal(t, c.wantBatches, sizes)
+			assert.Len(t, written, len(c.reqs))
+		})
+	}
+}
+
 func TestCreateRequest(t *testing.T) {
 	mockConn := new(mockDynamoDB)
 	table := "create_test"
@@ -194,10 +407,21 @@ func TestCreateRequest(t *testing.T) {
 		CreatedAt:      time.Now().UTC(),
 		EffectiveAfter: time.Now().Add(time.Hour).UTC(),
 	}
+	oversized := func(update func(*schema.ScheduledRequest)) *schema.ScheduledRequest {
+		r := *req
+		update(&r)
+		return &r
+	}
+	manyHeaders := map[string]string{}
+	for i := 0; i <= schema.DefaultMaxHeaders; i++ {
+		manyHeaders[fmt.Sprintf("X-Header-%d", i)] = "value"
+	}
 	for _, c := range []struct {
 		caseName string
+		req      *schema.ScheduledRequest
 		setup    func()
 		err      bool
+		wantErr  string
 	}{
 		{
 			caseName: "ok",
@@ -210,13 +434,50 @@ func TestCreateRequest(t *testing.T) {
 			},
 			err: true,
 		},
+		{
+			caseName: "payload_too_large",
+			req: oversized(func(r *schema.ScheduledRequest) {
+				r.Payload = strings.Repeat("x", schema.DefaultMaxPayloadSize+1)
+			}),
+			setup:   func() {},
+			err:     true,
+			wantErr: "payload of 358401 bytes exceeds the limit of 358400 bytes",
+		},
+		{
+			caseName: "too_many_headers",
+			req: oversized(func(r *schema.ScheduledRequest) {
+				r.Headers = manyHeaders
+			}),
+			setup:   func() {},
+			err:     true,
+			wantErr: "51 headers exceed the limit of 50 headers",
+		},
+		{
+			caseName: "header_too_large",
+			req: oversized(func(r *schema.ScheduledRequest) {
+				r.Headers = map[string]string{"Cookie": strings.Repeat("x", schema.DefaultMaxHeaderSize)}
+			}),
+			setup:   func() {},
+			err:     true,
+			wantErr: `header "Cookie" of 8198 bytes exceeds the limit of 8192 bytes`,
+		},
 	} {
 		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
 			mockConn.clear()
 			c.setup()
-			err := Create(context.Background(), mockConn, table, req)
+			r := req
+			if c.req != nil {
+				r = c.req
+			}
+			err := Create(context.Background(), mockConn, table, r)
 			if c.err == true {
 				assert.Error(t, err)
+				if c.wantErr != "" {
+					assert.Equal(t, ErrValidation, pkgerrors.Cause(err))
+					assert.Contains(t, err.Error(), c.wantErr)
+					// rejected before reaching storage
+					assert.Nil(t, mockConn.lastPutItem)
+				}
 			} else {
 				require.NoError(t, err)
 				assert.NotNil(t, mockConn.lastPutItem)
@@ -241,15 +502,35 @@ func TestUpdateResult(t *testing.T) {
 	current := time.Now().UTC()
 	for _, c := range []struct {
 		caseName string
+		version  int64
 		setup    func()
+		wantCond string
+		errCause error
 		err      bool
 	}{
 		{
 			caseName: "ok",
+			version:  anyVersion,
+			setup:    func() {},
+		},
+		{
+			caseName: "versioned",
+			version:  3,
 			setup:    func() {},
+			wantCond: "Version = :v",
+		},
+		{
+			caseName: "version_conflict",
+			version:  3,
+			setup: func() {
+				mockConn.updateErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			errCause: errVersionConflict,
+			err:      true,
 		},
 		{
 			caseName: "error",
+			version:  anyVersion,
 			setup: func() {
 				mockConn.updateErr = errors.New("internal error")
 			},
@@ -259,14 +540,21 @@ func TestUpdateResult(t *testing.T) {
 		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
 			mockConn.clear()
 			c.setup()
-			err := updateResult(context.Background(), mockConn, table, req.ID, resp, current)
+			err := updateResult(context.Background(), mockConn, table, req.ID, c.version, resp, current)
 			if c.err == true {
 				assert.Error(t, err)
+				if c.errCause != nil {
+					assert.Equal(t, c.errCause, pkgerrors.Cause(err))
+				}
 			} else {
 				require.NoError(t, err)
+				assert.Equal(t, c.wantCond, aws.StringValue(mockConn.lastUpdateItem.ConditionExpression))
 				assert.NotNil(t, mockConn.lastUpdateItem)
 				assert.Equal(t, "test-updateResult", *mockConn.lastUpdateItem.Key["ID"].S)
 				assert.Equal(t, seriallized, *mockConn.lastUpdateItem.ExpressionAttributeValues[":r"].S)
+				// the successful attempt is counted too
+				assert.Contains(t, aws.StringValue(mockConn.lastUpdateItem.UpdateExpression), "LastAttemptAt = :e ADD Attempts :one")
+				assert.Equal(t, "1", aws.StringValue(mockConn.lastUpdateItem.ExpressionAttributeValues[":one"].N))
 			}
 		})
 	}
@@ -280,15 +568,41 @@ func TestRemoveRequest(t *testing.T) {
 	}
 	for _, c := range []struct {
 		caseName string
+		version  int64
 		setup    func()
+		wantCond string
+		errCause error
 		err      bool
 	}{
 		{
 			caseName: "ok",
+			version:  anyVersion,
+			setup:    func() {},
+		},
+		{
+			caseName: "unversioned",
+			version:  0,
 			setup:    func() {},
+			wantCond: "(attribute_not_exists(Version) or Version = :v)",
+		},
+		{
+			caseName: "versioned",
+			version:  3,
+			setup:    func() {},
+			wantCond: "Version = :v",
+		},
+		{
+			caseName: "version_conflict",
+			version:  3,
+			setup: func() {
+				mockConn.delErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			errCause: errVersionConflict,
+			err:      true,
 		},
 		{
 			caseName: "error",
+			version:  anyVersion,
 			setup: func() {
 				mockConn.delErr = errors.New("internal error")
 			},
@@ -298,14 +612,18 @@ func TestRemoveRequest(t *testing.T) {
 		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
 			mockConn.clear()
 			c.setup()
-			err := removeRequest(context.Background(), mockConn, table, req.ID)
+			err := removeRequest(context.Background(), mockConn, table, req.ID, c.version)
 			if c.err == true {
 				assert.Error(t, err)
+				if c.errCause != nil {
+					assert.Equal(t, c.errCause, pkgerrors.Cause(err))
+				}
 			} else {
 				require.NoError(t, err)
 				assert.NotNil(t, mockConn.lastDeleteItem)
 				assert.Equal(t, req.ID, *mockConn.lastDeleteItem.Key["ID"].S)
 				assert.Equal(t, table, *mockConn.lastDeleteItem.TableName)
+				assert.Equal(t, c.wantCond, aws.StringValue(mockConn.lastDeleteItem.ConditionExpression))
 			}
 		})
 	}
@@ -320,15 +638,35 @@ func TestLogFailure(t *testing.T) {
 	lerr := errors.New("Unexpected error happened!")
 	for _, c := range []struct {
 		caseName string
+		version  int64
 		setup    func()
+		wantCond string
+		errCause error
 		err      bool
 	}{
 		{
 			caseName: "ok",
+			version:  anyVersion,
+			setup:    func() {},
+		},
+		{
+			caseName: "unversioned",
+			version:  0,
 			setup:    func() {},
+			wantCond: "(attribute_not_exists(Version) or Version = :v)",
+		},
+		{
+			caseName: "version_conflict",
+			version:  3,
+			setup: func() {
+				mockConn.updateErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			errCause: errVersionConflict,
+			err:      true,
 		},
 		{
 			caseName: "error",
+			version:  anyVersion,
 			setup: func() {
 				mockConn.updateErr = errors.New("internal error")
 			},
@@ -338,14 +676,85 @@ func TestLogFailure(t *testing.T) {
 		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
 			mockConn.clear()
 			c.setup()
-			err := logFailure(context.Background(), mockConn, table, req.ID, lerr)
+			err := logFailure(context.Background(), mockConn, table, req.ID, c.version, lerr, time.Date(2018, time.September, 02, 0, 2, 3, 0, time.UTC))
 			if c.err == true {
 				assert.Error(t, err)
+				if c.errCause != nil {
+					assert.Equal(t, c.errCause, pkgerrors.Cause(err))
+				}
 			} else {
 				require.NoError(t, err)
+				assert.Equal(t, c.wantCond, aws.StringValue(mockConn.lastUpdateItem.ConditionExpression))
 				assert.NotNil(t, mockConn.lastUpdateItem)
 				assert.Equal(t, "test-logFailure", *mockConn.lastUpdateItem.Key["ID"].S)
 				assert.Equal(t, lerr.Error(), *mockConn.lastUpdateItem.ExpressionAttributeValues[":f"].S)
+				assert.Equal(t, "1", *mockConn.lastUpdateItem.ExpressionAttributeValues[":one"].N)
+				assert.Equal(t, "2018-09-02T00:02:03Z", *mockConn.lastUpdateItem.ExpressionAttributeValues[":at"].S)
+				history := mockConn.lastUpdateItem.ExpressionAttributeValues[":h"].L
+				require.Len(t, history, 1)
+				assert.Equal(t, "2018-09-02T00:02:03Z", *history[0].M["At"].S)
+				assert.Equal(t, lerr.Error(), *history[0].M["Reason"].S)
+			}
+		})
+	}
+}
+
+func TestReschedule(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "reschedule_test"
+	at := time.Date(2018, time.September, 02, 0, 2, 3, 0, time.UTC)
+	for _, c := range []struct {
+		caseName string
+		version  int64
+		setup    func()
+		wantCond string
+		errCause error
+		err      bool
+	}{
+		{
+			caseName: "ok",
+			version:  anyVersion,
+			setup:    func() {},
+		},
+		{
+			caseName: "versioned",
+			version:  3,
+			setup:    func() {},
+			wantCond: "Version = :v",
+		},
+		{
+			caseName: "version_conflict",
+			version:  3,
+			setup: func() {
+				mockConn.updateErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			errCause: errVersionConflict,
+			err:      true,
+		},
+		{
+			caseName: "error",
+			version:  anyVersion,
+			setup: func() {
+				mockConn.updateErr = errors.New("internal error")
+			},
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			c.setup()
+			err := reschedule(context.Background(), mockConn, table, "test-reschedule", c.version, at)
+			if c.err == true {
+				assert.Error(t, err)
+				if c.errCause != nil {
+					assert.Equal(t, c.errCause, pkgerrors.Cause(err))
+				}
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, c.wantCond, aws.StringValue(mockConn.lastUpdateItem.ConditionExpression))
+				assert.Equal(t, "test-reschedule", *mockConn.lastUpdateItem.Key["ID"].S)
+				assert.Equal(t, "2018-09-02T00:02:03Z", *mockConn.lastUpdateItem.ExpressionAttributeValues[":e"].S)
+				assert.False(t, *mockConn.lastUpdateItem.ExpressionAttributeValues[":l"].BOOL)
 			}
 		})
 	}
@@ -362,7 +771,9 @@ func TestLockUnlock(t *testing.T) {
 		caseName         string
 		setup            func() error
 		expectLockStatus bool
+		expectStatus     string
 		err              bool
+		wantErr          error
 	}{
 		{
 			caseName: "lock-ok",
@@ -379,12 +790,42 @@ func TestLockUnlock(t *testing.T) {
 			},
 			err: true,
 		},
+		{
+			caseName: "lock-already-locked",
+			setup: func() error {
+				mockConn.updateErr = &dynamodb.ConditionalCheckFailedException{
+					Item: map[string]*dynamodb.AttributeValue{"ID": {S: aws.String(req.ID)}, "Locking": {BOOL: aws.Bool(true)}},
+				}
+				return Lock(ctx, mockConn, table, req.ID)
+			},
+			err:     true,
+			wantErr: ErrAlreadyLocked,
+		},
+		{
+			caseName: "lock-not-found",
+			setup: func() error {
+				mockConn.updateErr = &dynamodb.ConditionalCheckFailedException{}
+				return Lock(ctx, mockConn, table, req.ID)
+			},
+			err:     true,
+			wantErr: ErrNotFound,
+		},
+		{
+			caseName: "unlock-not-found",
+			setup: func() error {
+				mockConn.updateErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+				return Unlock(ctx, mockConn, table, req.ID)
+			},
+			err:     true,
+			wantErr: ErrNotFound,
+		},
 		{
 			caseName: "unlock-ok",
 			setup: func() error {
 				return Unlock(ctx, mockConn, table, req.ID)
 			},
 			expectLockStatus: false,
+			expectStatus:     schema.StatusPending,
 		},
 		{
 			caseName: "unlock-error",
@@ -394,17 +835,40 @@ func TestLockUnlock(t *testing.T) {
 			},
 			err: true,
 		},
+		{
+			caseName: "cancel-ok",
+			setup: func() error {
+				return Cancel(ctx, mockConn, table, req.ID)
+			},
+			expectLockStatus: true,
+			expectStatus:     schema.StatusCancelled,
+		},
+		{
+			caseName: "cancel-not-found",
+			setup: func() error {
+				mockConn.updateErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+				return Cancel(ctx, mockConn, table, req.ID)
+			},
+			err:     true,
+			wantErr: ErrNotFound,
+		},
 	} {
 		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
 			mockConn.clear()
 			err := c.setup()
 			if c.err == true {
 				assert.Error(t, err)
+				if c.wantErr != nil {
+					assert.Equal(t, c.wantErr, pkgerrors.Cause(err))
+				}
 			} else {
 				require.NoError(t, err)
 				assert.NotNil(t, mockConn.lastUpdateItem)
 				assert.Equal(t, "test-lock", *mockConn.lastUpdateItem.Key["ID"].S)
 				assert.Equal(t, c.expectLockStatus, *mockConn.lastUpdateItem.ExpressionAttributeValues[":l"].BOOL)
+				if c.expectStatus != "" {
+					assert.Equal(t, c.expectStatus, aws.StringValue(mockConn.lastUpdateItem.ExpressionAttributeValues[":st"].S))
+				}
 			}
 		})
 	}
@@ -418,6 +882,7 @@ func TestGetRequest(t *testing.T) {
 		caseName string
 		setup    func()
 		err      bool
+		wantErr  error
 		want     schema.ScheduledRequest
 	}{
 		{
@@ -427,6 +892,12 @@ func TestGetRequest(t *testing.T) {
 			},
 			err: true,
 		},
+		{
+			caseName: "not_found",
+			setup:    func() {},
+			err:      true,
+			wantErr:  ErrNotFound,
+		},
 		{
 			caseName: "ok",
 			setup: func() {
@@ -449,6 +920,9 @@ func TestGetRequest(t *testing.T) {
 			record, err := Get(context.Background(), mockConn, table, reqID)
 			if c.err == true {
 				assert.Error(t, err)
+				if c.wantErr != nil {
+					assert.Equal(t, c.wantErr, pkgerrors.Cause(err))
+				}
 			} else {
 				require.NoError(t, err)
 				assert.NotNil(t, record)
@@ -457,3 +931,632 @@ func TestGetRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestRescheduleRequest(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "reschedule_test"
+	at := time.Date(2018, 9, 2, 0, 2, 3, 0, time.UTC)
+	for _, c := range []struct {
+		caseName   string
+		unlock     bool
+		setup      func()
+		wantUpdate string
+		err        bool
+	}{
+		{
+			caseName:   "keep_lock",
+			setup:      func() {},
+			wantUpdate: "SET EffectiveAfter = :e REMOVE FailureReason ADD Version :vone",
+		},
+		{
+			caseName:   "unlock",
+			unlock:     true,
+			setup:      func() {},
+			wantUpdate: "SET #st = :st, EffectiveAfter = :e, Locking = :l REMOVE FailureReason ADD Version :vone",
+		},
+		{
+			caseName: "not_found",
+			setup: func() {
+				mockConn.updateErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			c.setup()
+			err := Reschedule(context.Background(), mockConn, table, "test-reschedule", at, c.unlock)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, mockConn.lastUpdateItem)
+			assert.Equal(t, c.wantUpdate, aws.StringValue(mockConn.lastUpdateItem.UpdateExpression))
+			assert.Equal(t, "2018-09-02T00:02:03Z", aws.StringValue(mockConn.lastUpdateItem.ExpressionAttributeValues[":e"].S))
+			// operator command applies whatever the version
+			assert.Equal(t, "attribute_exists(ID)", aws.StringValue(mockConn.lastUpdateItem.ConditionExpression))
+		})
+	}
+}
+
+func TestRetryFailed(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "retry_failed_test"
+	at := time.Date(2018, 9, 3, 0, 0, 0, 0, time.UTC)
+	setupFailed := func() {
+		mockConn.items = []map[string]*dynamodb.AttributeValue{
+			{
+				"ID":             {S: aws.String("test-retry-failed-1")},
+				"EffectiveAfter": {S: aws.String("2018-09-02T00:02:03Z")},
+				"Locking":        {BOOL: aws.Bool(true)},
+				"FailureReason":  {S: aws.String("connection refused")},
+			},
+			{
+				"ID":             {S: aws.String("test-retry-failed-2")},
+				"EffectiveAfter": {S: aws.String("2018-09-02T00:02:03Z")},
+				"Locking":        {BOOL: aws.Bool(true)},
+				"FailureReason":  {S: aws.String("status 500")},
+			},
+		}
+	}
+	for _, c := range []struct {
+		caseName      string
+		at            time.Time
+		setup         func()
+		wantRetried   []string
+		wantEffective string
+		err           bool
+	}{
+		{
+			caseName:      "keep_effective_date",
+			setup:         setupFailed,
+			wantRetried:   []string{"test-retry-failed-1", "test-retry-failed-2"},
+			wantEffective: "2018-09-02T00:02:03Z",
+		},
+		{
+			caseName:      "bump_effective_date",
+			at:            at,
+			setup:         setupFailed,
+			wantRetried:   []string{"test-retry-failed-1", "test-retry-failed-2"},
+			wantEffective: "2018-09-03T00:00:00Z",
+		},
+		{
+			caseName:    "none",
+			setup:       func() {},
+			wantRetried: []string{},
+		},
+		{
+			caseName: "update_error",
+			setup: func() {
+				setupFailed()
+				mockConn.updateErr = errors.New("internal error")
+			},
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			c.setup()
+			retried, err := RetryFailed(context.Background(), mockConn, table, c.at)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.wantRetried, retried)
+			assert.Contains(t, mockConn.lastScanQ, "Locking = :locked")
+			if c.wantEffective != "" {
+				require.NotNil(t, mockConn.lastUpdateItem)
+				assert.Equal(t, c.wantEffective, aws.StringValue(mockConn.lastUpdateItem.ExpressionAttributeValues[":e"].S))
+				assert.False(t, aws.BoolValue(mockConn.lastUpdateItem.ExpressionAttributeValues[":l"].BOOL))
+			}
+		})
+	}
+}
+
+func TestPurge(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "purge_test"
+	before := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	setupExecuted := func() {
+		mockConn.items = []map[string]*dynamodb.AttributeValue{
+			{
+				"ID":              {S: aws.String("test-purge-1")},
+				"ExecutedAt":      {S: aws.String("2018-09-02T00:02:03Z")},
+				"PersistentStore": {BOOL: aws.Bool(true)},
+			},
+			{
+				"ID":              {S: aws.String("test-purge-2")},
+				"ExecutedAt":      {S: aws.String("2018-09-03T00:02:03Z")},
+				"PersistentStore": {BOOL: aws.Bool(true)},
+			},
+		}
+	}
+	for _, c := range []struct {
+		caseName     string
+		archiveTable string
+		setup        func()
+		wantPurged   []string
+		wantArchived bool
+		err          bool
+	}{
+		{
+			caseName:   "delete",
+			setup:      setupExecuted,
+			wantPurged: []string{"test-purge-1", "test-purge-2"},
+		},
+		{
+			caseName:     "archive",
+			archiveTable: "purge_archive_test",
+			setup:        setupExecuted,
+			wantPurged:   []string{"test-purge-1", "test-purge-2"},
+			wantArchived: true,
+		},
+		{
+			caseName:     "archive_over_limits",
+			archiveTable: "purge_archive_test",
+			setup: func() {
+				setupExecuted()
+				// stored before the payload limit was lowered
+				mockConn.items[1]["Payload"] = &dynamodb.AttributeValue{S: aws.String(strings.Repeat("a", schema.DefaultMaxPayloadSize+1))}
+			},
+			wantPurged:   []string{"test-purge-1", "test-purge-2"},
+			wantArchived: true,
+		},
+		{
+			caseName:     "archive_conflict",
+			archiveTable: "purge_archive_test",
+			setup: func() {
+				setupExecuted()
+				mockConn.putErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			err: true,
+		},
+		{
+			caseName:     "archive_error",
+			archiveTable: "purge_archive_test",
+			setup: func() {
+				setupExecuted()
+				mockConn.putErr = errors.New("internal error")
+			},
+			err: true,
+		},
+		{
+			caseName: "delete_error",
+			setup: func() {
+				setupExecuted()
+				mockConn.delErr = errors.New("internal error")
+			},
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			c.setup()
+			purged, err := Purge(context.Background(), mockConn, table, before, c.archiveTable)
+			if c.err {
+				assert.Error(t, err)
+				assert.Empty(t, purged)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.wantPurged, purged)
+			assert.Contains(t, mockConn.lastScanQ, "2018-10-01T00:00:00Z")
+			require.NotNil(t, mockConn.lastDeleteItem)
+			assert.Equal(t, "test-purge-2", aws.StringValue(mockConn.lastDeleteItem.Key["ID"].S))
+			if c.wantArchived {
+				put := mockConn.lastPutItem
+				require.NotNil(t, put)
+				assert.Equal(t, c.archiveTable, aws.StringValue(put.TableName))
+				assert.Equal(t, "attribute_not_exists(ID) or (attribute_not_exists(Version) or Version = :v)", aws.StringValue(put.ConditionExpression))
+				// copied as stored rather than as a new record
+				assert.Equal(t, "test-purge-2", aws.StringValue(put.Item["ID"].S))
+				if status := put.Item["Status"]; status != nil {
+					assert.NotEqual(t, schema.StatusPending, aws.StringValue(status.S))
+				}
+			} else {
+				assert.Nil(t, mockConn.lastPutItem)
+			}
+		})
+	}
+}
+
+func TestTrimResults(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "trim_results_test"
+	now := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	setupExecuted := func() {
+		mockConn.items = []map[string]*dynamodb.AttributeValue{
+			{
+				"ID":              {S: aws.String("test-trim-expired")},
+				"ExecutedAt":      {S: aws.String("2018-09-02T00:00:00Z")},
+				"ExecutionResult": {S: aws.String(`{"code":200}`)},
+				"ResultRetention": {S: aws.String("168h")},
+				"Version":         {N: aws.String("3")},
+			},
+			{
+				"ID":              {S: aws.String("test-trim-retained")},
+				"ExecutedAt":      {S: aws.String("2018-09-28T00:00:00Z")},
+				"ExecutionResult": {S: aws.String(`{"code":200}`)},
+				"ResultRetention": {S: aws.String("168h")},
+			},
+			{
+				"ID":              {S: aws.String("test-trim-forever")},
+				"ExecutedAt":      {S: aws.String("2018-09-02T00:00:00Z")},
+				"ExecutionResult": {S: aws.String(`{"code":200}`)},
+			},
+		}
+	}
+	for _, c := range []struct {
+		caseName    string
+		setup       func()
+		wantTrimmed []string
+		err         bool
+	}{
+		{
+			caseName:    "expired",
+			setup:       setupExecuted,
+			wantTrimmed: []string{"test-trim-expired"},
+		},
+		{
+			caseName: "updated_meanwhile",
+			setup: func() {
+				setupExecuted()
+				mockConn.updateErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			wantTrimmed: []string{},
+		},
+		{
+			caseName: "update_error",
+			setup: func() {
+				setupExecuted()
+				mockConn.updateErr = errors.New("internal error")
+			},
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			c.setup()
+			trimmed, err := TrimResults(context.Background(), mockConn, table, now)
+			if c.err {
+				assert.Error(t, err)
+				assert.Empty(t, trimmed)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.wantTrimmed, trimmed)
+			require.NotNil(t, mockConn.lastUpdateItem)
+			update := mockConn.lastUpdateItem
+			assert.Equal(t, "test-trim-expired", aws.StringValue(update.Key["ID"].S))
+			assert.Equal(t, "REMOVE ExecutionResult ADD Version :vone", aws.StringValue(update.UpdateExpression))
+			assert.Equal(t, "attribute_exists(ID) and Version = :v", aws.StringValue(update.ConditionExpression))
+			assert.Equal(t, "3", aws.StringValue(update.ExpressionAttributeValues[":v"].N))
+			// the record itself is kept
+			assert.Nil(t, mockConn.lastDeleteItem)
+		})
+	}
+}
+
+func TestClone(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "clone_test"
+	now := time.Date(2018, 9, 5, 0, 0, 0, 0, time.UTC)
+	at := now.Add(time.Hour)
+	setupExecuted := func() {
+		mockConn.item = map[string]*dynamodb.AttributeValue{
+			"ID":              {S: aws.String("test-clone")},
+			"CreatedAt":       {S: aws.String("2018-09-01T00:02:03Z")},
+			"EffectiveAfter":  {S: aws.String("2018-09-02T00:02:03Z")},
+			"ExecutedAt":      {S: aws.String("2018-09-02T00:02:05Z")},
+			"Method":          {S: aws.String("POST")},
+			"URL":             {S: aws.String("/reports")},
+			"Payload":         {S: aws.String(`{"daily":true}`)},
+			"Locking":         {BOOL: aws.Bool(true)},
+			"FailureReason":   {S: aws.String("status 500")},
+			"Attempts":        {N: aws.String("2")},
+			"LastAttemptAt":   {S: aws.String("2018-09-02T00:02:04Z")},
+			"FailureHistory":  {L: []*dynamodb.AttributeValue{{M: map[string]*dynamodb.AttributeValue{"Reason": {S: aws.String("status 500")}}}}},
+			"Version":         {N: aws.String("7")},
+			"CreatedBy":       {S: aws.String("billing-ui")},
+			"PersistentStore": {BOOL: aws.Bool(true)},
+			"ExecutionResult": {S: aws.String(`{"code":200}`)},
+		}
+	}
+	for _, c := range []struct {
+		caseName string
+		setup    func()
+		err      bool
+	}{
+		{
+			caseName: "ok",
+			setup:    setupExecuted,
+		},
+		{
+			caseName: "not_found",
+			setup:    func() {},
+			err:      true,
+		},
+		{
+			caseName: "new_id_taken",
+			setup: func() {
+				setupExecuted()
+				mockConn.putErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			c.setup()
+			req, err := Clone(context.Background(), mockConn, table, "test-clone", "test-clone-copy", "ops", at, now)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "test-clone-copy", req.ID)
+			assert.Equal(t, "POST", req.Method)
+			assert.Equal(t, `{"daily":true}`, req.Payload)
+			assert.True(t, req.PersistentStore)
+			assert.Equal(t, now, req.CreatedAt)
+			assert.Equal(t, at, req.EffectiveAfter)
+			assert.False(t, req.Locking)
+			assert.Empty(t, req.FailureReason)
+			assert.Zero(t, req.Attempts)
+			assert.True(t, req.LastAttemptAt.IsZero())
+			assert.Empty(t, req.FailureHistory)
+			assert.Zero(t, req.Version)
+			assert.Equal(t, "ops", req.CreatedBy)
+			assert.Empty(t, req.ExecutionResult)
+			assert.True(t, req.ExecutedAt.IsZero())
+			require.NotNil(t, mockConn.lastPutItem)
+			assert.Equal(t, "0", aws.StringValue(mockConn.lastPutItem.Item["Version"].N))
+			assert.Equal(t, "ops", aws.StringValue(mockConn.lastPutItem.Item["CreatedBy"].S))
+			assert.Equal(t, "attribute_not_exists(ID)", aws.StringValue(mockConn.lastPutItem.ConditionExpression))
+		})
+	}
+}
+
+func TestStorageCancellation(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "storage_cancellation_test"
+	batchWriteBackoff = time.Millisecond
+	newRequest := func(i int) *schema.ScheduledRequest {
+		return &schema.ScheduledRequest{
+			ID:             fmt.Sprintf("test-cancellation-%d", i),
+			CreatedAt:      time.Now().UTC(),
+			EffectiveAfter: time.Now().Add(time.Hour).UTC(),
+		}
+	}
+	setupItems := func() {
+		for i := 1; i <= 3; i++ {
+			mockConn.items = append(mockConn.items, map[string]*dynamodb.AttributeValue{
+				"ID":             {S: aws.String(fmt.Sprintf("test-cancellation-%d", i))},
+				"EffectiveAfter": {S: aws.String("2018-09-02T00:02:03Z")},
+				"Locking":        {BOOL: aws.Bool(true)},
+				"FailureReason":  {S: aws.String("connection refused")},
+			})
+		}
+	}
+	for _, c := range []struct {
+		caseName    string
+		setup       func()
+		cancelAfter int32
+		run         func(ctx context.Context) error
+		verify      func(t *testing.T)
+	}{
+		{
+			caseName: "create_canceled",
+			run: func(ctx context.Context) error {
+				return Create(ctx, mockConn, table, newRequest(1))
+			},
+			verify: func(t *testing.T) {
+				assert.Nil(t, mockConn.lastPutItem)
+			},
+		},
+		{
+			caseName:    "fetch_between_pages",
+			setup:       setupItems,
+			cancelAfter: 1,
+			run: func(ctx context.Context) error {
+				_, err := FetchSchedRequests(ctx, mockConn, table, time.Date(2018, 9, 3, 0, 0, 0, 0, time.UTC), 10, 1, nil)
+				return err
+			},
+			verify: func(t *testing.T) {
+				assert.Equal(t, 1, mockConn.scanCalls)
+			},
+		},
+		{
+			caseName:    "batch_create_between_batches",
+			cancelAfter: 1,
+			run: func(ctx context.Context) error {
+				reqs := []*schema.ScheduledRequest{}
+				for i := 0; i < 30; i++ {
+					reqs = append(reqs, newRequest(i))
+				}
+				return BatchCreate(ctx, mockConn, table, reqs)
+			},
+			verify: func(t *testing.T) {
+				assert.Len(t, mockConn.batches, 1)
+			},
+		},
+		{
+			caseName:    "retry_failed_between_updates",
+			setup:       setupItems,
+			cancelAfter: 2,
+			run: func(ctx context.Context) error {
+				retried, err := RetryFailed(ctx, mockConn, table, time.Time{})
+				assert.Equal(t, []string{"test-cancellation-1"}, retried)
+				return err
+			},
+			verify: func(t *testing.T) {
+				require.NotNil(t, mockConn.lastUpdateItem)
+				assert.Equal(t, "test-cancellation-1", aws.StringValue(mockConn.lastUpdateItem.Key["ID"].S))
+			},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			if c.setup != nil {
+				c.setup()
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if c.cancelAfter == 0 {
+				cancel()
+			}
+			mockConn.cancel, mockConn.cancelAfter = cancel, c.cancelAfter
+			err := c.run(ctx)
+			require.Error(t, err)
+			aerr, ok := pkgerrors.Cause(err).(awserr.Error)
+			require.True(t, ok, "unexpected error %v", err)
+			assert.Equal(t, request.CanceledErrorCode, aerr.Code())
+			c.verify(t)
+		})
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	table := "upsert_test"
+	for _, c := range []struct {
+		caseName    string
+		req         *schema.ScheduledRequest
+		setup       func()
+		err         bool
+		wantErr     error
+		wantCreated bool
+		wantVersion int64
+		wantRemove  string
+	}{
+		{
+			caseName:    "created",
+			req:         &schema.ScheduledRequest{ID: "test-upsert", Method: "GET", URL: "/reports"},
+			setup:       func() {},
+			wantCreated: true,
+			wantVersion: 1,
+		},
+		{
+			caseName: "replaced",
+			req:      &schema.ScheduledRequest{ID: "test-upsert", Method: "GET", URL: "/reports"},
+			setup: func() {
+				mockConn.item = map[string]*dynamodb.AttributeValue{
+					"ID":      {S: aws.String("test-upsert")},
+					"Version": {N: aws.String("7")},
+				}
+			},
+			wantVersion: 8,
+		},
+		{
+			caseName: "replaced_from_older_version",
+			req:      &schema.ScheduledRequest{ID: "test-upsert", Method: "GET", URL: "/reports", Version: 3},
+			setup: func() {
+				mockConn.item = map[string]*dynamodb.AttributeValue{
+					"ID":      {S: aws.String("test-upsert")},
+					"Version": {N: aws.String("7")},
+				}
+			},
+			wantVersion: 8,
+		},
+		{
+			caseName: "stale_tags",
+			req:      &schema.ScheduledRequest{ID: "test-upsert", Method: "GET", URL: "/reports", Tags: map[string]string{"env": "prod"}},
+			setup: func() {
+				mockConn.item = map[string]*dynamodb.AttributeValue{
+					"ID":       {S: aws.String("test-upsert")},
+					"Version":  {N: aws.String("2")},
+					"Tag_env":  {S: aws.String("dev")},
+					"Tag_team": {S: aws.String("billing")},
+				}
+			},
+			wantVersion: 3,
+			wantRemove:  "Tag_team",
+		},
+		{
+			caseName: "running",
+			req:      &schema.ScheduledRequest{ID: "test-upsert", Method: "GET", URL: "/reports"},
+			setup: func() {
+				mockConn.updateErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			err:     true,
+			wantErr: ErrAlreadyLocked,
+		},
+		{
+			caseName: "locked_without_status",
+			req:      &schema.ScheduledRequest{ID: "test-upsert", Method: "GET", URL: "/reports"},
+			setup: func() {
+				// stored before Status was maintained and being executed, refused by the lock clause
+				mockConn.item = map[string]*dynamodb.AttributeValue{
+					"ID":      {S: aws.String("test-upsert")},
+					"Locking": {BOOL: aws.Bool(true)},
+				}
+				mockConn.updateErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			err:     true,
+			wantErr: ErrAlreadyLocked,
+		},
+		{
+			caseName: "invalid",
+			req:      &schema.ScheduledRequest{ID: "test-upsert", Method: "GET", URL: "/reports", Payload: strings.Repeat("x", schema.DefaultMaxPayloadSize+1)},
+			setup:    func() {},
+			err:      true,
+			wantErr:  ErrValidation,
+		},
+		{
+			caseName: "error",
+			req:      &schema.ScheduledRequest{ID: "test-upsert", Method: "GET", URL: "/reports"},
+			setup: func() {
+				mockConn.updateErr = errors.New("internal error")
+			},
+			err: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			mockConn.item = nil
+			c.setup()
+			created, err := Upsert(context.Background(), mockConn, table, c.req)
+			if c.wantErr == ErrAlreadyLocked {
+				// a record without Status must be unlocked to be replaced
+				update := mockConn.lastUpdateItem
+				require.NotNil(t, update)
+				assert.Equal(t, "(attribute_not_exists(#st) and (attribute_not_exists(Locking) or Locking = :unlocked)) or #st <> :running", aws.StringValue(update.ConditionExpression))
+				assert.False(t, aws.BoolValue(update.ExpressionAttributeValues[":unlocked"].BOOL))
+				assert.Equal(t, schema.StatusRunning, aws.StringValue(update.ExpressionAttributeValues[":running"].S))
+			}
+			if c.err {
+				assert.Error(t, err)
+				if c.wantErr != nil {
+					assert.Equal(t, c.wantErr, pkgerrors.Cause(err))
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.wantCreated, created)
+			// the version only goes up from the stored one, whatever the version of request
+			assert.Equal(t, c.wantVersion, c.req.Version)
+			update := mockConn.lastUpdateItem
+			require.NotNil(t, update)
+			if c.wantRemove != "" {
+				assert.Equal(t, "REMOVE #t0", aws.StringValue(update.UpdateExpression))
+				assert.Equal(t, c.wantRemove, aws.StringValue(update.ExpressionAttributeNames["#t0"]))
+				assert.Equal(t, "Version = :v", aws.StringValue(update.ConditionExpression))
+				assert.Equal(t, fmt.Sprint(c.wantVersion), aws.StringValue(update.ExpressionAttributeValues[":v"].N))
+				return
+			}
+			assert.Equal(t, upsertCondition, aws.StringValue(update.ConditionExpression))
+			assert.True(t, strings.HasSuffix(aws.StringValue(update.UpdateExpression), " ADD Version :one"))
+			assert.Equal(t, dynamodb.ReturnValueAllOld, aws.StringValue(update.ReturnValues))
+			assert.Equal(t, schema.StatusPending, aws.StringValue(update.ExpressionAttributeValues[":st"].S))
+			names := map[string]bool{}
+			for _, name := range update.ExpressionAttributeNames {
+				names[aws.StringValue(name)] = true
+			}
+			assert.True(t, names["URL"])
+			assert.False(t, names["Version"])
+			assert.False(t, names["ID"])
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/schema"
+)
+
+// Types of request changes told by table stream
+const (
+	ChangeCreated  = "created"
+	ChangeUpdated  = "updated"
+	ChangeExecuted = "executed"
+	ChangeFailed   = "failed"
+	ChangeRemoved  = "removed"
+)
+
+// ChangeEvent is the change of a stored request posted to the callback url
+type ChangeEvent struct {
+	Type      string `json:"type"`
+	RequestID string `json:"request_id"`
+	// The request after the change, or before it if removed
+	Request *schema.ScheduledRequest `json:"request"`
+	At      time.Time                `json:"at"`
+}
+
+// StreamCallback posts the changes of stored requests read from the table stream to a url, e.g.
+// confirming to the service which scheduled a request that it got created or executed
+type StreamCallback struct {
+	callbackURL string
+	client      *http.Client
+}
+
+// NewStreamCallback returns callback posting change events to given url
+func NewStreamCallback(callbackURL string) *StreamCallback {
+	return &StreamCallback{
+		callbackURL: callbackURL,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Handle posts a change event of every stream record, the failed records are reported to be
+// read again
+func (s *StreamCallback) Handle(ctx context.Context, event events.DynamoDBEvent) events.DynamoDBEventResponse {
+	resp := events.DynamoDBEventResponse{BatchItemFailures: []events.DynamoDBBatchItemFailure{}}
+	for _, record := range event.Records {
+		change, err := changeOf(record)
+		if err == nil {
+			err = s.post(ctx, change)
+		}
+		if err != nil {
+			log.Printf("handle stream record failed event_id=%s error=%s \n", record.EventID, err)
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.DynamoDBBatchItemFailure{ItemIdentifier: record.Change.SequenceNumber})
+		}
+	}
+	return resp
+}
+
+// changeOf returns the change event of stream record, telling executions and failures apart from
+// other updates
+func changeOf(record events.DynamoDBEventRecord) (*ChangeEvent, error) {
+	image := record.Change.NewImage
+	if record.EventName == string(events.DynamoDBOperationTypeRemove) {
+		image = record.Change.OldImage
+	}
+	req, err := requestOf(image)
+	if err != nil {
+		return nil, errors.Wrap(err, "new image")
+	}
+	change := &ChangeEvent{RequestID: req.ID, Request: req, At: record.Change.ApproximateCreationDateTime.UTC()}
+	switch record.EventName {
+	case string(events.DynamoDBOperationTypeInsert):
+		change.Type = ChangeCreated
+	case string(events.DynamoDBOperationTypeRemove):
+		change.Type = ChangeRemoved
+	default:
+		old, err := requestOf(record.Change.OldImage)
+		if err != nil {
+			return nil, errors.Wrap(err, "old image")
+		}
+		switch {
+		case req.FailureReason != "" && req.Attempts != old.Attempts:
+			change.Type = ChangeFailed
+		case !req.ExecutedAt.Equal(old.ExecutedAt):
+			change.Type = ChangeExecuted
+		default:
+			change.Type = ChangeUpdated
+		}
+	}
+	return change, nil
+}
+
+// requestOf returns the request of stream image, which is given in the attribute value format
+// of the table
+func requestOf(image map[string]events.DynamoDBAttributeValue) (*schema.ScheduledRequest, error) {
+	serialized, err := json.Marshal(image)
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Marshal")
+	}
+	var item map[string]*dynamodb.AttributeValue
+	if err = json.Unmarshal(serialized, &item); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	req := new(schema.ScheduledRequest)
+	if err = dynamodbattribute.UnmarshalMap(item, req); err != nil {
+		return nil, errors.Wrap(err, "dynamodbattribute.UnmarshalMap")
+	}
+	return req, nil
+}
+
+func (s *StreamCallback) post(ctx context.Context, change *ChangeEvent) error {
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+	req, err := http.NewRequest(http.MethodPost, s.callbackURL, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrap(err, "http.NewRequest")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	log.Printf("post change event type=%s id=%s \n", change.Type, change.RequestID)
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "s.client.Do")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected callback response code=%d", resp.StatusCode)
+	}
+	return nil
+}
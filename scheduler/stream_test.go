@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamCallback(t *testing.T) {
+	var received []*ChangeEvent
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		change := new(ChangeEvent)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(change))
+		received = append(received, change)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	image := func(attempts, failure, executedAt string) map[string]events.DynamoDBAttributeValue {
+		return map[string]events.DynamoDBAttributeValue{
+			"ID":             events.NewStringAttribute("test-stream"),
+			"EffectiveAfter": events.NewStringAttribute("2018-09-02T00:02:03Z"),
+			"ExecutedAt":     events.NewStringAttribute(executedAt),
+			"Locking":        events.NewBooleanAttribute(true),
+			"Attempts":       events.NewNumberAttribute(attempts),
+			"FailureReason":  events.NewStringAttribute(failure),
+			"Headers": events.NewMapAttribute(map[string]events.DynamoDBAttributeValue{
+				"X-Tenant": events.NewStringAttribute("acme"),
+			}),
+		}
+	}
+	never := "0001-01-01T00:00:00Z"
+	record := func(name string, old, new map[string]events.DynamoDBAttributeValue) events.DynamoDBEventRecord {
+		return events.DynamoDBEventRecord{
+			EventID:   "event-" + name,
+			EventName: name,
+			Change: events.DynamoDBStreamRecord{
+				ApproximateCreationDateTime: events.SecondsEpochTime{Time: time.Date(2018, 9, 2, 0, 2, 5, 0, time.UTC)},
+				OldImage:                    old,
+				NewImage:                    new,
+				SequenceNumber:              "seq-" + name,
+			},
+		}
+	}
+	for _, c := range []struct {
+		caseName     string
+		record       events.DynamoDBEventRecord
+		status       int
+		wantType     string
+		wantFailures int
+	}{
+		{
+			caseName: "created",
+			record:   record("INSERT", nil, image("0", "", never)),
+			wantType: ChangeCreated,
+		},
+		{
+			caseName: "executed",
+			record:   record("MODIFY", image("0", "", never), image("0", "", "2018-09-02T00:02:04Z")),
+			wantType: ChangeExecuted,
+		},
+		{
+			caseName: "failed",
+			record:   record("MODIFY", image("0", "", never), image("1", "status 500", never)),
+			wantType: ChangeFailed,
+		},
+		{
+			caseName: "updated",
+			record:   record("MODIFY", image("1", "status 500", never), image("1", "", never)),
+			wantType: ChangeUpdated,
+		},
+		{
+			caseName: "removed",
+			record:   record("REMOVE", image("0", "", never), nil),
+			wantType: ChangeRemoved,
+		},
+		{
+			caseName:     "callback_error",
+			record:       record("INSERT", nil, image("0", "", never)),
+			status:       http.StatusServiceUnavailable,
+			wantType:     ChangeCreated,
+			wantFailures: 1,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			received = nil
+			status = http.StatusOK
+			if c.status != 0 {
+				status = c.status
+			}
+			resp := NewStreamCallback(server.URL).Handle(context.Background(), events.DynamoDBEvent{
+				Records: []events.DynamoDBEventRecord{c.record},
+			})
+			require.Len(t, resp.BatchItemFailures, c.wantFailures)
+			if c.wantFailures > 0 {
+				assert.Equal(t, c.record.Change.SequenceNumber, resp.BatchItemFailures[0].ItemIdentifier)
+			}
+			require.Len(t, received, 1)
+			assert.Equal(t, c.wantType, received[0].Type)
+			assert.Equal(t, "test-stream", received[0].RequestID)
+			require.NotNil(t, received[0].Request)
+			assert.Equal(t, "acme", received[0].Request.Headers["X-Tenant"])
+		})
+	}
+}
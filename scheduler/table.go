@@ -0,0 +1,125 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/pkg/errors"
+)
+
+// tableActiveInterval is the wait between checks of a table being created
+var tableActiveInterval = 2 * time.Second
+
+// EnsureTable creates the table of scheduled requests keyed by ID unless it exists already, along
+// with the status index and the indexes of tagKeys, returns whether it was created once the table
+// is active. The indexes of an existing table are left as they are
+func EnsureTable(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string, tagKeys ...string) (bool, error) {
+	input := &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("ID"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			{AttributeName: aws.String("EffectiveAfter"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			{AttributeName: aws.String("Status"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("ID"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		},
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{dueIndex(StatusIndex, "Status")},
+		BillingMode:            aws.String(dynamodb.BillingModePayPerRequest),
+	}
+	for _, key := range tagKeys {
+		input.AttributeDefinitions = append(input.AttributeDefinitions, &dynamodb.AttributeDefinition{
+			AttributeName: aws.String(TagAttribute(key)), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+		})
+		input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, dueIndex(TagIndex(key), TagAttribute(key)))
+	}
+	return ensureTable(ctx, conn, input)
+}
+
+// dueIndex returns the index of name keyed by attribute and sorted by EffectiveAfter
+func dueIndex(name, attribute string) *dynamodb.GlobalSecondaryIndex {
+	return &dynamodb.GlobalSecondaryIndex{
+		IndexName: aws.String(name),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String(attribute), KeyType: aws.String(dynamodb.KeyTypeHash)},
+			{AttributeName: aws.String("EffectiveAfter"), KeyType: aws.String(dynamodb.KeyTypeRange)},
+		},
+		Projection: &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+	}
+}
+
+// EnsureAuditTable creates the table of audit events keyed by request and time unless it
+// exists already, returns whether it was created once the table is active
+func EnsureAuditTable(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string) (bool, error) {
+	return ensureTable(ctx, conn, &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("RequestID"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			{AttributeName: aws.String("At"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("RequestID"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+			{AttributeName: aws.String("At"), KeyType: aws.String(dynamodb.KeyTypeRange)},
+		},
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+	})
+}
+
+// EnsureCheckpointTable creates the table of fetch cursors keyed by schedule table name unless
+// it exists already, returns whether it was created once the table is active
+func EnsureCheckpointTable(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string) (bool, error) {
+	return ensureNamedTable(ctx, conn, tableName)
+}
+
+// EnsureLeaseTable creates the table of schedule leases keyed by schedule table name unless it
+// exists already, returns whether it was created once the table is active
+func EnsureLeaseTable(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string) (bool, error) {
+	return ensureNamedTable(ctx, conn, tableName)
+}
+
+// ensureNamedTable creates a table keyed by Name unless it exists already
+func ensureNamedTable(ctx context.Context, conn dynamodbiface.DynamoDBAPI, tableName string) (bool, error) {
+	return ensureTable(ctx, conn, &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("Name"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("Name"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		},
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+	})
+}
+
+func ensureTable(ctx context.Context, conn dynamodbiface.DynamoDBAPI, input *dynamodb.CreateTableInput) (bool, error) {
+	tableName := aws.StringValue(input.TableName)
+	created := true
+	if _, err := conn.CreateTableWithContext(ctx, input); err != nil {
+		aerr, ok := err.(awserr.Error)
+		if !ok || aerr.Code() != dynamodb.ErrCodeResourceInUseException {
+			return false, errors.Wrapf(err, "conn.CreateTable table_name=%s", tableName)
+		}
+		// the table exists already, possibly still being created
+		created = false
+	}
+	log.Printf("wait for table table_name=%s created=%t \n", tableName, created)
+	for {
+		output, err := conn.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: input.TableName})
+		if err != nil {
+			return false, errors.Wrapf(err, "conn.DescribeTable table_name=%s", tableName)
+		}
+		if aws.StringValue(output.Table.TableStatus) == dynamodb.TableStatusActive {
+			return created, nil
+		}
+		select {
+		case <-ctx.Done():
+			return false, errors.Wrapf(ctx.Err(), "wait for table table_name=%s status=%s", tableName, aws.StringValue(output.Table.TableStatus))
+		case <-time.After(tableActiveInterval):
+		}
+	}
+}
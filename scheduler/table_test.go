@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockTableDB struct {
+	dynamodbiface.DynamoDBAPI
+	lastCreate *dynamodb.CreateTableInput
+	createErr  error
+	// statuses returned by consecutive describe calls, the last one is repeated
+	statuses    []string
+	describeErr error
+	describes   int
+}
+
+func (mdb *mockTableDB) CreateTableWithContext(ctx aws.Context, input *dynamodb.CreateTableInput, opts ...request.Option) (*dynamodb.CreateTableOutput, error) {
+	mdb.lastCreate = input
+	if mdb.createErr != nil {
+		return nil, mdb.createErr
+	}
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+func (mdb *mockTableDB) DescribeTableWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.Option) (*dynamodb.DescribeTableOutput, error) {
+	if mdb.describeErr != nil {
+		return nil, mdb.describeErr
+	}
+	status := mdb.statuses[len(mdb.statuses)-1]
+	if mdb.describes < len(mdb.statuses) {
+		status = mdb.statuses[mdb.describes]
+	}
+	mdb.describes++
+	return &dynamodb.DescribeTableOutput{
+		Table: &dynamodb.TableDescription{TableName: input.TableName, TableStatus: aws.String(status)},
+	}, nil
+}
+
+func TestEnsureTable(t *testing.T) {
+	tableActiveInterval = time.Millisecond
+	inUse := awserr.New(dynamodb.ErrCodeResourceInUseException, "Table already exists", nil)
+	for _, c := range []struct {
+		caseName      string
+		conn          *mockTableDB
+		timeout       time.Duration
+		wantCreated   bool
+		wantDescribes int
+		err           bool
+	}{
+		{
+			caseName:      "created",
+			conn:          &mockTableDB{statuses: []string{dynamodb.TableStatusCreating, dynamodb.TableStatusActive}},
+			wantCreated:   true,
+			wantDescribes: 2,
+		},
+		{
+			caseName:      "exists",
+			conn:          &mockTableDB{createErr: inUse, statuses: []string{dynamodb.TableStatusActive}},
+			wantDescribes: 1,
+		},
+		{
+			caseName: "create_error",
+			conn:     &mockTableDB{createErr: errors.New("access denied")},
+			err:      true,
+		},
+		{
+			caseName: "describe_error",
+			conn:     &mockTableDB{describeErr: errors.New("internal error")},
+			err:      true,
+		},
+		{
+			caseName: "never_active",
+			conn:     &mockTableDB{statuses: []string{dynamodb.TableStatusCreating}},
+			timeout:  20 * time.Millisecond,
+			err:      true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			ctx := context.Background()
+			if c.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, c.timeout)
+				defer cancel()
+			}
+			created, err := EnsureTable(ctx, c.conn, "ensure_table_test")
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.wantCreated, created)
+			assert.Equal(t, c.wantDescribes, c.conn.describes)
+			assert.Equal(t, "ID", aws.StringValue(c.conn.lastCreate.KeySchema[0].AttributeName))
+		})
+	}
+}
+
+func TestEnsureTableIndexes(t *testing.T) {
+	conn := &mockTableDB{statuses: []string{dynamodb.TableStatusActive}}
+	_, err := EnsureTable(context.Background(), conn, "ensure_table_test", "team")
+	require.NoError(t, err)
+	indexes := conn.lastCreate.GlobalSecondaryIndexes
+	require.Len(t, indexes, 2)
+	assert.Equal(t, StatusIndex, aws.StringValue(indexes[0].IndexName))
+	assert.Equal(t, "Status", aws.StringValue(indexes[0].KeySchema[0].AttributeName))
+	assert.Equal(t, "Tag_team-index", aws.StringValue(indexes[1].IndexName))
+	assert.Equal(t, "Tag_team", aws.StringValue(indexes[1].KeySchema[0].AttributeName))
+	assert.Equal(t, "EffectiveAfter", aws.StringValue(indexes[1].KeySchema[1].AttributeName))
+	assert.Len(t, conn.lastCreate.AttributeDefinitions, 4)
+}
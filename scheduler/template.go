@@ -0,0 +1,153 @@
+package scheduler
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// envVarPrefix restricts template env lookups so that secrets of the function environment
+// (e.g. AWS credentials) can not be leaked into outgoing requests
+const envVarPrefix = "CITIUM_VAR_"
+
+var timeLayouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC850":      time.RFC850,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Kitchen":     time.Kitchen,
+	"Date":        "2006-01-02",
+}
+
+// renderTemplate substitutes the `{{ ... }}` placeholders of given text. A placeholder is a
+// pipeline of commands separated by `|`:
+// - now: current time
+// - date <offset>: current time shifted by a duration, e.g. `date +24h`
+// - uuid: random UUID v4
+// - env <NAME>: value of environment variable CITIUM_VAR_<NAME>
+// - format <layout>: format a time by layout name (e.g. RFC3339) or Go layout string
+// - unix: format a time as seconds since epoch
+// - var <NAME>: value captured by a previous step of composite request
+// Time values are formatted as RFC3339 unless stated otherwise.
+func renderTemplate(text string, now time.Time) (string, error) {
+	return renderTemplateVars(text, now, nil)
+}
+
+// renderTemplateVars substitutes placeholders like renderTemplate with captured variables available
+func renderTemplateVars(text string, now time.Time, vars map[string]string) (string, error) {
+	var out strings.Builder
+	rest := text
+	for {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			out.WriteString(rest)
+			break
+		}
+		end := strings.Index(rest[start:], "}}")
+		if end < 0 {
+			return "", errors.Errorf("unclosed placeholder at %q", rest[start:])
+		}
+		out.WriteString(rest[:start])
+		value, err := evalPipeline(rest[start+2:start+end], now, vars)
+		if err != nil {
+			return "", errors.Wrapf(err, "evalPipeline %s", rest[start:start+end+2])
+		}
+		out.WriteString(value)
+		rest = rest[start+end+2:]
+	}
+	return out.String(), nil
+}
+
+func evalPipeline(pipeline string, now time.Time, vars map[string]string) (string, error) {
+	var value interface{}
+	for i, stage := range strings.Split(pipeline, "|") {
+		fields := strings.Fields(stage)
+		if len(fields) == 0 {
+			return "", errors.New("empty command")
+		}
+		name, args := fields[0], fields[1:]
+		if i == 0 {
+			v, err := evalSource(name, args, now, vars)
+			if err != nil {
+				return "", err
+			}
+			value = v
+			continue
+		}
+		t, ok := value.(time.Time)
+		if !ok {
+			return "", errors.Errorf("command %s expects a time value", name)
+		}
+		switch name {
+		case "format":
+			if len(args) != 1 {
+				return "", errors.New("format expects 1 argument")
+			}
+			layout, found := timeLayouts[args[0]]
+			if !found {
+				layout = args[0]
+			}
+			value = t.Format(layout)
+		case "unix":
+			value = strconv.FormatInt(t.Unix(), 10)
+		default:
+			return "", errors.Errorf("unknown command %s", name)
+		}
+	}
+	if t, ok := value.(time.Time); ok {
+		return t.Format(time.RFC3339), nil
+	}
+	return value.(string), nil
+}
+
+func evalSource(name string, args []string, now time.Time, vars map[string]string) (interface{}, error) {
+	switch name {
+	case "now":
+		return now, nil
+	case "date":
+		if len(args) != 1 {
+			return nil, errors.New("date expects 1 argument")
+		}
+		offset, err := time.ParseDuration(strings.TrimPrefix(args[0], "+"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "time.ParseDuration %s", args[0])
+		}
+		return now.Add(offset), nil
+	case "uuid":
+		return newUUID()
+	case "env":
+		if len(args) != 1 {
+			return nil, errors.New("env expects 1 argument")
+		}
+		return os.Getenv(envVarPrefix + args[0]), nil
+	case "var":
+		if len(args) != 1 {
+			return nil, errors.New("var expects 1 argument")
+		}
+		v, ok := vars[args[0]]
+		if !ok {
+			return nil, errors.Errorf("undefined variable %s", args[0])
+		}
+		return v, nil
+	}
+	return nil, errors.Errorf("unknown command %s", name)
+}
+
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "rand.Read")
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,101 @@
+package scheduler
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	now := time.Date(2018, time.September, 01, 0, 2, 3, 0, time.UTC)
+	require.NoError(t, os.Setenv("CITIUM_VAR_TENANT", "test-tenant"))
+	defer os.Unsetenv("CITIUM_VAR_TENANT")
+	for _, c := range []struct {
+		caseName string
+		text     string
+		err      bool
+		want     string
+	}{
+		{
+			caseName: "no_placeholder",
+			text:     "/test-no-placeholder",
+			want:     "/test-no-placeholder",
+		},
+		{
+			caseName: "now",
+			text:     "/reports?at={{now}}",
+			want:     "/reports?at=2018-09-01T00:02:03Z",
+		},
+		{
+			caseName: "date_offset_formatted",
+			text:     "{\"until\":\"{{ date +24h | format RFC1123 }}\"}",
+			want:     "{\"until\":\"Sun, 02 Sep 2018 00:02:03 UTC\"}",
+		},
+		{
+			caseName: "negative_offset_custom_layout",
+			text:     "{{date -1h | format 2006-01-02T15}}",
+			want:     "2018-08-31T23",
+		},
+		{
+			caseName: "unix",
+			text:     "{{now | unix}}",
+			want:     "1535760123",
+		},
+		{
+			caseName: "env",
+			text:     "/tenants/{{env TENANT}}/{{env MISSING}}",
+			want:     "/tenants/test-tenant/",
+		},
+		{
+			caseName: "var",
+			text:     "Bearer {{ var token }}",
+			want:     "Bearer test-token",
+		},
+		{
+			caseName: "undefined_var",
+			text:     "{{ var refresh }}",
+			err:      true,
+		},
+		{
+			caseName: "unknown_command",
+			text:     "{{today}}",
+			err:      true,
+		},
+		{
+			caseName: "format_non_time",
+			text:     "{{uuid | format RFC3339}}",
+			err:      true,
+		},
+		{
+			caseName: "invalid_offset",
+			text:     "{{date tomorrow}}",
+			err:      true,
+		},
+		{
+			caseName: "unclosed",
+			text:     "{{now",
+			err:      true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			rendered, err := renderTemplateVars(c.text, now, map[string]string{"token": "test-token"})
+			if c.err == true {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, c.want, rendered)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateUUID(t *testing.T) {
+	rendered, err := renderTemplate("{{uuid}}", time.Now())
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), rendered)
+}
@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/meomap/citium/schema"
+)
+
+// timingTrace captures the connection phases of an http attempt
+type timingTrace struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	firstByte    time.Time
+	reused       bool
+}
+
+// newTimingTrace returns trace of an attempt starting now
+func newTimingTrace() *timingTrace {
+	return &timingTrace{start: time.Now()}
+}
+
+// set records the time of a phase, hooks could be called from dialing goroutines
+func (tt *timingTrace) set(t *time.Time) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	// keep the first of parallel dials
+	if t.IsZero() {
+		*t = time.Now()
+	}
+}
+
+func (tt *timingTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { tt.set(&tt.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { tt.set(&tt.dnsDone) },
+		ConnectStart:         func(string, string) { tt.set(&tt.connectStart) },
+		ConnectDone:          func(string, string, error) { tt.set(&tt.connectDone) },
+		TLSHandshakeStart:    func() { tt.set(&tt.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { tt.set(&tt.tlsDone) },
+		GotFirstResponseByte: func() { tt.set(&tt.firstByte) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			tt.mu.Lock()
+			defer tt.mu.Unlock()
+			tt.reused = info.Reused
+		},
+	}
+}
+
+// timing returns the phases of the attempt done at given time
+func (tt *timingTrace) timing(done time.Time) *schema.Timing {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	return &schema.Timing{
+		DNS:     millis(tt.dnsStart, tt.dnsDone),
+		Connect: millis(tt.connectStart, tt.connectDone),
+		TLS:     millis(tt.tlsStart, tt.tlsDone),
+		TTFB:    millis(tt.start, tt.firstByte),
+		Total:   millis(tt.start, done),
+		Reused:  tt.reused,
+	}
+}
+
+// millis returns elapsed milliseconds between phase times, zero if phase did not happen
+func millis(from, to time.Time) float64 {
+	if from.IsZero() || to.IsZero() {
+		return 0
+	}
+	return float64(to.Sub(from)) / float64(time.Millisecond)
+}
@@ -0,0 +1,50 @@
+package scheduler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimingTrace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &http.Transport{}}
+
+	for i, reused := range []bool{false, true} {
+		trace := newTimingTrace()
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req.WithContext(httptrace.WithClientTrace(context.Background(), trace.clientTrace())))
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		timing := trace.timing(time.Now())
+		assert.Equal(t, reused, timing.Reused, "attempt %d", i)
+		if reused {
+			assert.Zero(t, timing.Connect)
+		} else {
+			assert.True(t, timing.Connect > 0)
+		}
+		// no dns lookup nor tls handshake of plain ip address
+		assert.Zero(t, timing.DNS)
+		assert.Zero(t, timing.TLS)
+		assert.True(t, timing.TTFB >= 20)
+		assert.True(t, timing.Total >= timing.TTFB)
+	}
+}
+
+func TestMillis(t *testing.T) {
+	at := time.Date(2018, time.September, 02, 0, 2, 3, 0, time.UTC)
+	assert.Equal(t, 1.5, millis(at, at.Add(1500*time.Microsecond)))
+	assert.Zero(t, millis(time.Time{}, at))
+	assert.Zero(t, millis(at, time.Time{}))
+}
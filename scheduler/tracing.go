@@ -0,0 +1,29 @@
+package scheduler
+
+import (
+	"context"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+
+	"github.com/meomap/citium/schema"
+)
+
+// traceExecution runs fn within an X-Ray subsegment of the scheduled request, so that storage
+// and target calls made by fn are grouped per request in the trace
+func traceExecution(ctx context.Context, enabled bool, req *schema.ScheduledRequest, fn func(ctx context.Context) error) error {
+	if !enabled {
+		return fn(ctx)
+	}
+	return xray.Capture(ctx, "execute", func(ctx context.Context) error {
+		if seg := xray.GetSegment(ctx); seg != nil {
+			seg.AddAnnotation("request_id", req.ID)
+			seg.AddAnnotation("target", req.Target())
+		}
+		return fn(ctx)
+	})
+}
+
+// EnableTracing records outgoing requests of client as X-Ray subsegments
+func (c *HTTPClient) EnableTracing() {
+	c.Client = xray.Client(c.Client)
+}
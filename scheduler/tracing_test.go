@@ -0,0 +1,49 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/schema"
+)
+
+func TestTraceExecution(t *testing.T) {
+	req := &schema.ScheduledRequest{ID: "abc", TargetType: schema.TargetSQS}
+	for _, c := range []struct {
+		caseName string
+		enabled  bool
+	}{
+		{caseName: "disabled"},
+		{caseName: "enabled", enabled: true},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			ctx, root := xray.BeginSegmentWithSampling(context.Background(), "test", httptest.NewRequest(http.MethodGet, "/", nil), &header.Header{SamplingDecision: header.Sampled})
+			defer root.Close(nil)
+			called := false
+			err := traceExecution(ctx, c.enabled, req, func(ctx context.Context) error {
+				called = true
+				seg := xray.GetSegment(ctx)
+				require.NotNil(t, seg)
+				if c.enabled == true {
+					assert.Equal(t, "execute", seg.Name)
+					assert.Equal(t, root, seg.ParentSegment)
+					assert.Equal(t, "abc", seg.Annotations["request_id"])
+					assert.Equal(t, schema.TargetSQS, seg.Annotations["target"])
+				} else {
+					assert.Equal(t, root, seg)
+				}
+				return nil
+			})
+			require.NoError(t, err)
+			assert.True(t, called)
+		})
+	}
+}
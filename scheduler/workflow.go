@@ -0,0 +1,173 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/sfn"
+	"github.com/aws/aws-sdk-go/service/sfn/sfniface"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/schema"
+)
+
+// Actions of the execution workflow states, invoked by the state machine in turn
+const (
+	// WorkflowLock locks the request, the workflow ends if a concurrent run holds it already
+	WorkflowLock = "lock"
+	// WorkflowCall performs the action of request, failing for the state machine to retry
+	WorkflowCall = "call"
+	// WorkflowRecord stores the result of the call, or its failure once retries are exhausted
+	WorkflowRecord = "record"
+	// WorkflowUnlock reschedules the request the target asked to call later
+	WorkflowUnlock = "unlock"
+)
+
+// WorkflowState is the state of a request execution passed between the workflow states
+type WorkflowState struct {
+	ID string `json:"id"`
+	// Whether the request was locked by a concurrent run, which executes it instead
+	LockConflict bool `json:"lock_conflict,omitempty"`
+	// Result of a successful call
+	Response *schema.Response `json:"response,omitempty"`
+	// Time the target asked to be called again at, the request is unlocked then
+	RetryAt *time.Time `json:"retry_at,omitempty"`
+	// Error caught by the state machine once the call retries are exhausted
+	Failure *WorkflowFailure `json:"failure,omitempty"`
+}
+
+// WorkflowFailure is the error caught by the state machine, Cause holds the error of function
+type WorkflowFailure struct {
+	Error string `json:"Error"`
+	Cause string `json:"Cause"`
+}
+
+// executionNameChars are the characters not allowed in an execution name
+var executionNameChars = regexp.MustCompile(`[^0-9A-Za-z_-]`)
+
+// maxExecutionName is the longest execution name
+const maxExecutionName = 80
+
+// dispatch starts the workflow execution of request instead of executing it inline. The name of
+// execution is derived from the request and its effective date, so that dispatching it again
+// while the workflow has not locked it yet starts no second execution
+func dispatch(ctx context.Context, conn sfniface.SFNAPI, stateMachineARN string, req *schema.ScheduledRequest) error {
+	if conn == nil {
+		return errors.New("sfn client is not configured")
+	}
+	input, err := json.Marshal(&WorkflowState{ID: req.ID})
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+	suffix := fmt.Sprintf("-%d", req.EffectiveAfter.Unix())
+	name := executionNameChars.ReplaceAllString(req.ID, "_")
+	if len(name) > maxExecutionName-len(suffix) {
+		name = name[:maxExecutionName-len(suffix)]
+	}
+	log.Printf("dispatch request %s state_machine_arn=%s \n", req.ToString(), stateMachineARN)
+	_, err = conn.StartExecutionWithContext(ctx, &sfn.StartExecutionInput{
+		StateMachineArn: aws.String(stateMachineARN),
+		Name:            aws.String(name + suffix),
+		Input:           aws.String(string(input)),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "conn.StartExecution id=%s state_machine_arn=%s", req.ID, stateMachineARN)
+	}
+	return nil
+}
+
+// RunWorkflowStep performs the action of a workflow state on the request of state, returning the
+// state passed to the next one
+func RunWorkflowStep(ctx context.Context, conf *config.Configuration, dbconn dynamodbiface.DynamoDBAPI, svc *Services, action string, state *WorkflowState) (*WorkflowState, error) {
+	log.Printf("run workflow step action=%s id=%s \n", action, state.ID)
+	if state.ID == "" {
+		return nil, errors.Wrapf(ErrValidation, "missing id of action=%s", action)
+	}
+	switch action {
+	case WorkflowLock:
+		// the request is read by the call state after locking
+		err := acquireLock(ctx, dbconn, conf.TableName, state.ID, anyVersion)
+		if err == ErrAlreadyLocked {
+			log.Printf("skip locked request id=%s \n", state.ID)
+			state.LockConflict = true
+			return state, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "lock id=%s table_name=%s", state.ID, conf.TableName)
+		}
+		recordAudit(ctx, svc.Audit, state.ID, AuditLocked, "workflow")
+		return state, nil
+	case WorkflowCall:
+		req, err := getExisting(ctx, dbconn, conf.TableName, state.ID)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		var resp *schema.Response
+		err = traceExecution(ctx, svc.Tracing, req, func(ctx context.Context) error {
+			var perr error
+			resp, perr = perform(ctx, svc, req)
+			return perr
+		})
+		switch cause := errors.Cause(err).(type) {
+		case *retryAfterError:
+			state.RetryAt = &cause.at
+			return state, nil
+		case *circuitOpenError:
+			state.RetryAt = &cause.until
+			return state, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "perform %s", req.ToString())
+		}
+		resp.Duration = float64(time.Since(start)) / float64(time.Millisecond)
+		state.Response = resp
+		return state, nil
+	case WorkflowRecord:
+		req, err := getExisting(ctx, dbconn, conf.TableName, state.ID)
+		if err != nil {
+			return nil, err
+		}
+		if state.Failure != nil {
+			perr := errors.Errorf("%s: %s", state.Failure.Error, state.Failure.Cause)
+			if err = recordFailure(ctx, dbconn, svc, req, conf.TableName, perr); err != nil {
+				return nil, errors.Wrapf(err, "recordFailure id=%s", state.ID)
+			}
+			return state, nil
+		}
+		if state.Response == nil {
+			return nil, errors.Wrapf(ErrValidation, "missing response of id=%s", state.ID)
+		}
+		if err = recordSuccess(ctx, dbconn, svc, req, conf.TableName, state.Response); err != nil {
+			return nil, errors.Wrapf(err, "recordSuccess id=%s", state.ID)
+		}
+		return state, nil
+	case WorkflowUnlock:
+		if state.RetryAt == nil {
+			return nil, errors.Wrapf(ErrValidation, "missing retry time of id=%s", state.ID)
+		}
+		// like its lock, the workflow releases the request whatever its version
+		if err := reschedule(ctx, dbconn, conf.TableName, state.ID, anyVersion, *state.RetryAt); err != nil {
+			return nil, errors.Wrapf(err, "reschedule id=%s", state.ID)
+		}
+		recordAudit(ctx, svc.Audit, state.ID, AuditUnlocked, "workflow")
+		return state, nil
+	}
+	return nil, errors.Wrapf(ErrValidation, "unknown workflow action=%s", action)
+}
+
+// getExisting returns the stored request of id, ErrNotFound if there is none
+func getExisting(ctx context.Context, dbconn dynamodbiface.DynamoDBAPI, table, reqID string) (*schema.ScheduledRequest, error) {
+	req, err := Get(ctx, dbconn, table, reqID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get")
+	}
+	return req, nil
+}
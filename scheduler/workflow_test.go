@@ -0,0 +1,229 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/schema"
+)
+
+func TestDispatch(t *testing.T) {
+	arn := "arn:aws:states:us-east-1:123456789012:stateMachine:citium-execution"
+	effective := time.Date(2018, 9, 5, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		caseName string
+		id       string
+		wantName string
+	}{
+		{
+			caseName: "plain_id",
+			id:       "test-dispatch",
+			wantName: "test-dispatch-1536105600",
+		},
+		{
+			caseName: "sanitized_id",
+			id:       "invoice/42 reminder",
+			wantName: "invoice_42_reminder-1536105600",
+		},
+		{
+			caseName: "long_id",
+			id:       strings.Repeat("a", 100),
+			wantName: strings.Repeat("a", 69) + "-1536105600",
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			conn := new(mockSFN)
+			err := dispatch(context.Background(), conn, arn, &schema.ScheduledRequest{ID: c.id, EffectiveAfter: effective})
+			require.NoError(t, err)
+			require.NotNil(t, conn.lastStartInput)
+			assert.Equal(t, arn, aws.StringValue(conn.lastStartInput.StateMachineArn))
+			assert.Equal(t, c.wantName, aws.StringValue(conn.lastStartInput.Name))
+			var state WorkflowState
+			require.NoError(t, json.Unmarshal([]byte(aws.StringValue(conn.lastStartInput.Input)), &state))
+			assert.Equal(t, c.id, state.ID)
+		})
+	}
+}
+
+func TestTriggerAPIStepFunctions(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	mockConn.clear()
+	mockConn.items = []map[string]*dynamodb.AttributeValue{
+		{"ID": {S: aws.String("test-dispatch-1")}},
+		{"ID": {S: aws.String("test-dispatch-2")}},
+	}
+	mockClient := new(mockHTTPClient)
+	mockClient.clear()
+	conf := &config.Configuration{
+		TableName:                "TriggerAPIStepFunctions_test",
+		ExecutionMode:            config.ExecutionStepFunctions,
+		ExecutionStateMachineARN: "arn:aws:states:us-east-1:123456789012:stateMachine:citium-execution",
+	}
+	summary, err := TriggerAPI(context.Background(), conf, mockConn, &Services{HTTP: mockClient, StepFunctions: new(mockSFN)})
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.Dispatched)
+	assert.Equal(t, 0, summary.Executed)
+	mockClient.assertCalled(t, 0)
+	// the workflow locks the requests
+	assert.Nil(t, mockConn.lastUpdateItem)
+}
+
+func TestRunWorkflowStep(t *testing.T) {
+	mockConn := new(mockDynamoDB)
+	mockClient := new(mockHTTPClient)
+	conf := &config.Configuration{
+		TableName: "RunWorkflowStep_test",
+	}
+	svc := &Services{HTTP: mockClient}
+	retryAt := time.Date(2018, 9, 5, 0, 0, 0, 0, time.UTC)
+	storedItem := func() {
+		mockConn.item = map[string]*dynamodb.AttributeValue{
+			"ID":     {S: aws.String("test-workflow")},
+			"Method": {S: aws.String("GET")},
+			"URL":    {S: aws.String("/reports")},
+		}
+	}
+	for _, c := range []struct {
+		caseName string
+		action   string
+		state    *WorkflowState
+		setup    func()
+		err      bool
+		verify   func(t *testing.T, state *WorkflowState)
+	}{
+		{
+			caseName: "lock",
+			action:   WorkflowLock,
+			state:    &WorkflowState{ID: "test-workflow"},
+			setup:    func() {},
+			verify: func(t *testing.T, state *WorkflowState) {
+				assert.False(t, state.LockConflict)
+				require.NotNil(t, mockConn.lastUpdateItem)
+			},
+		},
+		{
+			caseName: "lock_conflict",
+			action:   WorkflowLock,
+			state:    &WorkflowState{ID: "test-workflow"},
+			setup: func() {
+				mockConn.updateErr = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+			},
+			verify: func(t *testing.T, state *WorkflowState) {
+				assert.True(t, state.LockConflict)
+			},
+		},
+		{
+			caseName: "call",
+			action:   WorkflowCall,
+			state:    &WorkflowState{ID: "test-workflow"},
+			setup:    storedItem,
+			verify: func(t *testing.T, state *WorkflowState) {
+				mockClient.assertCalled(t, 1)
+				require.NotNil(t, state.Response)
+				assert.Nil(t, state.RetryAt)
+			},
+		},
+		{
+			caseName: "call_failed",
+			action:   WorkflowCall,
+			state:    &WorkflowState{ID: "test-workflow"},
+			setup: func() {
+				storedItem()
+				mockClient.requestErr = errors.New("connection refused")
+			},
+			err: true,
+		},
+		{
+			caseName: "call_later",
+			action:   WorkflowCall,
+			state:    &WorkflowState{ID: "test-workflow"},
+			setup: func() {
+				storedItem()
+				mockClient.requestErr = &retryAfterError{at: retryAt}
+			},
+			verify: func(t *testing.T, state *WorkflowState) {
+				require.NotNil(t, state.RetryAt)
+				assert.Equal(t, retryAt, *state.RetryAt)
+			},
+		},
+		{
+			caseName: "call_not_found",
+			action:   WorkflowCall,
+			state:    &WorkflowState{ID: "test-workflow"},
+			setup:    func() {},
+			err:      true,
+		},
+		{
+			caseName: "record",
+			action:   WorkflowRecord,
+			state:    &WorkflowState{ID: "test-workflow", Response: &schema.Response{Code: 200}},
+			setup:    storedItem,
+			verify: func(t *testing.T, state *WorkflowState) {
+				require.NotNil(t, mockConn.lastDeleteItem)
+			},
+		},
+		{
+			caseName: "record_failure",
+			action:   WorkflowRecord,
+			state: &WorkflowState{
+				ID:      "test-workflow",
+				Failure: &WorkflowFailure{Error: "withStack", Cause: `{"errorMessage":"connection refused"}`},
+			},
+			setup: storedItem,
+			verify: func(t *testing.T, state *WorkflowState) {
+				require.NotNil(t, mockConn.lastUpdateItem)
+				assert.Contains(t, mockConn.lastUpdateItem.GoString(), "connection refused")
+				assert.Nil(t, mockConn.lastDeleteItem)
+			},
+		},
+		{
+			caseName: "record_missing_response",
+			action:   WorkflowRecord,
+			state:    &WorkflowState{ID: "test-workflow"},
+			setup:    storedItem,
+			err:      true,
+		},
+		{
+			caseName: "unlock",
+			action:   WorkflowUnlock,
+			state:    &WorkflowState{ID: "test-workflow", RetryAt: &retryAt},
+			setup:    func() {},
+			verify: func(t *testing.T, state *WorkflowState) {
+				require.NotNil(t, mockConn.lastUpdateItem)
+				assert.Contains(t, mockConn.lastUpdateItem.GoString(), retryAt.Format(unixFormat))
+			},
+		},
+		{
+			caseName: "missing_id",
+			action:   WorkflowLock,
+			state:    &WorkflowState{},
+			setup:    func() {},
+			err:      true,
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			mockConn.clear()
+			mockClient.clear()
+			c.setup()
+			state, err := RunWorkflowStep(context.Background(), conf, mockConn, svc, c.action, c.state)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			c.verify(t, state)
+		})
+	}
+}
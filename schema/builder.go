@@ -0,0 +1,229 @@
+package schema
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+// RequestBuilder constructs a ScheduledRequest step by step, Build validates it once complete so
+// that programmatic callers need not know which fields are required by which target
+type RequestBuilder struct {
+	req    ScheduledRequest
+	delay  time.Duration
+	limits SizeLimits
+	// problems of the setters, returned by Build
+	err error
+}
+
+// NewRequestBuilder returns builder of a request created now, due at once unless scheduled
+// otherwise and checked against the default size limits
+func NewRequestBuilder() *RequestBuilder {
+	return &RequestBuilder{
+		req: ScheduledRequest{CreatedAt: time.Now().UTC()},
+		limits: SizeLimits{
+			Payload:    DefaultMaxPayloadSize,
+			Headers:    DefaultMaxHeaders,
+			HeaderSize: DefaultMaxHeaderSize,
+		},
+	}
+}
+
+// ID sets the unique id of request
+func (b *RequestBuilder) ID(id string) *RequestBuilder {
+	b.req.ID = id
+	return b
+}
+
+// HTTP calls url with method, the default target
+func (b *RequestBuilder) HTTP(method, url string) *RequestBuilder {
+	b.req.TargetType = TargetHTTP
+	b.req.Method, b.req.URL = method, url
+	return b
+}
+
+// Get calls url with GET method
+func (b *RequestBuilder) Get(url string) *RequestBuilder {
+	return b.HTTP("GET", url)
+}
+
+// Post calls url with POST method
+func (b *RequestBuilder) Post(url string) *RequestBuilder {
+	return b.HTTP("POST", url)
+}
+
+// SQS sends the payload to queue instead of calling a url
+func (b *RequestBuilder) SQS(target *SQSTarget) *RequestBuilder {
+	b.req.TargetType, b.req.SQS = TargetSQS, target
+	return b
+}
+
+// Kinesis puts the payload onto stream instead of calling a url
+func (b *RequestBuilder) Kinesis(target *KinesisTarget) *RequestBuilder {
+	b.req.TargetType, b.req.Kinesis = TargetKinesis, target
+	return b
+}
+
+// StepFunctions starts the execution of state machine instead of calling a url
+func (b *RequestBuilder) StepFunctions(target *StepFunctionsTarget) *RequestBuilder {
+	b.req.TargetType, b.req.StepFunctions = TargetStepFunctions, target
+	return b
+}
+
+// Header adds the header sent along, replacing the one of the same name
+func (b *RequestBuilder) Header(name, value string) *RequestBuilder {
+	if b.req.Headers == nil {
+		b.req.Headers = map[string]string{}
+	}
+	b.req.Headers[name] = value
+	return b
+}
+
+// Headers adds the headers sent along
+func (b *RequestBuilder) Headers(headers map[string]string) *RequestBuilder {
+	for name, value := range headers {
+		b.Header(name, value)
+	}
+	return b
+}
+
+// Payload sets the text payload, template placeholders are rendered before sending
+func (b *RequestBuilder) Payload(payload string) *RequestBuilder {
+	b.req.Payload, b.req.PayloadEncoding = payload, ""
+	return b
+}
+
+// BinaryPayload sets the payload of binary data, sent as is
+func (b *RequestBuilder) BinaryPayload(payload []byte) *RequestBuilder {
+	b.req.Payload, b.req.PayloadEncoding = base64.StdEncoding.EncodeToString(payload), PayloadBase64
+	return b
+}
+
+// JSONPayload sets the payload of v marshalled into JSON document
+func (b *RequestBuilder) JSONPayload(v interface{}) *RequestBuilder {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		b.err = multierr.Append(b.err, errors.Wrap(err, "json.Marshal payload"))
+		return b
+	}
+	b.req.Payload, b.req.PayloadEncoding = string(raw), PayloadJSON
+	return b
+}
+
+// At schedules the request at the given time
+func (b *RequestBuilder) At(at time.Time) *RequestBuilder {
+	b.req.EffectiveAfter, b.delay = at.UTC(), 0
+	return b
+}
+
+// After schedules the request the given duration after its creation
+func (b *RequestBuilder) After(delay time.Duration) *RequestBuilder {
+	b.req.EffectiveAfter, b.delay = time.Time{}, delay
+	return b
+}
+
+// ExpectStatus sets the accepted response status codes or classes, e.g. `2xx` or `200,204`
+func (b *RequestBuilder) ExpectStatus(spec string) *RequestBuilder {
+	b.req.ExpectStatus = spec
+	return b
+}
+
+// Assert adds the assertion of the value at JSON path of response body
+func (b *RequestBuilder) Assert(path, expected string) *RequestBuilder {
+	b.req.Assertions = append(b.req.Assertions, Assertion{Path: path, Expected: expected})
+	return b
+}
+
+// Persistent keeps the request with its result after execution, for retention if it is positive
+func (b *RequestBuilder) Persistent(retention time.Duration) *RequestBuilder {
+	b.req.PersistentStore = true
+	b.req.ResultRetention = ""
+	if retention > 0 {
+		b.req.ResultRetention = retention.String()
+	}
+	return b
+}
+
+// Tag adds the tag used to select and filter the request
+func (b *RequestBuilder) Tag(key, value string) *RequestBuilder {
+	if b.req.Tags == nil {
+		b.req.Tags = map[string]string{}
+	}
+	b.req.Tags[key] = value
+	return b
+}
+
+// Metadata adds the caller data stored along with the request
+func (b *RequestBuilder) Metadata(key, value string) *RequestBuilder {
+	if b.req.Metadata == nil {
+		b.req.Metadata = map[string]string{}
+	}
+	b.req.Metadata[key] = value
+	return b
+}
+
+// Description sets the human-readable purpose of request
+func (b *RequestBuilder) Description(description string) *RequestBuilder {
+	b.req.Description = description
+	return b
+}
+
+// CallbackURL sets the url the outcome of every execution is posted to
+func (b *RequestBuilder) CallbackURL(url string) *RequestBuilder {
+	b.req.CallbackURL = url
+	return b
+}
+
+// CreatedBy sets who schedules the request
+func (b *RequestBuilder) CreatedBy(creator string) *RequestBuilder {
+	b.req.CreatedBy = creator
+	return b
+}
+
+// SizeLimits replaces the default limits the request is checked against, e.g. by the ones the
+// scheduler is configured with
+func (b *RequestBuilder) SizeLimits(limits SizeLimits) *RequestBuilder {
+	b.limits = limits
+	return b
+}
+
+// Build returns the request once it passes every validation, the problems of setters otherwise
+func (b *RequestBuilder) Build() (*ScheduledRequest, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	req := b.req
+	// maps and slices are copied so that reusing the builder leaves the request as built
+	req.Headers = copyMap(req.Headers)
+	req.Tags = copyMap(req.Tags)
+	req.Metadata = copyMap(req.Metadata)
+	req.Assertions = append([]Assertion(nil), req.Assertions...)
+	if req.EffectiveAfter.IsZero() {
+		req.EffectiveAfter = req.CreatedAt.Add(b.delay)
+	}
+	if req.ID == "" {
+		return nil, errors.New("ID is required")
+	}
+	if err := req.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "validate %s", req.ToString())
+	}
+	if err := req.CheckSize(b.limits); err != nil {
+		return nil, errors.Wrapf(err, "check size id=%s", req.ID)
+	}
+	return &req, nil
+}
+
+// copyMap returns a copy of m, nil if m is nil
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
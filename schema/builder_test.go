@@ -0,0 +1,225 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestBuilder(t *testing.T) {
+	at := time.Date(2024, 5, 1, 9, 0, 0, 0, time.FixedZone("ICT", 7*3600))
+	for _, c := range []struct {
+		caseName string
+		build    func(b *RequestBuilder) *RequestBuilder
+		err      string
+		verify   func(t *testing.T, req *ScheduledRequest)
+	}{
+		{
+			caseName: "missing_id",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.Get("/reports")
+			},
+			err: "ID is required",
+		},
+		{
+			caseName: "http",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").Post("/reports").Header("X-Tenant", "acme").Tag("team", "billing").
+					Metadata("ticket", "OPS-1").Description("monthly report").CreatedBy("billing-ui").
+					ExpectStatus("2xx").Assert("$.status", "ok")
+			},
+			verify: func(t *testing.T, req *ScheduledRequest) {
+				assert.Equal(t, TargetHTTP, req.Target())
+				assert.Equal(t, "POST", req.Method)
+				assert.Equal(t, "/reports", req.URL)
+				assert.Equal(t, map[string]string{"X-Tenant": "acme"}, req.Headers)
+				assert.Equal(t, map[string]string{"team": "billing"}, req.Tags)
+				assert.Equal(t, map[string]string{"ticket": "OPS-1"}, req.Metadata)
+				assert.Equal(t, []Assertion{{Path: "$.status", Expected: "ok"}}, req.Assertions)
+				assert.Equal(t, "billing-ui", req.CreatedBy)
+				// due at once by default
+				assert.Equal(t, req.CreatedAt, req.EffectiveAfter)
+			},
+		},
+		{
+			caseName: "http_without_url",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").HTTP("GET", "")
+			},
+			err: "Method and URL are required by http target",
+		},
+		{
+			caseName: "at",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").Get("/reports").After(time.Hour).At(at)
+			},
+			verify: func(t *testing.T, req *ScheduledRequest) {
+				assert.Equal(t, at.UTC(), req.EffectiveAfter)
+				assert.Equal(t, time.UTC, req.EffectiveAfter.Location())
+			},
+		},
+		{
+			caseName: "after",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").Get("/reports").At(at).After(time.Hour)
+			},
+			verify: func(t *testing.T, req *ScheduledRequest) {
+				// the last of At and After applies
+				assert.Equal(t, req.CreatedAt.Add(time.Hour), req.EffectiveAfter)
+			},
+		},
+		{
+			caseName: "sqs",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").SQS(&SQSTarget{QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/jobs"}).Payload("hello")
+			},
+			verify: func(t *testing.T, req *ScheduledRequest) {
+				assert.Equal(t, TargetSQS, req.Target())
+				assert.Equal(t, "hello", req.Payload)
+			},
+		},
+		{
+			caseName: "sqs_missing_target",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").SQS(nil)
+			},
+			err: "SQS is required by sqs target",
+		},
+		{
+			caseName: "kinesis_missing_target",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").Kinesis(nil)
+			},
+			err: "Kinesis is required by kinesis target",
+		},
+		{
+			caseName: "sfn",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").StepFunctions(&StepFunctionsTarget{StateMachineARN: "arn:aws:states:us-east-1:123456789012:stateMachine:report"})
+			},
+			verify: func(t *testing.T, req *ScheduledRequest) {
+				assert.Equal(t, TargetStepFunctions, req.Target())
+			},
+		},
+		{
+			caseName: "sfn_missing_target",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").StepFunctions(nil)
+			},
+			err: "StepFunctions is required by sfn target",
+		},
+		{
+			caseName: "json_payload",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").Post("/reports").JSONPayload(map[string]int{"month": 5})
+			},
+			verify: func(t *testing.T, req *ScheduledRequest) {
+				assert.Equal(t, `{"month":5}`, req.Payload)
+				assert.Equal(t, PayloadJSON, req.PayloadEncoding)
+			},
+		},
+		{
+			caseName: "json_payload_marshal_error",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").Post("/reports").JSONPayload(make(chan int))
+			},
+			err: "json.Marshal payload",
+		},
+		{
+			caseName: "binary_payload",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").Post("/upload").BinaryPayload([]byte{0xff, 0x00})
+			},
+			verify: func(t *testing.T, req *ScheduledRequest) {
+				assert.Equal(t, "/wA=", req.Payload)
+				assert.Equal(t, PayloadBase64, req.PayloadEncoding)
+			},
+		},
+		{
+			caseName: "persistent",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").Get("/reports").Persistent(720 * time.Hour)
+			},
+			verify: func(t *testing.T, req *ScheduledRequest) {
+				assert.True(t, req.PersistentStore)
+				assert.Equal(t, "720h0m0s", req.ResultRetention)
+			},
+		},
+		{
+			caseName: "invalid_callback_url",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").Get("/reports").CallbackURL("/hooks")
+			},
+			err: "invalid CallbackURL",
+		},
+		{
+			caseName: "payload_over_default_limit",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").Post("/reports").Payload(strings.Repeat("a", DefaultMaxPayloadSize+1))
+			},
+			err: "exceeds the limit",
+		},
+		{
+			caseName: "payload_over_given_limit",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").Post("/reports").Payload("hello").SizeLimits(SizeLimits{Payload: 4})
+			},
+			err: "payload of 5 bytes exceeds the limit of 4 bytes",
+		},
+		{
+			caseName: "headers_over_given_limit",
+			build: func(b *RequestBuilder) *RequestBuilder {
+				return b.ID("test-builder").Get("/reports").Headers(map[string]string{"A": "1", "B": "2"}).SizeLimits(SizeLimits{Headers: 1})
+			},
+			err: "2 headers exceed the limit of 1 headers",
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			req, err := c.build(NewRequestBuilder()).Build()
+			if c.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), c.err)
+				assert.Nil(t, req)
+				return
+			}
+			require.NoError(t, err)
+			c.verify(t, req)
+		})
+	}
+}
+
+func TestRequestBuilderCopies(t *testing.T) {
+	b := NewRequestBuilder().ID("test-builder").Get("/reports").
+		Header("X-Tenant", "acme").Tag("team", "billing").Metadata("ticket", "OPS-1").Assert("$.status", "ok")
+	first, err := b.Build()
+	require.NoError(t, err)
+
+	// reusing the builder leaves the request built before unchanged
+	b.ID("test-builder-2").Header("X-Tenant", "globex").Tag("team", "ops").Metadata("ticket", "OPS-2").Assert("$.count", "1")
+	second, err := b.Build()
+	require.NoError(t, err)
+	assert.Equal(t, "test-builder", first.ID)
+	assert.Equal(t, map[string]string{"X-Tenant": "acme"}, first.Headers)
+	assert.Equal(t, map[string]string{"team": "billing"}, first.Tags)
+	assert.Equal(t, map[string]string{"ticket": "OPS-1"}, first.Metadata)
+	assert.Len(t, first.Assertions, 1)
+	assert.Equal(t, "globex", second.Headers["X-Tenant"])
+	assert.Len(t, second.Assertions, 2)
+
+	// nor does changing the built request alter the next one
+	second.Tags["team"] = "changed"
+	third, err := b.Build()
+	require.NoError(t, err)
+	assert.Equal(t, "ops", third.Tags["team"])
+
+	// the maps given by callers are copied as well
+	headers := map[string]string{"Accept": "application/json"}
+	req, err := NewRequestBuilder().ID("test-builder").Get("/reports").Headers(headers).Build()
+	require.NoError(t, err)
+	headers["Accept"] = "text/plain"
+	assert.Equal(t, "application/json", req.Headers["Accept"])
+}
@@ -1,10 +1,29 @@
 package schema
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/pkg/errors"
 )
 
+func init() {
+	govalidator.TagMap["expectstatus"] = govalidator.Validator(func(str string) bool {
+		_, err := MatchStatus(str, 0)
+		return err == nil
+	})
+	govalidator.TagMap["targettype"] = govalidator.Validator(KnownTarget)
+}
+
 // ScheduledRequest defines the parameters for a request call triggering
 type ScheduledRequest struct {
 	// Unique ID across global region.
@@ -13,6 +32,10 @@ type ScheduledRequest struct {
 	// Created datetime which will be seriallized into unix nano seconds since epoch.
 	CreatedAt time.Time `json:"CreatedAt" valid:"required"`
 
+	// Who scheduled the request: the AWS identity or given creator of the cli, or the owner of the
+	// management API token.
+	CreatedBy string `json:"CreatedBy"`
+
 	// This properties is updated after execution and PersistentStore=true
 	ExecutedAt time.Time `json:"ExecutedAt"`
 
@@ -25,22 +48,76 @@ type ScheduledRequest struct {
 	// The attribute to prevent request got executed even if effective date already past.
 	Locking bool `json:"Locking"`
 
+	// Stage of the request lifecycle maintained by the scheduler, PENDING once created. Requests
+	// stored before it existed have none, see CurrentStatus.
+	Status string `json:"Status" valid:"in(PENDING|RUNNING|SUCCEEDED|FAILED|CANCELLED|EXPIRED)"`
+
+	// Incremented by every update of the stored request. Replacing it requires the version it
+	// was read at, so that concurrent edits and executions never overwrite each other silently.
+	Version int64 `json:"Version"`
+
 	// Attribute to log failure reason for previous execution attempt
 	FailureReason string `json:"FailureReason"`
 
-	// Request method name. Available options are:
+	// Number of executions tried, whether they succeeded or failed, and the time of the last one
+	Attempts      int       `json:"Attempts"`
+	LastAttemptAt time.Time `json:"LastAttemptAt"`
+
+	// Reasons of all the failed executions, oldest first
+	FailureHistory []Failure `json:"FailureHistory"`
+
+	// Action performed at the scheduled time, defaults to TargetHTTP if empty.
+	TargetType string `json:"TargetType" valid:"targettype"`
+
+	// Request method name, required by http target. Available options are:
 	// - GET
 	// - POST
 	// - PUT
 	// - DELETE
-	Method string `json:"Method" valid:"required,in(GET|PUT|POST|DELETE)"`
+	Method string `json:"Method" valid:"in(GET|PUT|POST|DELETE)"`
 
-	// Absolute path or relative url string
-	URL string `json:"URL" valid:"required"`
+	// Absolute path or relative url string, required by http target
+	URL string `json:"URL"`
+
+	// Message destination, required by sqs target. Payload is sent as message body.
+	SQS *SQSTarget `json:"SQS"`
+
+	// Record destination, required by kinesis target. Payload is sent as record data.
+	Kinesis *KinesisTarget `json:"Kinesis"`
+
+	// State machine to start, required by sfn target. Payload is sent as execution input.
+	StepFunctions *StepFunctionsTarget `json:"StepFunctions"`
+
+	// Topic to produce to, required by kafka target. Payload is sent as message value.
+	Kafka *KafkaTarget `json:"Kafka"`
+
+	// Topic to publish to, required by mqtt target. Payload is sent as message payload.
+	MQTT *MQTTTarget `json:"MQTT"`
+
+	// Command to run on instances, required by ssm target
+	SSM *SSMTarget `json:"SSM"`
+
+	// Item write, required by dynamodb target. Payload is the JSON item of PutItem or
+	// the JSON expression attribute values of UpdateItem.
+	DynamoDB *DynamoDBTarget `json:"DynamoDB"`
+
+	// Ordered http calls, required by steps target. They are executed one after another
+	// within a single execution, which fails at the first failed step.
+	Steps []Step `json:"Steps"`
 
 	// Request optional data payload
 	Payload string `json:"Payload"`
 
+	// Optional encoding of Payload, set to `base64` for non-text bodies (protobuf, images)
+	// which are decoded before sending. Template placeholders are not rendered in such payloads.
+	// Set to `json` for a JSON document, which is given and output as is rather than as string,
+	// checked before storing and after rendering, and sent as application/json by default.
+	PayloadEncoding string `json:"PayloadEncoding" valid:"in(base64|json)"`
+
+	// Optional JSON Schema the payload must satisfy, given inline or referenced as
+	// `s3://bucket/key`. The payload is validated against it when the request is stored.
+	PayloadSchema string `json:"PayloadSchema"`
+
 	// Optional headers by specific request
 	Headers map[string]string `json:"Headers"`
 
@@ -52,11 +129,543 @@ type ScheduledRequest struct {
 	// A string that captures the output from the response returned, available only after
 	// request got called and `PersistentStore=true`.
 	ExecutionResult string `json:"ExecutionResult"`
+
+	// Optional duration e.g. `720h` the ExecutionResult is kept for after execution, it is
+	// cleared then by the trim-results cleanup while the record itself is preserved.
+	ResultRetention string `json:"ResultRetention"`
+
+	// Optional comma separated list of accepted response status codes or classes, e.g. `2xx`
+	// or `200,204`. A response not matching is treated as execution failure.
+	ExpectStatus string `json:"ExpectStatus" valid:"expectstatus"`
+
+	// Optional checks on the JSON response body, all of them must hold for the execution
+	// to be considered successful.
+	Assertions []Assertion `json:"Assertions"`
+
+	// A boolean value that pipes the response body straight into a S3 object instead of
+	// buffering it, the object location is then stored as result. Assertions are not checked
+	// against streamed responses.
+	StreamResultToS3 bool `json:"StreamResultToS3"`
+
+	// Optional absolute url the outcome of every execution is posted to, so that the caller
+	// learns it without reading the table.
+	CallbackURL string `json:"CallbackURL"`
+
+	// Optional human-readable purpose of the request, shown by the cli and in failure alerts
+	Description string `json:"Description"`
+
+	// Optional labels grouping requests e.g. by team or service, matched by list filters
+	Tags map[string]string `json:"Tags"`
+
+	// Optional data of the caller e.g. ticket or correlation ids, stored and returned along with
+	// the request but never interpreted.
+	Metadata map[string]string `json:"Metadata"`
+
+	// Partition of the schedule the request belongs to, fetched only by the runs of that shard
+	// or by unsharded runs. Requests spread over shards are run concurrently without contending
+	// for the same items.
+	Shard int `json:"Shard"`
+}
+
+// Assertion declares the expected value found at a JSONPath expression of the response body
+type Assertion struct {
+	// JSONPath expression, e.g. `$.status` or `$.items[0].id`
+	Path string `json:"Path" valid:"required"`
+
+	// Expected value in its JSON text form, strings are compared without quotes
+	Expected string `json:"Expected"`
+}
+
+// Failure records a failed execution
+type Failure struct {
+	At     time.Time `json:"At"`
+	Reason string    `json:"Reason"`
 }
 
 // ToString returns string representation
 func (req ScheduledRequest) ToString() string {
-	return fmt.Sprintf("id=%s effective_after=%s locking=%t", req.ID, req.EffectiveAfter, req.Locking)
+	return fmt.Sprintf("id=%s target=%s effective_after=%s locking=%t", req.ID, req.Target(), req.EffectiveAfter, req.Locking)
+}
+
+// Validate checks request fields including the ones required by its target type
+func (req *ScheduledRequest) Validate() error {
+	if _, err := govalidator.ValidateStruct(req); err != nil {
+		return errors.Wrap(err, "govalidator.ValidateStruct")
+	}
+	if req.Shard < 0 {
+		return errors.Errorf("invalid shard %d", req.Shard)
+	}
+	if req.ResultRetention != "" {
+		if d, err := time.ParseDuration(req.ResultRetention); err != nil || d <= 0 {
+			return errors.Errorf("invalid ResultRetention %q, expect positive duration e.g. 720h", req.ResultRetention)
+		}
+	}
+	if req.CallbackURL != "" {
+		if u, err := url.Parse(req.CallbackURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("invalid CallbackURL %q, expect absolute http(s) url", req.CallbackURL)
+		}
+	}
+	if req.PayloadEncoding == PayloadJSON && !validJSONTemplate(req.Payload) {
+		return errors.New("Payload is not a valid JSON document")
+	}
+	if req.PayloadSchema != "" {
+		if req.PayloadEncoding == PayloadBase64 {
+			return errors.New("PayloadSchema requires a JSON payload")
+		}
+		if !strings.HasPrefix(req.PayloadSchema, "s3://") && !json.Valid([]byte(req.PayloadSchema)) {
+			return errors.New("PayloadSchema is neither a JSON document nor a s3://bucket/key reference")
+		}
+	}
+	for name, value := range req.Headers {
+		if !validHeaderName(name) || strings.ContainsAny(value, "\r\n") {
+			return errors.Errorf("invalid header %q", name)
+		}
+	}
+	switch req.Target() {
+	case TargetHTTP:
+		if req.Method == "" || req.URL == "" {
+			return errors.New("Method and URL are required by http target")
+		}
+		if err := validateURL(req.URL); err != nil {
+			return errors.Wrapf(err, "invalid URL %q", req.URL)
+		}
+	case TargetSQS:
+		if req.SQS == nil {
+			return errors.New("SQS is required by sqs target")
+		}
+	case TargetKinesis:
+		if req.Kinesis == nil {
+			return errors.New("Kinesis is required by kinesis target")
+		}
+	case TargetStepFunctions:
+		if req.StepFunctions == nil {
+			return errors.New("StepFunctions is required by sfn target")
+		}
+	case TargetKafka:
+		if req.Kafka == nil || len(req.Kafka.Brokers) == 0 {
+			return errors.New("Kafka with Brokers is required by kafka target")
+		}
+	case TargetMQTT:
+		if req.MQTT == nil {
+			return errors.New("MQTT is required by mqtt target")
+		}
+	case TargetSSM:
+		if req.SSM == nil || (len(req.SSM.InstanceIDs) == 0 && len(req.SSM.Targets) == 0) {
+			return errors.New("SSM with InstanceIDs or Targets is required by ssm target")
+		}
+	case TargetSteps:
+		if len(req.Steps) == 0 {
+			return errors.New("Steps are required by steps target")
+		}
+		names := map[string]bool{}
+		for _, step := range req.Steps {
+			if names[step.Name] {
+				return errors.Errorf("duplicated step name %s", step.Name)
+			}
+			names[step.Name] = true
+		}
+	case TargetDynamoDB:
+		if req.DynamoDB == nil {
+			return errors.New("DynamoDB is required by dynamodb target")
+		}
+		if req.DynamoDB.Operation == OperationUpdateItem && (req.DynamoDB.Key == "" || req.DynamoDB.UpdateExpression == "") {
+			return errors.New("Key and UpdateExpression are required by UpdateItem operation")
+		}
+	}
+	return nil
+}
+
+// SizeLimits bound the payload and headers of a request so that its item stays well within the
+// 400KB DynamoDB allows, zero values are unbounded
+type SizeLimits struct {
+	// Bytes of Payload
+	Payload int
+	// Number of Headers, and bytes of the name and value of a header
+	Headers    int
+	HeaderSize int
+}
+
+// Default size limits leave room for the other attributes, e.g. failure history
+const (
+	DefaultMaxPayloadSize = 350 * 1024
+	DefaultMaxHeaders     = 50
+	DefaultMaxHeaderSize  = 8 * 1024
+)
+
+// CheckSize returns an error telling the exceeded limit if payload or headers are too large
+func (req *ScheduledRequest) CheckSize(limits SizeLimits) error {
+	if limits.Payload > 0 && len(req.Payload) > limits.Payload {
+		return errors.Errorf("payload of %d bytes exceeds the limit of %d bytes", len(req.Payload), limits.Payload)
+	}
+	if limits.Headers > 0 && len(req.Headers) > limits.Headers {
+		return errors.Errorf("%d headers exceed the limit of %d headers", len(req.Headers), limits.Headers)
+	}
+	if limits.HeaderSize > 0 {
+		for name, value := range req.Headers {
+			if size := len(name) + len(value); size > limits.HeaderSize {
+				return errors.Errorf("header %q of %d bytes exceeds the limit of %d bytes", name, size, limits.HeaderSize)
+			}
+		}
+	}
+	return nil
+}
+
+// validateURL accepts a relative url joined with BASE_URL, or an absolute http(s) one
+func validateURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.IsAbs() && ((u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+		return errors.New("expect relative or absolute http(s) url")
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is a http token as of RFC 7230
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c > unicode.MaxASCII || c <= ' ' || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c) || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// templatePlaceholders match the `{{ ... }}` placeholders rendered before sending
+var templatePlaceholders = regexp.MustCompile(`\{\{.*?\}\}`)
+
+// validJSONTemplate tells whether payload is a JSON document once its template placeholders are
+// rendered, each of them is taken as a number which is valid both bare and within a string
+func validJSONTemplate(payload string) bool {
+	return json.Valid([]byte(templatePlaceholders.ReplaceAllString(payload, "0")))
+}
+
+// plainRequest has the fields of ScheduledRequest without its JSON methods
+type plainRequest ScheduledRequest
+
+// UnmarshalJSON accepts Payload given either as string or as JSON document, the latter is kept
+// compacted with PayloadEncoding set to json unless another encoding is given
+func (req *ScheduledRequest) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		*plainRequest
+		Payload json.RawMessage `json:"Payload"`
+	}{plainRequest: (*plainRequest)(req)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	raw := bytes.TrimSpace(aux.Payload)
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+		req.Payload = ""
+	case raw[0] == '"':
+		return json.Unmarshal(raw, &req.Payload)
+	default:
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, raw); err != nil {
+			return errors.Wrap(err, "json.Compact Payload")
+		}
+		req.Payload = compacted.String()
+		if req.PayloadEncoding == "" {
+			req.PayloadEncoding = PayloadJSON
+		}
+	}
+	return nil
+}
+
+// MarshalJSON outputs the Payload of json encoding as document rather than string, so that it
+// is neither double encoded nor escaped
+func (req ScheduledRequest) MarshalJSON() ([]byte, error) {
+	if req.PayloadEncoding != PayloadJSON || !json.Valid([]byte(req.Payload)) {
+		return json.Marshal(plainRequest(req))
+	}
+	return json.Marshal(struct {
+		plainRequest
+		Payload json.RawMessage `json:"Payload"`
+	}{plainRequest: plainRequest(req), Payload: json.RawMessage(req.Payload)})
+}
+
+// ResultExpired tells whether the retention of the stored ExecutionResult is over at now, never
+// if the request has no result or no valid retention
+func (req ScheduledRequest) ResultExpired(now time.Time) bool {
+	if req.ExecutionResult == "" || req.ResultRetention == "" || req.ExecutedAt.IsZero() {
+		return false
+	}
+	retention, err := time.ParseDuration(req.ResultRetention)
+	if err != nil || retention <= 0 {
+		return false
+	}
+	return !req.ExecutedAt.Add(retention).After(now)
+}
+
+// Target returns request target type, defaulting to http
+func (req ScheduledRequest) Target() string {
+	if req.TargetType == "" {
+		return TargetHTTP
+	}
+	return req.TargetType
+}
+
+// SQSTarget defines the message sent to a SQS queue
+type SQSTarget struct {
+	// Destination queue url
+	QueueURL string `json:"QueueURL" valid:"required"`
+
+	// Optional string attributes attached to the message
+	MessageAttributes map[string]string `json:"MessageAttributes"`
+
+	// Message group & deduplication ids, used by FIFO queues only
+	GroupID         string `json:"GroupID"`
+	DeduplicationID string `json:"DeduplicationID"`
+}
+
+// KinesisTarget defines the record put onto a Kinesis stream
+type KinesisTarget struct {
+	// Destination stream name
+	StreamName string `json:"StreamName" valid:"required"`
+
+	// Determines which shard the record is assigned to
+	PartitionKey string `json:"PartitionKey" valid:"required"`
+}
+
+// StepFunctionsTarget defines the state machine execution to be started
+type StepFunctionsTarget struct {
+	// Arn of the state machine to execute
+	StateMachineARN string `json:"StateMachineARN" valid:"required"`
+
+	// Optional execution name, could contain template placeholders e.g. {{ uuid }}
+	Name string `json:"Name"`
+}
+
+// KafkaTarget defines the message produced to a Kafka/MSK topic
+type KafkaTarget struct {
+	// Bootstrap broker addresses in format host:port
+	Brokers []string `json:"Brokers"`
+
+	// Destination topic
+	Topic string `json:"Topic" valid:"required"`
+
+	// Optional message key & headers, could contain template placeholders
+	Key     string            `json:"Key"`
+	Headers map[string]string `json:"Headers"`
+
+	// Optional SASL mechanism, credentials are taken from runtime configuration. Available options are:
+	// - PLAIN
+	// - AWS_MSK_IAM
+	SASLMechanism string `json:"SASLMechanism" valid:"in(PLAIN|AWS_MSK_IAM)"`
+
+	// If true then connections to brokers are encrypted, always enabled with SASL
+	TLS bool `json:"TLS"`
+}
+
+// MQTTTarget defines the message published to a MQTT topic
+type MQTTTarget struct {
+	// Optional broker url e.g. tls://broker.example.com:8883, AWS IoT Core is used if empty
+	Broker string `json:"Broker"`
+
+	// Destination topic, could contain template placeholders
+	Topic string `json:"Topic" valid:"required"`
+
+	// Delivery guarantee, either 0 (at most once) or 1 (at least once)
+	QoS int `json:"QoS" valid:"range(0|1)"`
+
+	// If true then broker keeps the message for future subscribers, not supported by AWS IoT Core
+	Retain bool `json:"Retain"`
+}
+
+// SSMTarget defines the Systems Manager command sent to instances
+type SSMTarget struct {
+	// Document to run e.g. AWS-RunShellScript
+	DocumentName string `json:"DocumentName" valid:"required"`
+
+	// Instances selected by ids or by targets keys e.g. tag:Env -> [prod]
+	InstanceIDs []string            `json:"InstanceIDs"`
+	Targets     map[string][]string `json:"Targets"`
+
+	// Document parameters e.g. commands -> [uptime]
+	Parameters map[string][]string `json:"Parameters"`
+
+	// Optional user specified information about the command
+	Comment string `json:"Comment"`
+}
+
+// DynamoDBTarget defines the item written to an arbitrary table
+type DynamoDBTarget struct {
+	// Destination table
+	TableName string `json:"TableName" valid:"required"`
+
+	// Write operation, either PutItem or UpdateItem
+	Operation string `json:"Operation" valid:"required,in(PutItem|UpdateItem)"`
+
+	// JSON object of the primary key attributes of updated item, could contain template placeholders
+	Key string `json:"Key"`
+
+	// Update expression e.g. `SET Enabled = :enabled`, UpdateItem only
+	UpdateExpression string `json:"UpdateExpression"`
+
+	// Optional condition of the write e.g. `attribute_exists(ID)`
+	ConditionExpression string `json:"ConditionExpression"`
+
+	// Optional substitution tokens of attribute names in expressions
+	ExpressionAttributeNames map[string]string `json:"ExpressionAttributeNames"`
+}
+
+// Step defines a http call of steps target
+type Step struct {
+	// Step name unique within the request
+	Name string `json:"Name" valid:"required"`
+
+	// Request method name, one of GET, PUT, POST or DELETE
+	Method string `json:"Method" valid:"required,in(GET|PUT|POST|DELETE)"`
+
+	// Absolute path or relative url string
+	URL string `json:"URL" valid:"required"`
+
+	// Optional headers & payload. Like url they could refer to values captured by previous
+	// steps with `{{ var NAME }}` placeholders.
+	Headers map[string]string `json:"Headers"`
+	Payload string            `json:"Payload"`
+
+	// Optional accepted response status codes or classes, e.g. `2xx`
+	ExpectStatus string `json:"ExpectStatus" valid:"expectstatus"`
+
+	// Variables captured from JSON response body by name & JSONPath, e.g. token -> $.access_token
+	Capture map[string]string `json:"Capture"`
+}
+
+// Available operations of dynamodb target
+const (
+	// OperationPutItem creates or replaces the whole item
+	OperationPutItem = "PutItem"
+	// OperationUpdateItem edits attributes of an existing item
+	OperationUpdateItem = "UpdateItem"
+)
+
+// Available SASL mechanisms of kafka target
+const (
+	// SASLPlain authenticates by username & password
+	SASLPlain = "PLAIN"
+	// SASLAWSMSKIAM authenticates to MSK by the IAM credentials of the function
+	SASLAWSMSKIAM = "AWS_MSK_IAM"
+)
+
+// Stages of the request lifecycle
+const (
+	// StatusPending waits for its effective date or the next run
+	StatusPending = "PENDING"
+	// StatusRunning is locked by the run executing it, or stuck by an interrupted execution
+	StatusRunning = "RUNNING"
+	// StatusSucceeded was executed and kept by PersistentStore
+	StatusSucceeded = "SUCCEEDED"
+	// StatusFailed is left locked by a failed execution until it is retried
+	StatusFailed = "FAILED"
+	// StatusCancelled is kept locked and never executed
+	StatusCancelled = "CANCELLED"
+	// StatusExpired was due for longer than the scheduler accepts to run it late
+	StatusExpired = "EXPIRED"
+)
+
+// CurrentStatus returns the status of request, derived from its lock, failure and execution for
+// the requests stored before they had one
+func (req ScheduledRequest) CurrentStatus() string {
+	switch {
+	case req.Status != "":
+		return req.Status
+	case req.FailureReason != "":
+		return StatusFailed
+	case !req.ExecutedAt.IsZero():
+		return StatusSucceeded
+	case req.Locking:
+		return StatusRunning
+	default:
+		return StatusPending
+	}
+}
+
+// Available target types
+const (
+	// TargetHTTP performs a http request call
+	TargetHTTP = "http"
+	// TargetSQS sends a message to a SQS queue
+	TargetSQS = "sqs"
+	// TargetKinesis puts a record onto a Kinesis stream
+	TargetKinesis = "kinesis"
+	// TargetStepFunctions starts a Step Functions state machine execution
+	TargetStepFunctions = "sfn"
+	// TargetKafka produces a message to a Kafka topic
+	TargetKafka = "kafka"
+	// TargetMQTT publishes a message to a MQTT topic
+	TargetMQTT = "mqtt"
+	// TargetSSM sends a Systems Manager command to EC2 instances
+	TargetSSM = "ssm"
+	// TargetDynamoDB writes an item to a DynamoDB table
+	TargetDynamoDB = "dynamodb"
+	// TargetSteps performs an ordered list of http calls
+	TargetSteps = "steps"
+)
+
+var targetTypes = struct {
+	sync.RWMutex
+	known map[string]bool
+}{known: map[string]bool{
+	TargetHTTP:          true,
+	TargetSQS:           true,
+	TargetKinesis:       true,
+	TargetStepFunctions: true,
+	TargetKafka:         true,
+	TargetMQTT:          true,
+	TargetSSM:           true,
+	TargetDynamoDB:      true,
+	TargetSteps:         true,
+}}
+
+// RegisterTargetType accepts name as TargetType of requests besides the built-in ones, e.g. the
+// target of an executor plugged into the scheduler
+func RegisterTargetType(name string) {
+	targetTypes.Lock()
+	defer targetTypes.Unlock()
+	targetTypes.known[name] = true
+}
+
+// KnownTarget tells whether name is a built-in or registered target type
+func KnownTarget(name string) bool {
+	targetTypes.RLock()
+	defer targetTypes.RUnlock()
+	return targetTypes.known[name]
+}
+
+// Encodings of payload
+const (
+	// PayloadBase64 marks payload as base64 encoded binary data
+	PayloadBase64 = "base64"
+	// PayloadJSON marks payload as JSON document
+	PayloadJSON = "json"
+)
+
+// MatchStatus reports whether status code satisfies the expectation spec, which is a comma
+// separated list of exact codes (e.g. `200,204`) or classes (e.g. `2xx`).
+// Empty spec matches any status code.
+func MatchStatus(spec string, code int) (bool, error) {
+	if spec == "" {
+		return true, nil
+	}
+	matched := false
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if len(part) == 3 && strings.HasSuffix(part, "xx") && part[0] >= '1' && part[0] <= '5' {
+			matched = matched || code/100 == int(part[0]-'0')
+			continue
+		}
+		expect, err := strconv.Atoi(part)
+		if err != nil || expect < 100 || expect > 599 {
+			return false, errors.Errorf("invalid status code expectation %q", part)
+		}
+		matched = matched || code == expect
+	}
+	return matched, nil
 }
 
 // Response capture the execution result
@@ -65,9 +674,32 @@ type Response struct {
 	Code int `json:"code"`
 	// Response body data payload
 	Body string `json:"body"`
+	// Whether body got cut off at the configured max body size
+	Truncated bool `json:"truncated,omitempty"`
+	// S3 object key of the body in RESULT_BUCKET in case it got streamed
+	ObjectKey string `json:"object_key,omitempty"`
+	// Retry-After header value answered by target, if any
+	RetryAfter string `json:"retry_after,omitempty"`
+	// Duration of the execution in milliseconds, including retries
+	Duration float64 `json:"duration_ms,omitempty"`
+	// Phases of the last http attempt
+	Timing *Timing `json:"timing,omitempty"`
+}
+
+// Timing breaks down an http attempt in milliseconds, connection phases are zero when skipped
+// e.g. for reused connections
+type Timing struct {
+	DNS     float64 `json:"dns_ms"`
+	Connect float64 `json:"connect_ms"`
+	TLS     float64 `json:"tls_ms"`
+	// Time to first response byte since the attempt started
+	TTFB float64 `json:"ttfb_ms"`
+	// Time until response body got read
+	Total  float64 `json:"total_ms"`
+	Reused bool    `json:"reused,omitempty"`
 }
 
 // ToString returns string representation
 func (resp Response) ToString() string {
-	return fmt.Sprintf("code=%d body=%s", resp.Code, resp.Body)
+	return fmt.Sprintf("code=%d body=%s truncated=%t object_key=%s duration_ms=%.1f", resp.Code, resp.Body, resp.Truncated, resp.ObjectKey, resp.Duration)
 }
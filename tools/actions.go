@@ -0,0 +1,758 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/config"
+	"github.com/meomap/citium/scheduler"
+	"github.com/meomap/citium/schema"
+)
+
+// idFlag registers the flag of the request an action applies to
+func idFlag(fs *flag.FlagSet) *string {
+	return fs.String("id", "", "request unique id")
+}
+
+// freezeFlag registers the flag of the effective date of created requests
+func freezeFlag(fs *flag.FlagSet) *time.Duration {
+	return fs.Duration("freeze", time.Hour, "freeze duration (in secs) until effective date to execute request")
+}
+
+// importFlags registers the flags of the file of request definitions
+func importFlags(fs *flag.FlagSet) (*string, *string) {
+	return fs.String("file", "", "file of request definitions, - reads stdin"),
+		fs.String("format", "", "format of import file, one of json (array), jsonl or csv, guessed from file extension if empty")
+}
+
+// baseURLFlag registers the flag of the base url of http targets
+func baseURLFlag(fs *flag.FlagSet, usage string) *string {
+	return fs.String("base-url", "", usage+", defaults to BASE_URL env variable or base_url of -config profile")
+}
+
+// orEnv returns value, or else the one of env variable e.g. set by the -config profile
+func orEnv(value, name string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+func setupCreate(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	var assertions, tags stringsFlag
+	fs.Var(&tags, "tag", "repeatable tag of the request in format key=value")
+	fs.Var(&assertions, "assert", "repeatable response body assertion in format jsonpath=expected, e.g. $.status=ok")
+	var (
+		id            = idFlag(fs)
+		freezeDur     = freezeFlag(fs)
+		method        = fs.String("method", http.MethodGet, "request method name")
+		rURL          = fs.String("url", "", "request url path, could be absolute path or relative (in case BASE_URL env variable is set)")
+		payload       = fs.String("payload", "", "payload data, - reads it from stdin")
+		payloadFile   = fs.String("payload-file", "", "file of payload data instead of -payload, binary data is base64 encoded")
+		payloadEnc    = fs.String("payload-encoding", "", "payload encoding, set to base64 for binary payload or json for JSON document")
+		headers       = fs.String("headers", "", "comma separated list of headers in format key:value, sent as message headers by kafka target. backslash escapes commas of values e.g. Accept:a\\,b")
+		headersFile   = fs.String("headers-file", "", "JSON or YAML file of headers map, overridden by -headers")
+		persistEnable = fs.Bool("persistent", false, "if true then persistently store request after execution")
+		streamToS3    = fs.Bool("stream-to-s3", false, "if true then response body is streamed into RESULT_BUCKET instead of being stored as result")
+		target        = fs.String("target", schema.TargetHTTP, "target type of the scheduled action, one of http, sqs, kinesis, sfn, kafka, mqtt, ssm, dynamodb or steps")
+		queueURL      = fs.String("queue-url", "", "destination queue url of sqs target")
+		msgGroupID    = fs.String("message-group-id", "", "message group id of sqs target sending to FIFO queue")
+		msgDedupID    = fs.String("message-dedup-id", "", "message deduplication id of sqs target sending to FIFO queue")
+		msgAttrs      = fs.String("message-attributes", "", "comma separated list of sqs message attributes in format key:value")
+		streamName    = fs.String("stream-name", "", "destination stream name of kinesis target")
+		partitionKey  = fs.String("partition-key", "", "record partition key of kinesis target")
+		stateMachine  = fs.String("state-machine-arn", "", "state machine arn of sfn target")
+		execName      = fs.String("execution-name", "", "optional execution name of sfn target")
+		brokers       = fs.String("brokers", "", "comma separated list of bootstrap broker addresses of kafka target")
+		topic         = fs.String("topic", "", "destination topic of kafka or mqtt target")
+		msgKey        = fs.String("message-key", "", "optional message key of kafka target")
+		saslMechanism = fs.String("sasl-mechanism", "", "optional sasl mechanism of kafka target, either PLAIN or AWS_MSK_IAM")
+		kafkaTLS      = fs.Bool("kafka-tls", false, "if true then kafka target connects to brokers over TLS")
+		mqttBroker    = fs.String("broker", "", "optional broker url of mqtt target e.g. tls://broker.example.com:8883, AWS IoT Core is used if empty")
+		qos           = fs.Int("qos", 0, "delivery guarantee of mqtt target, either 0 or 1")
+		retain        = fs.Bool("retain", false, "if true then mqtt target message is retained by broker")
+		documentName  = fs.String("document-name", "", "document to run by ssm target e.g. AWS-RunShellScript")
+		instanceIDs   = fs.String("instance-ids", "", "comma separated list of instance ids of ssm target")
+		ssmTargets    = fs.String("ssm-targets", "", "semicolon separated list of ssm target instance selectors in format key=value1,value2 e.g. tag:Env=prod")
+		ssmParams     = fs.String("ssm-parameters", "", "semicolon separated list of ssm document parameters in format name=value1,value2")
+		comment       = fs.String("comment", "", "optional comment of ssm target command")
+		itemTable     = fs.String("item-table", "", "destination table of dynamodb target")
+		operation     = fs.String("operation", schema.OperationPutItem, "write operation of dynamodb target, either PutItem or UpdateItem")
+		itemKey       = fs.String("item-key", "", "JSON object of the primary key of dynamodb target updated item")
+		updateExpr    = fs.String("update-expression", "", "update expression of dynamodb target UpdateItem operation")
+		conditionExpr = fs.String("condition-expression", "", "optional condition expression of dynamodb target")
+		steps         = fs.String("steps", "", "JSON array of the http calls of steps target")
+		expectStatus  = fs.String("expect-status", "", "comma separated list of accepted response status codes or classes, e.g. 2xx or 200,204")
+		callbackURL   = fs.String("callback-url", "", "optional absolute url the outcome of every execution of the request is posted to")
+		payloadSchema = fs.String("payload-schema", "", "optional JSON Schema the payload must satisfy, inline or s3://bucket/key")
+		retention     = fs.String("result-retention", "", "optional duration e.g. 720h the execution result is kept for, cleared then by trim-results action")
+		description   = fs.String("description", "", "optional human-readable purpose of the request")
+		metadata      = fs.String("metadata", "", "optional comma separated list of caller data stored along with the request in format key=value")
+		shard         = fs.Int("shard", -1, "shard of the request")
+		createdBy     = fs.String("created-by", "", "creator of the request, defaults to the ARN of the AWS identity")
+		dryRun        = fs.Bool("dry-run", false, "validate and print the item without writing it")
+	)
+	return func() {
+		env := g.openTable()
+		req := &schema.ScheduledRequest{
+			ID:               *id,
+			CreatedAt:        time.Now().UTC(),
+			TargetType:       *target,
+			Method:           *method,
+			URL:              *rURL,
+			PayloadEncoding:  *payloadEnc,
+			PersistentStore:  *persistEnable,
+			ResultRetention:  *retention,
+			PayloadSchema:    *payloadSchema,
+			ExpectStatus:     *expectStatus,
+			StreamResultToS3: *streamToS3,
+			Description:      *description,
+			CallbackURL:      *callbackURL,
+		}
+		req.Tags = mustTags(tags)
+		if *metadata != "" {
+			req.Metadata = mustPairs("metadata", *metadata)
+		}
+		req.Payload, req.PayloadEncoding = readPayload(*payload, *payloadFile, *payloadEnc)
+		req.Headers = readHeaders(*headersFile, *headers)
+		switch *target {
+		case schema.TargetSQS:
+			req.SQS = &schema.SQSTarget{
+				QueueURL:        *queueURL,
+				GroupID:         *msgGroupID,
+				DeduplicationID: *msgDedupID,
+			}
+			if *msgAttrs != "" {
+				req.SQS.MessageAttributes = mustPairs("message-attributes", *msgAttrs)
+			}
+		case schema.TargetKinesis:
+			req.Kinesis = &schema.KinesisTarget{
+				StreamName:   *streamName,
+				PartitionKey: *partitionKey,
+			}
+		case schema.TargetStepFunctions:
+			req.StepFunctions = &schema.StepFunctionsTarget{
+				StateMachineARN: *stateMachine,
+				Name:            *execName,
+			}
+		case schema.TargetKafka:
+			req.Kafka = &schema.KafkaTarget{
+				Topic:         *topic,
+				Key:           *msgKey,
+				SASLMechanism: *saslMechanism,
+				TLS:           *kafkaTLS,
+			}
+			if *brokers != "" {
+				req.Kafka.Brokers = strings.Split(*brokers, ",")
+			}
+			req.Kafka.Headers = req.Headers
+		case schema.TargetMQTT:
+			req.MQTT = &schema.MQTTTarget{
+				Broker: *mqttBroker,
+				Topic:  *topic,
+				QoS:    *qos,
+				Retain: *retain,
+			}
+		case schema.TargetSSM:
+			req.SSM = &schema.SSMTarget{
+				DocumentName: *documentName,
+				Targets:      parseLists(*ssmTargets),
+				Parameters:   parseLists(*ssmParams),
+				Comment:      *comment,
+			}
+			if *instanceIDs != "" {
+				req.SSM.InstanceIDs = strings.Split(*instanceIDs, ",")
+			}
+		case schema.TargetSteps:
+			if err := json.Unmarshal([]byte(*steps), &req.Steps); err != nil {
+				fmt.Printf("Invalid steps %q: %s\n", *steps, err)
+				os.Exit(1)
+			}
+		case schema.TargetDynamoDB:
+			req.DynamoDB = &schema.DynamoDBTarget{
+				TableName:           *itemTable,
+				Operation:           *operation,
+				Key:                 *itemKey,
+				UpdateExpression:    *updateExpr,
+				ConditionExpression: *conditionExpr,
+			}
+		}
+		for _, v := range assertions {
+			parts := strings.SplitN(v, "=", 2)
+			if len(parts) != 2 {
+				fmt.Printf("Invalid assertion %q, expect format jsonpath=expected\n", v)
+				os.Exit(1)
+			}
+			req.Assertions = append(req.Assertions, schema.Assertion{Path: parts[0], Expected: parts[1]})
+		}
+		if *shard > 0 {
+			req.Shard = *shard
+		}
+		req.EffectiveAfter = req.CreatedAt.Add(*freezeDur)
+		req.CreatedBy = creator(env.sess, *createdBy, *g.actor)
+		if err := req.Validate(); err != nil {
+			panic(err)
+		}
+		if err := req.CheckSize(env.limits); err != nil {
+			fmt.Printf("Request too large id=%s: %s\n", req.ID, err)
+			os.Exit(1)
+		}
+		if err := env.contracts.Check(context.Background(), req); err != nil {
+			fmt.Printf("Invalid payload id=%s: %s\n", req.ID, err)
+			os.Exit(1)
+		}
+		if *dryRun {
+			printDryRun(env.table, []*schema.ScheduledRequest{req})
+			return
+		}
+		if err := scheduler.Create(context.Background(), env.svc, env.table, req); err != nil {
+			panic(err)
+		}
+		if err := env.audit.Record(context.Background(), req.ID, scheduler.AuditCreated, ""); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func setupClone(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	var (
+		id        = idFlag(fs)
+		newID     = fs.String("new-id", "", "id of the created request")
+		at        = fs.String("at", "", "effective date of the created request, RFC 3339 time or duration from now e.g. +2h, after -freeze if empty")
+		freezeDur = freezeFlag(fs)
+		createdBy = fs.String("created-by", "", "creator of the created request, defaults to the ARN of the AWS identity")
+		output    = addOutputFlag(fs)
+	)
+	return func() {
+		env := g.openTable()
+		now := time.Now().UTC()
+		effective := now.Add(*freezeDur)
+		if *at != "" {
+			var err error
+			if effective, err = parseAt(*at, now); err != nil {
+				fmt.Printf("Invalid value of flag `-at` %q: %s\n", *at, err)
+				os.Exit(1)
+			}
+		}
+		if *newID == "" {
+			fmt.Printf("Empty value of the required flag `-new-id`\n")
+			os.Exit(1)
+		}
+		req, err := scheduler.Clone(context.Background(), env.svc, env.table, *id, *newID, creator(env.sess, *createdBy, *g.actor), effective, now)
+		if err != nil {
+			panic(err)
+		}
+		if err = env.audit.Record(context.Background(), req.ID, scheduler.AuditCreated, "clone of "+*id); err != nil {
+			panic(err)
+		}
+		printOutput(output.String(), req)
+	}
+}
+
+func setupGet(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	id := idFlag(fs)
+	output := addOutputFlag(fs)
+	return func() {
+		env := g.openTable()
+		req, err := scheduler.Get(context.Background(), env.svc, env.table, *id)
+		if errors.Cause(err) == scheduler.ErrNotFound {
+			fmt.Println("not found")
+			return
+		}
+		if err != nil {
+			panic(err)
+		}
+		printOutput(output.String(), req)
+	}
+}
+
+func setupList(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	var tags stringsFlag
+	fs.Var(&tags, "tag", "repeatable tag filter in format key=value")
+	var (
+		lockedFilter = fs.String("locked", "", "lock state filter, either true or false")
+		failedFilter = fs.String("failed", "", "failure filter, true lists requests whose last execution failed")
+		dueBefore    = fs.String("due-before", "", "list requests effective at or before RFC 3339 time")
+		dueAfter     = fs.String("due-after", "", "list requests effective at or after RFC 3339 time")
+		urlContains  = fs.String("url-contains", "", "list requests whose url contains the value")
+		statusFilter = fs.String("status", "", "list requests of status, one of PENDING, RUNNING, SUCCEEDED, FAILED, CANCELLED or EXPIRED")
+		limit        = fs.Int("limit", 0, "most requests printed, 0 lists all of them")
+		nextToken    = fs.String("next-token", "", "page token printed by a previous list action to continue from")
+		output       = addOutputFlag(fs)
+	)
+	return func() {
+		env := g.openTable()
+		filter := scheduler.ListFilter{
+			Locked:      parseBoolFilter("locked", *lockedFilter),
+			Failed:      parseBoolFilter("failed", *failedFilter),
+			DueBefore:   parseTimeFilter("due-before", *dueBefore),
+			DueAfter:    parseTimeFilter("due-after", *dueAfter),
+			URLContains: *urlContains,
+			Status:      *statusFilter,
+		}
+		filter.Tags = mustTags(tags)
+		if reflect.DeepEqual(filter, scheduler.ListFilter{}) {
+			// without filters, list the requests to be run next
+			locked := false
+			filter.Locked, filter.DueBefore = &locked, time.Now().UTC()
+		}
+		indexes, err := config.LoadIndexes(env.table)
+		if err != nil {
+			panic(err)
+		}
+		records, next, err := scheduler.ListIndexed(context.Background(), env.svc, indexes, scheduler.ListOptions{Limit: *limit, StartKey: *nextToken, Filter: filter})
+		if err != nil {
+			panic(err)
+		}
+		printOutput(output.String(), records)
+		if next != "" {
+			// keep stdout a valid JSON document
+			fmt.Fprintf(os.Stderr, "More requests to list with -next-token=%s\n", next)
+		}
+	}
+}
+
+func setupLock(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	id := idFlag(fs)
+	return func() {
+		env := g.openTable()
+		err := scheduler.Lock(context.Background(), env.svc, env.table, *id)
+		if cause := errors.Cause(err); cause == scheduler.ErrNotFound || cause == scheduler.ErrAlreadyLocked {
+			fmt.Printf("Cannot lock %s: %s\n", *id, cause)
+			os.Exit(1)
+		}
+		if err != nil {
+			panic(err)
+		}
+		if err := env.audit.Record(context.Background(), *id, scheduler.AuditLocked, ""); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func setupUnlock(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	id := idFlag(fs)
+	return func() {
+		env := g.openTable()
+		err := scheduler.Unlock(context.Background(), env.svc, env.table, *id)
+		if cause := errors.Cause(err); cause == scheduler.ErrNotFound {
+			fmt.Printf("Cannot unlock %s: %s\n", *id, cause)
+			os.Exit(1)
+		}
+		if err != nil {
+			panic(err)
+		}
+		if err := env.audit.Record(context.Background(), *id, scheduler.AuditUnlocked, ""); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func setupCancel(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	id := idFlag(fs)
+	return func() {
+		env := g.openTable()
+		if err := scheduler.Cancel(context.Background(), env.svc, env.table, *id); err != nil {
+			panic(err)
+		}
+		if err := env.audit.Record(context.Background(), *id, scheduler.AuditCancelled, ""); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func setupHistory(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	id := idFlag(fs)
+	output := addOutputFlag(fs)
+	return func() {
+		env := g.openTable()
+		history, err := scheduler.GetHistory(context.Background(), env.svc, env.table, *id)
+		if err != nil {
+			panic(err)
+		}
+		printOutput(output.String(), history)
+	}
+}
+
+func setupStats(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	output := addOutputFlag(fs)
+	return func() {
+		env := g.openTable()
+		stats, err := scheduler.ComputeStats(context.Background(), env.svc, env.table, 10, time.Now().UTC())
+		if err != nil {
+			panic(err)
+		}
+		printOutput(output.String(), stats)
+	}
+}
+
+func setupAudit(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	id := idFlag(fs)
+	output := addOutputFlag(fs)
+	return func() {
+		env := g.openTable()
+		if env.audit == nil {
+			fmt.Printf("Empty value of the required flag `-audit-table`\n")
+			os.Exit(1)
+		}
+		events, err := env.audit.History(context.Background(), *id)
+		if err != nil {
+			panic(err)
+		}
+		printOutput(output.String(), events)
+	}
+}
+
+func setupRedrive(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	dlqQueueURL := fs.String("dlq-queue-url", "", "dead-letter queue url")
+	dlqTable := fs.String("dlq-table", "", "dead-letter table")
+	return func() {
+		env := g.openTable()
+		var dlq scheduler.DeadLetterQueue
+		switch {
+		case *dlqQueueURL != "":
+			dlq = scheduler.NewSQSDeadLetterQueue(sqs.New(env.sess), *dlqQueueURL)
+		case *dlqTable != "":
+			dlq = scheduler.NewTableDeadLetterQueue(env.svc, *dlqTable)
+		default:
+			fmt.Printf("Empty value of the required flag `-dlq-queue-url` or `-dlq-table`\n")
+			os.Exit(1)
+		}
+		redriven, err := dlq.Redrive(context.Background(), env.svc, env.table, time.Now().UTC())
+		fmt.Printf("redriven %d requests\n", redriven)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func setupImport(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	importFile, importFormat := importFlags(fs)
+	var (
+		freezeDur = freezeFlag(fs)
+		createdBy = fs.String("created-by", "", "creator of the requests not giving theirs, defaults to the ARN of the AWS identity")
+		dryRun    = fs.Bool("dry-run", false, "validate and print the items without writing them")
+		upsert    = fs.Bool("upsert", false, "create or replace the requests whatever their stored version instead of creating new ones only")
+	)
+	return func() {
+		env := g.openTable()
+		entries, err := readImport(*importFile, *importFormat)
+		if err != nil {
+			panic(err)
+		}
+		reqs, valid := validateEntries(entries, time.Now().UTC(), *freezeDur, env.limits)
+		// nothing is written unless every request is valid
+		if !checkContracts(env.contracts, entries) || !valid {
+			os.Exit(1)
+		}
+		by := creator(env.sess, *createdBy, *g.actor)
+		for _, req := range reqs {
+			if req.CreatedBy == "" {
+				req.CreatedBy = by
+			}
+		}
+		if *dryRun {
+			printDryRun(env.table, reqs)
+			return
+		}
+		if *upsert {
+			created := 0
+			for _, req := range reqs {
+				ok, err := scheduler.Upsert(context.Background(), env.svc, env.table, req)
+				if errors.Cause(err) == scheduler.ErrAlreadyLocked {
+					fmt.Printf("Cannot upsert %s while it is running\n", req.ID)
+					os.Exit(1)
+				}
+				if err != nil {
+					panic(err)
+				}
+				if ok {
+					created++
+				}
+				if err = env.audit.Record(context.Background(), req.ID, scheduler.AuditCreated, "import upsert"); err != nil {
+					panic(err)
+				}
+			}
+			fmt.Printf("upserted %d requests created=%d\n", len(reqs), created)
+			return
+		}
+		if err = scheduler.BatchCreate(context.Background(), env.svc, env.table, reqs); err != nil {
+			panic(err)
+		}
+		for _, req := range reqs {
+			if err = env.audit.Record(context.Background(), req.ID, scheduler.AuditCreated, "import"); err != nil {
+				panic(err)
+			}
+		}
+		fmt.Printf("imported %d requests\n", len(reqs))
+	}
+}
+
+func setupValidate(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	importFile, importFormat := importFlags(fs)
+	freezeDur := freezeFlag(fs)
+	return func() {
+		// validating definitions is the only action not touching the table
+		env := g.open()
+		entries, err := readImport(*importFile, *importFormat)
+		if err != nil {
+			fmt.Printf("Invalid file %s: %s\n", *importFile, err)
+			os.Exit(1)
+		}
+		_, valid := validateEntries(entries, time.Now().UTC(), *freezeDur, env.limits)
+		if !checkContracts(env.contracts, entries) || !valid {
+			os.Exit(1)
+		}
+		fmt.Printf("%d requests are valid\n", len(entries))
+	}
+}
+
+func setupHealthcheck(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	baseURL := baseURLFlag(fs, "base url probed, skipped if empty")
+	output := addOutputFlag(fs)
+	return func() {
+		env := g.openTable()
+		report := scheduler.CheckHealth(context.Background(), env.svc, env.table, &http.Client{Timeout: 10 * time.Second}, orEnv(*baseURL, "BASE_URL"))
+		printOutput(output.String(), report)
+		if !report.Healthy {
+			os.Exit(1)
+		}
+	}
+}
+
+// runTrigger executes requests like the scheduled function does with trigger, printing the summary
+func runTrigger(name string, g *globalFlags, baseURL string, output string, tags map[string]string, trigger func(ctx context.Context, conf *config.Configuration, services *scheduler.Services) (*scheduler.RunSummary, error)) {
+	env := g.openTable()
+	conf, services := executionServices(env.sess, env.table, orEnv(baseURL, "BASE_URL"), env.endpoint)
+	if len(tags) > 0 {
+		conf.RunTags = tags
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	summary, err := trigger(ctx, conf, services)
+	if summary != nil {
+		printOutput(output, summary)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %s\n", name, err)
+		stop()
+		os.Exit(1)
+	}
+}
+
+func setupRun(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	var tags stringsFlag
+	fs.Var(&tags, "tag", "repeatable tag in format key=value the executed requests must have")
+	shard := fs.Int("shard", -1, "only shard of the executed due requests, all of them if negative")
+	baseURL := baseURLFlag(fs, "base url of relative request urls")
+	output := addOutputFlag(fs)
+	return func() {
+		runTrigger("run", g, *baseURL, output.String(), mustTags(tags), func(ctx context.Context, conf *config.Configuration, services *scheduler.Services) (*scheduler.RunSummary, error) {
+			if *shard >= 0 {
+				return scheduler.TriggerShard(ctx, conf, services.DynamoDB, services, *shard)
+			}
+			return scheduler.TriggerAPI(ctx, conf, services.DynamoDB, services)
+		})
+	}
+}
+
+func setupTrigger(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	id := idFlag(fs)
+	baseURL := baseURLFlag(fs, "base url of relative request urls")
+	output := addOutputFlag(fs)
+	return func() {
+		runTrigger("trigger", g, *baseURL, output.String(), nil, func(ctx context.Context, conf *config.Configuration, services *scheduler.Services) (*scheduler.RunSummary, error) {
+			return scheduler.TriggerRequest(ctx, conf, services.DynamoDB, services, *id)
+		})
+	}
+}
+
+func setupReschedule(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	id := idFlag(fs)
+	at := fs.String("at", "", "new effective date, RFC 3339 time or duration from now e.g. +2h")
+	unlock := fs.Bool("unlock", false, "unlock the rescheduled request")
+	return func() {
+		env := g.openTable()
+		effective, err := parseAt(*at, time.Now().UTC())
+		if err != nil {
+			fmt.Printf("Invalid value of flag `-at` %q: %s\n", *at, err)
+			os.Exit(1)
+		}
+		if err = scheduler.Reschedule(context.Background(), env.svc, env.table, *id, effective, *unlock); err != nil {
+			panic(err)
+		}
+		if err = env.audit.Record(context.Background(), *id, scheduler.AuditRescheduled, effective.Format(time.RFC3339)); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func setupRetryFailed(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	at := fs.String("at", "", "new effective date of retried requests, RFC 3339 time or duration from now e.g. +2h, kept if empty")
+	return func() {
+		env := g.openTable()
+		var effective time.Time
+		if *at != "" {
+			var err error
+			if effective, err = parseAt(*at, time.Now().UTC()); err != nil {
+				fmt.Printf("Invalid value of flag `-at` %q: %s\n", *at, err)
+				os.Exit(1)
+			}
+		}
+		retried, err := scheduler.RetryFailed(context.Background(), env.svc, env.table, effective)
+		for _, reqID := range retried {
+			if aErr := env.audit.Record(context.Background(), reqID, scheduler.AuditUnlocked, "retry-failed"); aErr != nil {
+				panic(aErr)
+			}
+		}
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("retried %d requests\n", len(retried))
+	}
+}
+
+func setupPurge(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	var (
+		execBefore   = fs.String("executed-before", "", "purge requests executed before RFC 3339 time or duration ago e.g. 720h")
+		archiveTable = fs.String("archive-table", "", "optional table requests are copied into before being deleted")
+		dryRun       = fs.Bool("dry-run", false, "print the ids of the requests to purge without deleting them")
+	)
+	return func() {
+		env := g.openTable()
+		before, err := parseBefore(*execBefore, time.Now().UTC())
+		if err != nil || *execBefore == "" {
+			fmt.Printf("Invalid value of the required flag `-executed-before` %q, expect RFC 3339 time or duration\n", *execBefore)
+			os.Exit(1)
+		}
+		if *dryRun {
+			records, _, err := scheduler.List(context.Background(), env.svc, env.table, scheduler.ListOptions{Filter: scheduler.ListFilter{ExecutedBefore: before}})
+			if err != nil {
+				panic(err)
+			}
+			for _, req := range records {
+				fmt.Println(req.ID)
+			}
+			fmt.Fprintf(os.Stderr, "dry run, %d requests would be purged from table_name=%s\n", len(records), env.table)
+			return
+		}
+		purged, err := scheduler.Purge(context.Background(), env.svc, env.table, before, *archiveTable)
+		for _, reqID := range purged {
+			if aErr := env.audit.Record(context.Background(), reqID, scheduler.AuditDeleted, "purge"); aErr != nil {
+				panic(aErr)
+			}
+		}
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("purged %d requests\n", len(purged))
+	}
+}
+
+func setupTrimResults(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "print the ids of the requests whose results would be cleared without clearing them")
+	return func() {
+		env := g.openTable()
+		now := time.Now().UTC()
+		if *dryRun {
+			records, err := scheduler.ExpiredResults(context.Background(), env.svc, env.table, now)
+			if err != nil {
+				panic(err)
+			}
+			for _, req := range records {
+				fmt.Println(req.ID)
+			}
+			fmt.Fprintf(os.Stderr, "dry run, the results of %d requests would be cleared from table_name=%s\n", len(records), env.table)
+			return
+		}
+		trimmed, err := scheduler.TrimResults(context.Background(), env.svc, env.table, now)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("cleared the results of %d requests\n", len(trimmed))
+	}
+}
+
+func setupMigrate(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "count the items to migrate without updating them")
+	output := addOutputFlag(fs)
+	return func() {
+		env := g.openTable()
+		report, err := scheduler.Migrate(context.Background(), env.svc, env.table, 0, *dryRun, func(r *scheduler.MigrationReport) {
+			fmt.Fprintf(os.Stderr, "scanned=%d migrated=%d conflicts=%d\n", r.Scanned, r.Migrated, r.Conflicts)
+		})
+		if err != nil {
+			panic(err)
+		}
+		printOutput(output.String(), report)
+	}
+}
+
+func setupCreateTable(fs *flag.FlagSet) func() {
+	g := addGlobalFlags(fs)
+	checkpointTable := fs.String("checkpoint-table", "", "optional table of fetch cursors to create")
+	leaseTable := fs.String("lease-table", "", "optional table of schedule leases to create")
+	return func() {
+		env := g.openTable()
+		indexes, err := config.LoadIndexes(env.table)
+		if err != nil {
+			panic(err)
+		}
+		created, err := scheduler.EnsureTable(context.Background(), env.svc, env.table, indexes.IndexedTags...)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("table %s created=%t\n", env.table, created)
+		if *g.auditTable != "" {
+			if created, err = scheduler.EnsureAuditTable(context.Background(), env.svc, *g.auditTable); err != nil {
+				panic(err)
+			}
+			fmt.Printf("audit table %s created=%t\n", *g.auditTable, created)
+		}
+		if *checkpointTable != "" {
+			if created, err = scheduler.EnsureCheckpointTable(context.Background(), env.svc, *checkpointTable); err != nil {
+				panic(err)
+			}
+			fmt.Printf("checkpoint table %s created=%t\n", *checkpointTable, created)
+		}
+		if *leaseTable != "" {
+			if created, err = scheduler.EnsureLeaseTable(context.Background(), env.svc, *leaseTable); err != nil {
+				panic(err)
+			}
+			fmt.Printf("lease table %s created=%t\n", *leaseTable, created)
+		}
+	}
+}
@@ -4,120 +4,370 @@
 // export AWS_REGION=YOUR_REGION
 // export AWS_ACCESS_KEY_ID=YOUR_AKID
 // export AWS_SECRET_ACCESS_KEY=YOUR_SECRET_KEY
-//
 package main
 
 import (
-	"context"
+	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"net/http"
+	"io"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
-	"github.com/asaskevich/govalidator"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
 
+	"github.com/meomap/citium/config"
 	"github.com/meomap/citium/scheduler"
 	"github.com/meomap/citium/schema"
 )
 
-func main() {
-	var (
-		action = flag.String("action", "", `command action name. the available options are:
-	- create: request to add new record with specific parameters
-	- get: retrieve scheduled request by given id
-	- list: fetch all the scheduled requests to be run next
-	- lock: request to lock record by given id
-	- unlock: request to unlock record by given id
-`)
-		id            = flag.String("id", "", "request unique id")
-		table         = flag.String("table", "", "dynamodb table to store request")
-		freezeDur     = flag.Duration("freeze", time.Hour, "freeze duration (in secs) until effective date to execute request")
-		method        = flag.String("method", http.MethodGet, "request method name")
-		rURL          = flag.String("url", "", "request url path, could be absolute path or relative (in case BASE_URL env variable is set)")
-		payload       = flag.String("payload", "", "payload data")
-		headers       = flag.String("headers", "", "comma separated list of headers in format key:value")
-		persistEnable = flag.Bool("persistent", false, "if true then persistently store request after execution")
-	)
-	flag.Parse()
+// stringsFlag collects values of a repeatable flag
+type stringsFlag []string
 
-	if *table == "" {
-		fmt.Printf("Empty value of the required flag `-table`\n")
-		os.Exit(1)
-	}
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
 
-	svc := dynamodb.New(session.Must(session.NewSession(nil)), aws.NewConfig())
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
 
-	switch *action {
-	case "list":
-		records, err := scheduler.FetchSchedRequests(context.Background(), svc, *table, time.Now().UTC())
-		if err != nil {
-			panic(err)
-		}
-		serialized, err := json.Marshal(records)
-		if err != nil {
-			panic(err)
+// parsePairs parses comma separated list of key:value pairs. A value holds everything after the
+// first colon of its pair, a backslash escapes the next character e.g. a comma of the value
+func parsePairs(s string) (map[string]string, error) {
+	m := map[string]string{}
+	var key, cur strings.Builder
+	inValue, escaped := false, false
+	flush := func() error {
+		if !inValue {
+			return errors.Errorf("invalid pair %q, expect format key:value", cur.String())
 		}
-		fmt.Println(string(serialized))
-	case "create":
-		req := &schema.ScheduledRequest{
-			ID:              *id,
-			CreatedAt:       time.Now().UTC(),
-			Method:          *method,
-			URL:             *rURL,
-			Payload:         *payload,
-			PersistentStore: *persistEnable,
+		k := strings.TrimSpace(key.String())
+		if k == "" {
+			return errors.Errorf("empty key of pair with value %q", cur.String())
 		}
-		if *headers != "" {
-			req.Headers = map[string]string{}
-			lst := strings.Split(*headers, ",")
-			for _, v := range lst {
-				parts := strings.Split(v, ":")
-				req.Headers[parts[0]] = parts[1]
+		m[k] = cur.String()
+		key.Reset()
+		cur.Reset()
+		inValue = false
+		return nil
+	}
+	for _, c := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == ',':
+			if err := flush(); err != nil {
+				return nil, err
 			}
+		case c == ':' && !inValue:
+			key.WriteString(cur.String())
+			cur.Reset()
+			inValue = true
+		default:
+			cur.WriteRune(c)
 		}
-		req.EffectiveAfter = req.CreatedAt.Add(*freezeDur)
-		valid, err := govalidator.ValidateStruct(req)
-		if err != nil {
-			panic(err)
-		} else if !valid {
-			panic("Request validation still failed somehow")
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// mustTags parses the repeated -tag flag values in format key=value, nil if there is none
+func mustTags(tags []string) map[string]string {
+	var m map[string]string
+	for _, v := range tags {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			fmt.Printf("Invalid tag %q, expect format key=value\n", v)
+			os.Exit(1)
 		}
-		if err = scheduler.Create(context.Background(), svc, *table, req); err != nil {
-			panic(err)
+		if m == nil {
+			m = map[string]string{}
 		}
-	case "get":
-		req, err := scheduler.Get(context.Background(), svc, *table, *id)
+		m[parts[0]] = parts[1]
+	}
+	return m
+}
+
+// mustPairs returns the pairs of flag value, exits on invalid ones
+func mustPairs(name, s string) map[string]string {
+	m, err := parsePairs(s)
+	if err != nil {
+		fmt.Printf("Invalid value of flag `-%s`: %s\n", name, err)
+		os.Exit(1)
+	}
+	return m
+}
+
+// creator returns the creator of requests given by flag, or else the ARN of the AWS identity of
+// session, falling back to actor if it is unknown
+func creator(sess *session.Session, createdBy, actor string) string {
+	if createdBy != "" {
+		return createdBy
+	}
+	identity, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		fmt.Printf("Unknown AWS identity, requests are created by %q: %s\n", actor, err)
+		return actor
+	}
+	return aws.StringValue(identity.Arn)
+}
+
+// readPayload returns the payload given inline, from stdin if "-", or from file at path along with
+// its encoding. Binary data read from stdin or file is base64 encoded unless encoding is set
+func readPayload(inline, path, encoding string) (string, string) {
+	if inline != "" && path != "" {
+		fmt.Printf("Flags `-payload` and `-payload-file` are mutually exclusive\n")
+		os.Exit(1)
+	}
+	var (
+		raw []byte
+		err error
+	)
+	switch {
+	case path != "":
+		raw, err = os.ReadFile(path)
+	case inline == "-":
+		raw, err = io.ReadAll(os.Stdin)
+	default:
+		return inline, encoding
+	}
+	if err != nil {
+		fmt.Printf("Read payload failed: %s\n", err)
+		os.Exit(1)
+	}
+	if encoding == "" && !utf8.Valid(raw) {
+		return base64.StdEncoding.EncodeToString(raw), schema.PayloadBase64
+	}
+	return string(raw), encoding
+}
+
+// readHeaders returns the headers of JSON or YAML map in file at path overridden by the inline
+// ones of -headers, nil if there is none
+func readHeaders(path, inline string) map[string]string {
+	var headers map[string]string
+	if path != "" {
+		raw, err := os.ReadFile(path)
 		if err != nil {
-			if aerr, ok := err.(awserr.Error); ok {
-				if aerr.Code() == dynamodb.ErrCodeResourceNotFoundException {
-					fmt.Println("not found")
-					return
-				}
-			}
-			panic(err)
+			fmt.Printf("Invalid value of flag `-headers-file`: %s\n", err)
+			os.Exit(1)
+		}
+		// a JSON document is valid YAML as well
+		if err = yaml.Unmarshal(raw, &headers); err != nil {
+			fmt.Printf("Invalid headers file %s, expect a JSON or YAML map of strings: %s\n", path, err)
+			os.Exit(1)
 		}
+	}
+	if inline == "" {
+		return headers
+	}
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	for k, v := range mustPairs("headers", inline) {
+		headers[k] = v
+	}
+	return headers
+}
+
+// parseLists parses semicolon separated list of key=value1,value2 pairs
+func parseLists(s string) map[string][]string {
+	if s == "" {
+		return nil
+	}
+	m := map[string][]string{}
+	for _, v := range strings.Split(s, ";") {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			fmt.Printf("Invalid list %q, expect format key=value1,value2\n", v)
+			os.Exit(1)
+		}
+		m[parts[0]] = strings.Split(parts[1], ",")
+	}
+	return m
+}
+
+// printDryRun prints the items that would be written, one JSON document per line
+func printDryRun(table string, reqs []*schema.ScheduledRequest) {
+	for _, req := range reqs {
 		serialized, err := json.Marshal(req)
 		if err != nil {
 			panic(err)
 		}
 		fmt.Println(string(serialized))
-	case "lock":
-		if err := scheduler.Lock(context.Background(), svc, *table, *id); err != nil {
-			panic(err)
+	}
+	fmt.Fprintf(os.Stderr, "dry run, %d requests would be written to table_name=%s\n", len(reqs), table)
+}
+
+// executionServices returns the configuration and clients executing requests the same way as
+// the scheduled function, table, baseURL and endpoint take precedence over its environment
+func executionServices(sess *session.Session, table, baseURL, endpoint string) (*config.Configuration, *scheduler.Services) {
+	os.Setenv("TABLE_NAME", table)
+	if baseURL != "" {
+		os.Setenv("BASE_URL", baseURL)
+	}
+	if endpoint != "" {
+		os.Setenv("DYNAMODB_ENDPOINT", endpoint)
+	}
+	conf, err := config.NewConfiguration()
+	if err != nil {
+		panic(err)
+	}
+	services, _, err := scheduler.NewServices(conf, sess, nil)
+	if err != nil {
+		panic(err)
+	}
+	return conf, services
+}
+
+// parseAt returns the time given as RFC 3339 or as duration from now prefixed by +
+func parseAt(s string, now time.Time) (time.Time, error) {
+	if strings.HasPrefix(s, "+") {
+		d, err := time.ParseDuration(s[1:])
+		if err != nil {
+			return time.Time{}, err
 		}
-	case "unlock":
-		if err := scheduler.Unlock(context.Background(), svc, *table, *id); err != nil {
+		return now.Add(d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseBefore returns the time given as RFC 3339 or as duration before now
+func parseBefore(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseBoolFilter returns nil if filter flag is empty
+func parseBoolFilter(name, s string) *bool {
+	if s == "" {
+		return nil
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		fmt.Printf("Invalid value of flag `-%s` %q, expect true or false\n", name, s)
+		os.Exit(1)
+	}
+	return &v
+}
+
+// parseTimeFilter returns zero time if filter flag is empty
+func parseTimeFilter(name, s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	v, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		fmt.Printf("Invalid value of flag `-%s` %q, expect RFC 3339 time e.g. 2006-01-02T15:04:05Z\n", name, s)
+		os.Exit(1)
+	}
+	return v
+}
+
+// globalFlags are the flags shared by every action
+type globalFlags struct {
+	table      *string
+	endpoint   *string
+	configFile *string
+	profile    *string
+	actor      *string
+	auditTable *string
+}
+
+func addGlobalFlags(fs *flag.FlagSet) *globalFlags {
+	return &globalFlags{
+		table:      fs.String("table", "", "dynamodb table to store request, defaults to table_name of -config profile"),
+		endpoint:   fs.String("endpoint", "", "optional DynamoDB endpoint e.g. http://localhost:8000 of DynamoDB Local, defaults to dynamodb_endpoint of -config profile"),
+		configFile: fs.String("config", os.Getenv("CONFIG_FILE"), "optional config file providing defaults of -table and -base-url"),
+		profile:    fs.String("profile", os.Getenv("CITIUM_PROFILE"), "profile of -config file e.g. dev, staging or prod"),
+		actor:      fs.String("actor", os.Getenv("USER"), "actor of the recorded state transitions"),
+		auditTable: fs.String("audit-table", "", "optional table recording state transitions made by the action, required by audit action"),
+	}
+}
+
+// environment holds the clients and settings an action runs with
+type environment struct {
+	table     string
+	endpoint  string
+	limits    schema.SizeLimits
+	sess      *session.Session
+	svc       *dynamodb.DynamoDB
+	contracts *scheduler.PayloadContracts
+	// nil unless -audit-table is given, recording nothing then
+	audit *scheduler.AuditLog
+}
+
+// open loads the -config profile and returns the environment of the action, the flags taking
+// precedence over the environment variables it sets
+func (g *globalFlags) open() *environment {
+	if *g.configFile != "" {
+		if err := config.LoadFile(*g.configFile, *g.profile); err != nil {
 			panic(err)
 		}
-	default:
-		flag.PrintDefaults()
+	}
+	env := &environment{
+		table:    orEnv(*g.table, "TABLE_NAME"),
+		endpoint: orEnv(*g.endpoint, "DYNAMODB_ENDPOINT"),
+	}
+	// the limits the functions enforce, checked before dry run or writing
+	var err error
+	if env.limits, err = config.LoadSizeLimits(); err != nil {
+		fmt.Printf("Invalid size limits: %s\n", err)
+		os.Exit(1)
+	}
+	scheduler.SetSizeLimits(env.limits)
+
+	env.sess = session.Must(session.NewSession(nil))
+	env.svc = dynamodb.New(env.sess, scheduler.NewDynamoDBConfig(env.endpoint))
+	env.contracts = scheduler.NewPayloadContracts(s3.New(env.sess))
+	scheduler.SetPayloadContracts(env.contracts)
+	if *g.auditTable != "" {
+		env.audit = scheduler.NewAuditLog(env.svc, *g.auditTable, *g.actor)
+	}
+	return env
+}
+
+// openTable is open of the actions requiring -table
+func (g *globalFlags) openTable() *environment {
+	env := g.open()
+	if env.table == "" {
+		fmt.Printf("Empty value of the required flag `-table`\n")
+		os.Exit(1)
+	}
+	return env
+}
+
+func main() {
+	run, err := parseCommand(commands, os.Args[1:], os.Stderr)
+	switch {
+	case err == flag.ErrHelp:
+		return
+	case err == errUnknownAction:
 		os.Exit(1)
+	case err != nil:
+		// the flag set printed the error along with the usage of the action
+		os.Exit(2)
 	}
+	run()
 }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitCommand(t *testing.T) {
+	for _, c := range []struct {
+		caseName string
+		args     []string
+		name     string
+		rest     []string
+	}{
+		{
+			caseName: "first_argument",
+			args:     []string{"list", "-table=t"},
+			name:     "list",
+			rest:     []string{"-table=t"},
+		},
+		{
+			caseName: "action_flag",
+			args:     []string{"-table=t", "-action=list", "-limit=2"},
+			name:     "list",
+			rest:     []string{"-table=t", "-limit=2"},
+		},
+		{
+			caseName: "action_flag_double_dash",
+			args:     []string{"--action=get", "-id=a"},
+			name:     "get",
+			rest:     []string{"-id=a"},
+		},
+		{
+			caseName: "action_flag_separate_value",
+			args:     []string{"-id=a", "-action", "get"},
+			name:     "get",
+			rest:     []string{"-id=a"},
+		},
+		{
+			caseName: "after_terminator",
+			args:     []string{"-id=a", "--", "-action=get"},
+			rest:     []string{"-id=a", "--", "-action=get"},
+		},
+		{
+			caseName: "none",
+			args:     []string{"-table=t"},
+			rest:     []string{"-table=t"},
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			name, rest := splitCommand(c.args)
+			assert.Equal(t, c.name, name)
+			assert.Equal(t, c.rest, rest)
+		})
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	for _, c := range []struct {
+		caseName string
+		args     []string
+		err      error
+		output   string
+	}{
+		{
+			caseName: "create",
+			args:     []string{"create", "-table=t", "-id=a", "-target=sqs", "-queue-url=https://sqs/q", "-tag=team=a", "-tag=env=b"},
+		},
+		{
+			caseName: "legacy_action_flag",
+			args:     []string{"-action=list", "-table=t", "-locked=true", "-output=table"},
+		},
+		{
+			caseName: "completion_shell",
+			args:     []string{"completion", "zsh"},
+		},
+		{
+			caseName: "flag_of_other_action",
+			args:     []string{"get", "-table=t", "-queue-url=https://sqs/q"},
+			err:      fmt.Errorf("flag provided but not defined: -queue-url"),
+			output:   "Usage:",
+		},
+		{
+			caseName: "invalid_output",
+			args:     []string{"get", "-output=xml"},
+			err:      fmt.Errorf(`invalid value "xml" for flag -output: unknown format=xml, expect json, yaml or table`),
+		},
+		{
+			caseName: "unexpected_argument",
+			args:     []string{"list", "extra"},
+			err:      fmt.Errorf(`unexpected argument "extra" of action=list`),
+		},
+		{
+			caseName: "unknown_action",
+			args:     []string{"bogus"},
+			err:      errUnknownAction,
+			output:   `Unknown action "bogus"`,
+		},
+		{
+			caseName: "no_action",
+			args:     []string{"-table=t"},
+			err:      errUnknownAction,
+			output:   "Actions:",
+		},
+		{
+			caseName: "help",
+			args:     []string{"-h"},
+			err:      flag.ErrHelp,
+			output:   "create-table",
+		},
+		{
+			caseName: "help_of_action",
+			args:     []string{"help", "purge"},
+			err:      flag.ErrHelp,
+			output:   "-executed-before",
+		},
+	} {
+		t.Run(fmt.Sprintf("case=%s", c.caseName), func(t *testing.T) {
+			var out bytes.Buffer
+			run, err := parseCommand(commands, c.args, &out)
+			if c.err != nil {
+				require.Error(t, err)
+				assert.Equal(t, c.err.Error(), err.Error())
+				assert.Nil(t, run)
+			} else {
+				require.NoError(t, err)
+				assert.NotNil(t, run)
+			}
+			assert.Contains(t, out.String(), c.output)
+		})
+	}
+}
+
+func TestParseCommandDispatch(t *testing.T) {
+	var ran []string
+	cmds := []command{
+		{name: "one", setup: func(fs *flag.FlagSet) func() {
+			id := fs.String("id", "", "")
+			return func() { ran = append(ran, "one:"+*id) }
+		}},
+		{name: "two", args: "<arg>", setup: func(fs *flag.FlagSet) func() {
+			limit := fs.Int("limit", 0, "")
+			return func() { ran = append(ran, fmt.Sprintf("two:%d:%s", *limit, fs.Arg(0))) }
+		}},
+	}
+	for _, args := range [][]string{
+		{"one", "-id=a"},
+		{"-id=b", "-action=one"},
+		{"two", "-limit=3", "x"},
+		{"-action", "two", "-limit=4"},
+	} {
+		run, err := parseCommand(cmds, args, &bytes.Buffer{})
+		require.NoError(t, err)
+		run()
+	}
+	assert.Equal(t, []string{"one:a", "one:b", "two:3:x", "two:4:"}, ran)
+
+	var out bytes.Buffer
+	_, err := parseCommand(cmds, []string{"help", "two"}, &out)
+	assert.Equal(t, flag.ErrHelp, err)
+	assert.Contains(t, out.String(), "two [flags] <arg>")
+	assert.Contains(t, out.String(), "-limit")
+	assert.NotContains(t, out.String(), "-id")
+}
+
+func TestCompletionScript(t *testing.T) {
+	script, err := completionScript("bash", commands)
+	require.NoError(t, err)
+	for _, word := range []string{"retry-failed", "completion", "-action", "-queue-url", "-executed-before", "-table"} {
+		assert.Contains(t, script, word)
+	}
+	_, err = completionScript("fish", commands)
+	assert.Error(t, err)
+}
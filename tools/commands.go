@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// errUnknownAction is returned by parseCommand when no known action is given
+var errUnknownAction = errors.New("unknown action")
+
+// command is an action of the CLI, run either as `citium-cli <name> [flags]` or as
+// `citium-cli -action=<name> [flags]`
+type command struct {
+	name  string
+	usage string
+	// args names the positional arguments of the action, none are accepted if empty
+	args string
+	// setup registers the flags of the action into fs and returns its handler, run once they are parsed
+	setup func(fs *flag.FlagSet) func()
+}
+
+// commands lists the actions in the order of help text, new actions are added here so that
+// they are documented and completed by shells
+var commands = []command{
+	{name: "create", usage: "request to add new record with specific parameters", setup: setupCreate},
+	{name: "clone", usage: "copy the request by given id into a new one of -new-id due at -at, or after -freeze if not given", setup: setupClone},
+	{name: "get", usage: "retrieve scheduled request by given id", setup: setupGet},
+	{name: "list", usage: "fetch all the scheduled requests to be run next, or the ones matching filter flags -locked, -failed, -due-before, -due-after, -url-contains, -status and -tag", setup: setupList},
+	{name: "lock", usage: "request to lock record by given id", setup: setupLock},
+	{name: "unlock", usage: "request to unlock record by given id", setup: setupUnlock},
+	{name: "cancel", usage: "keep the request by given id without ever executing it again, as CANCELLED", setup: setupCancel},
+	{name: "history", usage: "show the last result and the failures of executions of request by given id", setup: setupHistory},
+	{name: "stats", usage: "count the requests of -table by state and show the next 10 due ones and the oldest lock", setup: setupStats},
+	{name: "audit", usage: "show the recorded state transitions of request by given id", setup: setupAudit},
+	{name: "redrive", usage: "move requests of the dead-letter queue given by -dlq-queue-url or -dlq-table back into the schedule", setup: setupRedrive},
+	{name: "import", usage: "create the requests defined in -file at once", setup: setupImport},
+	{name: "healthcheck", usage: "verify the table, its permissions and reachability of -base-url, exits with failure if unhealthy", setup: setupHealthcheck},
+	{name: "run", usage: "execute the due requests of -table, or of its -shard or -tag only, like the scheduled function does, configured by its environment variables", setup: setupRun},
+	{name: "trigger", usage: "execute the request by given id at once regardless of its effective date, configured like the run action", setup: setupTrigger},
+	{name: "reschedule", usage: "move the request by given id to -at, clearing its last failure and unlocking it if -unlock is set", setup: setupReschedule},
+	{name: "retry-failed", usage: "unlock the requests left locked by a failure and clear their failures, moving them to -at if given", setup: setupRetryFailed},
+	{name: "purge", usage: "delete the requests kept by PersistentStore which were executed before -executed-before, copying them into -archive-table if given", setup: setupPurge},
+	{name: "trim-results", usage: "clear the execution results kept past the ResultRetention of their requests, keeping the requests", setup: setupTrimResults},
+	{name: "validate", usage: "check the requests defined in -file like import does without writing anything, exits with failure if any is invalid", setup: setupValidate},
+	{name: "migrate", usage: "backfill the attributes added to requests since the items of -table were stored, printing progress to stderr, or only count them if -dry-run is set", setup: setupMigrate},
+	{name: "create-table", usage: "create the schedule table, and the -audit-table, -checkpoint-table and -lease-table if given, unless they exist already", setup: setupCreateTable},
+}
+
+func init() {
+	// completion reads the flags of every other action, added here to not refer to commands
+	// while initializing it
+	commands = append(commands, command{
+		name:  "completion",
+		usage: "print the completion script of shell bash or zsh, e.g. `source <(citium-cli completion bash)`",
+		args:  "<bash|zsh>",
+		setup: setupCompletion,
+	})
+}
+
+func findCommand(cmds []command, name string) (command, bool) {
+	for _, c := range cmds {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// splitCommand returns the action given as first argument, or else by -action flag anywhere in
+// args, along with the rest of args
+func splitCommand(args []string) (string, []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return args[0], args[1:]
+	}
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		switch {
+		case strings.HasPrefix(name, "action="):
+			rest := append(append([]string{}, args[:i]...), args[i+1:]...)
+			return strings.TrimPrefix(name, "action="), rest
+		case name == "action" && i+1 < len(args):
+			rest := append(append([]string{}, args[:i]...), args[i+2:]...)
+			return args[i+1], rest
+		}
+	}
+	return "", args
+}
+
+// parseCommand parses the action given by args along with its flags, and returns its handler.
+// Help is written to out and flag.ErrHelp returned when asked by `help [action]` or -h,
+// errUnknownAction is returned if args give no known action
+func parseCommand(cmds []command, args []string, out io.Writer) (func(), error) {
+	name, rest := splitCommand(args)
+	if name == "help" {
+		if len(rest) == 0 {
+			usage(out, cmds)
+			return nil, flag.ErrHelp
+		}
+		name, rest = rest[0], []string{"-h"}
+	}
+	c, ok := findCommand(cmds, name)
+	if !ok {
+		switch {
+		case name != "":
+			fmt.Fprintf(out, "Unknown action %q\n\n", name)
+		case len(rest) > 0 && isHelp(rest[0]):
+			usage(out, cmds)
+			return nil, flag.ErrHelp
+		}
+		usage(out, cmds)
+		return nil, errUnknownAction
+	}
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(out)
+	run := c.setup(fs)
+	fs.Usage = func() {
+		line := strings.TrimSpace(fmt.Sprintf("%s %s [flags] %s", os.Args[0], c.name, c.args))
+		fmt.Fprintf(out, "Usage: %s\n\n%s\n\nFlags:\n", line, c.usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(rest); err != nil {
+		return nil, err
+	}
+	if c.args == "" && fs.NArg() > 0 {
+		err := errors.Errorf("unexpected argument %q of action=%s", fs.Arg(0), c.name)
+		fmt.Fprintln(out, err)
+		fs.Usage()
+		return nil, err
+	}
+	return run, nil
+}
+
+func isHelp(arg string) bool {
+	switch arg {
+	case "-h", "-help", "--h", "--help":
+		return true
+	}
+	return false
+}
+
+// usage prints the help of the CLI
+func usage(out io.Writer, cmds []command) {
+	fmt.Fprintf(out, "Usage: %s <action> [flags]\n\nActions:\n", os.Args[0])
+	for _, c := range cmds {
+		fmt.Fprintf(out, "  %-14s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(out, "\nRun `%s help <action>` for the flags of an action.\n", os.Args[0])
+}
+
+func setupCompletion(fs *flag.FlagSet) func() {
+	return func() {
+		script, err := completionScript(fs.Arg(0), commands)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Print(script)
+	}
+}
+
+// completionScript returns the script completing the actions of cmds and their flags in shell
+func completionScript(shell string, cmds []command) (string, error) {
+	// -action is kept by splitCommand rather than by any flag set
+	seen := map[string]bool{"action": true}
+	flags := []string{"-action"}
+	for _, c := range cmds {
+		fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+		c.setup(fs)
+		fs.VisitAll(func(f *flag.Flag) {
+			if !seen[f.Name] {
+				seen[f.Name] = true
+				flags = append(flags, "-"+f.Name)
+			}
+		})
+	}
+	sort.Strings(flags)
+	switch shell {
+	case "bash":
+		names := make([]string, len(cmds))
+		for i, c := range cmds {
+			names[i] = c.name
+		}
+		return fmt.Sprintf(`_citium_cli() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    if [ "$COMP_CWORD" -eq 1 ] && [[ "$cur" != -* ]]; then
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+    else
+        COMPREPLY=($(compgen -W "%s" -- "$cur"))
+    fi
+}
+complete -o default -F _citium_cli citium-cli
+`, strings.Join(names, " "), strings.Join(flags, " ")), nil
+	case "zsh":
+		described := make([]string, len(cmds))
+		for i, c := range cmds {
+			desc := strings.NewReplacer(":", `\:`, "'", `'\''`).Replace(c.usage)
+			described[i] = fmt.Sprintf("'%s:%s'", c.name, desc)
+		}
+		return fmt.Sprintf(`#compdef citium-cli
+_citium_cli() {
+    local -a actions
+    actions=(%s)
+    if (( CURRENT == 2 )) && [[ "$words[2]" != -* ]]; then
+        _describe 'action' actions
+    else
+        compadd -- %s
+    fi
+}
+compdef _citium_cli citium-cli
+`, strings.Join(described, " "), strings.Join(flags, " ")), nil
+	default:
+		return "", errors.Errorf("unknown shell=%s, expect bash or zsh", shell)
+	}
+}
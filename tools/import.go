@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/meomap/citium/scheduler"
+	"github.com/meomap/citium/schema"
+)
+
+// Formats of import file
+const (
+	importJSON      = "json"
+	importJSONLines = "jsonl"
+	importCSV       = "csv"
+)
+
+// importEntry is a request definition of import file along with its position for error reports
+type importEntry struct {
+	position string
+	req      *schema.ScheduledRequest
+}
+
+// readImport parses the request definitions of file at path, "-" reads stdin. Format is either
+// json (array of requests), jsonl (a request per line) or csv (http requests with a header row
+// naming the columns), guessed from file extension if empty.
+func readImport(path, format string) ([]importEntry, error) {
+	var (
+		raw []byte
+		err error
+	)
+	if path == "-" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read path=%s", path)
+	}
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+	switch format {
+	case importJSON:
+		return readJSON(path, raw)
+	case importJSONLines:
+		var entries []importEntry
+		scanner := bufio.NewScanner(bytes.NewReader(raw))
+		scanner.Buffer(nil, 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			req := new(schema.ScheduledRequest)
+			if err = json.Unmarshal(scanner.Bytes(), req); err != nil {
+				return nil, errors.Wrapf(err, "json.Unmarshal path=%s line=%d", path, line)
+			}
+			entries = append(entries, importEntry{position: fmt.Sprintf("line=%d", line), req: req})
+		}
+		return entries, errors.Wrapf(scanner.Err(), "scanner.Scan path=%s", path)
+	case importCSV:
+		return readCSV(path, raw)
+	default:
+		return nil, errors.Errorf("unknown import format=%s, expect one of json, jsonl or csv", format)
+	}
+}
+
+// readJSON decodes the array of requests one by one to report the line each entry starts at
+func readJSON(path string, raw []byte) ([]importEntry, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, errors.Errorf("expect json array of requests path=%s", path)
+	}
+	var entries []importEntry
+	for dec.More() {
+		// the offset is past the previous entry, skip the separators up to the next one
+		offset := int(dec.InputOffset())
+		for offset < len(raw) && strings.ContainsRune(", \t\r\n", rune(raw[offset])) {
+			offset++
+		}
+		line := bytes.Count(raw[:offset], []byte("\n")) + 1
+		req := new(schema.ScheduledRequest)
+		if err := dec.Decode(req); err != nil {
+			return nil, errors.Wrapf(err, "json.Decode path=%s line=%d", path, line)
+		}
+		entries = append(entries, importEntry{position: fmt.Sprintf("line=%d", line), req: req})
+	}
+	return entries, nil
+}
+
+// validateEntries fills the dates left empty, CreatedAt with now and EffectiveAfter with freeze
+// from it, then prints the problems of every invalid, too large or duplicated request. The
+// requests are returned along with whether all of them are valid
+func validateEntries(entries []importEntry, now time.Time, freeze time.Duration, limits schema.SizeLimits) ([]*schema.ScheduledRequest, bool) {
+	reqs := make([]*schema.ScheduledRequest, 0, len(entries))
+	seen := map[string]string{}
+	valid := true
+	for _, entry := range entries {
+		req := entry.req
+		if req.CreatedAt.IsZero() {
+			req.CreatedAt = now
+		}
+		if req.EffectiveAfter.IsZero() {
+			req.EffectiveAfter = req.CreatedAt.Add(freeze)
+		}
+		if err := req.Validate(); err != nil {
+			fmt.Printf("Invalid request %s id=%s: %s\n", entry.position, req.ID, err)
+			valid = false
+		}
+		if err := req.CheckSize(limits); err != nil {
+			fmt.Printf("Request too large %s id=%s: %s\n", entry.position, req.ID, err)
+			valid = false
+		}
+		if position, ok := seen[req.ID]; ok && req.ID != "" {
+			fmt.Printf("Duplicate request %s id=%s, already defined at %s\n", entry.position, req.ID, position)
+			valid = false
+		}
+		seen[req.ID] = entry.position
+		reqs = append(reqs, req)
+	}
+	return reqs, valid
+}
+
+// checkContracts prints the violations of the payload schemas of every entry, returning whether
+// all of them are satisfied
+func checkContracts(contracts *scheduler.PayloadContracts, entries []importEntry) bool {
+	valid := true
+	for _, entry := range entries {
+		if err := contracts.Check(context.Background(), entry.req); err != nil {
+			fmt.Printf("Invalid payload %s id=%s: %s\n", entry.position, entry.req.ID, err)
+			valid = false
+		}
+	}
+	return valid
+}
+
+// csvColumns sets the request field of each csv column
+var csvColumns = map[string]func(req *schema.ScheduledRequest, v string) error{
+	"ID":              func(req *schema.ScheduledRequest, v string) error { req.ID = v; return nil },
+	"TargetType":      func(req *schema.ScheduledRequest, v string) error { req.TargetType = v; return nil },
+	"Method":          func(req *schema.ScheduledRequest, v string) error { req.Method = v; return nil },
+	"URL":             func(req *schema.ScheduledRequest, v string) error { req.URL = v; return nil },
+	"Payload":         func(req *schema.ScheduledRequest, v string) error { req.Payload = v; return nil },
+	"PayloadEncoding": func(req *schema.ScheduledRequest, v string) error { req.PayloadEncoding = v; return nil },
+	"ExpectStatus":    func(req *schema.ScheduledRequest, v string) error { req.ExpectStatus = v; return nil },
+	"Description":     func(req *schema.ScheduledRequest, v string) error { req.Description = v; return nil },
+	"CallbackURL":     func(req *schema.ScheduledRequest, v string) error { req.CallbackURL = v; return nil },
+	"PayloadSchema":   func(req *schema.ScheduledRequest, v string) error { req.PayloadSchema = v; return nil },
+	"ResultRetention": func(req *schema.ScheduledRequest, v string) error { req.ResultRetention = v; return nil },
+	"Headers": func(req *schema.ScheduledRequest, v string) (err error) {
+		if v != "" {
+			req.Headers, err = parsePairs(v)
+		}
+		return err
+	},
+	"EffectiveAfter": func(req *schema.ScheduledRequest, v string) (err error) {
+		if v != "" {
+			req.EffectiveAfter, err = time.Parse(time.RFC3339, v)
+		}
+		return err
+	},
+	"PersistentStore": func(req *schema.ScheduledRequest, v string) (err error) {
+		if v != "" {
+			req.PersistentStore, err = strconv.ParseBool(v)
+		}
+		return err
+	},
+	"StreamResultToS3": func(req *schema.ScheduledRequest, v string) (err error) {
+		if v != "" {
+			req.StreamResultToS3, err = strconv.ParseBool(v)
+		}
+		return err
+	},
+}
+
+func readCSV(path string, raw []byte) ([]importEntry, error) {
+	rows, err := csv.NewReader(bytes.NewReader(raw)).ReadAll()
+	if err != nil {
+		return nil, errors.Wrapf(err, "csv.ReadAll path=%s", path)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	header := rows[0]
+	for _, column := range header {
+		if csvColumns[column] == nil {
+			return nil, errors.Errorf("unknown csv column=%s path=%s", column, path)
+		}
+	}
+	entries := make([]importEntry, 0, len(rows)-1)
+	for i, row := range rows[1:] {
+		// header is the first line
+		line := i + 2
+		req := new(schema.ScheduledRequest)
+		for j, column := range header {
+			if err = csvColumns[column](req, row[j]); err != nil {
+				return nil, errors.Wrapf(err, "invalid csv value path=%s line=%d column=%s", path, line, column)
+			}
+		}
+		entries = append(entries, importEntry{position: fmt.Sprintf("line=%d", line), req: req})
+	}
+	return entries, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/meomap/citium/scheduler"
+	"github.com/meomap/citium/schema"
+)
+
+// Formats of read action output
+const (
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputTable = "table"
+)
+
+// outputFlag is the -output flag of read actions, accepting the formats above only
+type outputFlag string
+
+func addOutputFlag(fs *flag.FlagSet) *outputFlag {
+	f := outputFlag(outputJSON)
+	fs.Var(&f, "output", "`format` of the output, either json, yaml or table")
+	return &f
+}
+
+func (f *outputFlag) String() string {
+	return string(*f)
+}
+
+func (f *outputFlag) Set(v string) error {
+	switch v {
+	case outputJSON, outputYAML, outputTable:
+		*f = outputFlag(v)
+		return nil
+	}
+	return errors.Errorf("unknown format=%s, expect json, yaml or table", v)
+}
+
+// maxCellLen truncates long values of table output, e.g. failure reasons, to keep a row per line
+const maxCellLen = 60
+
+// printOutput writes v to stdout in format. Table rows are given to requests, audit events,
+// execution history and table stats, other values are printed as yaml by table format
+func printOutput(format string, v interface{}) {
+	if err := writeOutput(os.Stdout, format, v); err != nil {
+		panic(err)
+	}
+}
+
+func writeOutput(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case outputTable:
+		switch rows := v.(type) {
+		case *schema.ScheduledRequest:
+			return writeRequests(w, []*schema.ScheduledRequest{rows})
+		case []*schema.ScheduledRequest:
+			return writeRequests(w, rows)
+		case []*scheduler.AuditEvent:
+			return writeEvents(w, rows)
+		case *scheduler.ExecutionHistory:
+			return writeHistory(w, rows)
+		case *scheduler.TableStats:
+			return writeStats(w, rows)
+		}
+		return writeOutput(w, outputYAML, v)
+	case outputYAML:
+		// go through json to keep the attribute names of json output
+		serialized, err := json.Marshal(v)
+		if err != nil {
+			return errors.Wrap(err, "json.Marshal")
+		}
+		var doc interface{}
+		if err = json.Unmarshal(serialized, &doc); err != nil {
+			return errors.Wrap(err, "json.Unmarshal")
+		}
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		return errors.Wrap(enc.Encode(doc), "yaml.Encode")
+	default:
+		serialized, err := json.Marshal(v)
+		if err != nil {
+			return errors.Wrap(err, "json.Marshal")
+		}
+		_, err = fmt.Fprintln(w, string(serialized))
+		return err
+	}
+}
+
+func writeRequests(w io.Writer, reqs []*schema.ScheduledRequest) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tMETHOD\tURL\tDUE\tLOCKED\tLAST FAILURE\tDESCRIPTION")
+	for _, req := range reqs {
+		method, target := req.Method, req.URL
+		if req.Target() != schema.TargetHTTP {
+			// other targets have no url, show their type instead
+			method, target = req.Target(), "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			req.ID, method, cell(target), req.EffectiveAfter.UTC().Format(time.RFC3339),
+			strconv.FormatBool(req.Locking), cell(req.FailureReason), cell(req.Description))
+	}
+	return tw.Flush()
+}
+
+func writeEvents(w io.Writer, events []*scheduler.AuditEvent) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "AT\tACTION\tACTOR\tDETAIL")
+	for _, e := range events {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.At.UTC().Format(time.RFC3339), e.Action, e.Actor, cell(e.Detail))
+	}
+	return tw.Flush()
+}
+
+// writeHistory prints the executions from the oldest, the failures followed by the last success
+// kept by PersistentStore
+func writeHistory(w io.Writer, history *scheduler.ExecutionHistory) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "AT\tOUTCOME\tDETAIL")
+	for _, f := range history.Failures {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.At.UTC().Format(time.RFC3339), "failed", cell(f.Reason))
+	}
+	if history.ExecutedAt != nil {
+		detail := "-"
+		if history.Result != nil {
+			detail = fmt.Sprintf("code=%d duration=%.0fms body=%s", history.Result.Code, history.Result.Duration, history.Result.Body)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", history.ExecutedAt.UTC().Format(time.RFC3339), "executed", cell(detail))
+	}
+	return tw.Flush()
+}
+
+// writeStats prints the counts by state and by status followed by the next due requests and the oldest lock
+func writeStats(w io.Writer, stats *scheduler.TableStats) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATE\tCOUNT")
+	for _, state := range []string{scheduler.StatePending, scheduler.StateLocked, scheduler.StateFailed, scheduler.StateExecuted} {
+		fmt.Fprintf(tw, "%s\t%d\n", state, stats.States[state])
+	}
+	fmt.Fprintf(tw, "total\t%d\n", stats.Total)
+	fmt.Fprintf(tw, "due now\t%d\n", stats.Due)
+	fmt.Fprintln(tw, "\nSTATUS\tCOUNT")
+	for _, status := range []string{schema.StatusPending, schema.StatusRunning, schema.StatusSucceeded, schema.StatusFailed, schema.StatusCancelled, schema.StatusExpired} {
+		fmt.Fprintf(tw, "%s\t%d\n", status, stats.Statuses[status])
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "\nNext due:")
+	if err := writeRequests(w, stats.NextDue); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "\nOldest lock:")
+	if stats.OldestLock == nil {
+		_, err := fmt.Fprintln(w, "-")
+		return err
+	}
+	return writeRequests(w, []*schema.ScheduledRequest{stats.OldestLock})
+}
+
+// cell returns the single line form of value shortened to maxCellLen, "-" if empty
+func cell(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if s == "" {
+		return "-"
+	}
+	if runes := []rune(s); len(runes) > maxCellLen {
+		return string(runes[:maxCellLen-3]) + "..."
+	}
+	return s
+}